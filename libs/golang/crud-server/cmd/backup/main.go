@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/dae-go/crud-server/pkg/backup"
+	"github.com/dae-go/crud-server/pkg/client"
+)
+
+func main() {
+	var (
+		serverURL = flag.String("server", "http://localhost:8080", "Server URL")
+		token     = flag.String("token", os.Getenv("CRUD_TOKEN"), "Bearer token sent with every request (defaults to $CRUD_TOKEN)")
+		out       = flag.String("out", "", "Write a single backup to this file")
+		dir       = flag.String("dir", "", "Directory for timestamped backups")
+		every     = flag.Duration("every", 0, "Take a backup into -dir at this interval until interrupted (e.g., 1h)")
+		keep      = flag.Int("keep", 0, "Number of backups to keep in -dir (0 keeps all)")
+	)
+
+	flag.Parse()
+
+	opts := []client.Option{client.WithUserAgent("crud-server-backup")}
+	if *token != "" {
+		opts = append(opts, client.WithAuthToken(*token))
+	}
+	c := client.NewClient(*serverURL, opts...)
+
+	switch {
+	case *out != "":
+		if err := writeBackup(context.Background(), c, *out); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Backup written to %s\n", *out)
+	case *dir != "" && *every > 0:
+		schedule(c, *dir, *every, *keep)
+	case *dir != "":
+		if err := backupToDir(context.Background(), c, *dir, *keep); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		flag.Usage()
+		os.Exit(1)
+	}
+}
+
+// writeBackup downloads an archive into path, only replacing path once the
+// download has completed
+func writeBackup(ctx context.Context, c *client.Client, path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".backup-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := c.BackupContext(ctx, tmp); err != nil {
+		tmp.Close()
+		return fmt.Errorf("backup failed: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// backupToDir takes one timestamped backup and applies the retention limit
+func backupToDir(ctx context.Context, c *client.Client, dir string, keep int) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, backup.FileName(time.Now()))
+	if err := writeBackup(ctx, c, path); err != nil {
+		return err
+	}
+	fmt.Printf("Backup written to %s\n", path)
+
+	removed, err := backup.Prune(dir, keep)
+	if err != nil {
+		return fmt.Errorf("failed to prune old backups: %w", err)
+	}
+	for _, p := range removed {
+		fmt.Printf("Removed old backup %s\n", p)
+	}
+	return nil
+}
+
+// schedule takes a backup immediately and then at every interval until
+// interrupted. Failed backups are logged and retried at the next tick.
+func schedule(c *client.Client, dir string, every time.Duration, keep int) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("Backing up every %v into %s. Press Ctrl+C to stop\n", every, dir)
+
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		if err := backupToDir(ctx, c, dir, keep); err != nil && ctx.Err() == nil {
+			log.Printf("Warning: %v\n", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			fmt.Println("\nBackup schedule stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/dae-go/crud-server/pkg/client"
+)
+
+const defaultMix = "get=50,list=10,insert=20,update=15,delete=5"
+
+func main() {
+	var (
+		serverURL = flag.String("server", "http://localhost:8080", "Server URL")
+		token     = flag.String("token", os.Getenv("CRUD_TOKEN"), "Bearer token sent with every request (defaults to $CRUD_TOKEN)")
+		clients   = flag.Int("clients", 10, "Number of concurrent clients")
+		duration  = flag.Duration("duration", 10*time.Second, "How long to run the workload")
+		requests  = flag.Int("requests", 0, "Stop after this many operations in total instead of after -duration")
+		mixSpec   = flag.String("mix", defaultMix, "Relative weights of the operations: "+strings.Join(opOrder, ", "))
+		table     = flag.String("table", "bench", "Table to create for the run; it must not exist yet")
+		seedCount = flag.Int("seed", 1000, "Records to insert before the workload starts")
+		payload   = flag.Int("payload", 256, "Size in bytes of each record's payload field")
+		pageSize  = flag.Int("page-size", 50, "Records fetched by list and scan operations")
+		keep      = flag.Bool("keep", false, "Keep the table after the run")
+		jsonOut   = flag.Bool("json", false, "Print the report as JSON")
+		randSeed  = flag.Int64("rand-seed", 1, "Seed for choosing operations and record values")
+	)
+	flag.Parse()
+
+	m, err := parseMix(*mixSpec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *clients < 1 {
+		log.Fatal("-clients must be at least 1")
+	}
+
+	// Keep a connection per client alive; the default transport would close
+	// all but two and measure connection setup instead
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = *clients
+	opts := []client.Option{
+		client.WithUserAgent("crud-server-bench"),
+		client.WithTimeout(30 * time.Second),
+		client.WithTransport(transport),
+	}
+	if *token != "" {
+		opts = append(opts, client.WithAuthToken(*token))
+	}
+	c := client.NewClient(*serverURL, opts...)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := c.CreateTableContext(ctx, benchTable(*table)); err != nil {
+		if errors.Is(err, client.ErrConflict) {
+			log.Fatalf("table %s already exists; pick another with -table or drop it first", *table)
+		}
+		log.Fatal(err)
+	}
+	if !*keep {
+		defer func() {
+			if err := c.DeleteTableContext(context.Background(), *table); err != nil {
+				log.Printf("dropping table %s: %v", *table, err)
+			}
+		}()
+	}
+
+	w := &workload{
+		client:   c,
+		table:    *table,
+		ids:      &idPool{},
+		payload:  strings.Repeat("x", max(*payload, 0)),
+		pageSize: *pageSize,
+	}
+
+	if !*jsonOut {
+		fmt.Printf("Seeding %d records into %s...\n", *seedCount, *table)
+	}
+	if err := seed(ctx, w, *seedCount, *clients, *randSeed); err != nil {
+		log.Print(err)
+		return
+	}
+
+	if !*jsonOut {
+		fmt.Printf("Running %s with %d clients...\n", m, *clients)
+	}
+	runCtx := ctx
+	if *requests == 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+	stats, elapsed := run(runCtx, w, m, *clients, *requests, *randSeed)
+
+	r := &report{Server: *serverURL, Clients: *clients, Mix: m.String(), Seeded: *seedCount, Elapsed: elapsed.Seconds()}
+	total := &opStats{}
+	for _, op := range sortedOps(stats) {
+		r.Ops = append(r.Ops, summarize(op, stats[op], elapsed))
+		total.merge(stats[op])
+	}
+	r.Total = summarize("total", total, elapsed)
+	r.Total.FirstError = ""
+
+	if *jsonOut {
+		err = r.writeJSON(os.Stdout)
+	} else {
+		fmt.Println()
+		err = r.writeText(os.Stdout)
+	}
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+// seed inserts the starting records using every client
+func seed(ctx context.Context, w *workload, count, clients int, randSeed int64) error {
+	var (
+		next    atomic.Int64
+		wg      sync.WaitGroup
+		errOnce sync.Once
+		seedErr error
+	)
+	for i := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(randSeed - int64(i) - 1))
+			for next.Add(1) <= int64(count) {
+				if _, err := w.run(ctx, opInsert, rng); err != nil {
+					errOnce.Do(func() { seedErr = fmt.Errorf("seeding: %w", err) })
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return seedErr
+}
+
+// run drives the workload until ctx is done or limit operations have been
+// started, and returns the merged statistics and the elapsed time
+func run(ctx context.Context, w *workload, m *mix, clients, limit int, randSeed int64) (map[string]*opStats, time.Duration) {
+	var (
+		started atomic.Int64
+		wg      sync.WaitGroup
+	)
+	perWorker := make([]map[string]*opStats, clients)
+
+	start := time.Now()
+	for i := range clients {
+		stats := make(map[string]*opStats)
+		perWorker[i] = stats
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(randSeed + int64(i)))
+			for ctx.Err() == nil {
+				if limit > 0 && started.Add(1) > int64(limit) {
+					return
+				}
+				op := m.pick(rng)
+				begin := time.Now()
+				skipped, err := w.run(ctx, op, rng)
+				took := time.Since(begin)
+				if skipped {
+					continue
+				}
+				if err != nil && ctx.Err() != nil {
+					// Cut off by the end of the run rather than failed
+					return
+				}
+
+				s := stats[op]
+				if s == nil {
+					s = &opStats{}
+					stats[op] = s
+				}
+				s.latencies = append(s.latencies, took)
+				switch {
+				case err == nil:
+				case errors.Is(err, client.ErrNotFound) && op != opList && op != opScan:
+					s.misses++
+				default:
+					s.errors++
+					if s.firstErr == nil {
+						s.firstErr = err
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	merged := make(map[string]*opStats)
+	for _, stats := range perWorker {
+		for op, s := range stats {
+			if merged[op] == nil {
+				merged[op] = &opStats{}
+			}
+			merged[op].merge(s)
+		}
+	}
+	return merged, elapsed
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// opStats collects the outcomes of one operation for one worker, so workers
+// never share them while running
+type opStats struct {
+	latencies []time.Duration
+	errors    int
+	// misses counts reads and updates of records deleted in the meantime
+	misses int
+	// firstErr keeps one example error for the report
+	firstErr error
+}
+
+func (s *opStats) merge(other *opStats) {
+	s.latencies = append(s.latencies, other.latencies...)
+	s.errors += other.errors
+	s.misses += other.misses
+	if s.firstErr == nil {
+		s.firstErr = other.firstErr
+	}
+}
+
+// opReport summarizes one operation. Latencies are in milliseconds.
+type opReport struct {
+	Op         string  `json:"op"`
+	Count      int     `json:"count"`
+	Errors     int     `json:"errors"`
+	Misses     int     `json:"misses"`
+	Throughput float64 `json:"ops_per_sec"`
+	Mean       float64 `json:"mean_ms"`
+	P50        float64 `json:"p50_ms"`
+	P90        float64 `json:"p90_ms"`
+	P99        float64 `json:"p99_ms"`
+	Max        float64 `json:"max_ms"`
+	FirstError string  `json:"first_error,omitempty"`
+}
+
+// report is the result of a benchmark run
+type report struct {
+	Server  string     `json:"server"`
+	Clients int        `json:"clients"`
+	Mix     string     `json:"mix"`
+	Seeded  int        `json:"seeded"`
+	Elapsed float64    `json:"elapsed_sec"`
+	Ops     []opReport `json:"ops"`
+	Total   opReport   `json:"total"`
+}
+
+func summarize(op string, s *opStats, elapsed time.Duration) opReport {
+	r := opReport{Op: op, Count: len(s.latencies), Errors: s.errors, Misses: s.misses}
+	if s.firstErr != nil {
+		r.FirstError = s.firstErr.Error()
+	}
+	if elapsed > 0 {
+		r.Throughput = float64(r.Count) / elapsed.Seconds()
+	}
+	if r.Count == 0 {
+		return r
+	}
+
+	sorted := append([]time.Duration(nil), s.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var sum time.Duration
+	for _, d := range sorted {
+		sum += d
+	}
+	r.Mean = ms(sum / time.Duration(len(sorted)))
+	r.P50 = ms(percentile(sorted, 50))
+	r.P90 = ms(percentile(sorted, 90))
+	r.P99 = ms(percentile(sorted, 99))
+	r.Max = ms(sorted[len(sorted)-1])
+	return r
+}
+
+// percentile returns the nearest-rank percentile of sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func (r *report) writeText(w io.Writer) error {
+	fmt.Fprintf(w, "%d clients ran %s against %s for %.1fs, starting from %d records\n\n", r.Clients, r.Mix, r.Server, r.Elapsed, r.Seeded)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "OP\tCOUNT\tERRORS\tMISSES\tOPS/S\tMEAN\tP50\tP90\tP99\tMAX\t")
+	for _, op := range append(r.Ops, r.Total) {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%.1f\t%.2fms\t%.2fms\t%.2fms\t%.2fms\t%.2fms\t\n",
+			op.Op, op.Count, op.Errors, op.Misses, op.Throughput, op.Mean, op.P50, op.P90, op.P99, op.Max)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, op := range r.Ops {
+		if op.FirstError != "" {
+			fmt.Fprintf(w, "\nfirst %s error: %s", op.Op, op.FirstError)
+		}
+	}
+	fmt.Fprintln(w)
+	return nil
+}
+
+func (r *report) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
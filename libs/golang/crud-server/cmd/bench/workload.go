@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dae-go/crud-server/pkg/client"
+	"github.com/dae-go/crud-server/pkg/db"
+)
+
+// Operations a workload can mix
+const (
+	opGet    = "get"
+	opList   = "list"
+	opScan   = "scan"
+	opInsert = "insert"
+	opUpdate = "update"
+	opDelete = "delete"
+)
+
+// opOrder is the order operations are reported in
+var opOrder = []string{opGet, opList, opScan, opInsert, opUpdate, opDelete}
+
+// mix holds the relative weight of each operation
+type mix struct {
+	ops     []string
+	weights []int
+	total   int
+}
+
+// parseMix parses weights such as "get=60,insert=20,update=20"
+func parseMix(spec string) (*mix, error) {
+	m := &mix{}
+	seen := map[string]bool{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		weight, err := strconv.Atoi(value)
+		if !ok || err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid mix entry %q (expected op=weight)", part)
+		}
+		if !contains(opOrder, name) {
+			return nil, fmt.Errorf("unknown operation %q (expected one of %s)", name, strings.Join(opOrder, ", "))
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("operation %q appears twice in the mix", name)
+		}
+		seen[name] = true
+		if weight == 0 {
+			continue
+		}
+		m.ops = append(m.ops, name)
+		m.weights = append(m.weights, weight)
+		m.total += weight
+	}
+	if m.total == 0 {
+		return nil, errors.New("the mix needs at least one operation with a positive weight")
+	}
+	return m, nil
+}
+
+// pick chooses an operation with probability proportional to its weight
+func (m *mix) pick(rng *rand.Rand) string {
+	n := rng.Intn(m.total)
+	for i, w := range m.weights {
+		if n < w {
+			return m.ops[i]
+		}
+		n -= w
+	}
+	return m.ops[len(m.ops)-1]
+}
+
+func (m *mix) String() string {
+	parts := make([]string, len(m.ops))
+	for i, op := range m.ops {
+		parts[i] = fmt.Sprintf("%s=%d", op, m.weights[i])
+	}
+	return strings.Join(parts, ",")
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// idPool tracks the ids of the records the benchmark created, so reads,
+// updates and deletes target records that should exist
+type idPool struct {
+	mu  sync.Mutex
+	ids []int
+}
+
+func (p *idPool) add(id int) {
+	p.mu.Lock()
+	p.ids = append(p.ids, id)
+	p.mu.Unlock()
+}
+
+// random returns a known id, or false when the pool is empty
+func (p *idPool) random(rng *rand.Rand) (int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.ids) == 0 {
+		return 0, false
+	}
+	return p.ids[rng.Intn(len(p.ids))], true
+}
+
+// take removes and returns a random id
+func (p *idPool) take(rng *rand.Rand) (int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.ids) == 0 {
+		return 0, false
+	}
+	i := rng.Intn(len(p.ids))
+	id := p.ids[i]
+	p.ids[i] = p.ids[len(p.ids)-1]
+	p.ids = p.ids[:len(p.ids)-1]
+	return id, true
+}
+
+// workload runs operations against one table
+type workload struct {
+	client   *client.Client
+	table    string
+	ids      *idPool
+	payload  string
+	pageSize int
+}
+
+// benchTable is the definition of the table the benchmark writes to
+func benchTable(name string) *db.Table {
+	return &db.Table{Name: name, Columns: []db.Column{
+		{Name: "n", Type: "number"},
+		{Name: "name", Type: "string"},
+		{Name: "payload", Type: "string"},
+	}}
+}
+
+func (w *workload) newRecord(rng *rand.Rand) map[string]interface{} {
+	n := rng.Intn(1_000_000)
+	return map[string]interface{}{
+		"n":       n,
+		"name":    "record-" + strconv.Itoa(n),
+		"payload": w.payload,
+	}
+}
+
+// run performs one operation. skipped is set when there was nothing to do,
+// e.g. an update while no records exist.
+func (w *workload) run(ctx context.Context, op string, rng *rand.Rand) (skipped bool, err error) {
+	switch op {
+	case opGet:
+		id, ok := w.ids.random(rng)
+		if !ok {
+			return true, nil
+		}
+		_, err = w.client.GetRecordContext(ctx, w.table, id)
+	case opList:
+		_, err = w.client.ListPageContext(ctx, w.table, 0, w.pageSize)
+	case opScan:
+		// A range filter touches every record of the table
+		threshold := rng.Intn(1_000_000)
+		_, err = w.client.ListPageContext(ctx, w.table, 0, w.pageSize, db.Where("n", db.OpGte, threshold))
+	case opInsert:
+		var record map[string]interface{}
+		record, err = w.client.CreateRecordContext(ctx, w.table, w.newRecord(rng))
+		if err == nil {
+			if id, ok := record["id"].(float64); ok {
+				w.ids.add(int(id))
+			}
+		}
+	case opUpdate:
+		id, ok := w.ids.random(rng)
+		if !ok {
+			return true, nil
+		}
+		_, err = w.client.UpdateRecordContext(ctx, w.table, map[string]interface{}{"id": id, "n": rng.Intn(1_000_000)})
+	case opDelete:
+		id, ok := w.ids.take(rng)
+		if !ok {
+			return true, nil
+		}
+		err = w.client.DeleteRecordContext(ctx, w.table, id)
+	}
+	return false, err
+}
+
+// sortedOps returns the operations in stats in report order
+func sortedOps(stats map[string]*opStats) []string {
+	ops := make([]string, 0, len(stats))
+	for op := range stats {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		return indexOf(opOrder, ops[i]) < indexOf(opOrder, ops[j])
+	})
+	return ops
+}
+
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return len(list)
+}
@@ -0,0 +1,575 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dae-go/crud-server/pkg/client"
+	"github.com/dae-go/crud-server/pkg/db"
+)
+
+// app holds the state shared by one-shot commands and the shell
+type app struct {
+	ctx      context.Context
+	client   *client.Client
+	out      io.Writer
+	json     bool
+	pageSize int
+
+	// schemas caches table definitions for output ordering and completion
+	schemas map[string]*db.Table
+	// tables caches the table list for completion in the shell
+	tables []string
+	editor *lineEditor
+}
+
+// argKind tells the shell how to complete a positional argument
+type argKind int
+
+const (
+	argNone argKind = iota
+	argTable
+	argColumn // column=value pairs or filters for the preceding table
+)
+
+type command struct {
+	name  string
+	usage string
+	help  string
+	args  []argKind // completion hint per positional argument; the last repeats
+	run   func(a *app, args []string) error
+	shell bool // only available in interactive mode
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{name: "tables", help: "List all tables", run: (*app).listTables},
+		{name: "schema", usage: "<table>", help: "Show a table's columns", args: []argKind{argTable}, run: (*app).showSchema},
+		{name: "create-table", usage: "<name> <column:type[:ref]>... [search=col,col] [ttl=duration] [expire=delete|soft] [owner=col [read=owner|all]]", help: "Create a table", run: (*app).createTable},
+		{name: "stats", usage: "[table]", help: "Show statistics for every table, or details for one", args: []argKind{argTable}, run: (*app).showStats},
+		{name: "compact", help: "Fold the server's journal into a new snapshot", run: (*app).compact},
+		{name: "drop-table", usage: "<table>", help: "Delete a table", args: []argKind{argTable}, run: (*app).dropTable},
+		{name: "list", usage: "<table> [filter]...", help: "List records, e.g. list users age>=30", args: []argKind{argTable, argColumn}, run: (*app).listRecords},
+		{name: "get", usage: "<table> <id>", help: "Show a record", args: []argKind{argTable, argNone}, run: (*app).getRecord},
+		{name: "insert", usage: "<table> <column=value>...", help: "Insert a record", args: []argKind{argTable, argColumn}, run: (*app).insertRecord},
+		{name: "update", usage: "<table> <id> <column=value>...", help: "Update fields of a record", args: []argKind{argTable, argNone, argColumn}, run: (*app).updateRecord},
+		{name: "delete", usage: "<table> <id>", help: "Delete a record", args: []argKind{argTable, argNone}, run: (*app).deleteRecord},
+		{name: "search", usage: "<table> <query>...", help: "Full-text search a table", args: []argKind{argTable, argNone}, run: (*app).search},
+		{name: "browse", usage: "<table> [filter]...", help: "Page through records interactively", args: []argKind{argTable, argColumn}, run: (*app).browse, shell: true},
+		{name: "edit", usage: "<table> <id>", help: "Edit a record field by field", args: []argKind{argTable, argNone}, run: (*app).edit, shell: true},
+		{name: "history", help: "Show command history", shell: true},
+		{name: "help", help: "Show this help", shell: true},
+		{name: "exit", help: "Leave the shell", shell: true},
+	}
+}
+
+func lookupCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+func printCommands(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, c := range commands {
+		suffix := ""
+		if c.shell {
+			suffix = " (shell only)"
+		}
+		fmt.Fprintf(tw, "  %s %s\t%s%s\n", c.name, c.usage, c.help, suffix)
+	}
+	tw.Flush()
+}
+
+// run executes a one-shot command
+func (a *app) run(args []string) error {
+	cmd, ok := lookupCommand(args[0])
+	if !ok || cmd.run == nil || cmd.shell {
+		return fmt.Errorf("unknown command %q (run crudctl -h for a list)", args[0])
+	}
+	return cmd.run(a, args[1:])
+}
+
+// schema returns a table's definition, fetching it once
+func (a *app) schema(name string) (*db.Table, error) {
+	if t, ok := a.schemas[name]; ok {
+		return t, nil
+	}
+	t, err := a.client.GetTableContext(a.ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if a.schemas == nil {
+		a.schemas = make(map[string]*db.Table)
+	}
+	a.schemas[name] = t
+	return t, nil
+}
+
+func need(args []string, n int, usage string) error {
+	if len(args) < n {
+		return fmt.Errorf("usage: %s", usage)
+	}
+	return nil
+}
+
+func (a *app) listTables(args []string) error {
+	tables, err := a.client.ListTablesContext(a.ctx)
+	if err != nil {
+		return err
+	}
+	sort.Strings(tables)
+	if a.json {
+		return a.printJSON(tables)
+	}
+	if len(tables) == 0 {
+		fmt.Fprintln(a.out, "No tables found")
+		return nil
+	}
+	for _, t := range tables {
+		fmt.Fprintln(a.out, t)
+	}
+	return nil
+}
+
+func (a *app) showSchema(args []string) error {
+	if err := need(args, 1, "schema <table>"); err != nil {
+		return err
+	}
+	t, err := a.schema(args[0])
+	if err != nil {
+		return err
+	}
+	if a.json {
+		return a.printJSON(t)
+	}
+	tw := tabwriter.NewWriter(a.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "COLUMN\tTYPE\tSEARCH")
+	for _, col := range t.Columns {
+		indexed := ""
+		for _, s := range t.SearchColumns {
+			if s == col.Name {
+				indexed = "yes"
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", col.Name, col.Type, indexed)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if t.TTL != "" {
+		mode := t.ExpireMode
+		if mode == "" {
+			mode = db.ExpireDelete
+		}
+		fmt.Fprintf(a.out, "\nRecords expire after %s (%s)\n", t.TTL, mode)
+	}
+	return nil
+}
+
+func (a *app) showStats(args []string) error {
+	if len(args) == 0 {
+		return a.showDatabaseStats()
+	}
+	stats, err := a.client.GetTableStatsContext(a.ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if a.json {
+		return a.printJSON(stats)
+	}
+	tw := tabwriter.NewWriter(a.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "records:\t%d\n", stats.Records)
+	fmt.Fprintf(tw, "approx size:\t%s\n", formatBytes(stats.ApproxBytes))
+	if stats.IndexTerms > 0 {
+		fmt.Fprintf(tw, "index:\t%d terms, %d postings\n", stats.IndexTerms, stats.IndexPostings)
+	}
+	fmt.Fprintf(tw, "expiring:\t%d\n", stats.Expiring)
+	fmt.Fprintf(tw, "expired:\t%d\n", stats.Expired)
+	fmt.Fprintf(tw, "soft deleted:\t%d\n", stats.SoftDeleted)
+	if stats.TTL != "" {
+		fmt.Fprintf(tw, "ttl:\t%s\n", stats.TTL)
+	}
+	if stats.NextExpiry != nil {
+		fmt.Fprintf(tw, "next expiry:\t%s\n", stats.NextExpiry.Local().Format(time.DateTime))
+	}
+	if stats.LastSweep != nil {
+		fmt.Fprintf(tw, "last sweep:\t%s\n", stats.LastSweep.Local().Format(time.DateTime))
+	}
+	fmt.Fprintf(tw, "changes:\t%d inserted, %d updated, %d deleted, %d expired\n",
+		stats.Mutations[db.OpInsert], stats.Mutations[db.OpUpdate], stats.Mutations[db.OpDelete], stats.Mutations[db.OpExpire])
+	fmt.Fprintf(tw, "changes last minute:\t%d\n", stats.MutationsLastMinute)
+	if stats.LastModified != nil {
+		fmt.Fprintf(tw, "last modified:\t%s\n", stats.LastModified.Local().Format(time.DateTime))
+	}
+	return tw.Flush()
+}
+
+func (a *app) showDatabaseStats() error {
+	stats, err := a.client.StatsContext(a.ctx)
+	if err != nil {
+		return err
+	}
+	if a.json {
+		return a.printJSON(stats)
+	}
+
+	tw := tabwriter.NewWriter(a.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TABLE\tRECORDS\tSIZE\tINDEX TERMS\tCHANGES/MIN\tLAST MODIFIED")
+	for _, t := range stats.Tables {
+		modified := "-"
+		if t.LastModified != nil {
+			modified = t.LastModified.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%d\t%d\t%s\n", t.Table, t.Records, formatBytes(t.ApproxBytes), t.IndexTerms, t.MutationsLastMinute, modified)
+	}
+	fmt.Fprintf(tw, "total\t%d\t%s\t\t\t\n", stats.Records, formatBytes(stats.ApproxBytes))
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if s := stats.Storage; s != nil {
+		fmt.Fprintf(a.out, "\nStorage in %s: snapshot %s, journal %s in %d entries\n", s.Dir, formatBytes(s.SnapshotBytes), formatBytes(s.JournalBytes), s.JournalEntries)
+		if s.LastCompaction != nil {
+			fmt.Fprintf(a.out, "Last compaction: %s\n", s.LastCompaction.Local().Format(time.DateTime))
+		}
+		if s.Error != "" {
+			fmt.Fprintf(a.out, "Storage error: %s\n", s.Error)
+		}
+	}
+	return nil
+}
+
+func (a *app) compact(args []string) error {
+	result, err := a.client.CompactContext(a.ctx)
+	if err != nil {
+		return err
+	}
+	if a.json {
+		return a.printJSON(result)
+	}
+	fmt.Fprintf(a.out, "Compacted %d tables (%d records) into a %s snapshot, folding in %d journal entries\n",
+		result.Tables, result.Records, formatBytes(result.SnapshotBytes), result.JournalEntries)
+	return nil
+}
+
+// formatBytes renders a byte count with a binary unit, e.g. 1.5 KiB
+func formatBytes(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	value := float64(n)
+	for _, unit := range []string{"KiB", "MiB", "GiB"} {
+		value /= 1024
+		if value < 1024 || unit == "GiB" {
+			return fmt.Sprintf("%.1f %s", value, unit)
+		}
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+func (a *app) createTable(args []string) error {
+	if err := need(args, 2, "create-table <name> <column:type[:ref]>... [search=col,col] [ttl=duration] [expire=delete|soft] [owner=col [read=owner|all]]"); err != nil {
+		return err
+	}
+	table := &db.Table{Name: args[0]}
+	for _, arg := range args[1:] {
+		if cols, ok := strings.CutPrefix(arg, "search="); ok {
+			table.SearchColumns = strings.Split(cols, ",")
+			continue
+		}
+		if ttl, ok := strings.CutPrefix(arg, "ttl="); ok {
+			table.TTL = ttl
+			continue
+		}
+		if mode, ok := strings.CutPrefix(arg, "expire="); ok {
+			table.ExpireMode = mode
+			continue
+		}
+		if col, ok := strings.CutPrefix(arg, "owner="); ok {
+			if table.Policy == nil {
+				table.Policy = &db.RowPolicy{}
+			}
+			table.Policy.OwnerColumn = col
+			continue
+		}
+		if mode, ok := strings.CutPrefix(arg, "read="); ok {
+			if table.Policy == nil {
+				table.Policy = &db.RowPolicy{}
+			}
+			table.Policy.Read = mode
+			continue
+		}
+		name, typ, ok := strings.Cut(arg, ":")
+		typ, ref, _ := strings.Cut(typ, ":")
+		if !ok || name == "" || typ == "" {
+			return fmt.Errorf("invalid column %q (expected name:type or name:type:ref_table)", arg)
+		}
+		table.Columns = append(table.Columns, db.Column{Name: name, Type: typ, Ref: ref})
+	}
+	if err := a.client.CreateTableContext(a.ctx, table); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "Table '%s' created\n", table.Name)
+	return nil
+}
+
+func (a *app) dropTable(args []string) error {
+	if err := need(args, 1, "drop-table <table>"); err != nil {
+		return err
+	}
+	if err := a.client.DeleteTableContext(a.ctx, args[0]); err != nil {
+		return err
+	}
+	delete(a.schemas, args[0])
+	fmt.Fprintf(a.out, "Table '%s' deleted\n", args[0])
+	return nil
+}
+
+func parseFilters(args []string) ([]db.Filter, error) {
+	filters := make([]db.Filter, 0, len(args))
+	for _, arg := range args {
+		f, err := db.ParseFilter(arg)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	return filters, nil
+}
+
+func (a *app) listRecords(args []string) error {
+	if err := need(args, 1, "list <table> [filter]..."); err != nil {
+		return err
+	}
+	filters, err := parseFilters(args[1:])
+	if err != nil {
+		return err
+	}
+	records, err := a.client.FindRecordsContext(a.ctx, args[0], filters...)
+	if err != nil {
+		return err
+	}
+	if a.json {
+		return a.printJSON(records)
+	}
+	if len(records) == 0 {
+		fmt.Fprintln(a.out, "No records found")
+		return nil
+	}
+	return a.printRecords(args[0], records)
+}
+
+func (a *app) getRecord(args []string) error {
+	if err := need(args, 2, "get <table> <id>"); err != nil {
+		return err
+	}
+	record, err := a.client.GetRecordContext(a.ctx, args[0], args[1])
+	if err != nil {
+		return err
+	}
+	if a.json {
+		return a.printJSON(record)
+	}
+	tw := tabwriter.NewWriter(a.out, 0, 0, 2, ' ', 0)
+	for _, col := range a.columnOrder(args[0], []map[string]interface{}{record}) {
+		fmt.Fprintf(tw, "%s:\t%s\n", col, formatValue(record[col]))
+	}
+	return tw.Flush()
+}
+
+// parseAssignments turns column=value arguments into a record. Values that
+// parse as JSON (numbers, booleans, null, quoted strings) keep their type;
+// anything else is a string.
+func parseAssignments(args []string) (map[string]interface{}, error) {
+	record := make(map[string]interface{}, len(args))
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid assignment %q (expected column=value)", arg)
+		}
+		record[key] = parseValue(value)
+	}
+	return record, nil
+}
+
+func parseValue(s string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err == nil {
+		switch v.(type) {
+		case float64, bool, string, nil:
+			return v
+		}
+	}
+	return s
+}
+
+func (a *app) insertRecord(args []string) error {
+	if err := need(args, 2, "insert <table> <column=value>..."); err != nil {
+		return err
+	}
+	record, err := parseAssignments(args[1:])
+	if err != nil {
+		return err
+	}
+	created, err := a.client.CreateRecordContext(a.ctx, args[0], record)
+	if err != nil {
+		return err
+	}
+	if a.json {
+		return a.printJSON(created)
+	}
+	fmt.Fprintf(a.out, "Record %v created\n", formatValue(created["id"]))
+	return nil
+}
+
+func (a *app) updateRecord(args []string) error {
+	if err := need(args, 3, "update <table> <id> <column=value>..."); err != nil {
+		return err
+	}
+	id, err := strconv.Atoi(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid id %q", args[1])
+	}
+	record, err := parseAssignments(args[2:])
+	if err != nil {
+		return err
+	}
+	record["id"] = id
+	updated, err := a.client.UpdateRecordContext(a.ctx, args[0], record)
+	if err != nil {
+		return err
+	}
+	if a.json {
+		return a.printJSON(updated)
+	}
+	fmt.Fprintf(a.out, "Record %d updated\n", id)
+	return nil
+}
+
+func (a *app) deleteRecord(args []string) error {
+	if err := need(args, 2, "delete <table> <id>"); err != nil {
+		return err
+	}
+	id, err := strconv.Atoi(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid id %q", args[1])
+	}
+	if err := a.client.DeleteRecordContext(a.ctx, args[0], id); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "Record %d deleted\n", id)
+	return nil
+}
+
+func (a *app) search(args []string) error {
+	if err := need(args, 2, "search <table> <query>..."); err != nil {
+		return err
+	}
+	results, err := a.client.SearchContext(a.ctx, args[0], strings.Join(args[1:], " "), a.pageSize)
+	if err != nil {
+		return err
+	}
+	if a.json {
+		return a.printJSON(results)
+	}
+	if len(results) == 0 {
+		fmt.Fprintln(a.out, "No matching records found")
+		return nil
+	}
+	for _, r := range results {
+		fmt.Fprintf(a.out, "%v (score %.3f)\n", formatValue(r.Record["id"]), r.Score)
+		for col, text := range r.Highlights {
+			fmt.Fprintf(a.out, "  %s: %s\n", col, html.UnescapeString(text))
+		}
+	}
+	return nil
+}
+
+func (a *app) printJSON(v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(a.out, string(data))
+	return err
+}
+
+// columnOrder lists id first, then the schema's columns, then any other
+// fields present in records in alphabetical order
+func (a *app) columnOrder(table string, records []map[string]interface{}) []string {
+	seen := map[string]bool{"id": true}
+	cols := []string{"id"}
+	if t, err := a.schema(table); err == nil {
+		for _, c := range t.Columns {
+			if !seen[c.Name] {
+				seen[c.Name] = true
+				cols = append(cols, c.Name)
+			}
+		}
+	}
+	var extra []string
+	for _, r := range records {
+		for k := range r {
+			if !seen[k] {
+				seen[k] = true
+				extra = append(extra, k)
+			}
+		}
+	}
+	sort.Strings(extra)
+	return append(cols, extra...)
+}
+
+func (a *app) printRecords(table string, records []map[string]interface{}) error {
+	cols := a.columnOrder(table, records)
+	tw := tabwriter.NewWriter(a.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.ToUpper(strings.Join(cols, "\t")))
+	for _, r := range records {
+		cells := make([]string, len(cols))
+		for i, c := range cols {
+			cells[i] = truncate(formatValue(r[c]), 40)
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	return tw.Flush()
+}
+
+// formatValue prints whole numbers without a fraction, since ids and
+// counts come back from JSON as float64
+func formatValue(v interface{}) string {
+	switch n := v.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	case string:
+		return n
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+func truncate(s string, max int) string {
+	r := []rune(s)
+	if len(r) <= max {
+		return s
+	}
+	return string(r[:max-3]) + "..."
+}
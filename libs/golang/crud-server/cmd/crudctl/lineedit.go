@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// errInterrupt is returned by readLine when the user presses Ctrl+C
+var errInterrupt = errors.New("interrupted")
+
+const maxHistory = 500
+
+// completer returns the candidates for the word ending at the end of line
+type completer func(line string) []string
+
+// lineEditor reads lines from a terminal with history navigation and tab
+// completion. When the input is not a terminal it reads plain lines.
+type lineEditor struct {
+	in       *bufio.Reader
+	out      io.Writer
+	fd       int
+	raw      bool
+	history  []string
+	histFile string
+	complete completer
+}
+
+func newLineEditor(in *os.File, out io.Writer, histFile string, complete completer) *lineEditor {
+	e := &lineEditor{
+		in:       bufio.NewReader(in),
+		out:      out,
+		fd:       int(in.Fd()),
+		raw:      isTerminal(int(in.Fd())),
+		histFile: histFile,
+		complete: complete,
+	}
+	e.loadHistory()
+	return e
+}
+
+func (e *lineEditor) loadHistory() {
+	if e.histFile == "" {
+		return
+	}
+	data, err := os.ReadFile(e.histFile)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			e.history = append(e.history, line)
+		}
+	}
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+}
+
+// addHistory records line in memory and appends it to the history file
+func (e *lineEditor) addHistory(line string) {
+	if line == "" || (len(e.history) > 0 && e.history[len(e.history)-1] == line) {
+		return
+	}
+	e.history = append(e.history, line)
+	if e.histFile == "" {
+		return
+	}
+	f, err := os.OpenFile(e.histFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, line)
+}
+
+// readLine prompts for a line, pre-filled with initial
+func (e *lineEditor) readLine(prompt, initial string) (string, error) {
+	if !e.raw {
+		return e.readPlain(prompt, initial)
+	}
+
+	restore, err := makeRaw(e.fd)
+	if err != nil {
+		e.raw = false
+		return e.readPlain(prompt, initial)
+	}
+	defer restore()
+
+	buf := []rune(initial)
+	pos := len(buf)
+	histPos := len(e.history)
+	saved := ""
+
+	redraw := func() {
+		fmt.Fprintf(e.out, "\r%s%s\x1b[K", prompt, string(buf))
+		if back := len(buf) - pos; back > 0 {
+			fmt.Fprintf(e.out, "\x1b[%dD", back)
+		}
+	}
+	redraw()
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			return string(buf), nil
+		case 3: // Ctrl+C
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupt
+		case 4: // Ctrl+D
+			if len(buf) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			if pos < len(buf) {
+				buf = append(buf[:pos], buf[pos+1:]...)
+			}
+		case 1: // Ctrl+A
+			pos = 0
+		case 5: // Ctrl+E
+			pos = len(buf)
+		case 11: // Ctrl+K
+			buf = buf[:pos]
+		case 21: // Ctrl+U
+			buf = buf[pos:]
+			pos = 0
+		case 127, 8: // Backspace
+			if pos > 0 {
+				buf = append(buf[:pos-1], buf[pos:]...)
+				pos--
+			}
+		case '\t':
+			buf, pos = e.completeAt(prompt, buf, pos)
+		case 27: // Escape sequence
+			seq := e.readEscape()
+			switch seq {
+			case "[A": // Up
+				if histPos > 0 {
+					if histPos == len(e.history) {
+						saved = string(buf)
+					}
+					histPos--
+					buf = []rune(e.history[histPos])
+					pos = len(buf)
+				}
+			case "[B": // Down
+				if histPos < len(e.history) {
+					histPos++
+					if histPos == len(e.history) {
+						buf = []rune(saved)
+					} else {
+						buf = []rune(e.history[histPos])
+					}
+					pos = len(buf)
+				}
+			case "[C": // Right
+				if pos < len(buf) {
+					pos++
+				}
+			case "[D": // Left
+				if pos > 0 {
+					pos--
+				}
+			case "[H", "OH", "[1~":
+				pos = 0
+			case "[F", "OF", "[4~":
+				pos = len(buf)
+			case "[3~": // Delete
+				if pos < len(buf) {
+					buf = append(buf[:pos], buf[pos+1:]...)
+				}
+			}
+		default:
+			if unicode.IsPrint(r) {
+				buf = append(buf[:pos], append([]rune{r}, buf[pos:]...)...)
+				pos++
+			}
+		}
+		redraw()
+	}
+}
+
+// readEscape reads the remainder of an ANSI escape sequence
+func (e *lineEditor) readEscape() string {
+	var seq []rune
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return string(seq)
+		}
+		seq = append(seq, r)
+		if len(seq) > 1 && (unicode.IsLetter(r) || r == '~') {
+			return string(seq)
+		}
+		if len(seq) > 6 {
+			return string(seq)
+		}
+	}
+}
+
+// completeAt completes the word before the cursor. A single candidate is
+// inserted; several candidates are extended to their common prefix and
+// listed below the prompt.
+func (e *lineEditor) completeAt(prompt string, buf []rune, pos int) ([]rune, int) {
+	if e.complete == nil {
+		return buf, pos
+	}
+	head := string(buf[:pos])
+	candidates := e.complete(head)
+	if len(candidates) == 0 {
+		return buf, pos
+	}
+
+	word := head[strings.LastIndexAny(head, " \t")+1:]
+	insert := commonPrefix(candidates)[len(word):]
+	// A completed word ending in '=' expects a value, not a new word
+	if len(candidates) == 1 && !strings.HasSuffix(candidates[0], "=") {
+		insert += " "
+	} else if insert == "" {
+		fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(candidates, "  "))
+	}
+
+	tail := append([]rune(insert), buf[pos:]...)
+	buf = append(buf[:pos], tail...)
+	return buf, pos + len([]rune(insert))
+}
+
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, w := range words[1:] {
+		for !strings.HasPrefix(w, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// readPlain reads a line without terminal control. An empty answer keeps
+// the initial value.
+func (e *lineEditor) readPlain(prompt, initial string) (string, error) {
+	if initial != "" {
+		prompt = fmt.Sprintf("%s[%s] ", prompt, initial)
+	}
+	fmt.Fprint(e.out, prompt)
+
+	line, err := e.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return initial, nil
+	}
+	return line, nil
+}
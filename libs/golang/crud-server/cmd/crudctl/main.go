@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dae-go/crud-server/pkg/client"
+)
+
+func main() {
+	var (
+		serverURL = flag.String("server", "http://localhost:8080", "Server URL")
+		token     = flag.String("token", os.Getenv("CRUD_TOKEN"), "Bearer token sent with every request (defaults to $CRUD_TOKEN)")
+		timeout   = flag.Duration("timeout", 10*time.Second, "Timeout for each request")
+		jsonOut   = flag.Bool("json", false, "Output in JSON format")
+		pageSize  = flag.Int("page-size", 20, "Records per page when browsing")
+	)
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: crudctl [flags] <command> [args]\n\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "Run without a command (or with \"shell\") for interactive mode.\n\nCommands:\n")
+		printCommands(flag.CommandLine.Output())
+		fmt.Fprintf(flag.CommandLine.Output(), "\nFlags:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	opts := []client.Option{
+		client.WithTimeout(*timeout),
+		client.WithUserAgent("crudctl"),
+		client.WithRetryPolicy(client.DefaultRetryPolicy),
+	}
+	if *token != "" {
+		opts = append(opts, client.WithAuthToken(*token))
+	}
+
+	a := &app{
+		ctx:      context.Background(),
+		client:   client.NewClient(*serverURL, opts...),
+		out:      os.Stdout,
+		json:     *jsonOut,
+		pageSize: *pageSize,
+	}
+
+	args := flag.Args()
+	if len(args) == 0 || args[0] == "shell" {
+		if err := a.shell(historyPath()); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := a.run(args); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+// historyPath returns the shell history file in the user's home directory
+func historyPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".crudctl_history")
+}
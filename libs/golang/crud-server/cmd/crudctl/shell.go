@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// shell runs the interactive console until exit or end of input
+func (a *app) shell(histFile string) error {
+	a.editor = newLineEditor(os.Stdin, a.out, histFile, a.completions)
+	fmt.Fprintln(a.out, "crudctl interactive mode. Type 'help' for commands, Tab to complete, Ctrl+D to exit.")
+
+	for {
+		line, err := a.editor.readLine("crud> ", "")
+		if errors.Is(err, errInterrupt) {
+			continue
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		args, err := splitArgs(line)
+		if err != nil {
+			fmt.Fprintln(a.out, "Error:", err)
+			continue
+		}
+		if len(args) == 0 {
+			continue
+		}
+		a.editor.addHistory(strings.TrimSpace(line))
+
+		switch args[0] {
+		case "exit", "quit":
+			return nil
+		case "help":
+			printCommands(a.out)
+			continue
+		case "history":
+			for i, h := range a.editor.history {
+				fmt.Fprintf(a.out, "%4d  %s\n", i+1, h)
+			}
+			continue
+		}
+
+		cmd, ok := lookupCommand(args[0])
+		if !ok || cmd.run == nil {
+			fmt.Fprintf(a.out, "Unknown command %q. Type 'help' for a list.\n", args[0])
+			continue
+		}
+		if err := cmd.run(a, args[1:]); err != nil {
+			fmt.Fprintln(a.out, "Error:", err)
+		}
+		if cmd.name == "create-table" || cmd.name == "drop-table" {
+			a.tables = nil
+		}
+	}
+}
+
+// splitArgs splits a line into words, honouring single and double quotes
+// and backslash escapes
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	var cur strings.Builder
+	inWord := false
+	var quote rune
+
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '\\' && quote != '\'' && i+1 < len(runes):
+			i++
+			cur.WriteRune(runes[i])
+			inWord = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				args = append(args, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		default:
+			cur.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if inWord {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
+
+// tableNames returns the cached table list, fetching it when needed
+func (a *app) tableNames() []string {
+	if a.tables == nil {
+		tables, err := a.client.ListTablesContext(a.ctx)
+		if err != nil {
+			return nil
+		}
+		sort.Strings(tables)
+		a.tables = tables
+	}
+	return a.tables
+}
+
+// completions returns candidates for the last word of line based on the
+// command's argument kinds
+func (a *app) completions(line string) []string {
+	words := strings.Fields(line)
+	if len(words) == 0 || strings.HasSuffix(line, " ") {
+		words = append(words, "")
+	}
+	word := words[len(words)-1]
+
+	var options []string
+	if len(words) == 1 {
+		for _, c := range commands {
+			options = append(options, c.name)
+		}
+	} else if cmd, ok := lookupCommand(words[0]); ok && len(cmd.args) > 0 {
+		pos := min(len(words)-2, len(cmd.args)-1)
+		switch cmd.args[pos] {
+		case argTable:
+			options = a.tableNames()
+		case argColumn:
+			if t, err := a.schema(words[1]); err == nil {
+				for _, c := range t.Columns {
+					options = append(options, c.Name+"=")
+				}
+			}
+		}
+	}
+
+	var matches []string
+	for _, o := range options {
+		if strings.HasPrefix(o, word) {
+			matches = append(matches, o)
+		}
+	}
+	return matches
+}
+
+// browse pages through a table's records
+func (a *app) browse(args []string) error {
+	if err := need(args, 1, "browse <table> [filter]..."); err != nil {
+		return err
+	}
+	table := args[0]
+	filters, err := parseFilters(args[1:])
+	if err != nil {
+		return err
+	}
+
+	offset := 0
+	for {
+		page, err := a.client.ListPageContext(a.ctx, table, offset, a.pageSize, filters...)
+		if err != nil {
+			return err
+		}
+
+		if len(page.Records) == 0 {
+			fmt.Fprintln(a.out, "No records found")
+		} else {
+			if err := a.printRecords(table, page.Records); err != nil {
+				return err
+			}
+		}
+		pages := max(1, (page.Total+a.pageSize-1)/a.pageSize)
+		fmt.Fprintf(a.out, "-- page %d/%d (%d records) -- [n]ext [p]rev [g <page>] [e <id>] edit [q]uit\n",
+			offset/a.pageSize+1, pages, page.Total)
+
+		line, err := a.editor.readLine("browse> ", "")
+		if errors.Is(err, errInterrupt) || err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			fields = []string{"n"}
+		}
+		switch fields[0] {
+		case "n", "next":
+			if offset+a.pageSize < page.Total {
+				offset += a.pageSize
+			}
+		case "p", "prev":
+			offset = max(0, offset-a.pageSize)
+		case "g", "goto":
+			if len(fields) > 1 {
+				if n, err := strconv.Atoi(fields[1]); err == nil && n >= 1 && n <= pages {
+					offset = (n - 1) * a.pageSize
+				}
+			}
+		case "e", "edit":
+			if len(fields) > 1 {
+				if err := a.edit([]string{table, fields[1]}); err != nil {
+					fmt.Fprintln(a.out, "Error:", err)
+				}
+			}
+		case "q", "quit":
+			return nil
+		}
+	}
+}
+
+// edit prompts for every column of a record, pre-filled with its current
+// value, and saves the fields that changed
+func (a *app) edit(args []string) error {
+	if err := need(args, 2, "edit <table> <id>"); err != nil {
+		return err
+	}
+	table := args[0]
+	record, err := a.client.GetRecordContext(a.ctx, table, args[1])
+	if err != nil {
+		return err
+	}
+
+	cols := a.columnOrder(table, []map[string]interface{}{record})
+	changes := map[string]interface{}{"id": record["id"]}
+	fmt.Fprintln(a.out, "Edit each field and press Enter (Ctrl+C to cancel).")
+	for _, col := range cols[1:] {
+		current := editableValue(record[col])
+		value, err := a.editor.readLine(col+": ", current)
+		if errors.Is(err, errInterrupt) || err == io.EOF {
+			fmt.Fprintln(a.out, "Edit cancelled")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if value != current {
+			changes[col] = parseValue(value)
+		}
+	}
+
+	if len(changes) == 1 {
+		fmt.Fprintln(a.out, "No changes")
+		return nil
+	}
+	if _, err := a.client.UpdateRecordContext(a.ctx, table, changes); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "Record %s updated (%d fields)\n", formatValue(record["id"]), len(changes)-1)
+	return nil
+}
+
+// editableValue renders a value so that parseValue reads it back with the
+// same type. Strings that would parse as another type are quoted.
+func editableValue(v interface{}) string {
+	s, ok := v.(string)
+	if !ok {
+		return formatValue(v)
+	}
+	if _, isString := parseValue(s).(string); !isString || strings.HasPrefix(s, "\"") {
+		return strconv.Quote(s)
+	}
+	return s
+}
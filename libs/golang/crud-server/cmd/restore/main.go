@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/dae-go/crud-server/pkg/client"
+	"github.com/dae-go/crud-server/pkg/db"
+)
+
+func main() {
+	var (
+		serverURL = flag.String("server", "http://localhost:8080", "Server URL")
+		token     = flag.String("token", os.Getenv("CRUD_TOKEN"), "Bearer token sent with every request (defaults to $CRUD_TOKEN)")
+		file      = flag.String("file", "", "Backup archive to restore")
+		strategy  = flag.String("strategy", "fail", "What to do with tables that already exist: fail, skip, replace or merge")
+	)
+
+	flag.Parse()
+
+	if *file == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	conflict, err := db.ParseConflictStrategy(*strategy)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		log.Fatal("Failed to open backup: ", err)
+	}
+	defer f.Close()
+
+	opts := []client.Option{client.WithUserAgent("crud-server-restore")}
+	if *token != "" {
+		opts = append(opts, client.WithAuthToken(*token))
+	}
+	c := client.NewClient(*serverURL, opts...)
+
+	results, err := c.Restore(f, conflict)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, r := range results {
+		fmt.Printf("  %s: %s (%d records)\n", r.Table, r.Action, r.Records)
+	}
+	fmt.Println("Restore completed successfully")
+}
@@ -4,12 +4,15 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"html"
 	"log"
 	"os"
 	"strconv"
 	"strings"
+	"text/tabwriter"
 
 	"github.com/dae-go/crud-server/pkg/client"
+	"github.com/dae-go/crud-server/pkg/db"
 )
 
 func main() {
@@ -18,11 +21,19 @@ func main() {
 		table     = flag.String("table", "", "Table name")
 		create    = flag.String("create", "", "Create a record with key:value pairs (e.g., name:John,age:30)")
 		list      = flag.Bool("list", false, "List all records in the table")
+		get       = flag.Int("get", -1, "Show a single record by ID")
 		update    = flag.String("update", "", "Update a record by ID with key:value pairs (e.g., 1,name:Jane,age:25)")
 		deleteID  = flag.Int("delete", -1, "Delete a record by ID")
+		search    = flag.String("search", "", "Full-text search the table's search columns")
+		limit     = flag.Int("limit", 0, "Maximum number of search results (0 for all)")
+		aggregate = flag.String("aggregate", "", "Aggregate records with count, sum, avg, min, max or count_distinct (e.g., count,avg(age))")
+		groupBy   = flag.String("group-by", "", "Group aggregates by these columns (e.g., country,city)")
 		json      = flag.Bool("json", false, "Output in JSON format")
 	)
 
+	var filters filterFlags
+	flag.Var(&filters, "where", "Filter listed or aggregated records (e.g., age>=30, name~smith); may be repeated")
+
 	flag.Parse()
 
 	if *table == "" {
@@ -35,17 +46,43 @@ func main() {
 	case *create != "":
 		createRecord(c, *table, *create)
 	case *list:
-		listRecords(c, *table, *json)
+		listRecords(c, *table, filters, *json)
+	case *get >= 0:
+		getRecord(c, *table, *get, *json)
 	case *update != "":
 		updateRecord(c, *table, *update)
 	case *deleteID >= 0:
 		deleteRecord(c, *table, *deleteID)
+	case *search != "":
+		searchRecords(c, *table, *search, *limit, *json)
+	case *aggregate != "":
+		aggregateRecords(c, *table, *aggregate, *groupBy, filters, *json)
 	default:
 		flag.Usage()
 		os.Exit(1)
 	}
 }
 
+// filterFlags collects repeated -where flags
+type filterFlags []db.Filter
+
+func (f *filterFlags) String() string {
+	parts := make([]string, len(*f))
+	for i, filter := range *f {
+		parts[i] = filter.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (f *filterFlags) Set(value string) error {
+	filter, err := db.ParseFilter(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, filter)
+	return nil
+}
+
 func parseKeyValuePairs(input string) (map[string]interface{}, error) {
 	record := make(map[string]interface{})
 	pairs := strings.Split(input, ",")
@@ -78,15 +115,16 @@ func createRecord(c *client.Client, table, data string) {
 		log.Fatal(err)
 	}
 
-	if err := c.CreateRecord(table, record); err != nil {
+	created, err := c.CreateRecord(table, record)
+	if err != nil {
 		log.Fatal(err)
 	}
 
-	fmt.Println("Record created successfully")
+	fmt.Printf("Record created successfully with ID %v\n", created["id"])
 }
 
-func listRecords(c *client.Client, table string, jsonOutput bool) {
-	records, err := c.GetRecords(table)
+func listRecords(c *client.Client, table string, filters []db.Filter, jsonOutput bool) {
+	records, err := c.FindRecords(table, filters...)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -116,6 +154,119 @@ func listRecords(c *client.Client, table string, jsonOutput bool) {
 	}
 }
 
+func getRecord(c *client.Client, table string, id int, jsonOutput bool) {
+	record, err := c.GetRecord(table, id)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if jsonOutput {
+		output, err := json.MarshalIndent(record, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(output))
+		return
+	}
+
+	fmt.Printf("ID: %v\n", record["id"])
+	for k, v := range record {
+		if k != "id" {
+			fmt.Printf("  %s: %v\n", k, v)
+		}
+	}
+}
+
+func searchRecords(c *client.Client, table, query string, limit int, jsonOutput bool) {
+	results, err := c.Search(table, query, limit)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(results) == 0 {
+		fmt.Println("No matching records found")
+		return
+	}
+
+	if jsonOutput {
+		output, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(output))
+		return
+	}
+
+	fmt.Printf("Results for '%s' in table '%s':\n", query, table)
+	for _, result := range results {
+		fmt.Printf("ID: %v (score %.3f)\n", result.Record["id"], result.Score)
+		for col, text := range result.Highlights {
+			fmt.Printf("  %s: %s\n", col, html.UnescapeString(text))
+		}
+		fmt.Println()
+	}
+}
+
+func aggregateRecords(c *client.Client, table, aggregate, groupBy string, filters []db.Filter, jsonOutput bool) {
+	q := db.AggregateQuery{Where: filters}
+	for _, expr := range strings.Split(aggregate, ",") {
+		agg, err := db.ParseAggregation(expr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		q.Aggregations = append(q.Aggregations, agg)
+	}
+	if groupBy != "" {
+		for _, col := range strings.Split(groupBy, ",") {
+			q.GroupBy = append(q.GroupBy, strings.TrimSpace(col))
+		}
+	}
+
+	groups, err := c.Aggregate(table, q)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if jsonOutput {
+		output, err := json.MarshalIndent(groups, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(output))
+		return
+	}
+
+	if len(groups) == 0 {
+		fmt.Println("No records found")
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	header := append([]string(nil), q.GroupBy...)
+	for _, agg := range q.Aggregations {
+		header = append(header, agg.String())
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, g := range groups {
+		row := make([]string, 0, len(header))
+		for _, col := range q.GroupBy {
+			row = append(row, formatCell(g.Group[col]))
+		}
+		for _, agg := range q.Aggregations {
+			row = append(row, formatCell(g.Values[agg.String()]))
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	tw.Flush()
+}
+
+func formatCell(v interface{}) string {
+	if v == nil {
+		return "-"
+	}
+	return fmt.Sprint(v)
+}
+
 func updateRecord(c *client.Client, table, data string) {
 	parts := strings.SplitN(data, ",", 2)
 	if len(parts) < 2 {
@@ -134,7 +285,7 @@ func updateRecord(c *client.Client, table, data string) {
 
 	record["id"] = id
 
-	if err := c.UpdateRecord(table, record); err != nil {
+	if _, err := c.UpdateRecord(table, record); err != nil {
 		log.Fatal(err)
 	}
 
@@ -1,30 +1,24 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 
 	"github.com/dae-go/crud-server/pkg/client"
+	"github.com/dae-go/crud-server/pkg/seed"
 )
 
-type SeedData struct {
-	Table   string                   `json:"table"`
-	Records []map[string]interface{} `json:"records"`
-}
-
-type SeedFile struct {
-	Seeds []SeedData `json:"seeds"`
-}
-
 func main() {
 	var (
 		serverURL = flag.String("server", "http://localhost:8080", "Server URL")
-		file      = flag.String("file", "", "Seed data file (JSON)")
+		token     = flag.String("token", os.Getenv("CRUD_TOKEN"), "Bearer token sent with every request (defaults to $CRUD_TOKEN)")
+		file      = flag.String("file", "", "Seed data file (JSON, or YAML with a .yaml/.yml extension)")
 		clear     = flag.Bool("clear", false, "Clear existing data before seeding")
+		dryRun    = flag.Bool("dry-run", false, "Show what would change without writing anything")
+		randSeed  = flag.Uint64("rand-seed", 1, "Seed for generated fake data; the same seed generates the same records")
 	)
 
 	flag.Parse()
@@ -34,71 +28,55 @@ func main() {
 		os.Exit(1)
 	}
 
-	c := client.NewClient(*serverURL)
-
-	seedDatabase(c, *file, *clear)
-}
-
-func seedDatabase(c *client.Client, filename string, clearData bool) {
-	data, err := ioutil.ReadFile(filename)
+	seedFile, err := seed.Load(*file)
 	if err != nil {
-		log.Fatal("Failed to read seed file: ", err)
+		log.Fatal("Failed to load seed file: ", err)
 	}
 
-	var seedFile SeedFile
-	if err := json.Unmarshal(data, &seedFile); err != nil {
-		log.Fatal("Failed to parse seed file: ", err)
+	clientOpts := []client.Option{client.WithUserAgent("crud-server-seed")}
+	if *token != "" {
+		clientOpts = append(clientOpts, client.WithAuthToken(*token))
 	}
-
-	for _, seed := range seedFile.Seeds {
-		fmt.Printf("Seeding table '%s'...\n", seed.Table)
-
-		if clearData {
-			// Get existing records and delete them
-			records, err := c.GetRecords(seed.Table)
-			if err != nil {
-				log.Printf("Warning: Failed to get records from '%s': %v\n", seed.Table, err)
-			} else {
-				for _, record := range records {
-					if id, ok := record["id"]; ok {
-						if err := c.DeleteRecord(seed.Table, id); err != nil {
-							log.Printf("Warning: Failed to delete record %v: %v\n", id, err)
-						}
-					}
-				}
-			}
-		}
-
-		// Insert new records
-		for i, record := range seed.Records {
-			if err := c.CreateRecord(seed.Table, record); err != nil {
-				log.Printf("Failed to insert record %d in table '%s': %v\n", i+1, seed.Table, err)
-			}
-		}
-
-		fmt.Printf("  Inserted %d records\n", len(seed.Records))
+	c := client.NewClient(*serverURL, clientOpts...)
+	opts := seed.Options{
+		Clear:    *clear,
+		DryRun:   *dryRun,
+		RandSeed: *randSeed,
+		Out:      os.Stdout,
+	}
+	summary, err := seed.Apply(context.Background(), c, seedFile, opts)
+	if err != nil {
+		log.Fatal("Seeding failed: ", err)
 	}
 
-	fmt.Println("Seeding completed successfully")
+	if *dryRun {
+		fmt.Printf("Dry run: %s (nothing was written)\n", summary)
+		return
+	}
+	fmt.Printf("Seeding completed successfully: %s\n", summary)
 }
 
-// Example seed file format:
+// Example seed file format (see pkg/seed for YAML, references and generated
+// records):
 /*
 {
   "seeds": [
     {
       "table": "users",
+      "key": "email",
       "records": [
-        {"name": "John Doe", "email": "john@example.com", "age": 30},
-        {"name": "Jane Smith", "email": "jane@example.com", "age": 25}
+        {"_label": "john", "name": "John Doe", "email": "john@example.com", "age": 30},
+        {"_label": "jane", "name": "Jane Smith", "email": "jane@example.com", "age": 25}
       ]
     },
     {
-      "table": "products",
+      "table": "orders",
+      "key": ["user_id", "number"],
       "records": [
-        {"name": "Laptop", "price": 999.99, "stock": 10},
-        {"name": "Mouse", "price": 29.99, "stock": 100}
-      ]
+        {"user_id": {"$ref": "users.john"}, "number": 1, "total": 999.99},
+        {"user_id": {"$ref": "users.jane"}, "number": 1, "total": 29.99}
+      ],
+      "generate": {"count": 100, "values": {"status": ["open", "shipped"]}}
     }
   ]
 }
@@ -2,48 +2,126 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
-	"github.com/dae-go/crud-server/internal"
+	"github.com/dae-go/crud-server/internal/grpcserver"
+	"github.com/dae-go/crud-server/pkg/db"
+	"github.com/dae-go/crud-server/pkg/server"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 )
 
 func main() {
-	// Create server instance
-	server := internal.NewServer()
+	addr := flag.String("addr", ":8080", "HTTP API listen address")
+	grpcAddr := flag.String("grpc-addr", ":9090", "gRPC API listen address (empty to disable)")
+	expiryInterval := flag.Duration("expiry-interval", 5*time.Second, "how often to remove expired records (0 to disable)")
+	corsOrigins := flag.String("cors-origins", "", "comma-separated origins allowed to call the API from a browser, e.g. https://app.example.com or * (empty disables CORS)")
+	corsCredentials := flag.Bool("cors-credentials", false, "allow browsers to send credentials with cross-origin requests")
+	corsMaxAge := flag.Duration("cors-max-age", 10*time.Minute, "how long browsers may cache CORS preflight responses")
+	compressMinSize := flag.Int("compress-min-size", server.DefaultCompressMinSize, "compress responses of at least this many bytes with zstd, gzip or deflate (negative to disable)")
+	dataDir := flag.String("data-dir", "", "directory to persist tables in as a snapshot and journal (empty keeps them in memory only)")
+	fsync := flag.Bool("fsync", false, "flush the journal to disk after every change")
+	prefix := flag.String("prefix", "", "path prefix to serve the HTTP API under, e.g. /crud")
+	tokensFile := flag.String("tokens", "", "JSON file mapping bearer tokens to subjects, enabling authentication and row policies")
+	flag.Parse()
 
-	// Setup routes
-	mux := server.SetupRoutes()
+	// Open the database
+	database := db.NewDatabase()
+	if *dataDir != "" {
+		if err := database.Persist(*dataDir, db.PersistOptions{Sync: *fsync}); err != nil {
+			log.Fatalf("Failed to load %s: %v\n", *dataDir, err)
+		}
+		fmt.Printf("Persisting tables in %s\n", *dataDir)
+	}
+	if *expiryInterval > 0 {
+		stopExpiry := database.StartExpiry(*expiryInterval)
+		defer stopExpiry()
+	}
+
+	// Add logging, CORS and compression middleware, outermost first
+	middleware := []server.Middleware{server.LoggingMiddleware}
+	if *corsOrigins != "" {
+		cors := server.CORSConfig{
+			AllowedOrigins:   strings.Split(*corsOrigins, ","),
+			AllowCredentials: *corsCredentials,
+			MaxAge:           *corsMaxAge,
+		}
+		middleware = append(middleware, func(next http.Handler) http.Handler {
+			return server.CORSMiddleware(next, cors)
+		})
+	}
+	if *compressMinSize >= 0 {
+		middleware = append(middleware, func(next http.Handler) http.Handler {
+			return server.CompressionMiddleware(next, *compressMinSize)
+		})
+	}
 
-	// Add logging middleware
-	handler := internal.LoggingMiddleware(mux)
+	var grpcOpts []grpcserver.Option
+	opts := []server.Option{
+		server.WithDatabase(database),
+		server.WithPrefix(*prefix),
+		server.WithMiddleware(middleware...),
+	}
+	if *tokensFile != "" {
+		tokens, err := server.LoadTokens(*tokensFile)
+		if err != nil {
+			log.Fatalf("Failed to load tokens: %v\n", err)
+		}
+		auth := server.TokenAuthenticator(tokens)
+		opts = append(opts, server.WithAuthenticator(auth))
+		grpcOpts = append(grpcOpts, grpcserver.WithAuthenticator(auth))
+	}
 
 	// Create HTTP server
 	httpServer := &http.Server{
-		Addr:         ":8080",
-		Handler:      handler,
+		Addr:         *addr,
+		Handler:      server.New(opts...),
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
 
+	// The gRPC API shares the database and speaks unencrypted HTTP/2. It has
+	// no write timeout because Watch streams stay open.
+	var grpcServer *http.Server
+	if *grpcAddr != "" {
+		grpcServer = &http.Server{
+			Addr:              *grpcAddr,
+			Handler:           grpcserver.NewServer(database, grpcOpts...),
+			ReadHeaderTimeout: 10 * time.Second,
+			IdleTimeout:       60 * time.Second,
+			Protocols:         new(http.Protocols),
+		}
+		grpcServer.Protocols.SetUnencryptedHTTP2(true)
+	}
+
 	// Channel to listen for interrupt signals
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
 	// Run server in a goroutine
 	go func() {
-		fmt.Println("Starting CRUD server on port 8080...")
+		fmt.Printf("Starting CRUD server on %s...\n", *addr)
 		fmt.Println("Press Ctrl+C to stop")
 		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Server failed to start: %v\n", err)
 		}
 	}()
 
+	if grpcServer != nil {
+		go func() {
+			fmt.Printf("Starting gRPC server on %s...\n", *grpcAddr)
+			if err := grpcServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("gRPC server failed to start: %v\n", err)
+			}
+		}()
+	}
+
 	// Wait for interrupt signal
 	<-stop
 
@@ -56,6 +134,17 @@ func main() {
 	if err := httpServer.Shutdown(ctx); err != nil {
 		log.Fatalf("Server shutdown failed: %v\n", err)
 	}
+	if grpcServer != nil {
+		// Open Watch streams never finish on their own, so close whatever is
+		// left once the grace period is over
+		if err := grpcServer.Shutdown(ctx); err != nil {
+			grpcServer.Close()
+		}
+	}
+
+	if err := database.Close(); err != nil {
+		log.Printf("Closing journal: %v\n", err)
+	}
 
 	fmt.Println("Server stopped gracefully")
 }
@@ -15,7 +15,8 @@ func main() {
 	var (
 		serverURL = flag.String("server", "http://localhost:8080", "Server URL")
 		create    = flag.String("create", "", "Create a table with the given name")
-		columns   = flag.String("columns", "", "Comma-separated list of column:type pairs (e.g., name:string,age:number)")
+		columns   = flag.String("columns", "", "Comma-separated list of column:type[:ref_table] entries (e.g., name:string,age:number,team_id:number:teams)")
+		search    = flag.String("search", "", "Comma-separated list of string columns to full-text index (e.g., title,notes)")
 		list      = flag.Bool("list", false, "List all tables")
 		delete    = flag.String("delete", "", "Delete a table with the given name")
 	)
@@ -29,7 +30,7 @@ func main() {
 		if *columns == "" {
 			log.Fatal("Columns are required when creating a table")
 		}
-		createTable(c, *create, *columns)
+		createTable(c, *create, *columns, *search)
 	case *list:
 		listTables(c)
 	case *delete != "":
@@ -40,19 +41,23 @@ func main() {
 	}
 }
 
-func createTable(c *client.Client, name, columnsStr string) {
+func createTable(c *client.Client, name, columnsStr, searchStr string) {
 	cols := strings.Split(columnsStr, ",")
 	columns := make([]db.Column, 0, len(cols))
 
 	for _, col := range cols {
 		parts := strings.Split(col, ":")
-		if len(parts) != 2 {
-			log.Fatalf("Invalid column format: %s (expected name:type)", col)
+		if len(parts) != 2 && len(parts) != 3 {
+			log.Fatalf("Invalid column format: %s (expected name:type or name:type:ref_table)", col)
 		}
-		columns = append(columns, db.Column{
+		column := db.Column{
 			Name: strings.TrimSpace(parts[0]),
 			Type: strings.TrimSpace(parts[1]),
-		})
+		}
+		if len(parts) == 3 {
+			column.Ref = strings.TrimSpace(parts[2])
+		}
+		columns = append(columns, column)
 	}
 
 	table := &db.Table{
@@ -60,6 +65,12 @@ func createTable(c *client.Client, name, columnsStr string) {
 		Columns: columns,
 	}
 
+	if searchStr != "" {
+		for _, col := range strings.Split(searchStr, ",") {
+			table.SearchColumns = append(table.SearchColumns, strings.TrimSpace(col))
+		}
+	}
+
 	if err := c.CreateTable(table); err != nil {
 		log.Fatal(err)
 	}
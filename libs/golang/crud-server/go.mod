@@ -1,3 +1,3 @@
 module github.com/dae-go/crud-server
 
-go 1.21
+go 1.24
@@ -0,0 +1,641 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/dae-go/crud-server/pkg/db"
+)
+
+// Error is a GraphQL error with the path of the field that raised it
+type Error struct {
+	Message string `json:"message"`
+	Path    []any  `json:"path,omitempty"`
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// Response is the result of executing an operation
+type Response struct {
+	Data   any      `json:"data,omitempty"`
+	Errors []*Error `json:"errors,omitempty"`
+}
+
+// errorResponse reports a request that failed before execution started
+func errorResponse(err error) *Response {
+	return &Response{Errors: []*Error{{Message: err.Error()}}}
+}
+
+// orderedMap is a JSON object that keeps its keys in selection order
+type orderedMap struct {
+	keys   []string
+	values map[string]any
+}
+
+func newOrderedMap() *orderedMap {
+	return &orderedMap{values: make(map[string]any)}
+}
+
+func (m *orderedMap) set(key string, value any) {
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(k)
+		buf.Write(key)
+		buf.WriteByte(':')
+		value, err := json.Marshal(m.values[k])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Execute runs a query or mutation from doc against schema. Subscriptions
+// are streamed by Handler instead.
+func Execute(ctx context.Context, schema *Schema, doc *Document, operationName string, variables map[string]any) *Response {
+	op, err := doc.Operation(operationName)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return execute(ctx, schema, doc, op, variables, nil)
+}
+
+// execute runs op. For subscriptions, event is the change being delivered.
+func execute(ctx context.Context, schema *Schema, doc *Document, op *Operation, variables map[string]any, event *db.ChangeEvent) *Response {
+	var root *namedDef
+	switch op.Type {
+	case "query":
+		root = schema.query
+	case "mutation":
+		root = schema.mutation
+	case "subscription":
+		root = schema.subscription
+	}
+	if root == nil {
+		return errorResponse(fmt.Errorf("schema does not support %s operations", op.Type))
+	}
+
+	vars, err := coerceVariables(schema, op, variables)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	e := &executor{ctx: ctx, schema: schema, doc: doc, vars: vars, event: event}
+	data, ok := e.selectionSet(root, nil, op.Selection, nil)
+	resp := &Response{Errors: e.errors}
+	if ok {
+		resp.Data = data
+	} else {
+		resp.Data = json.RawMessage("null")
+	}
+	return resp
+}
+
+type executor struct {
+	ctx    context.Context
+	schema *Schema
+	doc    *Document
+	vars   map[string]any
+	event  *db.ChangeEvent
+	errors []*Error
+}
+
+func (e *executor) addError(path []any, format string, args ...any) {
+	e.errors = append(e.errors, &Error{
+		Message: fmt.Sprintf(format, args...),
+		Path:    append([]any(nil), path...),
+	})
+}
+
+// fieldGroup is every selection of one response key
+type fieldGroup struct {
+	key    string
+	fields []*Field
+}
+
+// collectFields flattens fragments and applies @skip and @include for an
+// object of type typeName
+func (e *executor) collectFields(typeName string, sels []Selection, groups []*fieldGroup, visited map[string]bool) []*fieldGroup {
+	for _, sel := range sels {
+		switch s := sel.(type) {
+		case *Field:
+			if !e.included(s.Directives) {
+				continue
+			}
+			key := s.ResponseKey()
+			found := false
+			for _, g := range groups {
+				if g.key == key {
+					g.fields = append(g.fields, s)
+					found = true
+					break
+				}
+			}
+			if !found {
+				groups = append(groups, &fieldGroup{key: key, fields: []*Field{s}})
+			}
+		case *FragmentSpread:
+			if !e.included(s.Directives) || visited[s.Name] {
+				continue
+			}
+			visited[s.Name] = true
+			frag, ok := e.doc.Fragments[s.Name]
+			if !ok || frag.TypeCond != typeName {
+				continue
+			}
+			groups = e.collectFields(typeName, frag.Selection, groups, visited)
+		case *InlineFragment:
+			if !e.included(s.Directives) || (s.TypeCond != "" && s.TypeCond != typeName) {
+				continue
+			}
+			groups = e.collectFields(typeName, s.Selection, groups, visited)
+		}
+	}
+	return groups
+}
+
+func (e *executor) included(dirs []Directive) bool {
+	for _, d := range dirs {
+		if d.Name != "skip" && d.Name != "include" {
+			continue
+		}
+		cond := false
+		for _, a := range d.Args {
+			if a.Name == "if" {
+				cond, _ = e.value(a.Value).(bool)
+			}
+		}
+		if d.Name == "skip" && cond || d.Name == "include" && !cond {
+			return false
+		}
+	}
+	return true
+}
+
+// selectionSet resolves sels on source, an object of type t. It returns
+// false when a non-null field could not be resolved, making the object null.
+func (e *executor) selectionSet(t *namedDef, source any, sels []Selection, path []any) (*orderedMap, bool) {
+	out := newOrderedMap()
+	for _, g := range e.collectFields(t.name, sels, nil, map[string]bool{}) {
+		if err := e.ctx.Err(); err != nil {
+			e.addError(path, "%v", err)
+			return nil, false
+		}
+		f := g.fields[0]
+		fieldPath := append(path[:len(path):len(path)], g.key)
+
+		switch {
+		case f.Name == "__typename":
+			out.set(g.key, t.name)
+			continue
+		case t == e.schema.query && f.Name == "__schema":
+			out.set(g.key, e.dynamic(e.schema.introspect(), mergeSelections(g.fields), fieldPath))
+			continue
+		case t == e.schema.query && f.Name == "__type":
+			var value any
+			if name, ok := e.value(argValue(f.Args, "name")).(string); ok {
+				if def := e.schema.types[name]; def != nil {
+					value = e.schema.introspectType(named(name))
+				}
+			}
+			out.set(g.key, e.dynamic(value, mergeSelections(g.fields), fieldPath))
+			continue
+		}
+
+		def := t.field(f.Name)
+		if def == nil {
+			e.addError(fieldPath, "Cannot query field %q on type %q", f.Name, t.name)
+			out.set(g.key, nil)
+			continue
+		}
+
+		args, err := e.coerceArgs(def, f.Args)
+		var value any
+		if err == nil {
+			if def.resolve != nil {
+				value, err = def.resolve(resolveParams{ctx: e.ctx, source: source, args: args, event: e.event})
+			} else if m, ok := source.(map[string]any); ok {
+				value = m[def.name]
+			}
+		}
+		if err != nil {
+			e.addError(fieldPath, "%v", err)
+			if def.typ.kind == kindNonNull {
+				return nil, false
+			}
+			out.set(g.key, nil)
+			continue
+		}
+
+		completed, ok := e.complete(def.typ, mergeSelections(g.fields), value, fieldPath)
+		if !ok {
+			return nil, false
+		}
+		out.set(g.key, completed)
+	}
+	return out, true
+}
+
+func mergeSelections(fields []*Field) []Selection {
+	if len(fields) == 1 {
+		return fields[0].Selection
+	}
+	var sels []Selection
+	for _, f := range fields {
+		sels = append(sels, f.Selection...)
+	}
+	return sels
+}
+
+func argValue(args []Argument, name string) Value {
+	for _, a := range args {
+		if a.Name == name {
+			return a.Value
+		}
+	}
+	return nil
+}
+
+// complete shapes a resolved value according to its type. A false result
+// means a non-null value was missing and the parent must become null.
+func (e *executor) complete(t *typeRef, sels []Selection, value any, path []any) (any, bool) {
+	if t.kind == kindNonNull {
+		v, ok := e.completeNullable(t.ofType, sels, value, path)
+		if !ok {
+			return nil, false
+		}
+		if v == nil {
+			e.addError(path, "Cannot return null for non-nullable field")
+			return nil, false
+		}
+		return v, true
+	}
+	v, ok := e.completeNullable(t, sels, value, path)
+	if !ok {
+		return nil, true
+	}
+	return v, true
+}
+
+func (e *executor) completeNullable(t *typeRef, sels []Selection, value any, path []any) (any, bool) {
+	if value == nil {
+		return nil, true
+	}
+
+	if t.kind == kindList {
+		items := toList(value)
+		out := make([]any, len(items))
+		for i, item := range items {
+			v, ok := e.complete(t.ofType, sels, item, append(path[:len(path):len(path)], i))
+			if !ok {
+				return nil, false
+			}
+			out[i] = v
+		}
+		return out, true
+	}
+
+	def := e.schema.types[t.name]
+	if def.kind == kindObject {
+		if len(sels) == 0 {
+			e.addError(path, "Field of type %q must have a selection of subfields", t.name)
+			return nil, false
+		}
+		return e.selectionSet(def, value, sels, path)
+	}
+	if len(sels) > 0 {
+		e.addError(path, "Field of scalar type %q cannot have a selection", t.name)
+		return nil, false
+	}
+	v, err := serialize(t.name, value)
+	if err != nil {
+		e.addError(path, "%v", err)
+		return nil, false
+	}
+	return v, true
+}
+
+func toList(value any) []any {
+	switch v := value.(type) {
+	case []any:
+		return v
+	case []map[string]any:
+		out := make([]any, len(v))
+		for i, r := range v {
+			out[i] = r
+		}
+		return out
+	case []string:
+		out := make([]any, len(v))
+		for i, s := range v {
+			out[i] = s
+		}
+		return out
+	}
+	return []any{value}
+}
+
+// serialize converts a stored value to the representation of a scalar
+func serialize(scalar string, value any) (any, error) {
+	switch scalar {
+	case "ID":
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case int:
+			return strconv.Itoa(v), nil
+		case float64:
+			if v == math.Trunc(v) {
+				return strconv.FormatInt(int64(v), 10), nil
+			}
+		}
+	case "String":
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		return fmt.Sprint(value), nil
+	case "Int":
+		switch v := value.(type) {
+		case int:
+			return v, nil
+		case float64:
+			if v == math.Trunc(v) && math.Abs(v) <= math.MaxInt32 {
+				return int(v), nil
+			}
+		}
+	case "Float":
+		switch v := value.(type) {
+		case int:
+			return float64(v), nil
+		case float64:
+			return v, nil
+		}
+	case "Boolean":
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	case "JSON":
+		return value, nil
+	}
+	return nil, fmt.Errorf("%s cannot represent value %v", scalar, value)
+}
+
+// dynamic completes introspection values, which are plain maps and lists
+// rather than schema types. Values of type lazy are computed on demand.
+func (e *executor) dynamic(value any, sels []Selection, path []any) any {
+	if l, ok := value.(lazy); ok {
+		value = l()
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		if len(sels) == 0 {
+			return nil
+		}
+		typeName, _ := v["__typename"].(string)
+		out := newOrderedMap()
+		for _, g := range e.collectFields(typeName, sels, nil, map[string]bool{}) {
+			out.set(g.key, e.dynamic(v[g.fields[0].Name], mergeSelections(g.fields), append(path[:len(path):len(path)], g.key)))
+		}
+		return out
+	case []map[string]any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = e.dynamic(item, sels, append(path[:len(path):len(path)], i))
+		}
+		return out
+	}
+	return value
+}
+
+// value resolves variables and converts literals to plain Go values
+func (e *executor) value(v Value) any {
+	switch v := v.(type) {
+	case Variable:
+		return e.vars[string(v)]
+	case Enum:
+		return string(v)
+	case []Value:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = e.value(item)
+		}
+		return out
+	case ObjectValue:
+		out := make(map[string]any, len(v))
+		for _, a := range v {
+			out[a.Name] = e.value(a.Value)
+		}
+		return out
+	}
+	return v
+}
+
+func (e *executor) coerceArgs(def *fieldDef, args []Argument) (map[string]any, error) {
+	out := make(map[string]any, len(def.args))
+	for _, a := range args {
+		found := false
+		for _, d := range def.args {
+			found = found || d.name == a.Name
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown argument %q on field %q", a.Name, def.name)
+		}
+	}
+	for _, d := range def.args {
+		raw := argValue(args, d.name)
+		if v, isVar := raw.(Variable); isVar {
+			if _, set := e.vars[string(v)]; !set {
+				raw = nil
+			}
+		}
+		v, err := e.schema.coerceInput(d.typ, e.value(raw))
+		if err != nil {
+			return nil, fmt.Errorf("argument %q: %v", d.name, err)
+		}
+		if v != nil {
+			out[d.name] = v
+		}
+	}
+	return out, nil
+}
+
+// coerceVariables checks the request's variables against the operation's
+// declarations and fills in defaults
+func coerceVariables(schema *Schema, op *Operation, values map[string]any) (map[string]any, error) {
+	vars := make(map[string]any, len(op.Variables))
+	for _, def := range op.Variables {
+		t, err := schema.parseTypeRef(def.Type)
+		if err != nil {
+			return nil, fmt.Errorf("variable $%s: %v", def.Name, err)
+		}
+		value, set := values[def.Name]
+		if !set && def.HasDef {
+			e := &executor{}
+			value, set = e.value(def.Default), true
+		}
+		if !set {
+			if t.kind == kindNonNull {
+				return nil, fmt.Errorf("variable $%s of type %s was not provided", def.Name, def.Type)
+			}
+			continue
+		}
+		v, err := schema.coerceInput(t, value)
+		if err != nil {
+			return nil, fmt.Errorf("variable $%s: %v", def.Name, err)
+		}
+		vars[def.Name] = v
+	}
+	return vars, nil
+}
+
+// parseTypeRef resolves a variable type such as [String!]!
+func (s *Schema) parseTypeRef(text string) (*typeRef, error) {
+	if n := len(text); n > 0 && text[n-1] == '!' {
+		inner, err := s.parseTypeRef(text[:n-1])
+		if err != nil {
+			return nil, err
+		}
+		return nonNull(inner), nil
+	}
+	if n := len(text); n > 1 && text[0] == '[' && text[n-1] == ']' {
+		inner, err := s.parseTypeRef(text[1 : n-1])
+		if err != nil {
+			return nil, err
+		}
+		return listOf(inner), nil
+	}
+	def, ok := s.types[text]
+	if !ok || def.kind == kindObject {
+		return nil, fmt.Errorf("unknown input type %q", text)
+	}
+	return named(text), nil
+}
+
+// coerceInput converts an argument or variable value to the Go type
+// resolvers expect: int for Int, float64 for Float, string for ID and maps
+// for input objects
+func (s *Schema) coerceInput(t *typeRef, value any) (any, error) {
+	if t.kind == kindNonNull {
+		if value == nil {
+			return nil, fmt.Errorf("expected a non-null %s", t.ofType)
+		}
+		return s.coerceInput(t.ofType, value)
+	}
+	if value == nil {
+		return nil, nil
+	}
+
+	if t.kind == kindList {
+		items, ok := value.([]any)
+		if !ok {
+			items = []any{value}
+		}
+		out := make([]any, len(items))
+		for i, item := range items {
+			v, err := s.coerceInput(t.ofType, item)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = v
+		}
+		return out, nil
+	}
+
+	def := s.types[t.name]
+	if def.kind == kindInputObject {
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("expected an object of type %s", t.name)
+		}
+		out := make(map[string]any, len(obj))
+		for k, v := range obj {
+			field := def.field(k)
+			if field == nil {
+				return nil, fmt.Errorf("unknown field %q on %s", k, t.name)
+			}
+			c, err := s.coerceInput(field.typ, v)
+			if err != nil {
+				return nil, fmt.Errorf("field %q: %v", k, err)
+			}
+			out[k] = c
+		}
+		return out, nil
+	}
+
+	switch t.name {
+	case "Int":
+		if n, ok := number(value); ok && n == math.Trunc(n) && math.Abs(n) <= math.MaxInt32 {
+			return int(n), nil
+		}
+	case "Float":
+		if n, ok := number(value); ok {
+			return n, nil
+		}
+	case "String":
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+	case "Boolean":
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	case "ID":
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		if n, ok := number(value); ok && n == math.Trunc(n) {
+			return strconv.FormatInt(int64(n), 10), nil
+		}
+	case "JSON":
+		return plainJSON(value), nil
+	}
+	return nil, fmt.Errorf("%v is not a valid %s", value, t.name)
+}
+
+// number accepts both literal integers and JSON numbers
+func number(value any) (float64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// plainJSON converts integer literals to float64 so JSON arguments hold the
+// same types as decoded request bodies
+func plainJSON(value any) any {
+	switch v := value.(type) {
+	case int64:
+		return float64(v)
+	case []any:
+		for i := range v {
+			v[i] = plainJSON(v[i])
+		}
+	case map[string]any:
+		for k := range v {
+			v[k] = plainJSON(v[k])
+		}
+	}
+	return value
+}
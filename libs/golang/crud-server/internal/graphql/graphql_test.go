@@ -0,0 +1,324 @@
+package graphql
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dae-go/crud-server/pkg/db"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		wantErr bool
+	}{
+		{"shorthand", `{ users { id name } }`, false},
+		{"named with variables", `query Q($id: ID!, $n: [String!] = ["a"]) { users_by_id(id: $id) { id } }`, false},
+		{"aliases and args", `{ a: users(limit: 2, where: ["age>30"]) { id } b: users { id } }`, false},
+		{"fragments", `{ users { ...F ... on Users { name } } } fragment F on Users { id }`, false},
+		{"directives", `query($x: Boolean!) { users { id @skip(if: $x) name @include(if: true) } }`, false},
+		{"object literal", `mutation { insert_users(record: {name: "a\nb", age: 3.5, tags: [1, 2]}) { id } }`, false},
+		{"block string", `{ users(search: """  hello  """) { id } }`, false},
+		{"comments and commas", "# list\n{ users { id, name } }", false},
+		{"empty", ``, true},
+		{"unclosed", `{ users { id }`, true},
+		{"empty selection", `{ users { } }`, true},
+		{"bad token", `{ users % }`, true},
+		{"unterminated string", `{ users(search: "x) { id } }`, true},
+		{"duplicate fragment", `{ a } fragment F on T { a } fragment F on T { b }`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func testDB(t *testing.T) *db.Database {
+	t.Helper()
+	database := db.NewDatabase()
+	tables := []db.Table{
+		{Name: "users", Columns: []db.Column{{Name: "name", Type: "string"}, {Name: "age", Type: "number"}}},
+		{Name: "posts", Columns: []db.Column{
+			{Name: "title", Type: "string"},
+			{Name: "author_id", Type: "number", Ref: "users"},
+		}, SearchColumns: []string{"title"}},
+	}
+	for i := range tables {
+		if err := database.CreateTable(&tables[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, u := range []map[string]any{{"name": "Ann", "age": 31.0}, {"name": "Bob", "age": 25.0}} {
+		if _, err := database.InsertRecord("users", u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, p := range []map[string]any{
+		{"title": "Hello world", "author_id": 1.0},
+		{"title": "Second post", "author_id": 1.0},
+		{"title": "Bob writes", "author_id": 2.0},
+	} {
+		if _, err := database.InsertRecord("posts", p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return database
+}
+
+// post sends a GraphQL request and returns the decoded response as JSON text
+func post(t *testing.T, h http.Handler, query string, vars map[string]any) (int, string) {
+	t.Helper()
+	body, _ := json.Marshal(map[string]any{"query": query, "variables": vars})
+	req := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code, strings.TrimSpace(rec.Body.String())
+}
+
+func TestExecute(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		vars  map[string]any
+		want  string
+	}{
+		{
+			name:  "list with filter and relation",
+			query: `{ users(where: ["age>30"]) { id name posts { title } } }`,
+			want:  `{"data":{"users":[{"id":"1","name":"Ann","posts":[{"title":"Hello world"},{"title":"Second post"}]}]}}`,
+		},
+		{
+			name:  "forward relation and pagination",
+			query: `{ posts(offset: 1, limit: 1) { title author { name } } }`,
+			want:  `{"data":{"posts":[{"title":"Second post","author":{"name":"Ann"}}]}}`,
+		},
+		{
+			name:  "aliases, variables and fragments",
+			query: `query($id: ID!) { first: users_by_id(id: $id) { ...U } missing: users_by_id(id: 9) { id } } fragment U on Users { name __typename }`,
+			vars:  map[string]any{"id": 2},
+			want:  `{"data":{"first":{"name":"Bob","__typename":"Users"},"missing":null}}`,
+		},
+		{
+			name:  "search and count",
+			query: `{ posts(search: "writes") { id } posts_count(where: ["author_id=1"]) }`,
+			want:  `{"data":{"posts":[{"id":"3"}],"posts_count":2}}`,
+		},
+		{
+			name:  "directives",
+			query: `query($skip: Boolean!) { users(limit: 1) { name @skip(if: $skip) age @include(if: $skip) } }`,
+			vars:  map[string]any{"skip": true},
+			want:  `{"data":{"users":[{"age":31}]}}`,
+		},
+		{
+			name:  "unknown field",
+			query: `{ users { id nope } }`,
+			want:  `{"data":{"users":[{"id":"1","nope":null},{"id":"2","nope":null}]},"errors":[{"message":"Cannot query field \"nope\" on type \"Users\"","path":["users",0,"nope"]},{"message":"Cannot query field \"nope\" on type \"Users\"","path":["users",1,"nope"]}]}`,
+		},
+		{
+			name:  "bad filter",
+			query: `{ users(where: ["=5"]) { id } }`,
+			want:  `{"data":null,"errors":[{"message":"invalid filter \"=5\": missing column","path":["users"]}]}`,
+		},
+	}
+
+	h := NewHandler(testDB(t))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, got := post(t, h, tt.query, tt.vars)
+			if code != http.StatusOK {
+				t.Fatalf("status = %d, body %s", code, got)
+			}
+			if got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMutations(t *testing.T) {
+	database := testDB(t)
+	h := NewHandler(database)
+
+	steps := []struct {
+		query string
+		want  string
+	}{
+		{
+			`mutation { insert_users(record: {name: "Cy", age: 40}) { id name age } }`,
+			`{"data":{"insert_users":{"id":"3","name":"Cy","age":40}}}`,
+		},
+		{
+			`mutation { update_users(id: 3, record: {age: 41}) { name age } }`,
+			`{"data":{"update_users":{"name":"Cy","age":41}}}`,
+		},
+		{
+			`mutation { delete_users(id: "3") { name } }`,
+			`{"data":{"delete_users":{"name":"Cy"}}}`,
+		},
+		{
+			`mutation { delete_users(id: "3") { name } }`,
+			`{"data":{"delete_users":null},"errors":[{"message":"record with id 3 not found","path":["delete_users"]}]}`,
+		},
+		{
+			`mutation { insert_users(record: {nickname: "x"}) { id } }`,
+			`{"data":null,"errors":[{"message":"argument \"record\": unknown field \"nickname\" on UsersInput","path":["insert_users"]}]}`,
+		},
+	}
+	for _, s := range steps {
+		if _, got := post(t, h, s.query, nil); got != s.want {
+			t.Errorf("%s\ngot  %s\nwant %s", s.query, got, s.want)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/graphql?query="+strings.ReplaceAll(`mutation{delete_users(id:1){id}}`, " ", "+"), nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("mutation over GET: status = %d", rec.Code)
+	}
+}
+
+func TestSchemaRegeneration(t *testing.T) {
+	database := testDB(t)
+	h := NewHandler(database)
+
+	if _, got := post(t, h, `{ tags { id } }`, nil); !strings.Contains(got, `Cannot query field \"tags\"`) {
+		t.Fatalf("tags queried before the table exists: %s", got)
+	}
+
+	if err := database.CreateTable(&db.Table{Name: "tags", Columns: []db.Column{{Name: "label", Type: "string"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, got := post(t, h, `{ tags { id label } }`, nil); got != `{"data":{"tags":[]}}` {
+		t.Fatalf("after CreateTable: %s", got)
+	}
+
+	if err := database.DeleteTable("tags"); err != nil {
+		t.Fatal(err)
+	}
+	if _, got := post(t, h, `{ tags { id } }`, nil); !strings.Contains(got, `Cannot query field \"tags\"`) {
+		t.Fatalf("after DeleteTable: %s", got)
+	}
+}
+
+func TestIntrospection(t *testing.T) {
+	h := NewHandler(testDB(t))
+	_, got := post(t, h, `{
+		__schema { queryType { name } subscriptionType { name } }
+		__type(name: "Posts") { kind fields { name type { kind name ofType { name } } } }
+	}`, nil)
+
+	var resp struct {
+		Data struct {
+			Schema struct {
+				QueryType        struct{ Name string }
+				SubscriptionType struct{ Name string }
+			} `json:"__schema"`
+			Type struct {
+				Kind   string
+				Fields []struct {
+					Name string
+					Type struct {
+						Kind   string
+						Name   *string
+						OfType *struct{ Name string }
+					}
+				}
+			} `json:"__type"`
+		}
+	}
+	if err := json.Unmarshal([]byte(got), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Data.Schema.QueryType.Name != "Query" || resp.Data.Schema.SubscriptionType.Name != "Subscription" {
+		t.Errorf("root types = %s", got)
+	}
+	var fields []string
+	for _, f := range resp.Data.Type.Fields {
+		fields = append(fields, f.Name)
+	}
+	if want := "id title author_id author"; strings.Join(fields, " ") != want {
+		t.Errorf("Posts fields = %v, want %s", fields, want)
+	}
+	if id := resp.Data.Type.Fields[0].Type; id.Kind != "NON_NULL" || id.OfType == nil || id.OfType.Name != "ID" {
+		t.Errorf("id type = %+v", id)
+	}
+}
+
+func TestSDL(t *testing.T) {
+	sdl := BuildSchema(testDB(t)).SDL()
+	for _, want := range []string{
+		"type Query {",
+		"  posts(where: [String!], search: String, limit: Int, offset: Int): [Posts!]!\n",
+		"  author: Users\n",
+		"  posts(where: [String!], limit: Int, offset: Int): [Posts!]!\n",
+		"input UsersInput {",
+		"  insert_users(record: UsersInput!): Users!\n",
+		"  users_changes: UsersChange!\n",
+	} {
+		if !strings.Contains(sdl, want) {
+			t.Errorf("SDL missing %q:\n%s", want, sdl)
+		}
+	}
+}
+
+func TestSubscription(t *testing.T) {
+	database := testDB(t)
+	srv := httptest.NewServer(NewHandler(database))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	body := `{"query":"subscription { users_changes { op record { name } } }"}`
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	// The subscription is registered before the headers are flushed, so
+	// changes made now are delivered
+	if _, err := database.InsertRecord("posts", map[string]any{"title": "ignored"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.InsertRecord("users", map[string]any{"name": "Dee"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.DeleteTable("users"); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "event: ") || strings.HasPrefix(line, "data: ") {
+			events = append(events, line)
+		}
+	}
+	want := []string{
+		"event: next",
+		`data: {"data":{"users_changes":{"op":"insert","record":{"name":"Dee"}}}}`,
+		"event: complete",
+	}
+	if strings.Join(events, "\n") != strings.Join(want, "\n") {
+		t.Errorf("events:\n%s\nwant:\n%s", strings.Join(events, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,220 @@
+// Package graphql serves a GraphQL API generated from the crud-server table
+// definitions.
+//
+// Every table becomes an object type with a list query, a lookup by id, a
+// count, insert/update/delete mutations and a change subscription. Columns
+// with a Ref become relation fields in both directions. The schema is
+// rebuilt whenever a table is created or deleted.
+package graphql
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dae-go/crud-server/pkg/db"
+)
+
+// keepAliveInterval is how often an idle subscription stream sends a comment
+// so proxies keep the connection open
+const keepAliveInterval = 15 * time.Second
+
+// Handler serves GraphQL requests over HTTP. Queries and mutations are
+// accepted as POST (JSON or application/graphql bodies) and queries also as
+// GET. Subscriptions are streamed as server-sent events.
+type Handler struct {
+	db *db.Database
+
+	mu      sync.Mutex
+	schema  *Schema
+	version uint64
+}
+
+// NewHandler creates a handler for database
+func NewHandler(database *db.Database) *Handler {
+	return &Handler{db: database}
+}
+
+// Schema returns the current schema, regenerating it if tables were created
+// or deleted since it was last built
+func (h *Handler) Schema() *Schema {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Read the version first so a change made while building triggers
+	// another rebuild on the next call
+	version := h.db.SchemaVersion()
+	if h.schema == nil || version != h.version {
+		h.schema = BuildSchema(h.db)
+		h.version = version
+	}
+	return h.schema
+}
+
+// request is the standard GraphQL-over-HTTP request body
+type request struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req, err := readRequest(r)
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, errorResponse(err))
+		return
+	}
+	if req.Query == "" {
+		writeResponse(w, http.StatusBadRequest, errorResponse(errors.New("missing query")))
+		return
+	}
+
+	doc, err := Parse(req.Query)
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, errorResponse(err))
+		return
+	}
+	op, err := doc.Operation(req.OperationName)
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	schema := h.Schema()
+	switch {
+	case op.Type == "subscription":
+		h.subscribe(w, r, schema, doc, op, req.Variables)
+	case op.Type == "mutation" && r.Method != http.MethodPost:
+		w.Header().Set("Allow", http.MethodPost)
+		writeResponse(w, http.StatusMethodNotAllowed, errorResponse(errors.New("mutations require POST")))
+	default:
+		writeResponse(w, http.StatusOK, execute(r.Context(), schema, doc, op, req.Variables, nil))
+	}
+}
+
+// ServeSDL writes the current schema in the schema definition language
+func (h *Handler) ServeSDL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, h.Schema().SDL())
+}
+
+func readRequest(r *http.Request) (*request, error) {
+	req := &request{}
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		req.Query = q.Get("query")
+		req.OperationName = q.Get("operationName")
+		if v := q.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				return nil, fmt.Errorf("invalid variables: %v", err)
+			}
+		}
+	case http.MethodPost:
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType == "application/graphql" {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				return nil, err
+			}
+			req.Query = string(body)
+			break
+		}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			return nil, errors.New("Invalid request body")
+		}
+	default:
+		return nil, errors.New("Method not allowed")
+	}
+	return req, nil
+}
+
+func writeResponse(w http.ResponseWriter, status int, resp *Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// subscribe streams one result per change to the subscribed table using the
+// GraphQL over server-sent events protocol: each result is a "next" event,
+// and a "complete" event is sent if the table is deleted.
+func (h *Handler) subscribe(w http.ResponseWriter, r *http.Request, schema *Schema, doc *Document, op *Operation, variables map[string]any) {
+	e := &executor{schema: schema, doc: doc}
+	groups := e.collectFields(schema.subscription.name, op.Selection, nil, map[string]bool{})
+	if len(groups) != 1 {
+		writeResponse(w, http.StatusBadRequest, errorResponse(errors.New("subscriptions must select exactly one field")))
+		return
+	}
+	field := schema.subscription.field(groups[0].fields[0].Name)
+	if field == nil {
+		writeResponse(w, http.StatusBadRequest, errorResponse(fmt.Errorf("Cannot query field %q on type %q", groups[0].fields[0].Name, schema.subscription.name)))
+		return
+	}
+	if _, err := coerceVariables(schema, op, variables); err != nil {
+		writeResponse(w, http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	// Subscriptions outlive the server's write timeout
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	scope := h.db.For(r.Context())
+	events, cancel := h.db.Subscribe(64)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			io.WriteString(w, ": keep-alive\n\n")
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if ev.Table != field.table {
+				continue
+			}
+			if ev.Op == db.OpDeleteTable {
+				io.WriteString(w, "event: complete\ndata:\n\n")
+				rc.Flush()
+				return
+			}
+			if ev.Op != db.OpInsert && ev.Op != db.OpUpdate && ev.Op != db.OpDelete && ev.Op != db.OpExpire {
+				continue
+			}
+			// Row policies hide other subjects' records from the stream too
+			if !scope.CanSee(ev) {
+				continue
+			}
+			data, err := json.Marshal(execute(r.Context(), schema, doc, op, variables, &ev))
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: next\ndata: %s\n\n", data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package graphql
+
+// lazy defers building part of the introspection result until a query
+// selects it. Types refer to each other in cycles, so they cannot be built
+// eagerly.
+type lazy func() any
+
+// introspect builds the value of the __schema field
+func (s *Schema) introspect() map[string]any {
+	rootType := func(t *namedDef) any {
+		if t == nil {
+			return nil
+		}
+		return s.introspectType(named(t.name))
+	}
+	return map[string]any{
+		"__typename":       "__Schema",
+		"description":      nil,
+		"queryType":        rootType(s.query),
+		"mutationType":     rootType(s.mutation),
+		"subscriptionType": rootType(s.subscription),
+		"types": lazy(func() any {
+			types := make([]map[string]any, 0, len(s.order))
+			for _, name := range s.order {
+				types = append(types, s.introspectType(named(name)))
+			}
+			return types
+		}),
+		"directives": []map[string]any{
+			introspectDirective("include", "Include the field only when if is true"),
+			introspectDirective("skip", "Skip the field when if is true"),
+		},
+	}
+}
+
+// introspectType describes t as a __Type
+func (s *Schema) introspectType(t *typeRef) map[string]any {
+	m := map[string]any{
+		"__typename":     "__Type",
+		"name":           nil,
+		"description":    nil,
+		"fields":         nil,
+		"inputFields":    nil,
+		"interfaces":     nil,
+		"possibleTypes":  nil,
+		"enumValues":     nil,
+		"ofType":         nil,
+		"specifiedByURL": nil,
+		"isOneOf":        nil,
+	}
+	if t.kind == kindNonNull || t.kind == kindList {
+		m["kind"] = t.kind
+		m["ofType"] = s.introspectType(t.ofType)
+		return m
+	}
+
+	def := s.types[t.name]
+	m["kind"] = def.kind
+	m["name"] = def.name
+	if def.description != "" {
+		m["description"] = def.description
+	}
+	switch def.kind {
+	case kindObject:
+		m["interfaces"] = []map[string]any{}
+		m["fields"] = lazy(func() any {
+			fields := make([]map[string]any, len(def.fields))
+			for i, f := range def.fields {
+				fields[i] = s.introspectField(f)
+			}
+			return fields
+		})
+	case kindInputObject:
+		m["isOneOf"] = false
+		m["inputFields"] = lazy(func() any {
+			fields := make([]map[string]any, len(def.fields))
+			for i, f := range def.fields {
+				fields[i] = s.introspectInput(argDef{name: f.name, description: f.description, typ: f.typ})
+			}
+			return fields
+		})
+	}
+	return m
+}
+
+func (s *Schema) introspectField(f *fieldDef) map[string]any {
+	args := make([]map[string]any, len(f.args))
+	for i, a := range f.args {
+		args[i] = s.introspectInput(a)
+	}
+	return map[string]any{
+		"__typename":        "__Field",
+		"name":              f.name,
+		"description":       optional(f.description),
+		"args":              args,
+		"type":              lazy(func() any { return s.introspectType(f.typ) }),
+		"isDeprecated":      false,
+		"deprecationReason": nil,
+	}
+}
+
+func (s *Schema) introspectInput(a argDef) map[string]any {
+	return map[string]any{
+		"__typename":        "__InputValue",
+		"name":              a.name,
+		"description":       optional(a.description),
+		"type":              lazy(func() any { return s.introspectType(a.typ) }),
+		"defaultValue":      nil,
+		"isDeprecated":      false,
+		"deprecationReason": nil,
+	}
+}
+
+func introspectDirective(name, description string) map[string]any {
+	return map[string]any{
+		"__typename":   "__Directive",
+		"name":         name,
+		"description":  description,
+		"isRepeatable": false,
+		"locations":    []any{"FIELD", "FRAGMENT_SPREAD", "INLINE_FRAGMENT"},
+		"args": []map[string]any{{
+			"__typename":        "__InputValue",
+			"name":              "if",
+			"description":       nil,
+			"type":              map[string]any{"__typename": "__Type", "kind": kindNonNull, "name": nil, "ofType": map[string]any{"__typename": "__Type", "kind": kindScalar, "name": "Boolean", "ofType": nil}},
+			"defaultValue":      nil,
+			"isDeprecated":      false,
+			"deprecationReason": nil,
+		}},
+	}
+}
+
+func optional(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,578 @@
+package graphql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dae-go/crud-server/pkg/db"
+)
+
+// Type kinds, as reported by introspection
+const (
+	kindScalar      = "SCALAR"
+	kindObject      = "OBJECT"
+	kindInputObject = "INPUT_OBJECT"
+	kindList        = "LIST"
+	kindNonNull     = "NON_NULL"
+)
+
+// typeRef is a possibly wrapped reference to a named type
+type typeRef struct {
+	kind   string
+	name   string
+	ofType *typeRef
+}
+
+func named(name string) *typeRef  { return &typeRef{name: name} }
+func nonNull(t *typeRef) *typeRef { return &typeRef{kind: kindNonNull, ofType: t} }
+func listOf(t *typeRef) *typeRef  { return &typeRef{kind: kindList, ofType: t} }
+
+func (t *typeRef) String() string {
+	switch t.kind {
+	case kindNonNull:
+		return t.ofType.String() + "!"
+	case kindList:
+		return "[" + t.ofType.String() + "]"
+	}
+	return t.name
+}
+
+// namedType strips list and non-null wrappers
+func (t *typeRef) namedType() string {
+	for t.ofType != nil {
+		t = t.ofType
+	}
+	return t.name
+}
+
+// resolveParams is passed to a field resolver
+type resolveParams struct {
+	ctx    context.Context
+	source any
+	args   map[string]any
+	event  *db.ChangeEvent
+}
+
+type resolveFunc func(p resolveParams) (any, error)
+
+type argDef struct {
+	name        string
+	description string
+	typ         *typeRef
+}
+
+type fieldDef struct {
+	name        string
+	description string
+	typ         *typeRef
+	args        []argDef
+	// resolve computes the field; nil reads the key from a map source
+	resolve resolveFunc
+	// table is the table a subscription field watches
+	table string
+}
+
+// namedDef is a scalar, object or input object type
+type namedDef struct {
+	kind        string
+	name        string
+	description string
+	fields      []*fieldDef
+}
+
+func (t *namedDef) field(name string) *fieldDef {
+	for _, f := range t.fields {
+		if f.name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// addField appends f unless the type already has a field with that name
+func (t *namedDef) addField(f *fieldDef) {
+	if t.field(f.name) == nil {
+		t.fields = append(t.fields, f)
+	}
+}
+
+// Schema is the GraphQL schema generated from a database's tables
+type Schema struct {
+	types        map[string]*namedDef
+	order        []string // type names in SDL order
+	query        *namedDef
+	mutation     *namedDef
+	subscription *namedDef
+}
+
+func (s *Schema) add(t *namedDef) bool {
+	if _, dup := s.types[t.name]; dup {
+		return false
+	}
+	s.types[t.name] = t
+	return true
+}
+
+// builtinScalars are always part of the schema. JSON carries values of
+// columns whose type has no GraphQL equivalent.
+var builtinScalars = []string{"ID", "String", "Int", "Float", "Boolean", "JSON"}
+
+// columnType maps a column type onto a GraphQL scalar
+func columnType(t string) string {
+	switch strings.ToLower(t) {
+	case "string", "text":
+		return "String"
+	case "number", "float":
+		return "Float"
+	case "int", "integer":
+		return "Int"
+	case "bool", "boolean":
+		return "Boolean"
+	}
+	return "JSON"
+}
+
+// validName reports whether s is a legal GraphQL name that does not use
+// the reserved __ prefix
+func validName(s string) bool {
+	if s == "" || strings.HasPrefix(s, "__") {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c == '_' || isLetter(c) || (i > 0 && isDigit(c))) {
+			return false
+		}
+	}
+	return true
+}
+
+// typeName turns a table name such as order_items into OrderItems
+func typeName(table string) string {
+	var b strings.Builder
+	for _, part := range strings.Split(table, "_") {
+		if part != "" {
+			b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	return b.String()
+}
+
+// relationName is the field name for a reference column: author_id becomes
+// author, anything else gets a _record suffix
+func relationName(column string) string {
+	if base, ok := strings.CutSuffix(column, "_id"); ok && base != "" {
+		return base
+	}
+	return column + "_record"
+}
+
+// tableInfo is a table that made it into the schema
+type tableInfo struct {
+	table *db.Table
+	typ   *namedDef
+}
+
+// BuildSchema generates a schema from the tables currently in database.
+// Tables and columns whose names are not valid GraphQL names are left out.
+func BuildSchema(database *db.Database) *Schema {
+	s := &Schema{types: make(map[string]*namedDef)}
+	for _, name := range builtinScalars {
+		s.add(&namedDef{kind: kindScalar, name: name})
+	}
+	s.query = &namedDef{kind: kindObject, name: "Query"}
+	s.mutation = &namedDef{kind: kindObject, name: "Mutation"}
+	s.subscription = &namedDef{kind: kindObject, name: "Subscription"}
+	s.add(s.query)
+	s.add(s.mutation)
+	s.add(s.subscription)
+
+	s.query.addField(&fieldDef{
+		name:        "tables",
+		description: "Names of all tables",
+		typ:         nonNull(listOf(nonNull(named("String")))),
+		resolve: func(p resolveParams) (any, error) {
+			names := database.ListTables()
+			sort.Strings(names)
+			return names, nil
+		},
+	})
+
+	names := database.ListTables()
+	sort.Strings(names)
+	tables := make(map[string]*tableInfo)
+	var ordered []*tableInfo
+	for _, name := range names {
+		table, err := database.GetTable(name)
+		if err != nil || !validName(name) || !validName(typeName(name)) {
+			continue
+		}
+		t := &namedDef{kind: kindObject, name: typeName(name), description: "A record in the " + name + " table"}
+		if !s.add(t) {
+			continue
+		}
+		info := &tableInfo{table: table, typ: t}
+		tables[name] = info
+		ordered = append(ordered, info)
+	}
+
+	for _, info := range ordered {
+		s.addTable(database, info, tables)
+	}
+
+	if len(s.mutation.fields) == 0 {
+		delete(s.types, s.mutation.name)
+		s.mutation = nil
+	}
+	if len(s.subscription.fields) == 0 {
+		delete(s.types, s.subscription.name)
+		s.subscription = nil
+	}
+
+	// Root types first, then everything else by name
+	for name := range s.types {
+		s.order = append(s.order, name)
+	}
+	rank := map[string]int{"Query": 1, "Mutation": 2, "Subscription": 3}
+	sort.Slice(s.order, func(i, j int) bool {
+		ri, rj := rank[s.order[i]], rank[s.order[j]]
+		if ri == 0 {
+			ri = 4
+		}
+		if rj == 0 {
+			rj = 4
+		}
+		if ri != rj {
+			return ri < rj
+		}
+		return s.order[i] < s.order[j]
+	})
+	return s
+}
+
+// addTable adds the object, input and change types for one table along with
+// its root fields
+func (s *Schema) addTable(database *db.Database, info *tableInfo, tables map[string]*tableInfo) {
+	table, t := info.table, info.typ
+	name := table.Name
+
+	input := &namedDef{kind: kindInputObject, name: t.name + "Input", description: "Column values for a " + name + " record"}
+	t.addField(&fieldDef{name: "id", typ: nonNull(named("ID"))})
+	for _, col := range table.Columns {
+		if !validName(col.Name) || col.Name == "id" {
+			continue
+		}
+		typ := named(columnType(col.Type))
+		t.addField(&fieldDef{name: col.Name, typ: typ})
+		input.addField(&fieldDef{name: col.Name, typ: typ})
+	}
+
+	// Forward relations: a column referencing another table resolves to
+	// that table's record
+	for _, col := range table.Columns {
+		target, ok := tables[col.Ref]
+		if !ok || !validName(col.Name) {
+			continue
+		}
+		column, targetTable := col.Name, target.table.Name
+		t.addField(&fieldDef{
+			name:        relationName(column),
+			description: fmt.Sprintf("The %s record referenced by %s", targetTable, column),
+			typ:         named(target.typ.name),
+			resolve: func(p resolveParams) (any, error) {
+				id := p.source.(map[string]any)[column]
+				if id == nil {
+					return nil, nil
+				}
+				record, err := database.For(p.ctx).GetRecord(targetTable, id)
+				if err != nil {
+					return nil, nil
+				}
+				return record, nil
+			},
+		})
+	}
+
+	// Reverse relations: records in other tables that reference this one
+	for _, other := range sortedTables(tables) {
+		var refs []string
+		for _, col := range other.table.Columns {
+			if col.Ref == name && validName(col.Name) {
+				refs = append(refs, col.Name)
+			}
+		}
+		for _, column := range refs {
+			fieldName := other.table.Name
+			if len(refs) > 1 {
+				fieldName = other.table.Name + "_by_" + column
+			}
+			column, source := column, other.table.Name
+			t.addField(&fieldDef{
+				name:        fieldName,
+				description: fmt.Sprintf("Records in %s whose %s references this record", source, column),
+				typ:         nonNull(listOf(nonNull(named(other.typ.name)))),
+				args:        listArgs(false),
+				resolve: func(p resolveParams) (any, error) {
+					id := p.source.(map[string]any)["id"]
+					filters, err := whereArg(p.args)
+					if err != nil {
+						return nil, err
+					}
+					filters = append(filters, db.Where(column, db.OpEq, id))
+					records, err := database.For(p.ctx).FindRecords(source, filters)
+					if err != nil {
+						return nil, err
+					}
+					return paginate(records, p.args), nil
+				},
+			})
+		}
+	}
+	// Without columns, or when a table already took the name, records are
+	// passed as plain JSON
+	hasInput := len(input.fields) > 0 && s.add(input)
+
+	change := &namedDef{kind: kindObject, name: t.name + "Change", description: "A change to the " + name + " table"}
+	change.addField(&fieldDef{name: "op", description: "insert, update, delete or expire", typ: nonNull(named("String"))})
+	change.addField(&fieldDef{name: "record", description: "The record after an insert or update, or before a delete", typ: named(t.name)})
+	change.addField(&fieldDef{name: "time", description: "When the change happened (RFC 3339)", typ: nonNull(named("String"))})
+	hasChange := s.add(change)
+
+	s.query.addField(&fieldDef{
+		name:        name,
+		description: "List " + name + " records. where takes filter expressions such as \"age>=30\"; search runs a full-text query.",
+		typ:         nonNull(listOf(nonNull(named(t.name)))),
+		args:        listArgs(true),
+		resolve: func(p resolveParams) (any, error) {
+			filters, err := whereArg(p.args)
+			if err != nil {
+				return nil, err
+			}
+			var records []map[string]any
+			if q, _ := p.args["search"].(string); q != "" {
+				results, err := database.For(p.ctx).Search(name, q, 0)
+				if err != nil {
+					return nil, err
+				}
+				for _, r := range results {
+					if matchAll(r.Record, filters) {
+						records = append(records, r.Record)
+					}
+				}
+			} else if records, err = database.For(p.ctx).FindRecords(name, filters); err != nil {
+				return nil, err
+			}
+			return paginate(records, p.args), nil
+		},
+	})
+	s.query.addField(&fieldDef{
+		name:        name + "_by_id",
+		description: "Fetch one " + name + " record by id",
+		typ:         named(t.name),
+		args:        []argDef{{name: "id", typ: nonNull(named("ID"))}},
+		resolve: func(p resolveParams) (any, error) {
+			record, err := database.For(p.ctx).GetRecord(name, p.args["id"])
+			if err != nil {
+				return nil, nil
+			}
+			return record, nil
+		},
+	})
+	s.query.addField(&fieldDef{
+		name:        name + "_count",
+		description: "Count " + name + " records matching the filters",
+		typ:         nonNull(named("Int")),
+		args:        []argDef{{name: "where", typ: listOf(nonNull(named("String")))}},
+		resolve: func(p resolveParams) (any, error) {
+			filters, err := whereArg(p.args)
+			if err != nil {
+				return nil, err
+			}
+			records, err := database.For(p.ctx).FindRecords(name, filters)
+			if err != nil {
+				return nil, err
+			}
+			return len(records), nil
+		},
+	})
+
+	recordArg := argDef{name: "record", typ: nonNull(named(input.name))}
+	if !hasInput {
+		recordArg.typ = nonNull(named("JSON"))
+	}
+	s.mutation.addField(&fieldDef{
+		name:        "insert_" + name,
+		description: "Insert a " + name + " record and return it",
+		typ:         nonNull(named(t.name)),
+		args:        []argDef{recordArg},
+		resolve: func(p resolveParams) (any, error) {
+			record, _ := p.args["record"].(map[string]any)
+			if record == nil {
+				return nil, errors.New("record must be an object")
+			}
+			id, err := database.For(p.ctx).InsertRecord(name, record)
+			if err != nil {
+				return nil, err
+			}
+			return database.For(p.ctx).GetRecord(name, id)
+		},
+	})
+	s.mutation.addField(&fieldDef{
+		name:        "update_" + name,
+		description: "Update the given columns of a " + name + " record and return it",
+		typ:         nonNull(named(t.name)),
+		args:        []argDef{{name: "id", typ: nonNull(named("ID"))}, recordArg},
+		resolve: func(p resolveParams) (any, error) {
+			record, _ := p.args["record"].(map[string]any)
+			if record == nil {
+				return nil, errors.New("record must be an object")
+			}
+			changes := make(map[string]any, len(record)+1)
+			for k, v := range record {
+				changes[k] = v
+			}
+			changes["id"] = p.args["id"]
+			return database.For(p.ctx).UpdateRecord(name, changes)
+		},
+	})
+	s.mutation.addField(&fieldDef{
+		name:        "delete_" + name,
+		description: "Delete a " + name + " record and return it",
+		typ:         named(t.name),
+		args:        []argDef{{name: "id", typ: nonNull(named("ID"))}},
+		resolve: func(p resolveParams) (any, error) {
+			record, err := database.For(p.ctx).GetRecord(name, p.args["id"])
+			if err != nil {
+				return nil, err
+			}
+			if err := database.For(p.ctx).DeleteRecord(name, p.args["id"]); err != nil {
+				return nil, err
+			}
+			return record, nil
+		},
+	})
+
+	if !hasChange {
+		return
+	}
+	s.subscription.addField(&fieldDef{
+		name:        name + "_changes",
+		description: "Stream inserts, updates and deletes on " + name,
+		typ:         nonNull(named(change.name)),
+		table:       name,
+		resolve: func(p resolveParams) (any, error) {
+			ev := p.event
+			if ev == nil || ev.Table != name {
+				return nil, errors.New("subscriptions are only available over a streaming request")
+			}
+			return map[string]any{
+				"op":     string(ev.Op),
+				"record": ev.Record,
+				"time":   ev.Time.Format(time.RFC3339Nano),
+			}, nil
+		},
+	})
+}
+
+func sortedTables(tables map[string]*tableInfo) []*tableInfo {
+	list := make([]*tableInfo, 0, len(tables))
+	for _, t := range tables {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].table.Name < list[j].table.Name })
+	return list
+}
+
+// listArgs are the filter and pagination arguments of list fields
+func listArgs(search bool) []argDef {
+	args := []argDef{
+		{name: "where", description: "Filter expressions, all of which must match", typ: listOf(nonNull(named("String")))},
+	}
+	if search {
+		args = append(args, argDef{name: "search", description: "Full-text query over the table's search columns", typ: named("String")})
+	}
+	return append(args,
+		argDef{name: "limit", description: "Maximum number of records", typ: named("Int")},
+		argDef{name: "offset", description: "Number of records to skip", typ: named("Int")},
+	)
+}
+
+func whereArg(args map[string]any) ([]db.Filter, error) {
+	list, _ := args["where"].([]any)
+	filters := make([]db.Filter, 0, len(list))
+	for _, expr := range list {
+		f, err := db.ParseFilter(expr.(string))
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	return filters, nil
+}
+
+func matchAll(record map[string]any, filters []db.Filter) bool {
+	for _, f := range filters {
+		if !f.Match(record) {
+			return false
+		}
+	}
+	return true
+}
+
+// paginate applies the offset and limit arguments
+func paginate(records []map[string]any, args map[string]any) []map[string]any {
+	if offset, ok := args["offset"].(int); ok && offset > 0 {
+		if offset >= len(records) {
+			return []map[string]any{}
+		}
+		records = records[offset:]
+	}
+	if limit, ok := args["limit"].(int); ok && limit >= 0 && limit < len(records) {
+		records = records[:limit]
+	}
+	if records == nil {
+		return []map[string]any{}
+	}
+	return records
+}
+
+// SDL renders the schema in the GraphQL schema definition language
+func (s *Schema) SDL() string {
+	var b strings.Builder
+	b.WriteString("\"Any JSON value\"\nscalar JSON\n")
+	for _, name := range s.order {
+		t := s.types[name]
+		if t.kind == kindScalar {
+			continue
+		}
+		b.WriteString("\n")
+		if t.description != "" {
+			fmt.Fprintf(&b, "%q\n", t.description)
+		}
+		keyword := "type"
+		if t.kind == kindInputObject {
+			keyword = "input"
+		}
+		fmt.Fprintf(&b, "%s %s {\n", keyword, t.name)
+		for _, f := range t.fields {
+			if f.description != "" {
+				fmt.Fprintf(&b, "  %q\n", f.description)
+			}
+			b.WriteString("  " + f.name)
+			if len(f.args) > 0 {
+				args := make([]string, len(f.args))
+				for i, a := range f.args {
+					args[i] = a.name + ": " + a.typ.String()
+				}
+				b.WriteString("(" + strings.Join(args, ", ") + ")")
+			}
+			b.WriteString(": " + f.typ.String() + "\n")
+		}
+		b.WriteString("}\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,406 @@
+// Package grpcserver serves the crud.v1.Crud gRPC service (see
+// pkg/crudpb/crud.proto) on top of net/http's HTTP/2 support.
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dae-go/crud-server/pkg/crudpb"
+	"github.com/dae-go/crud-server/pkg/db"
+)
+
+// watchBuffer is how many changes a Watch call can fall behind before
+// further changes are dropped for it
+const watchBuffer = 256
+
+// statusError carries a gRPC status code back to the client
+type statusError struct {
+	code crudpb.Code
+	msg  string
+}
+
+func (e *statusError) Error() string { return e.msg }
+
+func errorf(code crudpb.Code, format string, args ...any) error {
+	return &statusError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+// dbError maps a database error onto a status code
+func dbError(err error) error {
+	msg := err.Error()
+	switch {
+	case errors.Is(err, db.ErrForbidden):
+		return errorf(crudpb.PermissionDenied, "%s", msg)
+	case strings.Contains(msg, "not found"):
+		return errorf(crudpb.NotFound, "%s", msg)
+	case strings.Contains(msg, "already exists"):
+		return errorf(crudpb.AlreadyExists, "%s", msg)
+	}
+	return errorf(crudpb.InvalidArgument, "%s", msg)
+}
+
+// method handles one RPC. body is the request message; responses are written
+// to st, once for unary methods and any number of times for streaming ones.
+type method func(ctx context.Context, body []byte, st *stream) error
+
+// stream writes response messages, flushing each one to the client
+type stream struct {
+	w  io.Writer
+	rc *http.ResponseController
+}
+
+func (st *stream) send(m crudpb.Message) error {
+	if err := crudpb.WriteFrame(st.w, m); err != nil {
+		return err
+	}
+	return st.rc.Flush()
+}
+
+// Server implements the Crud service for a database
+type Server struct {
+	db      *db.Database
+	auth    func(r *http.Request) (db.Subject, error)
+	methods map[string]method
+}
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithAuthenticator identifies the subject of every call from its metadata,
+// such as a bearer token in the authorization header, in the same way as
+// server.WithAuthenticator. Records are then accessed under the tables' row
+// policies, and creating or deleting tables needs an admin. Without an
+// authenticator every call acts as an admin.
+func WithAuthenticator(auth func(r *http.Request) (db.Subject, error)) Option {
+	return func(s *Server) {
+		s.auth = auth
+	}
+}
+
+// NewServer creates a gRPC server backed by database
+func NewServer(database *db.Database, opts ...Option) *Server {
+	s := &Server{db: database}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.methods = map[string]method{
+		crudpb.MethodListTables:   s.listTables,
+		crudpb.MethodGetTable:     s.getTable,
+		crudpb.MethodCreateTable:  s.createTable,
+		crudpb.MethodDeleteTable:  s.deleteTable,
+		crudpb.MethodGetRecord:    s.getRecord,
+		crudpb.MethodInsertRecord: s.insertRecord,
+		crudpb.MethodUpdateRecord: s.updateRecord,
+		crudpb.MethodDeleteRecord: s.deleteRecord,
+		crudpb.MethodListRecords:  s.listRecords,
+		crudpb.MethodWatch:        s.watch,
+	}
+	return s
+}
+
+// ServeHTTP handles a gRPC call. It must be served over HTTP/2; cmd/server
+// enables unencrypted HTTP/2 on the gRPC listener for that.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 {
+		http.Error(w, "gRPC requires HTTP/2", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	contentType := r.Header.Get("Content-Type")
+	if contentType != "application/grpc" && !strings.HasPrefix(contentType, "application/grpc+proto") &&
+		!strings.HasPrefix(contentType, "application/grpc;") {
+		http.Error(w, "Unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	ctx := r.Context()
+	if v := r.Header.Get("Grpc-Timeout"); v != "" {
+		timeout, err := crudpb.ParseTimeout(v)
+		if err == nil {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.WriteHeader(http.StatusOK)
+
+	ctx, err := s.authenticate(ctx, r)
+	if err == nil {
+		err = s.call(ctx, w, r)
+	}
+	code, msg := crudpb.OK, ""
+	if err != nil {
+		var se *statusError
+		switch {
+		case errors.As(err, &se):
+			code, msg = se.code, se.msg
+		case errors.Is(err, context.DeadlineExceeded):
+			code, msg = crudpb.DeadlineExceeded, err.Error()
+		case errors.Is(err, context.Canceled):
+			code, msg = crudpb.Canceled, err.Error()
+		default:
+			code, msg = crudpb.Internal, err.Error()
+		}
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(int(code)))
+	if msg != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", crudpb.EncodeMessage(msg))
+	}
+}
+
+// authenticate stores the caller's subject in ctx
+func (s *Server) authenticate(ctx context.Context, r *http.Request) (context.Context, error) {
+	if s.auth == nil {
+		return ctx, nil
+	}
+	subject, err := s.auth(r)
+	if err != nil {
+		return ctx, errorf(crudpb.Unauthenticated, "%v", err)
+	}
+	return db.WithSubject(ctx, subject), nil
+}
+
+// requireAdmin rejects callers other than admins when calls are
+// authenticated
+func requireAdmin(ctx context.Context, method string) error {
+	if subject, ok := db.SubjectFrom(ctx); ok && !subject.Admin {
+		return errorf(crudpb.PermissionDenied, "%s needs an admin", method)
+	}
+	return nil
+}
+
+// call reads the request message and runs the method
+func (s *Server) call(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	m, ok := s.methods[r.URL.Path]
+	if !ok {
+		return errorf(crudpb.Unimplemented, "unknown method %s", r.URL.Path)
+	}
+
+	body, err := crudpb.ReadFrame(r.Body)
+	if err == io.EOF {
+		return errorf(crudpb.InvalidArgument, "missing request message")
+	}
+	if err != nil {
+		return errorf(crudpb.InvalidArgument, "%v", err)
+	}
+	if _, err := crudpb.ReadFrame(r.Body); err != io.EOF {
+		return errorf(crudpb.InvalidArgument, "expected a single request message")
+	}
+
+	return m(ctx, body, &stream{w: w, rc: http.NewResponseController(w)})
+}
+
+// decode unmarshals a request, reporting failures as InvalidArgument
+func decode(body []byte, m crudpb.Message) error {
+	if err := m.Unmarshal(body); err != nil {
+		return errorf(crudpb.InvalidArgument, "invalid request message: %v", err)
+	}
+	return nil
+}
+
+// Table methods
+
+func (s *Server) listTables(ctx context.Context, body []byte, st *stream) error {
+	var req crudpb.ListTablesRequest
+	if err := decode(body, &req); err != nil {
+		return err
+	}
+	names := s.db.ListTables()
+	sort.Strings(names)
+	return st.send(&crudpb.ListTablesResponse{Names: names})
+}
+
+func (s *Server) getTable(ctx context.Context, body []byte, st *stream) error {
+	var req crudpb.GetTableRequest
+	if err := decode(body, &req); err != nil {
+		return err
+	}
+	table, err := s.db.GetTable(req.Name)
+	if err != nil {
+		return dbError(err)
+	}
+	return st.send(crudpb.TableFromDB(table))
+}
+
+func (s *Server) createTable(ctx context.Context, body []byte, st *stream) error {
+	var req crudpb.CreateTableRequest
+	if err := decode(body, &req); err != nil {
+		return err
+	}
+	if err := requireAdmin(ctx, "CreateTable"); err != nil {
+		return err
+	}
+	if req.Table == nil || req.Table.Name == "" || len(req.Table.Columns) == 0 {
+		return errorf(crudpb.InvalidArgument, "Table name and columns are required")
+	}
+	table := req.Table.DB()
+	if err := s.db.CreateTable(table); err != nil {
+		return dbError(err)
+	}
+	return st.send(crudpb.TableFromDB(table))
+}
+
+func (s *Server) deleteTable(ctx context.Context, body []byte, st *stream) error {
+	var req crudpb.DeleteTableRequest
+	if err := decode(body, &req); err != nil {
+		return err
+	}
+	if err := requireAdmin(ctx, "DeleteTable"); err != nil {
+		return err
+	}
+	if req.Name == "" {
+		return errorf(crudpb.InvalidArgument, "Table name is required")
+	}
+	if err := s.db.DeleteTable(req.Name); err != nil {
+		return dbError(err)
+	}
+	return st.send(&crudpb.DeleteTableResponse{})
+}
+
+// Record methods
+
+func (s *Server) getRecord(ctx context.Context, body []byte, st *stream) error {
+	var req crudpb.GetRecordRequest
+	if err := decode(body, &req); err != nil {
+		return err
+	}
+	record, err := s.db.For(ctx).GetRecord(req.Table, req.ID)
+	if err != nil {
+		return dbError(err)
+	}
+	return st.send(crudpb.RecordFromMap(record))
+}
+
+func (s *Server) insertRecord(ctx context.Context, body []byte, st *stream) error {
+	var req crudpb.InsertRecordRequest
+	if err := decode(body, &req); err != nil {
+		return err
+	}
+	if req.Fields == nil {
+		req.Fields = map[string]any{}
+	}
+	scope := s.db.For(ctx)
+	id, err := scope.InsertRecord(req.Table, req.Fields)
+	if err != nil {
+		return dbError(err)
+	}
+	record, err := scope.GetRecord(req.Table, id)
+	if err != nil {
+		return dbError(err)
+	}
+	return st.send(crudpb.RecordFromMap(record))
+}
+
+func (s *Server) updateRecord(ctx context.Context, body []byte, st *stream) error {
+	var req crudpb.UpdateRecordRequest
+	if err := decode(body, &req); err != nil {
+		return err
+	}
+	changes := make(map[string]any, len(req.Fields)+1)
+	for k, v := range req.Fields {
+		changes[k] = v
+	}
+	changes["id"] = req.ID
+	record, err := s.db.For(ctx).UpdateRecord(req.Table, changes)
+	if err != nil {
+		return dbError(err)
+	}
+	return st.send(crudpb.RecordFromMap(record))
+}
+
+func (s *Server) deleteRecord(ctx context.Context, body []byte, st *stream) error {
+	var req crudpb.DeleteRecordRequest
+	if err := decode(body, &req); err != nil {
+		return err
+	}
+	if err := s.db.For(ctx).DeleteRecord(req.Table, req.ID); err != nil {
+		return dbError(err)
+	}
+	return st.send(&crudpb.DeleteRecordResponse{})
+}
+
+// listRecords streams the matching records one message at a time
+func (s *Server) listRecords(ctx context.Context, body []byte, st *stream) error {
+	var req crudpb.ListRecordsRequest
+	if err := decode(body, &req); err != nil {
+		return err
+	}
+	filters := make([]db.Filter, 0, len(req.Where))
+	for _, expr := range req.Where {
+		f, err := db.ParseFilter(expr)
+		if err != nil {
+			return errorf(crudpb.InvalidArgument, "%v", err)
+		}
+		filters = append(filters, f)
+	}
+	if req.Offset < 0 || req.Limit < 0 {
+		return errorf(crudpb.InvalidArgument, "offset and limit must not be negative")
+	}
+
+	records, err := s.db.For(ctx).FindRecords(req.Table, filters)
+	if err != nil {
+		return dbError(err)
+	}
+	records = records[min(int(req.Offset), len(records)):]
+	if req.Limit > 0 && int(req.Limit) < len(records) {
+		records = records[:req.Limit]
+	}
+	for _, record := range records {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := st.send(crudpb.RecordFromMap(record)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// watch streams changes until the client cancels or the deadline passes
+func (s *Server) watch(ctx context.Context, body []byte, st *stream) error {
+	var req crudpb.WatchRequest
+	if err := decode(body, &req); err != nil {
+		return err
+	}
+	tables := make(map[string]bool, len(req.Tables))
+	for _, t := range req.Tables {
+		tables[t] = true
+	}
+
+	scope := s.db.For(ctx)
+	events, cancel := s.db.Subscribe(watchBuffer)
+	defer cancel()
+
+	// Send the response headers now so the client knows the watch is
+	// registered before the first change arrives
+	if err := st.rc.Flush(); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ev := <-events:
+			// Row policies hide other subjects' records from the stream too
+			if (len(tables) > 0 && !tables[ev.Table]) || !scope.CanSee(ev) {
+				continue
+			}
+			if err := st.send(crudpb.EventFromDB(ev)); err != nil {
+				return err
+			}
+		}
+	}
+}
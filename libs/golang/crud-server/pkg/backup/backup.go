@@ -0,0 +1,241 @@
+// Package backup reads and writes crud-server backup archives.
+//
+// An archive is a gzip-compressed tar file containing:
+//
+//	manifest.json                  format version, creation time and table list
+//	tables/<name>/schema.json      the db.Table definition
+//	tables/<name>/records.ndjson   one JSON record per line
+//
+// Table names are path escaped, so that each is a single path element
+// even when it contains slashes or is "." or "..".
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dae-go/crud-server/pkg/db"
+)
+
+// FormatVersion is written to every manifest
+const FormatVersion = 1
+
+// Manifest describes the contents of an archive
+type Manifest struct {
+	Version   int             `json:"version"`
+	CreatedAt time.Time       `json:"created_at"`
+	Tables    []ManifestTable `json:"tables"`
+}
+
+// ManifestTable summarizes one table in the archive
+type ManifestTable struct {
+	Name    string `json:"name"`
+	Records int    `json:"records"`
+	NextID  int    `json:"next_id"`
+}
+
+// Write encodes snap as a compressed archive
+func Write(w io.Writer, snap *db.Snapshot) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	manifest := Manifest{Version: FormatVersion, CreatedAt: snap.CreatedAt}
+	for _, t := range snap.Tables {
+		manifest.Tables = append(manifest.Tables, ManifestTable{
+			Name:    t.Table.Name,
+			Records: len(t.Records),
+			NextID:  t.NextID,
+		})
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFile(tw, "manifest.json", data, snap.CreatedAt); err != nil {
+		return err
+	}
+
+	for _, t := range snap.Tables {
+		schema, err := json.MarshalIndent(t.Table, "", "  ")
+		if err != nil {
+			return err
+		}
+		dir := "tables/" + escapeName(t.Table.Name) + "/"
+		if err := writeFile(tw, dir+"schema.json", schema, snap.CreatedAt); err != nil {
+			return err
+		}
+
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		for _, r := range t.Records {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		if err := writeFile(tw, dir+"records.ndjson", buf.Bytes(), snap.CreatedAt); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// escapeName turns a table name into a single path element
+func escapeName(name string) string {
+	escaped := url.PathEscape(name)
+	if escaped == "." || escaped == ".." {
+		escaped = strings.ReplaceAll(escaped, ".", "%2E")
+	}
+	return escaped
+}
+
+func writeFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+// Read decodes an archive written by Write
+func Read(r io.Reader) (*db.Snapshot, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup archive: %w", err)
+	}
+	defer gz.Close()
+
+	var manifest *Manifest
+	tables := make(map[string]*db.TableSnapshot)
+	table := func(name string) *db.TableSnapshot {
+		if tables[name] == nil {
+			tables[name] = &db.TableSnapshot{}
+		}
+		return tables[name]
+	}
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid backup archive: %w", err)
+		}
+
+		parts := strings.Split(hdr.Name, "/")
+		if len(parts) == 3 && parts[0] == "tables" {
+			if parts[1], err = url.PathUnescape(parts[1]); err != nil {
+				return nil, fmt.Errorf("invalid backup archive: bad table name in %s", hdr.Name)
+			}
+		}
+		switch {
+		case hdr.Name == "manifest.json":
+			manifest = &Manifest{}
+			if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+				return nil, fmt.Errorf("invalid manifest: %w", err)
+			}
+			if manifest.Version != FormatVersion {
+				return nil, fmt.Errorf("unsupported backup format version %d", manifest.Version)
+			}
+		case len(parts) == 3 && parts[0] == "tables" && parts[2] == "schema.json":
+			if err := json.NewDecoder(tr).Decode(&table(parts[1]).Table); err != nil {
+				return nil, fmt.Errorf("invalid schema for table %s: %w", parts[1], err)
+			}
+		case len(parts) == 3 && parts[0] == "tables" && parts[2] == "records.ndjson":
+			records, err := readRecords(tr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid records for table %s: %w", parts[1], err)
+			}
+			table(parts[1]).Records = records
+		}
+	}
+
+	if manifest == nil {
+		return nil, errors.New("invalid backup archive: missing manifest.json")
+	}
+
+	snap := &db.Snapshot{CreatedAt: manifest.CreatedAt}
+	for _, m := range manifest.Tables {
+		t, ok := tables[m.Name]
+		if !ok || t.Table.Name != m.Name {
+			return nil, fmt.Errorf("invalid backup archive: missing schema for table %s", m.Name)
+		}
+		if len(t.Records) != m.Records {
+			return nil, fmt.Errorf("invalid backup archive: table %s has %d records, manifest lists %d", m.Name, len(t.Records), m.Records)
+		}
+		t.NextID = m.NextID
+		snap.Tables = append(snap.Tables, *t)
+	}
+	return snap, nil
+}
+
+func readRecords(r io.Reader) ([]map[string]any, error) {
+	records := make([]map[string]any, 0)
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var record map[string]any
+		err := dec.Decode(&record)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+}
+
+// FileName returns the archive name used for a backup taken at t. Names
+// sort chronologically.
+func FileName(t time.Time) string {
+	return "backup-" + t.UTC().Format("20060102T150405Z") + ".tar.gz"
+}
+
+// Prune deletes all but the newest keep archives in dir that were named by
+// FileName and returns the removed paths. A keep of zero or less keeps
+// everything.
+func Prune(dir string, keep int) ([]string, error) {
+	if keep <= 0 {
+		return nil, nil
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, "backup-*.tar.gz"))
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) <= keep {
+		return nil, nil
+	}
+
+	sort.Strings(matches)
+	removed := matches[:len(matches)-keep]
+	for _, p := range removed {
+		if err := os.Remove(p); err != nil {
+			return nil, err
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,185 @@
+package backup
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dae-go/crud-server/pkg/db"
+)
+
+func seededDatabase(t *testing.T) *db.Database {
+	t.Helper()
+	d := db.NewDatabase()
+	err := d.CreateTable(&db.Table{
+		Name:          "notes",
+		Columns:       []db.Column{{Name: "title", Type: "string"}},
+		SearchColumns: []string{"title"},
+	})
+	if err != nil {
+		t.Fatalf("CreateTable failed: %v", err)
+	}
+	for _, title := range []string{"first note", "second note", "third note"} {
+		if _, err := d.InsertRecord("notes", map[string]any{"title": title}); err != nil {
+			t.Fatalf("InsertRecord failed: %v", err)
+		}
+	}
+	if err := d.DeleteRecord("notes", 2); err != nil {
+		t.Fatalf("DeleteRecord failed: %v", err)
+	}
+	return d
+}
+
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, seededDatabase(t).Snapshot()); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	snap, err := Read(&buf)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+
+	restored := db.NewDatabase()
+	for _, ts := range snap.Tables {
+		if _, err := restored.RestoreTable(ts, db.ConflictFail); err != nil {
+			t.Fatalf("RestoreTable failed: %v", err)
+		}
+	}
+
+	records, err := restored.GetRecords("notes")
+	if err != nil {
+		t.Fatalf("GetRecords failed: %v", err)
+	}
+	if len(records) != 2 || records[0]["id"] != 1 || records[1]["id"] != 3 {
+		t.Errorf("expected records 1 and 3, got %v", records)
+	}
+
+	id, err := restored.InsertRecord("notes", map[string]any{"title": "fourth note"})
+	if err != nil || id != 4 {
+		t.Errorf("expected next id 4, got %d (%v)", id, err)
+	}
+
+	results, err := restored.Search("notes", "third", 0)
+	if err != nil || len(results) != 1 {
+		t.Errorf("expected search index to be rebuilt, got %v (%v)", results, err)
+	}
+}
+
+func TestRoundTripNames(t *testing.T) {
+	names := []string{"a/b", "a", "..", ".", "50%", "x y", "tables/c/schema.json"}
+	d := db.NewDatabase()
+	for _, name := range names {
+		if err := d.CreateTable(&db.Table{Name: name, Columns: []db.Column{{Name: "table", Type: "string"}}}); err != nil {
+			t.Fatalf("CreateTable(%q) failed: %v", name, err)
+		}
+		if _, err := d.InsertRecord(name, map[string]any{"table": name}); err != nil {
+			t.Fatalf("InsertRecord(%q) failed: %v", name, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, d.Snapshot()); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	snap, err := Read(&buf)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	restored := db.NewDatabase()
+	for _, ts := range snap.Tables {
+		if _, err := restored.RestoreTable(ts, db.ConflictFail); err != nil {
+			t.Fatalf("RestoreTable(%q) failed: %v", ts.Table.Name, err)
+		}
+	}
+	for _, name := range names {
+		records, err := restored.GetRecords(name)
+		if err != nil || len(records) != 1 || records[0]["table"] != name {
+			t.Errorf("table %q: records %v, %v", name, records, err)
+		}
+	}
+}
+
+func TestRestoreStrategies(t *testing.T) {
+	snap := seededDatabase(t).Snapshot().Tables[0]
+
+	tests := []struct {
+		strategy db.ConflictStrategy
+		wantErr  bool
+		action   string
+		records  int
+	}{
+		{strategy: db.ConflictFail, wantErr: true},
+		{strategy: db.ConflictSkip, action: "skipped", records: 2},
+		{strategy: db.ConflictReplace, action: "replaced", records: 2},
+		{strategy: db.ConflictMerge, action: "merged", records: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.strategy), func(t *testing.T) {
+			d := db.NewDatabase()
+			if err := d.CreateTable(&snap.Table); err != nil {
+				t.Fatalf("CreateTable failed: %v", err)
+			}
+			// Leaves ids 1 and 4: id 1 collides with the snapshot, id 4 does not
+			for i := 0; i < 4; i++ {
+				if _, err := d.InsertRecord("notes", map[string]any{"title": "local"}); err != nil {
+					t.Fatalf("InsertRecord failed: %v", err)
+				}
+			}
+			d.DeleteRecord("notes", 2)
+			d.DeleteRecord("notes", 3)
+
+			result, err := d.RestoreTable(snap, tt.strategy)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("expected conflict error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RestoreTable failed: %v", err)
+			}
+			if result.Action != tt.action {
+				t.Errorf("expected action %s, got %s", tt.action, result.Action)
+			}
+			records, _ := d.GetRecords("notes")
+			if len(records) != tt.records {
+				t.Errorf("expected %d records, got %d: %v", tt.records, len(records), records)
+			}
+		})
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		name := filepath.Join(dir, FileName(start.Add(time.Duration(i)*time.Hour)))
+		if err := os.WriteFile(name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := Prune(dir, 2)
+	if err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if len(removed) != 3 {
+		t.Errorf("expected 3 removed, got %v", removed)
+	}
+
+	left, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(left) != 2 || filepath.Base(left[1]) != FileName(start.Add(4*time.Hour)) {
+		t.Errorf("expected the two newest backups to remain, got %v", left)
+	}
+}
+
+func TestReadRejectsGarbage(t *testing.T) {
+	if _, err := Read(bytes.NewReader([]byte("not an archive"))); err == nil {
+		t.Error("expected error for invalid archive")
+	}
+}
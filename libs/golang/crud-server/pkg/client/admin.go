@@ -0,0 +1,63 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/dae-go/crud-server/pkg/db"
+)
+
+// Stats fetches statistics for every table and, when the server persists
+// its data, for the storage
+func (c *Client) Stats() (*db.Stats, error) {
+	return c.StatsContext(context.Background())
+}
+
+func (c *Client) StatsContext(ctx context.Context) (*db.Stats, error) {
+	var stats db.Stats
+	err := c.do(ctx, request{
+		op:     "get stats",
+		method: http.MethodGet,
+		path:   "/admin/stats",
+		status: http.StatusOK,
+	}, &stats)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// Compact asks the server to fold its journal into a new snapshot. It fails
+// with ErrConflict when the server does not persist its data.
+func (c *Client) Compact() (*db.CompactResult, error) {
+	return c.CompactContext(context.Background())
+}
+
+func (c *Client) CompactContext(ctx context.Context) (*db.CompactResult, error) {
+	var result db.CompactResult
+	err := c.do(ctx, request{
+		op:     "compact",
+		method: http.MethodPost,
+		path:   "/admin/compact",
+		status: http.StatusOK,
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Ready returns nil when the server is ready to accept writes, or an
+// *APIError describing why it is not
+func (c *Client) Ready() error {
+	return c.ReadyContext(context.Background())
+}
+
+func (c *Client) ReadyContext(ctx context.Context) error {
+	return c.do(ctx, request{
+		op:     "check readiness",
+		method: http.MethodGet,
+		path:   "/ready",
+		status: http.StatusOK,
+	}, nil)
+}
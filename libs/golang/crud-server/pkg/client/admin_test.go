@@ -0,0 +1,68 @@
+package client
+
+import (
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dae-go/crud-server/pkg/db"
+	"github.com/dae-go/crud-server/pkg/server"
+)
+
+func TestAdmin(t *testing.T) {
+	dir := t.TempDir()
+	database := db.NewDatabase()
+	if err := database.Persist(dir, db.PersistOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	srv := httptest.NewServer(server.New(server.WithDatabase(database)))
+	defer srv.Close()
+	c := NewClient(srv.URL)
+
+	if err := c.CreateTable(&db.Table{Name: "users", Columns: []db.Column{{Name: "name", Type: "string"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CreateRecord("users", map[string]interface{}{"name": "Ann"}); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := c.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Tables) != 1 || stats.Records != 1 || stats.Tables[0].Mutations[db.OpInsert] != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if stats.Storage == nil || stats.Storage.JournalEntries != 2 {
+		t.Errorf("storage stats = %+v", stats.Storage)
+	}
+
+	if err := c.Ready(); err != nil {
+		t.Errorf("Ready = %v", err)
+	}
+
+	result, err := c.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Tables != 1 || result.Records != 1 || result.JournalEntries != 2 {
+		t.Errorf("compact result = %+v", result)
+	}
+
+	if err := os.Remove(filepath.Join(dir, db.JournalFile)); err != nil {
+		t.Fatal(err)
+	}
+	var apiErr *APIError
+	if err := c.Ready(); !errors.As(err, &apiErr) || apiErr.StatusCode != 503 {
+		t.Errorf("Ready without a journal = %v", err)
+	}
+
+	memory := httptest.NewServer(server.New())
+	defer memory.Close()
+	if _, err := NewClient(memory.URL).Compact(); !errors.Is(err, ErrConflict) {
+		t.Errorf("Compact without persistence = %v", err)
+	}
+}
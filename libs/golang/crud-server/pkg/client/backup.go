@@ -0,0 +1,70 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/dae-go/crud-server/pkg/db"
+)
+
+// Backup streams a compressed archive of every table into w. Backups are
+// never retried since part of the archive may already have been written.
+func (c *Client) Backup(w io.Writer) error {
+	return c.BackupContext(context.Background(), w)
+}
+
+func (c *Client) BackupContext(ctx context.Context, w io.Writer) error {
+	req, err := c.newRequest(ctx, http.MethodGet, c.baseURL+"/admin/backup", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return readAPIError("backup", resp)
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// Restore uploads an archive produced by Backup. strategy decides what
+// happens to tables that already exist on the server.
+func (c *Client) Restore(r io.Reader, strategy db.ConflictStrategy) ([]db.RestoreResult, error) {
+	return c.RestoreContext(context.Background(), r, strategy)
+}
+
+func (c *Client) RestoreContext(ctx context.Context, r io.Reader, strategy db.ConflictStrategy) ([]db.RestoreResult, error) {
+	params := url.Values{}
+	params.Set("strategy", string(strategy))
+
+	req, err := c.newRequest(ctx, http.MethodPost, c.baseURL+"/admin/restore?"+params.Encode(), r)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, readAPIError("restore", resp)
+	}
+
+	var results []db.RestoreResult
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
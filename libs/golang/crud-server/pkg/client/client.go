@@ -2,180 +2,503 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/dae-go/crud-server/pkg/db"
 )
 
 type Client struct {
-	baseURL string
-	client  *http.Client
+	baseURL   string
+	client    *http.Client
+	authToken string
+	userAgent string
+	retry     RetryPolicy
 }
 
-func NewClient(baseURL string) *Client {
-	return &Client{
-		baseURL: baseURL,
+// NewClient creates a client for the server at baseURL. Without options it
+// uses a plain http.Client and never retries.
+func NewClient(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
 		client:  &http.Client{},
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
-func (c *Client) CreateTable(table *db.Table) error {
-	data, err := json.Marshal(table)
-	if err != nil {
-		return err
+// request describes a single API call
+type request struct {
+	op     string // used in error messages, e.g. "create table"
+	method string
+	path   string
+	query  url.Values
+	body   any
+	status int // expected status code
+	// header, when set, receives the response headers
+	header *http.Header
+}
+
+// do performs req, retrying idempotent methods according to the client's
+// retry policy, and decodes the response body into out when out is non-nil.
+func (c *Client) do(ctx context.Context, req request, out any) error {
+	var payload []byte
+	if req.body != nil {
+		data, err := json.Marshal(req.body)
+		if err != nil {
+			return err
+		}
+		payload = data
 	}
 
-	resp, err := c.client.Post(c.baseURL+"/table", "application/json", bytes.NewBuffer(data))
-	if err != nil {
-		return err
+	target := c.baseURL + req.path
+	if len(req.query) > 0 {
+		target += "?" + req.query.Encode()
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusCreated {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to create table: %s", string(body))
+	attempts := 1
+	if isIdempotent(req.method) && c.retry.MaxAttempts > 1 {
+		attempts = c.retry.MaxAttempts
 	}
 
-	return nil
+	var lastErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			if err := c.retry.wait(ctx, attempt); err != nil {
+				return err
+			}
+		}
+
+		retry, err := c.send(ctx, req, target, payload, out)
+		if err == nil || !retry {
+			return err
+		}
+		lastErr = err
+	}
+	return lastErr
 }
 
-func (c *Client) ListTables() ([]string, error) {
-	resp, err := c.client.Get(c.baseURL + "/table")
+// newRequest builds a request carrying the client's auth and user agent
+func (c *Client) newRequest(ctx context.Context, method, target string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
 	if err != nil {
 		return nil, err
 	}
+	if c.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.authToken)
+	}
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	return req, nil
+}
+
+// send performs one attempt of req and reports whether a failure is worth
+// retrying.
+func (c *Client) send(ctx context.Context, req request, target string, payload []byte, out any) (bool, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+
+	httpReq, err := c.newRequest(ctx, req.method, target, body)
+	if err != nil {
+		return false, err
+	}
+	if payload != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("failed to list tables: %s", string(body))
+	if resp.StatusCode != req.status {
+		return isRetryableStatus(resp.StatusCode), readAPIError(req.op, resp)
 	}
 
-	var tables []string
-	if err := json.NewDecoder(resp.Body).Decode(&tables); err != nil {
-		return nil, err
+	if req.header != nil {
+		*req.header = resp.Header
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return false, err
+		}
 	}
+	return false, nil
+}
 
-	return tables, nil
+func (c *Client) CreateTable(table *db.Table) error {
+	return c.CreateTableContext(context.Background(), table)
 }
 
-func (c *Client) DeleteTable(name string) error {
-	data, err := json.Marshal(map[string]string{"name": name})
+func (c *Client) CreateTableContext(ctx context.Context, table *db.Table) error {
+	return c.do(ctx, request{
+		op:     "create table",
+		method: http.MethodPost,
+		path:   "/table",
+		body:   table,
+		status: http.StatusCreated,
+	}, nil)
+}
+
+func (c *Client) ListTables() ([]string, error) {
+	return c.ListTablesContext(context.Background())
+}
+
+func (c *Client) ListTablesContext(ctx context.Context) ([]string, error) {
+	var tables []string
+	err := c.do(ctx, request{
+		op:     "list tables",
+		method: http.MethodGet,
+		path:   "/table",
+		status: http.StatusOK,
+	}, &tables)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return tables, nil
+}
 
-	req, err := http.NewRequest(http.MethodDelete, c.baseURL+"/table", bytes.NewBuffer(data))
+// GetTable fetches a table's definition
+func (c *Client) GetTable(name string) (*db.Table, error) {
+	return c.GetTableContext(context.Background(), name)
+}
+
+func (c *Client) GetTableContext(ctx context.Context, name string) (*db.Table, error) {
+	var table db.Table
+	err := c.do(ctx, request{
+		op:     "get table",
+		method: http.MethodGet,
+		path:   "/table/" + url.PathEscape(name),
+		status: http.StatusOK,
+	}, &table)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/json")
+	return &table, nil
+}
 
-	resp, err := c.client.Do(req)
+// GetTableStats fetches a table's record counts, expiry, index and change
+// statistics
+func (c *Client) GetTableStats(name string) (*db.TableStats, error) {
+	return c.GetTableStatsContext(context.Background(), name)
+}
+
+func (c *Client) GetTableStatsContext(ctx context.Context, name string) (*db.TableStats, error) {
+	var stats db.TableStats
+	err := c.do(ctx, request{
+		op:     "get table stats",
+		method: http.MethodGet,
+		path:   "/table/" + url.PathEscape(name) + "/stats",
+		status: http.StatusOK,
+	}, &stats)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
+	return &stats, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to delete table: %s", string(body))
-	}
+func (c *Client) DeleteTable(name string) error {
+	return c.DeleteTableContext(context.Background(), name)
+}
 
-	return nil
+func (c *Client) DeleteTableContext(ctx context.Context, name string) error {
+	return c.do(ctx, request{
+		op:     "delete table",
+		method: http.MethodDelete,
+		path:   "/table",
+		body:   map[string]string{"name": name},
+		status: http.StatusOK,
+	}, nil)
 }
 
 func (c *Client) GetRecords(tableName string) ([]map[string]interface{}, error) {
-	resp, err := c.client.Get(c.baseURL + "/tables/" + tableName)
+	return c.GetRecordsContext(context.Background(), tableName)
+}
+
+func (c *Client) GetRecordsContext(ctx context.Context, tableName string) ([]map[string]interface{}, error) {
+	var records []map[string]interface{}
+	err := c.do(ctx, request{
+		op:     "get records",
+		method: http.MethodGet,
+		path:   "/tables/" + url.PathEscape(tableName),
+		status: http.StatusOK,
+	}, &records)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
+	return records, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("failed to get records: %s", string(body))
+func (c *Client) Search(tableName, query string, limit int) ([]db.SearchResult, error) {
+	return c.SearchContext(context.Background(), tableName, query, limit)
+}
+
+func (c *Client) SearchContext(ctx context.Context, tableName, query string, limit int) ([]db.SearchResult, error) {
+	params := url.Values{}
+	params.Set("q", query)
+	if limit > 0 {
+		params.Set("limit", strconv.Itoa(limit))
 	}
 
-	var records []map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&records); err != nil {
+	var results []db.SearchResult
+	err := c.do(ctx, request{
+		op:     "search records",
+		method: http.MethodGet,
+		path:   "/tables/" + url.PathEscape(tableName),
+		query:  params,
+		status: http.StatusOK,
+	}, &results)
+	if err != nil {
 		return nil, err
 	}
+	return results, nil
+}
 
-	return records, nil
+// recordResponse is the body returned by record writes
+type recordResponse struct {
+	ID     int                    `json:"id"`
+	Record map[string]interface{} `json:"record"`
+}
+
+// CreateRecord inserts record and returns the stored record including its
+// generated id
+func (c *Client) CreateRecord(tableName string, record map[string]interface{}) (map[string]interface{}, error) {
+	return c.CreateRecordContext(context.Background(), tableName, record)
 }
 
-func (c *Client) CreateRecord(tableName string, record map[string]interface{}) error {
-	data, err := json.Marshal(record)
+func (c *Client) CreateRecordContext(ctx context.Context, tableName string, record map[string]interface{}) (map[string]interface{}, error) {
+	var resp recordResponse
+	err := c.do(ctx, request{
+		op:     "create record",
+		method: http.MethodPost,
+		path:   "/tables/" + url.PathEscape(tableName),
+		body:   record,
+		status: http.StatusCreated,
+	}, &resp)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return resp.Record, nil
+}
 
-	resp, err := c.client.Post(c.baseURL+"/tables/"+tableName, "application/json", bytes.NewBuffer(data))
+// GetRecord fetches a single record by id
+func (c *Client) GetRecord(tableName string, id interface{}) (map[string]interface{}, error) {
+	return c.GetRecordContext(context.Background(), tableName, id)
+}
+
+func (c *Client) GetRecordContext(ctx context.Context, tableName string, id interface{}) (map[string]interface{}, error) {
+	var record map[string]interface{}
+	err := c.do(ctx, request{
+		op:     "get record",
+		method: http.MethodGet,
+		path:   "/tables/" + url.PathEscape(tableName) + "/" + url.PathEscape(fmt.Sprint(id)),
+		status: http.StatusOK,
+	}, &record)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
+	return record, nil
+}
 
-	if resp.StatusCode != http.StatusCreated {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to create record: %s", string(body))
+// FindRecords lists the records matching every filter
+func (c *Client) FindRecords(tableName string, filters ...db.Filter) ([]map[string]interface{}, error) {
+	return c.FindRecordsContext(context.Background(), tableName, filters...)
+}
+
+func (c *Client) FindRecordsContext(ctx context.Context, tableName string, filters ...db.Filter) ([]map[string]interface{}, error) {
+	params := url.Values{}
+	for _, f := range filters {
+		params.Add("where", f.String())
 	}
 
-	return nil
+	var records []map[string]interface{}
+	err := c.do(ctx, request{
+		op:     "find records",
+		method: http.MethodGet,
+		path:   "/tables/" + url.PathEscape(tableName),
+		query:  params,
+		status: http.StatusOK,
+	}, &records)
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
 }
 
-func (c *Client) UpdateRecord(tableName string, record map[string]interface{}) error {
-	data, err := json.Marshal(record)
-	if err != nil {
-		return err
+// Aggregate computes aggregates over a table on the server. Numbers in the
+// result decode as float64.
+func (c *Client) Aggregate(tableName string, q db.AggregateQuery) ([]db.AggregateGroup, error) {
+	return c.AggregateContext(context.Background(), tableName, q)
+}
+
+func (c *Client) AggregateContext(ctx context.Context, tableName string, q db.AggregateQuery) ([]db.AggregateGroup, error) {
+	params := url.Values{}
+	for _, col := range q.GroupBy {
+		params.Add("group_by", col)
+	}
+	for _, a := range q.Aggregations {
+		params.Add("agg", a.String())
+	}
+	for _, f := range q.Where {
+		params.Add("where", f.String())
 	}
 
-	req, err := http.NewRequest(http.MethodPut, c.baseURL+"/tables/"+tableName, bytes.NewBuffer(data))
+	var groups []db.AggregateGroup
+	err := c.do(ctx, request{
+		op:     "aggregate records",
+		method: http.MethodGet,
+		path:   "/tables/" + url.PathEscape(tableName) + "/aggregate",
+		query:  params,
+		status: http.StatusOK,
+	}, &groups)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/json")
+	return groups, nil
+}
 
-	resp, err := c.client.Do(req)
-	if err != nil {
-		return err
+// UpsertRecord inserts record, or merges it into the record whose key
+// columns match (every field of the record when key is empty). With dryRun
+// the server only reports what it would do.
+func (c *Client) UpsertRecord(tableName string, key []string, record map[string]interface{}, dryRun bool) (*db.UpsertResult, error) {
+	return c.UpsertRecordContext(context.Background(), tableName, key, record, dryRun)
+}
+
+func (c *Client) UpsertRecordContext(ctx context.Context, tableName string, key []string, record map[string]interface{}, dryRun bool) (*db.UpsertResult, error) {
+	params := url.Values{}
+	for _, col := range key {
+		params.Add("key", col)
+	}
+	if dryRun {
+		params.Set("dry_run", "true")
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to update record: %s", string(body))
+	var result db.UpsertResult
+	err := c.do(ctx, request{
+		op:     "upsert record",
+		method: http.MethodPost,
+		path:   "/tables/" + url.PathEscape(tableName) + "/upsert",
+		query:  params,
+		body:   record,
+		status: http.StatusOK,
+	}, &result)
+	if err != nil {
+		return nil, err
 	}
+	return &result, nil
+}
 
-	return nil
+// TruncateTable deletes every record of a table and returns how many there
+// were
+func (c *Client) TruncateTable(tableName string) (int, error) {
+	return c.TruncateTableContext(context.Background(), tableName)
 }
 
-func (c *Client) DeleteRecord(tableName string, id interface{}) error {
-	data, err := json.Marshal(map[string]interface{}{"id": id})
+func (c *Client) TruncateTableContext(ctx context.Context, tableName string) (int, error) {
+	var resp struct {
+		Deleted int `json:"deleted"`
+	}
+	err := c.do(ctx, request{
+		op:     "truncate table",
+		method: http.MethodPost,
+		path:   "/tables/" + url.PathEscape(tableName) + "/truncate",
+		status: http.StatusOK,
+	}, &resp)
 	if err != nil {
-		return err
+		return 0, err
 	}
+	return resp.Deleted, nil
+}
 
-	req, err := http.NewRequest(http.MethodDelete, c.baseURL+"/tables/"+tableName, bytes.NewBuffer(data))
-	if err != nil {
-		return err
+// Page is one page of a filtered record listing
+type Page struct {
+	Records []map[string]interface{}
+	// Total is the number of matching records across all pages
+	Total int
+}
+
+// ListPage returns up to limit records matching every filter, starting at
+// offset. A limit of zero returns every remaining record.
+func (c *Client) ListPage(tableName string, offset, limit int, filters ...db.Filter) (*Page, error) {
+	return c.ListPageContext(context.Background(), tableName, offset, limit, filters...)
+}
+
+func (c *Client) ListPageContext(ctx context.Context, tableName string, offset, limit int, filters ...db.Filter) (*Page, error) {
+	params := url.Values{}
+	for _, f := range filters {
+		params.Add("where", f.String())
+	}
+	if offset > 0 {
+		params.Set("offset", strconv.Itoa(offset))
+	}
+	if limit > 0 {
+		params.Set("limit", strconv.Itoa(limit))
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := c.client.Do(req)
+	page := &Page{}
+	var header http.Header
+	err := c.do(ctx, request{
+		op:     "list records",
+		method: http.MethodGet,
+		path:   "/tables/" + url.PathEscape(tableName),
+		query:  params,
+		status: http.StatusOK,
+		header: &header,
+	}, &page.Records)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to delete record: %s", string(body))
+	page.Total = len(page.Records)
+	if total, err := strconv.Atoi(header.Get("X-Total-Count")); err == nil {
+		page.Total = total
+	}
+	return page, nil
+}
+
+// UpdateRecord merges record into the stored record with the same id and
+// returns the result
+func (c *Client) UpdateRecord(tableName string, record map[string]interface{}) (map[string]interface{}, error) {
+	return c.UpdateRecordContext(context.Background(), tableName, record)
+}
+
+func (c *Client) UpdateRecordContext(ctx context.Context, tableName string, record map[string]interface{}) (map[string]interface{}, error) {
+	var resp recordResponse
+	err := c.do(ctx, request{
+		op:     "update record",
+		method: http.MethodPut,
+		path:   "/tables/" + url.PathEscape(tableName),
+		body:   record,
+		status: http.StatusOK,
+	}, &resp)
+	if err != nil {
+		return nil, err
 	}
+	return resp.Record, nil
+}
+
+func (c *Client) DeleteRecord(tableName string, id interface{}) error {
+	return c.DeleteRecordContext(context.Background(), tableName, id)
+}
 
-	return nil
+func (c *Client) DeleteRecordContext(ctx context.Context, tableName string, id interface{}) error {
+	return c.do(ctx, request{
+		op:     "delete record",
+		method: http.MethodDelete,
+		path:   "/tables/" + url.PathEscape(tableName),
+		body:   map[string]interface{}{"id": id},
+		status: http.StatusOK,
+	}, nil)
 }
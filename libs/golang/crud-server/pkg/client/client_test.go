@@ -0,0 +1,163 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dae-go/crud-server/pkg/db"
+	"github.com/dae-go/crud-server/pkg/server"
+)
+
+func TestAPIErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		expected error
+	}{
+		{"not found", http.StatusNotFound, ErrNotFound},
+		{"conflict", http.StatusConflict, ErrConflict},
+		{"validation", http.StatusBadRequest, ErrValidation},
+		{"unauthorized", http.StatusUnauthorized, ErrUnauthorized},
+		{"forbidden", http.StatusForbidden, ErrForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "boom", tt.status)
+			}))
+			defer srv.Close()
+
+			_, err := NewClient(srv.URL).ListTables()
+			if !errors.Is(err, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, err)
+			}
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.status {
+				t.Errorf("expected APIError with status %d, got %v", tt.status, err)
+			}
+		})
+	}
+}
+
+func TestRetries(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond, Jitter: 0.5}
+
+	t.Run("retries idempotent calls", func(t *testing.T) {
+		var calls atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) < 3 {
+				http.Error(w, "busy", http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte(`["users"]`))
+		}))
+		defer srv.Close()
+
+		tables, err := NewClient(srv.URL, WithRetryPolicy(policy)).ListTables()
+		if err != nil {
+			t.Fatalf("ListTables failed: %v", err)
+		}
+		if len(tables) != 1 || calls.Load() != 3 {
+			t.Errorf("expected 3 calls and 1 table, got %d calls and %v", calls.Load(), tables)
+		}
+	})
+
+	t.Run("does not retry POST", func(t *testing.T) {
+		var calls atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+		}))
+		defer srv.Close()
+
+		_, err := NewClient(srv.URL, WithRetryPolicy(policy)).CreateRecord("users", map[string]interface{}{"name": "x"})
+		if err == nil || calls.Load() != 1 {
+			t.Errorf("expected a single failed call, got %d calls and %v", calls.Load(), err)
+		}
+	})
+
+	t.Run("stops when the context is done", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+		}))
+		defer srv.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		slow := RetryPolicy{MaxAttempts: 5, BaseDelay: time.Hour}
+		_, err := NewClient(srv.URL, WithRetryPolicy(slow)).ListTablesContext(ctx)
+		if err == nil {
+			t.Error("expected an error for a cancelled context")
+		}
+	})
+}
+
+func TestHeaders(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("unexpected Authorization header %q", got)
+		}
+		if got := r.Header.Get("User-Agent"); got != "crud-test" {
+			t.Errorf("unexpected User-Agent header %q", got)
+		}
+		w.Write([]byte(`[]`))
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, WithAuthToken("secret"), WithUserAgent("crud-test"), WithTimeout(time.Second))
+	if _, err := c.ListTables(); err != nil {
+		t.Fatalf("ListTables failed: %v", err)
+	}
+}
+
+func TestTableNameEscaping(t *testing.T) {
+	for _, prefix := range []string{"", "/api"} {
+		srv := httptest.NewServer(server.New(server.WithPrefix(prefix)))
+		defer srv.Close()
+		c := NewClient(srv.URL + prefix)
+
+		// Names that would otherwise reach another table, a record or the
+		// query string
+		names := []string{"notes", "notes/1", "notes?x=1", "notes#top", "my notes"}
+		for _, name := range names {
+			if err := c.CreateTable(&db.Table{Name: name, Columns: []db.Column{{Name: "table", Type: "string"}}}); err != nil {
+				t.Fatalf("CreateTable(%q) failed: %v", name, err)
+			}
+			if _, err := c.CreateRecord(name, map[string]interface{}{"table": name}); err != nil {
+				t.Fatalf("CreateRecord(%q) failed: %v", name, err)
+			}
+		}
+		for _, name := range names {
+			records, err := c.GetRecords(name)
+			if err != nil || len(records) != 1 || records[0]["table"] != name {
+				t.Errorf("%sGetRecords(%q) = %v, %v", prefix, name, records, err)
+			}
+			record, err := c.GetRecord(name, 1)
+			if err != nil || record["table"] != name {
+				t.Errorf("%sGetRecord(%q, 1) = %v, %v", prefix, name, record, err)
+			}
+			if _, err := c.UpdateRecord(name, map[string]interface{}{"id": 1, "seen": true}); err != nil {
+				t.Errorf("%sUpdateRecord(%q) failed: %v", prefix, name, err)
+			}
+			groups, err := c.Aggregate(name, db.AggregateQuery{Aggregations: []db.Aggregation{{Func: db.AggCount}}})
+			if err != nil || len(groups) != 1 {
+				t.Errorf("%sAggregate(%q) = %v, %v", prefix, name, groups, err)
+			}
+			if _, err := c.UpsertRecord(name, []string{"table"}, map[string]interface{}{"table": name}, false); err != nil {
+				t.Errorf("%sUpsertRecord(%q) failed: %v", prefix, name, err)
+			}
+			if err := c.DeleteRecord(name, 1); err != nil {
+				t.Errorf("%sDeleteRecord(%q) failed: %v", prefix, name, err)
+			}
+			if _, err := c.TruncateTable(name); err != nil {
+				t.Errorf("%sTruncateTable(%q) failed: %v", prefix, name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Sentinel errors matched by errors.Is against an *APIError
+var (
+	ErrNotFound   = errors.New("not found")
+	ErrConflict   = errors.New("conflict")
+	ErrValidation = errors.New("validation failed")
+	// ErrUnauthorized means the auth token is missing or invalid
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden means the token's subject may not perform the request
+	ErrForbidden = errors.New("forbidden")
+)
+
+// APIError is returned when the server answers with an unexpected status
+type APIError struct {
+	Op         string
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("failed to %s: %s", e.Op, e.Message)
+}
+
+// Unwrap maps the status code onto one of the sentinel errors
+func (e *APIError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusConflict:
+		return ErrConflict
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return ErrValidation
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusForbidden:
+		return ErrForbidden
+	}
+	return nil
+}
+
+// readAPIError builds an APIError from an unexpected response
+func readAPIError(op string, resp *http.Response) error {
+	data, _ := io.ReadAll(resp.Body)
+	return &APIError{
+		Op:         op,
+		StatusCode: resp.StatusCode,
+		Message:    strings.TrimSpace(string(data)),
+	}
+}
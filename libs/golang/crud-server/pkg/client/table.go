@@ -0,0 +1,121 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/dae-go/crud-server/pkg/db"
+)
+
+// Table is a typed handle on a server table. Values of T are converted to
+// and from records through encoding/json, so struct fields are mapped to
+// columns using their `json` tags. A field tagged `json:"id"` receives the
+// record's generated id.
+type Table[T any] struct {
+	client *Client
+	name   string
+}
+
+// NewTable returns a typed handle on the table called name
+func NewTable[T any](c *Client, name string) *Table[T] {
+	return &Table[T]{client: c, name: name}
+}
+
+// Name returns the table name
+func (t *Table[T]) Name() string {
+	return t.name
+}
+
+// Insert stores v, updates it with the stored record and returns the
+// generated id
+func (t *Table[T]) Insert(ctx context.Context, v *T) (int, error) {
+	record, err := toRecord(v)
+	if err != nil {
+		return 0, err
+	}
+	delete(record, "id")
+
+	stored, err := t.client.CreateRecordContext(ctx, t.name, record)
+	if err != nil {
+		return 0, err
+	}
+	if err := fromRecord(stored, v); err != nil {
+		return 0, err
+	}
+
+	id, ok := stored["id"].(float64)
+	if !ok {
+		return 0, fmt.Errorf("server returned record without a numeric id")
+	}
+	return int(id), nil
+}
+
+// Get fetches the record with the given id
+func (t *Table[T]) Get(ctx context.Context, id int) (T, error) {
+	var v T
+	record, err := t.client.GetRecordContext(ctx, t.name, id)
+	if err != nil {
+		return v, err
+	}
+	err = fromRecord(record, &v)
+	return v, err
+}
+
+// List returns every record matching all filters
+func (t *Table[T]) List(ctx context.Context, filters ...db.Filter) ([]T, error) {
+	records, err := t.client.FindRecordsContext(ctx, t.name, filters...)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]T, len(records))
+	for i, record := range records {
+		if err := fromRecord(record, &values[i]); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// Update replaces the fields of the record with the given id with those of
+// v and updates v with the stored record
+func (t *Table[T]) Update(ctx context.Context, id int, v *T) error {
+	record, err := toRecord(v)
+	if err != nil {
+		return err
+	}
+	record["id"] = id
+
+	stored, err := t.client.UpdateRecordContext(ctx, t.name, record)
+	if err != nil {
+		return err
+	}
+	return fromRecord(stored, v)
+}
+
+// Delete removes the record with the given id
+func (t *Table[T]) Delete(ctx context.Context, id int) error {
+	return t.client.DeleteRecordContext(ctx, t.name, id)
+}
+
+func toRecord(v any) (map[string]interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("value must encode to a JSON object: %w", err)
+	}
+	return record, nil
+}
+
+func fromRecord(record map[string]interface{}, v any) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,133 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dae-go/crud-server/pkg/db"
+	"github.com/dae-go/crud-server/pkg/server"
+)
+
+type user struct {
+	ID    int    `json:"id,omitempty"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Age   int    `json:"age"`
+}
+
+func newTestTable(t *testing.T) *Table[user] {
+	t.Helper()
+	srv := httptest.NewServer(server.New())
+	t.Cleanup(srv.Close)
+
+	c := NewClient(srv.URL)
+	err := c.CreateTable(&db.Table{
+		Name: "users",
+		Columns: []db.Column{
+			{Name: "name", Type: "string"},
+			{Name: "email", Type: "string"},
+			{Name: "age", Type: "number"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateTable failed: %v", err)
+	}
+	return NewTable[user](c, "users")
+}
+
+func TestTable(t *testing.T) {
+	ctx := context.Background()
+	users := newTestTable(t)
+
+	alice := user{Name: "Alice", Email: "alice@example.com", Age: 31}
+	id, err := users.Insert(ctx, &alice)
+	if err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if id != 1 || alice.ID != 1 {
+		t.Fatalf("expected id 1 on return value and struct, got %d and %d", id, alice.ID)
+	}
+
+	bob := user{Name: "Bob", Email: "bob@example.com", Age: 24}
+	if _, err := users.Insert(ctx, &bob); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	got, err := users.Get(ctx, id)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if got != alice {
+		t.Errorf("expected %+v, got %+v", alice, got)
+	}
+
+	older, err := users.List(ctx, db.Where("age", db.OpGt, 30))
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(older) != 1 || older[0].Name != "Alice" {
+		t.Errorf("expected only Alice, got %+v", older)
+	}
+
+	alice.Age = 32
+	if err := users.Update(ctx, id, &alice); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if got, _ := users.Get(ctx, id); got.Age != 32 {
+		t.Errorf("expected updated age 32, got %d", got.Age)
+	}
+
+	if err := users.Delete(ctx, id); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := users.Get(ctx, id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound after delete, got %v", err)
+	}
+
+	all, err := users.List(ctx)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(all) != 1 || all[0] != bob {
+		t.Errorf("expected only Bob, got %+v", all)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	ctx := context.Background()
+	users := newTestTable(t)
+	for _, u := range []user{
+		{Name: "Alice", Email: "alice@example.com", Age: 31},
+		{Name: "Bob", Email: "bob@example.com", Age: 24},
+		{Name: "Alice", Email: "alice@work.example", Age: 41},
+	} {
+		if _, err := users.Insert(ctx, &u); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	avg, _ := db.ParseAggregation("avg(age)")
+	groups, err := users.client.AggregateContext(ctx, "users", db.AggregateQuery{
+		GroupBy:      []string{"name"},
+		Aggregations: []db.Aggregation{{Func: db.AggCount}, avg},
+		Where:        []db.Filter{db.Where("age", db.OpGte, 25)},
+	})
+	if err != nil {
+		t.Fatalf("Aggregate failed: %v", err)
+	}
+	if len(groups) != 1 || groups[0].Group["name"] != "Alice" ||
+		groups[0].Values["count"] != 2.0 || groups[0].Values["avg(age)"] != 36.0 {
+		t.Errorf("unexpected groups %+v", groups)
+	}
+
+	_, err = users.client.AggregateContext(ctx, "users", db.AggregateQuery{Aggregations: []db.Aggregation{{Func: "median", Column: "age"}}})
+	if !errors.Is(err, ErrValidation) {
+		t.Errorf("expected ErrValidation for an unknown function, got %v", err)
+	}
+	_, err = users.client.AggregateContext(ctx, "missing", db.AggregateQuery{})
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a missing table, got %v", err)
+	}
+}
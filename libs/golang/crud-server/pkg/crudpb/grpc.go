@@ -0,0 +1,169 @@
+package crudpb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ServiceName is the fully qualified name of the Crud service
+const ServiceName = "crud.v1.Crud"
+
+// Method paths, as sent in the :path pseudo-header
+const (
+	MethodListTables   = "/" + ServiceName + "/ListTables"
+	MethodGetTable     = "/" + ServiceName + "/GetTable"
+	MethodCreateTable  = "/" + ServiceName + "/CreateTable"
+	MethodDeleteTable  = "/" + ServiceName + "/DeleteTable"
+	MethodGetRecord    = "/" + ServiceName + "/GetRecord"
+	MethodInsertRecord = "/" + ServiceName + "/InsertRecord"
+	MethodUpdateRecord = "/" + ServiceName + "/UpdateRecord"
+	MethodDeleteRecord = "/" + ServiceName + "/DeleteRecord"
+	MethodListRecords  = "/" + ServiceName + "/ListRecords"
+	MethodWatch        = "/" + ServiceName + "/Watch"
+)
+
+// MaxMessageSize is the largest message either side accepts, matching the
+// default of the reference gRPC implementations
+const MaxMessageSize = 4 << 20
+
+// Code is a gRPC status code
+type Code uint32
+
+const (
+	OK               Code = 0
+	Canceled         Code = 1
+	Unknown          Code = 2
+	InvalidArgument  Code = 3
+	DeadlineExceeded Code = 4
+	NotFound         Code = 5
+	AlreadyExists    Code = 6
+	PermissionDenied Code = 7
+	Unimplemented    Code = 12
+	Internal         Code = 13
+	Unavailable      Code = 14
+	Unauthenticated  Code = 16
+)
+
+var codeNames = map[Code]string{
+	OK:               "OK",
+	Canceled:         "Canceled",
+	Unknown:          "Unknown",
+	InvalidArgument:  "InvalidArgument",
+	DeadlineExceeded: "DeadlineExceeded",
+	NotFound:         "NotFound",
+	AlreadyExists:    "AlreadyExists",
+	PermissionDenied: "PermissionDenied",
+	Unimplemented:    "Unimplemented",
+	Internal:         "Internal",
+	Unavailable:      "Unavailable",
+	Unauthenticated:  "Unauthenticated",
+}
+
+func (c Code) String() string {
+	if name, ok := codeNames[c]; ok {
+		return name
+	}
+	return "Code(" + strconv.FormatUint(uint64(c), 10) + ")"
+}
+
+// WriteFrame writes m with the gRPC length prefix. Messages are never
+// compressed.
+func WriteFrame(w io.Writer, m Message) error {
+	data := m.Marshal()
+	frame := make([]byte, 5, 5+len(data))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(data)))
+	_, err := w.Write(append(frame, data...))
+	return err
+}
+
+// ReadFrame reads one length-prefixed message. It returns io.EOF when the
+// stream ends cleanly between messages.
+func ReadFrame(r io.Reader) ([]byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("truncated gRPC frame header")
+		}
+		return nil, err
+	}
+	if header[0] != 0 {
+		return nil, fmt.Errorf("compressed gRPC messages are not supported")
+	}
+	n := binary.BigEndian.Uint32(header[1:])
+	if n > MaxMessageSize {
+		return nil, fmt.Errorf("gRPC message of %d bytes exceeds the %d byte limit", n, MaxMessageSize)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("truncated gRPC message: %w", err)
+	}
+	return data, nil
+}
+
+// EncodeMessage escapes a status message for the grpc-message trailer
+func EncodeMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// DecodeMessage reverses EncodeMessage
+func DecodeMessage(msg string) string {
+	if s, err := url.PathUnescape(msg); err == nil {
+		return s
+	}
+	return msg
+}
+
+// ParseTimeout parses a grpc-timeout header value such as "250m"
+func ParseTimeout(v string) (time.Duration, error) {
+	if len(v) < 2 || len(v) > 9 {
+		return 0, fmt.Errorf("invalid grpc-timeout %q", v)
+	}
+	n, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid grpc-timeout %q", v)
+	}
+	units := map[byte]time.Duration{
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+		'm': time.Millisecond,
+		'u': time.Microsecond,
+		'n': time.Nanosecond,
+	}
+	unit, ok := units[v[len(v)-1]]
+	if !ok {
+		return 0, fmt.Errorf("invalid grpc-timeout %q", v)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// FormatTimeout renders d as a grpc-timeout header value
+func FormatTimeout(d time.Duration) string {
+	if d <= 0 {
+		return "1n"
+	}
+	// The value may have at most eight digits
+	for _, u := range []struct {
+		suffix string
+		unit   time.Duration
+	}{{"n", time.Nanosecond}, {"u", time.Microsecond}, {"m", time.Millisecond}, {"S", time.Second}, {"M", time.Minute}} {
+		if n := d / u.unit; n < 1e8 {
+			return strconv.FormatInt(int64(n), 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(int64(d/time.Hour), 10) + "H"
+}
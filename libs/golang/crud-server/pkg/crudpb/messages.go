@@ -0,0 +1,615 @@
+// Package crudpb holds the message types of the crud-server gRPC API
+// (crud.proto) together with their protobuf encoding and the gRPC framing
+// and status codes shared by the server and pkg/grpcclient.
+package crudpb
+
+import (
+	"time"
+
+	"github.com/dae-go/crud-server/pkg/db"
+)
+
+// Message is implemented by every request and response type
+type Message interface {
+	Marshal() []byte
+	Unmarshal(b []byte) error
+}
+
+// decodeFields calls fn for each field in b. Fields fn does not handle are
+// skipped, so messages from newer peers still decode.
+func decodeFields(b []byte, fn func(d *decoder, field, wire int) (handled bool, err error)) error {
+	d := decoder{buf: b}
+	for !d.done() {
+		field, wire, err := d.next()
+		if err != nil {
+			return err
+		}
+		handled, err := fn(&d, field, wire)
+		if err != nil {
+			return err
+		}
+		if !handled {
+			if err := d.skip(wire); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// stringField decodes a string field after checking its wire type
+func stringField(d *decoder, field, wire int, dst *string) (bool, error) {
+	if err := expect(field, wire, wireBytes); err != nil {
+		return false, err
+	}
+	s, err := d.string()
+	*dst = s
+	return true, err
+}
+
+func int64Field(d *decoder, field, wire int, dst *int64) (bool, error) {
+	if err := expect(field, wire, wireVarint); err != nil {
+		return false, err
+	}
+	v, err := d.varint()
+	*dst = int64(v)
+	return true, err
+}
+
+func int32Field(d *decoder, field, wire int, dst *int32) (bool, error) {
+	var v int64
+	handled, err := int64Field(d, field, wire, &v)
+	*dst = int32(v)
+	return handled, err
+}
+
+func structField(d *decoder, field, wire int, dst *map[string]any) (bool, error) {
+	if err := expect(field, wire, wireBytes); err != nil {
+		return false, err
+	}
+	b, err := d.bytes()
+	if err != nil {
+		return true, err
+	}
+	*dst, err = decodeStruct(b)
+	return true, err
+}
+
+// Column describes one table column
+type Column struct {
+	Name string
+	Type string
+	Ref  string
+}
+
+func (m *Column) Marshal() []byte {
+	var e encoder
+	e.string(1, m.Name)
+	e.string(2, m.Type)
+	e.string(3, m.Ref)
+	return e.buf
+}
+
+func (m *Column) Unmarshal(b []byte) error {
+	*m = Column{}
+	return decodeFields(b, func(d *decoder, field, wire int) (bool, error) {
+		switch field {
+		case 1:
+			return stringField(d, field, wire, &m.Name)
+		case 2:
+			return stringField(d, field, wire, &m.Type)
+		case 3:
+			return stringField(d, field, wire, &m.Ref)
+		}
+		return false, nil
+	})
+}
+
+// Table is a table definition
+type Table struct {
+	Name          string
+	Columns       []Column
+	SearchColumns []string
+	TTL           string
+	ExpireMode    string
+}
+
+func (m *Table) Marshal() []byte {
+	var e encoder
+	e.string(1, m.Name)
+	for i := range m.Columns {
+		e.bytes(2, m.Columns[i].Marshal())
+	}
+	for _, s := range m.SearchColumns {
+		e.bytes(3, []byte(s))
+	}
+	e.string(4, m.TTL)
+	e.string(5, m.ExpireMode)
+	return e.buf
+}
+
+func (m *Table) Unmarshal(b []byte) error {
+	*m = Table{}
+	return decodeFields(b, func(d *decoder, field, wire int) (bool, error) {
+		switch field {
+		case 1:
+			return stringField(d, field, wire, &m.Name)
+		case 2:
+			if err := expect(field, wire, wireBytes); err != nil {
+				return false, err
+			}
+			data, err := d.bytes()
+			if err != nil {
+				return true, err
+			}
+			var c Column
+			if err := c.Unmarshal(data); err != nil {
+				return true, err
+			}
+			m.Columns = append(m.Columns, c)
+			return true, nil
+		case 3:
+			var s string
+			handled, err := stringField(d, field, wire, &s)
+			m.SearchColumns = append(m.SearchColumns, s)
+			return handled, err
+		case 4:
+			return stringField(d, field, wire, &m.TTL)
+		case 5:
+			return stringField(d, field, wire, &m.ExpireMode)
+		}
+		return false, nil
+	})
+}
+
+// TableFromDB converts a db.Table
+func TableFromDB(t *db.Table) *Table {
+	m := &Table{
+		Name:          t.Name,
+		SearchColumns: append([]string(nil), t.SearchColumns...),
+		TTL:           t.TTL,
+		ExpireMode:    t.ExpireMode,
+	}
+	for _, c := range t.Columns {
+		m.Columns = append(m.Columns, Column{Name: c.Name, Type: c.Type, Ref: c.Ref})
+	}
+	return m
+}
+
+// DB converts the table to a db.Table
+func (m *Table) DB() *db.Table {
+	t := &db.Table{
+		Name:          m.Name,
+		Columns:       []db.Column{},
+		SearchColumns: m.SearchColumns,
+		TTL:           m.TTL,
+		ExpireMode:    m.ExpireMode,
+	}
+	for _, c := range m.Columns {
+		t.Columns = append(t.Columns, db.Column{Name: c.Name, Type: c.Type, Ref: c.Ref})
+	}
+	return t
+}
+
+// ListTablesRequest is the request for ListTables
+type ListTablesRequest struct{}
+
+func (m *ListTablesRequest) Marshal() []byte { return nil }
+
+func (m *ListTablesRequest) Unmarshal(b []byte) error {
+	return decodeFields(b, func(*decoder, int, int) (bool, error) { return false, nil })
+}
+
+// ListTablesResponse is the response of ListTables
+type ListTablesResponse struct {
+	Names []string
+}
+
+func (m *ListTablesResponse) Marshal() []byte {
+	var e encoder
+	for _, n := range m.Names {
+		e.bytes(1, []byte(n))
+	}
+	return e.buf
+}
+
+func (m *ListTablesResponse) Unmarshal(b []byte) error {
+	*m = ListTablesResponse{}
+	return decodeFields(b, func(d *decoder, field, wire int) (bool, error) {
+		if field != 1 {
+			return false, nil
+		}
+		var s string
+		handled, err := stringField(d, field, wire, &s)
+		m.Names = append(m.Names, s)
+		return handled, err
+	})
+}
+
+// GetTableRequest is the request for GetTable
+type GetTableRequest struct {
+	Name string
+}
+
+func (m *GetTableRequest) Marshal() []byte {
+	var e encoder
+	e.string(1, m.Name)
+	return e.buf
+}
+
+func (m *GetTableRequest) Unmarshal(b []byte) error {
+	*m = GetTableRequest{}
+	return decodeFields(b, func(d *decoder, field, wire int) (bool, error) {
+		if field == 1 {
+			return stringField(d, field, wire, &m.Name)
+		}
+		return false, nil
+	})
+}
+
+// CreateTableRequest is the request for CreateTable
+type CreateTableRequest struct {
+	Table *Table
+}
+
+func (m *CreateTableRequest) Marshal() []byte {
+	var e encoder
+	if m.Table != nil {
+		e.bytes(1, m.Table.Marshal())
+	}
+	return e.buf
+}
+
+func (m *CreateTableRequest) Unmarshal(b []byte) error {
+	*m = CreateTableRequest{}
+	return decodeFields(b, func(d *decoder, field, wire int) (bool, error) {
+		if field != 1 {
+			return false, nil
+		}
+		if err := expect(field, wire, wireBytes); err != nil {
+			return false, err
+		}
+		data, err := d.bytes()
+		if err != nil {
+			return true, err
+		}
+		m.Table = &Table{}
+		return true, m.Table.Unmarshal(data)
+	})
+}
+
+// DeleteTableRequest is the request for DeleteTable
+type DeleteTableRequest struct {
+	Name string
+}
+
+func (m *DeleteTableRequest) Marshal() []byte {
+	var e encoder
+	e.string(1, m.Name)
+	return e.buf
+}
+
+func (m *DeleteTableRequest) Unmarshal(b []byte) error {
+	*m = DeleteTableRequest{}
+	return decodeFields(b, func(d *decoder, field, wire int) (bool, error) {
+		if field == 1 {
+			return stringField(d, field, wire, &m.Name)
+		}
+		return false, nil
+	})
+}
+
+// DeleteTableResponse is the response of DeleteTable
+type DeleteTableResponse struct{}
+
+func (m *DeleteTableResponse) Marshal() []byte { return nil }
+
+func (m *DeleteTableResponse) Unmarshal(b []byte) error {
+	return decodeFields(b, func(*decoder, int, int) (bool, error) { return false, nil })
+}
+
+// Record is a table record. Fields holds every column except id.
+type Record struct {
+	ID     int64
+	Fields map[string]any
+}
+
+func (m *Record) Marshal() []byte {
+	var e encoder
+	e.int64(1, m.ID)
+	if m.Fields != nil {
+		e.bytes(2, encodeStruct(m.Fields))
+	}
+	return e.buf
+}
+
+func (m *Record) Unmarshal(b []byte) error {
+	*m = Record{}
+	return decodeFields(b, func(d *decoder, field, wire int) (bool, error) {
+		switch field {
+		case 1:
+			return int64Field(d, field, wire, &m.ID)
+		case 2:
+			return structField(d, field, wire, &m.Fields)
+		}
+		return false, nil
+	})
+}
+
+// RecordFromMap converts a database record, moving its id out of the fields
+func RecordFromMap(record map[string]any) *Record {
+	m := &Record{Fields: make(map[string]any, len(record))}
+	for k, v := range record {
+		if k != "id" {
+			m.Fields[k] = v
+			continue
+		}
+		switch id := v.(type) {
+		case int:
+			m.ID = int64(id)
+		case int64:
+			m.ID = id
+		case float64:
+			m.ID = int64(id)
+		}
+	}
+	return m
+}
+
+// Map returns the record as a database record with an int64 "id"
+func (m *Record) Map() map[string]any {
+	record := make(map[string]any, len(m.Fields)+1)
+	for k, v := range m.Fields {
+		record[k] = v
+	}
+	record["id"] = m.ID
+	return record
+}
+
+// GetRecordRequest is the request for GetRecord
+type GetRecordRequest struct {
+	Table string
+	ID    int64
+}
+
+func (m *GetRecordRequest) Marshal() []byte {
+	var e encoder
+	e.string(1, m.Table)
+	e.int64(2, m.ID)
+	return e.buf
+}
+
+func (m *GetRecordRequest) Unmarshal(b []byte) error {
+	*m = GetRecordRequest{}
+	return decodeFields(b, func(d *decoder, field, wire int) (bool, error) {
+		switch field {
+		case 1:
+			return stringField(d, field, wire, &m.Table)
+		case 2:
+			return int64Field(d, field, wire, &m.ID)
+		}
+		return false, nil
+	})
+}
+
+// InsertRecordRequest is the request for InsertRecord
+type InsertRecordRequest struct {
+	Table  string
+	Fields map[string]any
+}
+
+func (m *InsertRecordRequest) Marshal() []byte {
+	var e encoder
+	e.string(1, m.Table)
+	e.bytes(2, encodeStruct(m.Fields))
+	return e.buf
+}
+
+func (m *InsertRecordRequest) Unmarshal(b []byte) error {
+	*m = InsertRecordRequest{}
+	return decodeFields(b, func(d *decoder, field, wire int) (bool, error) {
+		switch field {
+		case 1:
+			return stringField(d, field, wire, &m.Table)
+		case 2:
+			return structField(d, field, wire, &m.Fields)
+		}
+		return false, nil
+	})
+}
+
+// UpdateRecordRequest is the request for UpdateRecord
+type UpdateRecordRequest struct {
+	Table  string
+	ID     int64
+	Fields map[string]any
+}
+
+func (m *UpdateRecordRequest) Marshal() []byte {
+	var e encoder
+	e.string(1, m.Table)
+	e.int64(2, m.ID)
+	e.bytes(3, encodeStruct(m.Fields))
+	return e.buf
+}
+
+func (m *UpdateRecordRequest) Unmarshal(b []byte) error {
+	*m = UpdateRecordRequest{}
+	return decodeFields(b, func(d *decoder, field, wire int) (bool, error) {
+		switch field {
+		case 1:
+			return stringField(d, field, wire, &m.Table)
+		case 2:
+			return int64Field(d, field, wire, &m.ID)
+		case 3:
+			return structField(d, field, wire, &m.Fields)
+		}
+		return false, nil
+	})
+}
+
+// DeleteRecordRequest is the request for DeleteRecord
+type DeleteRecordRequest struct {
+	Table string
+	ID    int64
+}
+
+func (m *DeleteRecordRequest) Marshal() []byte {
+	var e encoder
+	e.string(1, m.Table)
+	e.int64(2, m.ID)
+	return e.buf
+}
+
+func (m *DeleteRecordRequest) Unmarshal(b []byte) error {
+	*m = DeleteRecordRequest{}
+	return decodeFields(b, func(d *decoder, field, wire int) (bool, error) {
+		switch field {
+		case 1:
+			return stringField(d, field, wire, &m.Table)
+		case 2:
+			return int64Field(d, field, wire, &m.ID)
+		}
+		return false, nil
+	})
+}
+
+// DeleteRecordResponse is the response of DeleteRecord
+type DeleteRecordResponse struct{}
+
+func (m *DeleteRecordResponse) Marshal() []byte { return nil }
+
+func (m *DeleteRecordResponse) Unmarshal(b []byte) error {
+	return decodeFields(b, func(*decoder, int, int) (bool, error) { return false, nil })
+}
+
+// ListRecordsRequest is the request for ListRecords
+type ListRecordsRequest struct {
+	Table  string
+	Where  []string
+	Offset int32
+	Limit  int32
+}
+
+func (m *ListRecordsRequest) Marshal() []byte {
+	var e encoder
+	e.string(1, m.Table)
+	for _, w := range m.Where {
+		e.bytes(2, []byte(w))
+	}
+	e.int64(3, int64(m.Offset))
+	e.int64(4, int64(m.Limit))
+	return e.buf
+}
+
+func (m *ListRecordsRequest) Unmarshal(b []byte) error {
+	*m = ListRecordsRequest{}
+	return decodeFields(b, func(d *decoder, field, wire int) (bool, error) {
+		switch field {
+		case 1:
+			return stringField(d, field, wire, &m.Table)
+		case 2:
+			var s string
+			handled, err := stringField(d, field, wire, &s)
+			m.Where = append(m.Where, s)
+			return handled, err
+		case 3:
+			return int32Field(d, field, wire, &m.Offset)
+		case 4:
+			return int32Field(d, field, wire, &m.Limit)
+		}
+		return false, nil
+	})
+}
+
+// WatchRequest is the request for Watch
+type WatchRequest struct {
+	Tables []string
+}
+
+func (m *WatchRequest) Marshal() []byte {
+	var e encoder
+	for _, t := range m.Tables {
+		e.bytes(1, []byte(t))
+	}
+	return e.buf
+}
+
+func (m *WatchRequest) Unmarshal(b []byte) error {
+	*m = WatchRequest{}
+	return decodeFields(b, func(d *decoder, field, wire int) (bool, error) {
+		if field != 1 {
+			return false, nil
+		}
+		var s string
+		handled, err := stringField(d, field, wire, &s)
+		m.Tables = append(m.Tables, s)
+		return handled, err
+	})
+}
+
+// ChangeEvent is one change delivered by Watch
+type ChangeEvent struct {
+	Op     string
+	Table  string
+	Record *Record
+	Time   time.Time
+}
+
+func (m *ChangeEvent) Marshal() []byte {
+	var e encoder
+	e.string(1, m.Op)
+	e.string(2, m.Table)
+	if m.Record != nil {
+		e.bytes(3, m.Record.Marshal())
+	}
+	if !m.Time.IsZero() {
+		e.bytes(4, encodeTimestamp(m.Time))
+	}
+	return e.buf
+}
+
+func (m *ChangeEvent) Unmarshal(b []byte) error {
+	*m = ChangeEvent{}
+	return decodeFields(b, func(d *decoder, field, wire int) (bool, error) {
+		switch field {
+		case 1:
+			return stringField(d, field, wire, &m.Op)
+		case 2:
+			return stringField(d, field, wire, &m.Table)
+		case 3, 4:
+			if err := expect(field, wire, wireBytes); err != nil {
+				return false, err
+			}
+			data, err := d.bytes()
+			if err != nil {
+				return true, err
+			}
+			if field == 3 {
+				m.Record = &Record{}
+				return true, m.Record.Unmarshal(data)
+			}
+			m.Time, err = decodeTimestamp(data)
+			return true, err
+		}
+		return false, nil
+	})
+}
+
+// EventFromDB converts a db.ChangeEvent
+func EventFromDB(ev db.ChangeEvent) *ChangeEvent {
+	m := &ChangeEvent{Op: string(ev.Op), Table: ev.Table, Time: ev.Time}
+	if ev.Record != nil {
+		m.Record = RecordFromMap(ev.Record)
+	}
+	return m
+}
+
+// DB converts the event to a db.ChangeEvent
+func (m *ChangeEvent) DB() db.ChangeEvent {
+	ev := db.ChangeEvent{Op: db.ChangeOp(m.Op), Table: m.Table, Time: m.Time}
+	if m.Record != nil {
+		ev.Record = m.Record.Map()
+	}
+	return ev
+}
@@ -0,0 +1,129 @@
+package crudpb
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	now := time.Date(2024, 5, 6, 7, 8, 9, 123456789, time.UTC)
+	tests := []struct {
+		name string
+		in   Message
+		out  Message
+	}{
+		{"table", &Table{Name: "posts", Columns: []Column{{Name: "title", Type: "string"}, {Name: "author_id", Type: "number", Ref: "users"}}, SearchColumns: []string{"title"}, TTL: "24h", ExpireMode: "soft"}, &Table{}},
+		{"list tables", &ListTablesResponse{Names: []string{"a", "b"}}, &ListTablesResponse{}},
+		{"create table", &CreateTableRequest{Table: &Table{Name: "t", Columns: []Column{{Name: "c", Type: "string"}}}}, &CreateTableRequest{}},
+		{"record", &Record{ID: 42, Fields: map[string]any{
+			"name":   "Ann",
+			"empty":  "",
+			"age":    31.5,
+			"admin":  false,
+			"none":   nil,
+			"tags":   []any{"a", 1.0, true},
+			"nested": map[string]any{"x": []any{}},
+		}}, &Record{}},
+		{"update", &UpdateRecordRequest{Table: "users", ID: 7, Fields: map[string]any{"age": 32.0}}, &UpdateRecordRequest{}},
+		{"list records", &ListRecordsRequest{Table: "users", Where: []string{"age>30", "name~an"}, Offset: 5, Limit: 10}, &ListRecordsRequest{}},
+		{"watch", &WatchRequest{Tables: []string{"users"}}, &WatchRequest{}},
+		{"event", &ChangeEvent{Op: "insert", Table: "users", Record: &Record{ID: 1, Fields: map[string]any{"name": "Ann"}}, Time: now}, &ChangeEvent{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.out.Unmarshal(tt.in.Marshal()); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.in, tt.out) {
+				t.Errorf("got %#v, want %#v", tt.out, tt.in)
+			}
+		})
+	}
+}
+
+// TestWireFormat pins the encoding to the protobuf wire format: tags,
+// varints, little-endian doubles and oneof members that are written even
+// when they hold the zero value
+func TestWireFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  Message
+		want string
+	}{
+		{"get record", &GetRecordRequest{Table: "users", ID: 300}, "0a05757365727310ac02"},
+		{"negative int32", &ListRecordsRequest{Offset: -1}, "18ffffffffffffffffff01"},
+		{"struct with number", &InsertRecordRequest{Fields: map[string]any{"a": 1.0}}, "12100a0e0a0161120911000000000000f03f"},
+		{"struct with null and bool", &InsertRecordRequest{Fields: map[string]any{"n": nil, "b": true}}, "12120a070a016212022001" + "0a070a016e12020800"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hex.EncodeToString(tt.msg.Marshal()); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalSkipsUnknownFields(t *testing.T) {
+	// GetTableRequest{name: "users"} followed by unknown fields 9 (varint),
+	// 10 (fixed64), 11 (bytes) and 12 (fixed32)
+	data, _ := hex.DecodeString("0a057573657273" + "4801" + "510102030405060708" + "5a026869" + "6501020304")
+	var m GetTableRequest
+	if err := m.Unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+	if m.Name != "users" {
+		t.Errorf("Name = %q", m.Name)
+	}
+
+	if err := m.Unmarshal([]byte{0x0a, 0x05, 'u'}); err == nil {
+		t.Error("truncated message decoded without error")
+	}
+}
+
+func TestFrames(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteFrame(&buf, &GetTableRequest{Name: "users"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(buf.Bytes()); got != "00000000070a057573657273" {
+		t.Errorf("frame = %s", got)
+	}
+	data, err := ReadFrame(&buf)
+	if err != nil || len(data) != 7 {
+		t.Fatalf("ReadFrame = %x, %v", data, err)
+	}
+	if _, err := ReadFrame(&buf); err == nil {
+		t.Error("expected io.EOF after the last frame")
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{250 * time.Millisecond, "250000u"},
+		{30 * time.Second, "30000000u"},
+		{2 * time.Minute, "120000m"},
+		{48 * time.Hour, "172800S"},
+	}
+	for _, tt := range tests {
+		got := FormatTimeout(tt.d)
+		if got != tt.want {
+			t.Errorf("FormatTimeout(%v) = %s, want %s", tt.d, got, tt.want)
+		}
+		if back, err := ParseTimeout(got); err != nil || back != tt.d {
+			t.Errorf("ParseTimeout(%s) = %v, %v", got, back, err)
+		}
+	}
+	for _, bad := range []string{"", "5", "5x", "-5S", "123456789S"} {
+		if _, err := ParseTimeout(bad); err == nil {
+			t.Errorf("ParseTimeout(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,369 @@
+package crudpb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Protocol buffer wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("crudpb: truncated message")
+
+// encoder appends protobuf fields to a buffer. Scalar helpers skip zero
+// values, as proto3 does.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) tag(field, wire int) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(field)<<3|uint64(wire))
+}
+
+func (e *encoder) varint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	e.tag(field, wireVarint)
+	e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+func (e *encoder) int64(field int, v int64) { e.varint(field, uint64(v)) }
+
+func (e *encoder) string(field int, s string) {
+	if s == "" {
+		return
+	}
+	e.bytes(field, []byte(s))
+}
+
+// bytes always writes the field, so it is also used for embedded messages
+// that must be present even when empty
+func (e *encoder) bytes(field int, b []byte) {
+	e.tag(field, wireBytes)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) double(field int, f float64) {
+	e.tag(field, wireFixed64)
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, math.Float64bits(f))
+}
+
+// decoder reads protobuf fields from a buffer
+type decoder struct {
+	buf []byte
+}
+
+func (d *decoder) done() bool { return len(d.buf) == 0 }
+
+// next reads a field tag
+func (d *decoder) next() (field, wire int, err error) {
+	v, err := d.varint()
+	if err != nil {
+		return 0, 0, err
+	}
+	field, wire = int(v>>3), int(v&7)
+	if field == 0 {
+		return 0, 0, errors.New("crudpb: invalid field number 0")
+	}
+	return field, wire, nil
+}
+
+func (d *decoder) varint() (uint64, error) {
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	d.buf = d.buf[n:]
+	return v, nil
+}
+
+func (d *decoder) bytes() ([]byte, error) {
+	n, err := d.varint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(d.buf)) {
+		return nil, errTruncated
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b, nil
+}
+
+func (d *decoder) string() (string, error) {
+	b, err := d.bytes()
+	return string(b), err
+}
+
+func (d *decoder) fixed64() (uint64, error) {
+	if len(d.buf) < 8 {
+		return 0, errTruncated
+	}
+	v := binary.LittleEndian.Uint64(d.buf)
+	d.buf = d.buf[8:]
+	return v, nil
+}
+
+// skip discards a field this package does not know about
+func (d *decoder) skip(wire int) error {
+	switch wire {
+	case wireVarint:
+		_, err := d.varint()
+		return err
+	case wireFixed64:
+		_, err := d.fixed64()
+		return err
+	case wireBytes:
+		_, err := d.bytes()
+		return err
+	case wireFixed32:
+		if len(d.buf) < 4 {
+			return errTruncated
+		}
+		d.buf = d.buf[4:]
+		return nil
+	}
+	return fmt.Errorf("crudpb: unsupported wire type %d", wire)
+}
+
+// expect checks that a known field has the wire type its declaration
+// requires
+func expect(field, wire, want int) error {
+	if wire != want {
+		return fmt.Errorf("crudpb: field %d has wire type %d, want %d", field, wire, want)
+	}
+	return nil
+}
+
+// google.protobuf.Struct and Value are encoded by hand so records keep their
+// dynamic shape on the wire.
+
+func encodeStruct(fields map[string]any) []byte {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var e encoder
+	for _, k := range keys {
+		var entry encoder
+		entry.string(1, k)
+		entry.bytes(2, encodeValue(fields[k]))
+		e.bytes(1, entry.buf)
+	}
+	return e.buf
+}
+
+func encodeValue(v any) []byte {
+	var e encoder
+	switch v := v.(type) {
+	case nil:
+		e.tag(1, wireVarint)
+		e.buf = append(e.buf, 0)
+	case float64:
+		e.double(2, v)
+	case float32:
+		e.double(2, float64(v))
+	case int:
+		e.double(2, float64(v))
+	case int64:
+		e.double(2, float64(v))
+	case string:
+		e.bytes(3, []byte(v))
+	case bool:
+		e.tag(4, wireVarint)
+		if v {
+			e.buf = append(e.buf, 1)
+		} else {
+			e.buf = append(e.buf, 0)
+		}
+	case map[string]any:
+		e.bytes(5, encodeStruct(v))
+	case []any:
+		var list encoder
+		for _, item := range v {
+			list.bytes(1, encodeValue(item))
+		}
+		e.bytes(6, list.buf)
+	default:
+		e.bytes(3, []byte(fmt.Sprint(v)))
+	}
+	return e.buf
+}
+
+func decodeStruct(b []byte) (map[string]any, error) {
+	fields := make(map[string]any)
+	d := decoder{buf: b}
+	for !d.done() {
+		field, wire, err := d.next()
+		if err != nil {
+			return nil, err
+		}
+		if field != 1 {
+			if err := d.skip(wire); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := expect(field, wire, wireBytes); err != nil {
+			return nil, err
+		}
+		entry, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+
+		var key string
+		var value any
+		ed := decoder{buf: entry}
+		for !ed.done() {
+			f, w, err := ed.next()
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case f == 1 && w == wireBytes:
+				if key, err = ed.string(); err != nil {
+					return nil, err
+				}
+			case f == 2 && w == wireBytes:
+				b, err := ed.bytes()
+				if err != nil {
+					return nil, err
+				}
+				if value, err = decodeValue(b); err != nil {
+					return nil, err
+				}
+			default:
+				if err := ed.skip(w); err != nil {
+					return nil, err
+				}
+			}
+		}
+		fields[key] = value
+	}
+	return fields, nil
+}
+
+func decodeValue(b []byte) (any, error) {
+	var value any
+	d := decoder{buf: b}
+	for !d.done() {
+		field, wire, err := d.next()
+		if err != nil {
+			return nil, err
+		}
+		switch field {
+		case 1:
+			_, err = d.varint()
+			value = nil
+		case 2:
+			var bits uint64
+			if bits, err = d.fixed64(); err == nil {
+				value = math.Float64frombits(bits)
+			}
+		case 3:
+			value, err = d.string()
+		case 4:
+			var v uint64
+			if v, err = d.varint(); err == nil {
+				value = v != 0
+			}
+		case 5:
+			var s []byte
+			if s, err = d.bytes(); err == nil {
+				value, err = decodeStruct(s)
+			}
+		case 6:
+			var s []byte
+			if s, err = d.bytes(); err == nil {
+				value, err = decodeList(s)
+			}
+		default:
+			err = d.skip(wire)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+func decodeList(b []byte) ([]any, error) {
+	list := []any{}
+	d := decoder{buf: b}
+	for !d.done() {
+		field, wire, err := d.next()
+		if err != nil {
+			return nil, err
+		}
+		if field != 1 || wire != wireBytes {
+			if err := d.skip(wire); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		item, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+		v, err := decodeValue(item)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+// google.protobuf.Timestamp
+
+func encodeTimestamp(t time.Time) []byte {
+	var e encoder
+	e.int64(1, t.Unix())
+	e.varint(2, uint64(t.Nanosecond()))
+	return e.buf
+}
+
+func decodeTimestamp(b []byte) (time.Time, error) {
+	var secs int64
+	var nanos int64
+	d := decoder{buf: b}
+	for !d.done() {
+		field, wire, err := d.next()
+		if err != nil {
+			return time.Time{}, err
+		}
+		switch {
+		case field == 1 && wire == wireVarint:
+			v, err := d.varint()
+			if err != nil {
+				return time.Time{}, err
+			}
+			secs = int64(v)
+		case field == 2 && wire == wireVarint:
+			v, err := d.varint()
+			if err != nil {
+				return time.Time{}, err
+			}
+			nanos = int64(int32(v))
+		default:
+			if err := d.skip(wire); err != nil {
+				return time.Time{}, err
+			}
+		}
+	}
+	return time.Unix(secs, nanos).UTC(), nil
+}
@@ -0,0 +1,268 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Aggregate functions
+const (
+	AggCount         = "count"
+	AggSum           = "sum"
+	AggAvg           = "avg"
+	AggMin           = "min"
+	AggMax           = "max"
+	AggCountDistinct = "count_distinct"
+)
+
+var aggFuncs = []string{AggCount, AggSum, AggAvg, AggMin, AggMax, AggCountDistinct}
+
+// Aggregation is one aggregate such as "avg(age)". Column is empty for
+// "count", which counts records rather than values.
+type Aggregation struct {
+	Func   string `json:"func"`
+	Column string `json:"column,omitempty"`
+}
+
+// ParseAggregation parses "count", "count(*)" or <func>(<column>)
+func ParseAggregation(expr string) (Aggregation, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == AggCount || expr == AggCount+"(*)" {
+		return Aggregation{Func: AggCount}, nil
+	}
+
+	name, rest, ok := strings.Cut(expr, "(")
+	column, closed := strings.CutSuffix(rest, ")")
+	column = strings.TrimSpace(column)
+	if !ok || !closed || column == "" || column == "*" {
+		return Aggregation{}, fmt.Errorf("invalid aggregate %q: expected count or <func>(<column>)", expr)
+	}
+	name = strings.TrimSpace(name)
+	for _, f := range aggFuncs {
+		if name == f {
+			return Aggregation{Func: name, Column: column}, nil
+		}
+	}
+	return Aggregation{}, fmt.Errorf("invalid aggregate %q: function must be one of %s", expr, strings.Join(aggFuncs, ", "))
+}
+
+// String renders the aggregation in the form accepted by ParseAggregation.
+// It is also the aggregation's key in AggregateGroup.Values.
+func (a Aggregation) String() string {
+	if a.Column == "" {
+		return a.Func
+	}
+	return a.Func + "(" + a.Column + ")"
+}
+
+// AggregateQuery selects the records to aggregate, how to group them and
+// what to compute for each group
+type AggregateQuery struct {
+	GroupBy      []string      `json:"group_by,omitempty"`
+	Aggregations []Aggregation `json:"aggregations"`
+	Where        []Filter      `json:"where,omitempty"`
+}
+
+// AggregateGroup is one row of an aggregate result. Group holds the
+// group-by column values, with null for records missing the column.
+type AggregateGroup struct {
+	Group  map[string]any `json:"group"`
+	Values map[string]any `json:"values"`
+}
+
+// accumulator computes one aggregation over a group
+type accumulator struct {
+	agg      Aggregation
+	count    int
+	sum      float64
+	min, max any
+	distinct map[any]bool
+}
+
+func (acc *accumulator) add(record map[string]any) {
+	if acc.agg.Column == "" {
+		acc.count++
+		return
+	}
+	v, ok := record[acc.agg.Column]
+	if !ok || v == nil {
+		return
+	}
+
+	switch acc.agg.Func {
+	case AggCount:
+		acc.count++
+	case AggSum, AggAvg:
+		if n, ok := toFloat(v); ok {
+			acc.count++
+			acc.sum += n
+		}
+	case AggMin:
+		if acc.min == nil || compareValues(v, acc.min) < 0 {
+			acc.min = v
+		}
+	case AggMax:
+		if acc.max == nil || compareValues(v, acc.max) > 0 {
+			acc.max = v
+		}
+	case AggCountDistinct:
+		if acc.distinct == nil {
+			acc.distinct = make(map[any]bool)
+		}
+		acc.distinct[groupValue(v)] = true
+	}
+}
+
+func (acc *accumulator) result() any {
+	switch acc.agg.Func {
+	case AggSum:
+		return acc.sum
+	case AggAvg:
+		if acc.count == 0 {
+			return nil
+		}
+		return acc.sum / float64(acc.count)
+	case AggMin:
+		return acc.min
+	case AggMax:
+		return acc.max
+	case AggCountDistinct:
+		return len(acc.distinct)
+	}
+	return acc.count
+}
+
+// groupValue normalizes a value for use as a map key, so 1 and 1.0 fall in
+// the same group and unhashable values still group by their text
+func groupValue(v any) any {
+	if n, ok := toFloat(v); ok {
+		return n
+	}
+	switch v.(type) {
+	case nil, string, bool:
+		return v
+	}
+	return fmt.Sprint(v)
+}
+
+// compareValues orders two non-nil values numerically when both are
+// numbers and by their text otherwise, like Filter
+func compareValues(a, b any) int {
+	x, okA := toFloat(a)
+	y, okB := toFloat(b)
+	switch {
+	case okA && okB && x < y:
+		return -1
+	case okA && okB && x > y:
+		return 1
+	case okA && okB:
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// Aggregate computes q's aggregations over the matching records of a table.
+// Groups are ordered by their group-by values, nulls first. Without GroupBy
+// there is exactly one group, even when no records match; without
+// aggregations the records are counted.
+func (db *Database) Aggregate(tableName string, q AggregateQuery) ([]AggregateGroup, error) {
+	return db.aggregate(unrestricted, tableName, q)
+}
+
+func (db *Database) aggregate(subject Subject, tableName string, q AggregateQuery) ([]AggregateGroup, error) {
+	aggs := q.Aggregations
+	if len(aggs) == 0 {
+		aggs = []Aggregation{{Func: AggCount}}
+	}
+	for _, a := range aggs {
+		if _, err := ParseAggregation(a.String()); err != nil {
+			return nil, err
+		}
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	tableData, exists := db.tables[tableName]
+	if !exists {
+		return nil, fmt.Errorf("table %s not found", tableName)
+	}
+
+	type group struct {
+		values []any
+		accs   []*accumulator
+	}
+	groups := make(map[string]*group)
+	newGroup := func(values []any) *group {
+		g := &group{values: values}
+		for _, a := range aggs {
+			g.accs = append(g.accs, &accumulator{agg: a})
+		}
+		return g
+	}
+	if len(q.GroupBy) == 0 {
+		// "[]" is the encoded key of every record when nothing is grouped
+		groups["[]"] = newGroup(nil)
+	}
+
+	withDeleted := includesDeleted(q.Where)
+	for _, r := range tableData.records {
+		if (!withDeleted && !live(r)) || !matchAll(r, q.Where) || !tableData.canRead(subject, r) {
+			continue
+		}
+		values := make([]any, len(q.GroupBy))
+		keys := make([]any, len(q.GroupBy))
+		for i, col := range q.GroupBy {
+			values[i] = r[col]
+			keys[i] = groupValue(r[col])
+		}
+		// Group values are strings, numbers, bools or nil after
+		// normalizing, all of which encode
+		keyJSON, _ := json.Marshal(keys)
+		g, ok := groups[string(keyJSON)]
+		if !ok {
+			g = newGroup(values)
+			groups[string(keyJSON)] = g
+		}
+		for _, acc := range g.accs {
+			acc.add(r)
+		}
+	}
+
+	ordered := make([]*group, 0, len(groups))
+	for _, g := range groups {
+		ordered = append(ordered, g)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		a, b := ordered[i].values, ordered[j].values
+		for k := range a {
+			switch {
+			case a[k] == nil && b[k] == nil:
+				continue
+			case a[k] == nil:
+				return true
+			case b[k] == nil:
+				return false
+			}
+			if c := compareValues(a[k], b[k]); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+
+	result := make([]AggregateGroup, 0, len(ordered))
+	for _, g := range ordered {
+		row := AggregateGroup{Group: make(map[string]any, len(q.GroupBy)), Values: make(map[string]any, len(aggs))}
+		for i, col := range q.GroupBy {
+			row.Group[col] = g.values[i]
+		}
+		for _, acc := range g.accs {
+			row.Values[acc.agg.String()] = acc.result()
+		}
+		result = append(result, row)
+	}
+	return result, nil
+}
@@ -0,0 +1,130 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAggregation(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    Aggregation
+		wantErr bool
+	}{
+		{"count", Aggregation{Func: AggCount}, false},
+		{"count(*)", Aggregation{Func: AggCount}, false},
+		{"count(email)", Aggregation{Func: AggCount, Column: "email"}, false},
+		{" avg( age ) ", Aggregation{Func: AggAvg, Column: "age"}, false},
+		{"count_distinct(country)", Aggregation{Func: AggCountDistinct, Column: "country"}, false},
+		{"sum", Aggregation{}, true},
+		{"sum(*)", Aggregation{}, true},
+		{"median(age)", Aggregation{}, true},
+		{"max(age", Aggregation{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := ParseAggregation(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	database := NewDatabase()
+	if err := database.CreateTable(&Table{Name: "orders"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []map[string]any{
+		{"country": "DE", "city": "Berlin", "total": 10.0, "customer": "ann"},
+		{"country": "DE", "city": "Berlin", "total": 30, "customer": "ann"},
+		{"country": "DE", "city": "Hamburg", "total": 5.5, "customer": "bob"},
+		{"country": "FR", "city": "Paris", "total": 20.0, "customer": "cy"},
+		{"city": "Nowhere", "total": "n/a"},
+	} {
+		if _, err := database.InsertRecord("orders", r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	aggs := func(exprs ...string) []Aggregation {
+		var result []Aggregation
+		for _, e := range exprs {
+			a, err := ParseAggregation(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result = append(result, a)
+		}
+		return result
+	}
+
+	tests := []struct {
+		name string
+		q    AggregateQuery
+		want []AggregateGroup
+	}{
+		{
+			name: "whole table",
+			q:    AggregateQuery{Aggregations: aggs("count", "count(country)", "sum(total)", "avg(total)", "min(total)", "max(city)", "count_distinct(customer)")},
+			want: []AggregateGroup{{Group: map[string]any{}, Values: map[string]any{
+				"count": 5, "count(country)": 4, "sum(total)": 65.5, "avg(total)": 16.375,
+				"min(total)": 5.5, "max(city)": "Paris", "count_distinct(customer)": 3,
+			}}},
+		},
+		{
+			name: "default count",
+			q:    AggregateQuery{},
+			want: []AggregateGroup{{Group: map[string]any{}, Values: map[string]any{"count": 5}}},
+		},
+		{
+			name: "grouped with null group first",
+			q:    AggregateQuery{GroupBy: []string{"country"}, Aggregations: aggs("count", "sum(total)")},
+			want: []AggregateGroup{
+				{Group: map[string]any{"country": nil}, Values: map[string]any{"count": 1, "sum(total)": 0.0}},
+				{Group: map[string]any{"country": "DE"}, Values: map[string]any{"count": 3, "sum(total)": 45.5}},
+				{Group: map[string]any{"country": "FR"}, Values: map[string]any{"count": 1, "sum(total)": 20.0}},
+			},
+		},
+		{
+			name: "two columns with filter",
+			q:    AggregateQuery{GroupBy: []string{"country", "city"}, Aggregations: aggs("avg(total)"), Where: []Filter{Where("country", OpEq, "DE")}},
+			want: []AggregateGroup{
+				{Group: map[string]any{"country": "DE", "city": "Berlin"}, Values: map[string]any{"avg(total)": 20.0}},
+				{Group: map[string]any{"country": "DE", "city": "Hamburg"}, Values: map[string]any{"avg(total)": 5.5}},
+			},
+		},
+		{
+			name: "no matches",
+			q:    AggregateQuery{Aggregations: aggs("count", "avg(total)", "max(total)"), Where: []Filter{Where("country", OpEq, "IT")}},
+			want: []AggregateGroup{{Group: map[string]any{}, Values: map[string]any{"count": 0, "avg(total)": nil, "max(total)": nil}}},
+		},
+		{
+			name: "no matching groups",
+			q:    AggregateQuery{GroupBy: []string{"country"}, Where: []Filter{Where("country", OpEq, "IT")}},
+			want: []AggregateGroup{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := database.Aggregate("orders", tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v\nwant %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := database.Aggregate("missing", AggregateQuery{}); err == nil {
+		t.Error("aggregated a missing table")
+	}
+	if _, err := database.Aggregate("orders", AggregateQuery{Aggregations: []Aggregation{{Func: AggSum}}}); err == nil {
+		t.Error("accepted sum without a column")
+	}
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// benchDB returns a database with an items table holding n records
+func benchDB(b *testing.B, n int) *Database {
+	b.Helper()
+	database := NewDatabase()
+	err := database.CreateTable(&Table{Name: "items", Columns: []Column{
+		{Name: "n", Type: "number"},
+		{Name: "name", Type: "string"},
+	}})
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := range n {
+		if _, err := database.InsertRecord("items", map[string]any{"n": i, "name": fmt.Sprintf("item-%d", i)}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return database
+}
+
+func BenchmarkInsert(b *testing.B) {
+	database := benchDB(b, 0)
+	b.ResetTimer()
+	for i := range b.N {
+		if _, err := database.InsertRecord("items", map[string]any{"n": i, "name": "item"}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUpdate(b *testing.B) {
+	const records = 10_000
+	database := benchDB(b, records)
+	b.ResetTimer()
+	for i := range b.N {
+		if _, err := database.UpdateRecord("items", map[string]any{"id": i%records + 1, "n": i}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkScan(b *testing.B) {
+	for _, records := range []int{1_000, 10_000} {
+		b.Run(fmt.Sprintf("records=%d", records), func(b *testing.B) {
+			database := benchDB(b, records)
+			// Matches the upper half of the table
+			filters := []Filter{Where("n", OpGte, records/2)}
+			b.ResetTimer()
+			for range b.N {
+				found, err := database.FindRecords("items", filters)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(found) != records/2 {
+					b.Fatalf("found %d records, want %d", len(found), records/2)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkContention runs reads and writes from parallel goroutines on one
+// table, at increasing shares of writes
+func BenchmarkContention(b *testing.B) {
+	const records = 10_000
+	for _, writes := range []int{0, 10, 50} {
+		b.Run(fmt.Sprintf("writes=%d%%", writes), func(b *testing.B) {
+			database := benchDB(b, records)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				rng := rand.New(rand.NewSource(rand.Int63()))
+				for pb.Next() {
+					id := rng.Intn(records) + 1
+					var err error
+					if rng.Intn(100) < writes {
+						_, err = database.UpdateRecord("items", map[string]any{"id": id, "n": rng.Intn(records)})
+					} else {
+						_, err = database.GetRecord("items", id)
+					}
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
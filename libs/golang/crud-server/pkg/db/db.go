@@ -3,28 +3,61 @@ package db
 import (
 	"errors"
 	"fmt"
+	"math"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Column struct {
 	Name string `json:"name"`
 	Type string `json:"type"`
+	// Ref names the table whose record ids this column holds. It is not
+	// enforced on writes; the GraphQL schema uses it to expose relations.
+	Ref string `json:"ref,omitempty"`
 }
 
 type Table struct {
 	Name    string   `json:"name"`
 	Columns []Column `json:"columns"`
+	// SearchColumns lists the string columns covered by the table's
+	// full-text index. The index is only built when this is non-empty.
+	SearchColumns []string `json:"search_columns,omitempty"`
+	// TTL is a duration such as "30m" after which inserted records expire.
+	// Records can override it with the _ttl or _expires_at fields.
+	TTL string `json:"ttl,omitempty"`
+	// ExpireMode is ExpireDelete (the default) or ExpireSoft
+	ExpireMode string `json:"expire_mode,omitempty"`
+	// Policy restricts records to their owners when accessed through a
+	// Scope
+	Policy *RowPolicy `json:"policy,omitempty"`
 }
 
 type Database struct {
 	mu     sync.RWMutex
 	tables map[string]*tableData
+	feed   feed
+	// schemaVersion is bumped whenever a table is created or removed
+	schemaVersion atomic.Uint64
+	// lastSweep is when ExpireRecords last ran
+	lastSweep time.Time
+	// journal is set by Persist
+	journal *journal
 }
 
 type tableData struct {
 	table   *Table
 	records []map[string]any
 	nextID  int
+	index   *searchIndex
+	ttl     time.Duration
+	// expired counts the records removed or soft-deleted by expiry
+	expired uint64
+	// mutations, recent and modified track changes for TableStats
+	mutations map[ChangeOp]uint64
+	recent    rateWindow
+	modified  time.Time
 }
 
 func NewDatabase() *Database {
@@ -41,13 +74,67 @@ func (db *Database) CreateTable(table *Table) error {
 		return fmt.Errorf("table %s already exists", table.Name)
 	}
 
-	db.tables[table.Name] = &tableData{
+	data, err := newTableData(table)
+	if err != nil {
+		return err
+	}
+	db.tables[table.Name] = data
+	db.schemaVersion.Add(1)
+	db.publish(OpCreateTable, table.Name, nil)
+
+	return nil
+}
+
+// newTableData validates a table definition and prepares its storage
+func newTableData(table *Table) (*tableData, error) {
+	data := &tableData{
 		table:   table,
 		records: []map[string]any{},
 		nextID:  1,
 	}
 
-	return nil
+	if len(table.SearchColumns) > 0 {
+		for _, name := range table.SearchColumns {
+			if !hasStringColumn(table, name) {
+				return nil, fmt.Errorf("search column %s must be a string column of table %s", name, table.Name)
+			}
+		}
+		data.index = newSearchIndex(table.SearchColumns)
+	}
+
+	if table.TTL != "" {
+		ttl, err := time.ParseDuration(table.TTL)
+		if err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("invalid ttl %q for table %s: expected a positive duration such as 30m", table.TTL, table.Name)
+		}
+		data.ttl = ttl
+	}
+	switch table.ExpireMode {
+	case "", ExpireDelete, ExpireSoft:
+	default:
+		return nil, fmt.Errorf("invalid expire_mode %q for table %s: expected %s or %s", table.ExpireMode, table.Name, ExpireDelete, ExpireSoft)
+	}
+	if err := validatePolicy(table); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func hasStringColumn(table *Table, name string) bool {
+	for _, col := range table.Columns {
+		if col.Name == name {
+			return col.Type == "string"
+		}
+	}
+	return false
+}
+
+// SchemaVersion returns a counter that changes whenever a table is created,
+// deleted or replaced, so callers can cache anything derived from the table
+// definitions
+func (db *Database) SchemaVersion() uint64 {
+	return db.schemaVersion.Load()
 }
 
 func (db *Database) ListTables() []string {
@@ -61,6 +148,26 @@ func (db *Database) ListTables() []string {
 	return tables
 }
 
+// GetTable returns a copy of the table's definition
+func (db *Database) GetTable(name string) (*Table, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	tableData, exists := db.tables[name]
+	if !exists {
+		return nil, fmt.Errorf("table %s not found", name)
+	}
+
+	table := *tableData.table
+	table.Columns = append([]Column(nil), table.Columns...)
+	table.SearchColumns = append([]string(nil), table.SearchColumns...)
+	if table.Policy != nil {
+		policy := *table.Policy
+		table.Policy = &policy
+	}
+	return &table, nil
+}
+
 func (db *Database) DeleteTable(name string) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
@@ -70,10 +177,17 @@ func (db *Database) DeleteTable(name string) error {
 	}
 
 	delete(db.tables, name)
+	db.schemaVersion.Add(1)
+	db.publish(OpDeleteTable, name, nil)
 	return nil
 }
 
+// GetRecords returns copies of the table's live records
 func (db *Database) GetRecords(tableName string) ([]map[string]any, error) {
+	return db.getRecords(unrestricted, tableName)
+}
+
+func (db *Database) getRecords(subject Subject, tableName string) ([]map[string]any, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
@@ -82,59 +196,138 @@ func (db *Database) GetRecords(tableName string) ([]map[string]any, error) {
 		return nil, fmt.Errorf("table %s not found", tableName)
 	}
 
-	result := make([]map[string]any, len(tableData.records))
-	copy(result, tableData.records)
+	result := make([]map[string]any, 0, len(tableData.records))
+	for _, r := range tableData.records {
+		if live(r) && tableData.canRead(subject, r) {
+			result = append(result, copyRecord(r))
+		}
+	}
 	return result, nil
 }
 
-func (db *Database) InsertRecord(tableName string, record map[string]any) error {
+// GetRecord returns a copy of the record with the given id
+func (db *Database) GetRecord(tableName string, id any) (map[string]any, error) {
+	return db.getRecord(unrestricted, tableName, id)
+}
+
+func (db *Database) getRecord(subject Subject, tableName string, id any) (map[string]any, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	tableData, exists := db.tables[tableName]
+	if !exists {
+		return nil, fmt.Errorf("table %s not found", tableName)
+	}
+
+	i := tableData.find(id)
+	if i < 0 || !live(tableData.records[i]) || !tableData.canRead(subject, tableData.records[i]) {
+		return nil, fmt.Errorf("record with id %v not found", id)
+	}
+	return copyRecord(tableData.records[i]), nil
+}
+
+// InsertRecord stores a copy of record and returns its generated id
+func (db *Database) InsertRecord(tableName string, record map[string]any) (int, error) {
+	return db.insertRecord(unrestricted, tableName, record)
+}
+
+func (db *Database) insertRecord(subject Subject, tableName string, record map[string]any) (int, error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
 	tableData, exists := db.tables[tableName]
 	if !exists {
-		return fmt.Errorf("table %s not found", tableName)
+		return 0, fmt.Errorf("table %s not found", tableName)
 	}
 
-	newRecord := make(map[string]any)
-	for k, v := range record {
-		newRecord[k] = v
+	newRecord := copyRecord(record)
+	if err := tableData.stampOwner(subject, newRecord); err != nil {
+		return 0, err
 	}
+	if err := tableData.setExpiry(newRecord, time.Now(), true); err != nil {
+		return 0, err
+	}
+	return db.insert(tableName, tableData, newRecord), nil
+}
 
-	newRecord["id"] = tableData.nextID
-	tableData.nextID++
+// insert stores a record whose expiry fields are already set under the next
+// id. It is called with db.mu held.
+func (db *Database) insert(tableName string, t *tableData, record map[string]any) int {
+	id := t.nextID
+	record["id"] = id
+	t.nextID++
 
-	tableData.records = append(tableData.records, newRecord)
-	return nil
+	t.records = append(t.records, record)
+	if t.index != nil {
+		t.index.add(id, record)
+	}
+	db.publish(OpInsert, tableName, record)
+	return id
 }
 
-func (db *Database) UpdateRecord(tableName string, record map[string]any) error {
+// UpdateRecord merges record into the stored record with the same id and
+// returns a copy of the result
+func (db *Database) UpdateRecord(tableName string, record map[string]any) (map[string]any, error) {
+	return db.updateRecord(unrestricted, tableName, record)
+}
+
+func (db *Database) updateRecord(subject Subject, tableName string, record map[string]any) (map[string]any, error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
 	tableData, exists := db.tables[tableName]
 	if !exists {
-		return fmt.Errorf("table %s not found", tableName)
+		return nil, fmt.Errorf("table %s not found", tableName)
 	}
 
-	id, hasID := record["id"]
+	rawID, hasID := record["id"]
 	if !hasID {
-		return errors.New("record must have an 'id' field")
+		return nil, errors.New("record must have an 'id' field")
 	}
 
-	for i, r := range tableData.records {
-		if r["id"] == id {
-			for k, v := range record {
-				tableData.records[i][k] = v
-			}
-			return nil
+	if i := tableData.find(rawID); i >= 0 && live(tableData.records[i]) && tableData.canRead(subject, tableData.records[i]) {
+		if !tableData.owns(subject, tableData.records[i]) {
+			return nil, fmt.Errorf("%w: record with id %v belongs to someone else", ErrForbidden, rawID)
+		}
+		if err := tableData.checkChanges(subject, record); err != nil {
+			return nil, err
 		}
+		changes := copyRecord(record)
+		if err := tableData.setExpiry(changes, time.Now(), false); err != nil {
+			return nil, err
+		}
+		db.merge(tableName, tableData, i, changes)
+		return copyRecord(tableData.records[i]), nil
 	}
 
-	return fmt.Errorf("record with id %v not found", id)
+	return nil, fmt.Errorf("record with id %v not found", rawID)
+}
+
+// merge applies changes whose expiry fields are already set to the record
+// at position i. It is called with db.mu held.
+func (db *Database) merge(tableName string, t *tableData, i int, changes map[string]any) {
+	record := t.records[i]
+	for k, v := range changes {
+		switch {
+		case k == "id":
+		case k == FieldExpiresAt && v == nil:
+			delete(record, k)
+		default:
+			record[k] = v
+		}
+	}
+	if t.index != nil {
+		id, _ := recordID(record["id"])
+		t.index.add(id, record)
+	}
+	db.publish(OpUpdate, tableName, record)
 }
 
 func (db *Database) DeleteRecord(tableName string, id any) error {
+	return db.deleteRecord(unrestricted, tableName, id)
+}
+
+func (db *Database) deleteRecord(subject Subject, tableName string, id any) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
@@ -143,12 +336,60 @@ func (db *Database) DeleteRecord(tableName string, id any) error {
 		return fmt.Errorf("table %s not found", tableName)
 	}
 
-	for i, r := range tableData.records {
-		if r["id"] == id {
-			tableData.records = append(tableData.records[:i], tableData.records[i+1:]...)
-			return nil
+	if i := tableData.find(id); i >= 0 && tableData.canRead(subject, tableData.records[i]) {
+		if !tableData.owns(subject, tableData.records[i]) {
+			return fmt.Errorf("%w: record with id %v belongs to someone else", ErrForbidden, id)
+		}
+		db.publish(OpDelete, tableName, tableData.records[i])
+		tableData.records = append(tableData.records[:i], tableData.records[i+1:]...)
+		if tableData.index != nil {
+			n, _ := recordID(id)
+			tableData.index.remove(n)
 		}
+		return nil
 	}
 
 	return fmt.Errorf("record with id %v not found", id)
 }
+
+// find returns the position of the record with the given id, or -1
+func (t *tableData) find(id any) int {
+	want, ok := recordID(id)
+	if !ok {
+		return -1
+	}
+	for i, r := range t.records {
+		if got, _ := recordID(r["id"]); got == want {
+			return i
+		}
+	}
+	return -1
+}
+
+// recordID normalizes an id to an int. IDs arrive as float64 when decoded
+// from JSON but are stored as int.
+func recordID(id any) (int, bool) {
+	switch v := id.(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case float64:
+		if v != math.Trunc(v) {
+			return 0, false
+		}
+		return int(v), true
+	case string:
+		n, err := strconv.Atoi(v)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+func copyRecord(record map[string]any) map[string]any {
+	c := make(map[string]any, len(record))
+	for k, v := range record {
+		c[k] = v
+	}
+	return c
+}
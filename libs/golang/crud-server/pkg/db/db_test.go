@@ -0,0 +1,47 @@
+package db
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestGetRecordsDuringUpdates(t *testing.T) {
+	database := NewDatabase()
+	if err := database.CreateTable(&Table{Name: "counters"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.InsertRecord("counters", map[string]any{"n": 0}); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1; i <= 1000; i++ {
+			if _, err := database.UpdateRecord("counters", map[string]any{"id": 1, "n": i}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	// Run with -race: the records read must not be the stored maps that
+	// the updates change
+	for range 1000 {
+		records, err := database.GetRecords("counters")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range records {
+			_ = r["n"]
+		}
+	}
+	wg.Wait()
+
+	records, _ := database.GetRecords("counters")
+	records[0]["n"] = -1
+	if r, _ := database.GetRecord("counters", 1); r["n"] != 1000 {
+		t.Errorf("stored n = %v after changing a returned record, want 1000", r["n"])
+	}
+}
@@ -0,0 +1,100 @@
+package db
+
+import (
+	"sync"
+	"time"
+)
+
+// ChangeOp identifies the kind of mutation in a ChangeEvent
+type ChangeOp string
+
+const (
+	OpCreateTable ChangeOp = "create_table"
+	OpDeleteTable ChangeOp = "delete_table"
+	OpInsert      ChangeOp = "insert"
+	OpUpdate      ChangeOp = "update"
+	OpDelete      ChangeOp = "delete"
+	// OpExpire is published when expiry removes or soft-deletes a record
+	OpExpire ChangeOp = "expire"
+)
+
+// ChangeEvent describes a single mutation. Record holds a copy of the
+// record after inserts, updates and soft-deleting expiries and before
+// deletes and removing expiries; it is nil for table operations.
+type ChangeEvent struct {
+	Op     ChangeOp       `json:"op"`
+	Table  string         `json:"table"`
+	Record map[string]any `json:"record,omitempty"`
+	Time   time.Time      `json:"time"`
+
+	// policy is the table's row policy when the change was made
+	policy *RowPolicy
+}
+
+// feed fans change events out to subscribers
+type feed struct {
+	mu     sync.Mutex
+	nextID int
+	subs   map[int]chan ChangeEvent
+}
+
+// Subscribe returns a channel receiving every change made after the call
+// and a function that cancels the subscription and closes the channel.
+// Events are delivered without blocking writers: when a subscriber's buffer
+// is full, further events are dropped for that subscriber until it catches
+// up, so buffer should cover the expected burst size.
+func (db *Database) Subscribe(buffer int) (<-chan ChangeEvent, func()) {
+	db.feed.mu.Lock()
+	defer db.feed.mu.Unlock()
+
+	if db.feed.subs == nil {
+		db.feed.subs = make(map[int]chan ChangeEvent)
+	}
+	id := db.feed.nextID
+	db.feed.nextID++
+	ch := make(chan ChangeEvent, buffer)
+	db.feed.subs[id] = ch
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			db.feed.mu.Lock()
+			defer db.feed.mu.Unlock()
+			delete(db.feed.subs, id)
+			close(ch)
+		})
+	}
+}
+
+// publish records a change in the table's statistics and the journal, then
+// sends an event to every subscriber. It is called with db.mu held so
+// subscribers observe changes in commit order.
+func (db *Database) publish(op ChangeOp, table string, record map[string]any) {
+	now := time.Now().UTC()
+	var policy *RowPolicy
+	if t, exists := db.tables[table]; exists {
+		t.track(op, now)
+		policy = t.table.Policy
+	}
+	if db.journal != nil {
+		db.appendEntry(op, table, record, now)
+	}
+
+	db.feed.mu.Lock()
+	defer db.feed.mu.Unlock()
+
+	if len(db.feed.subs) == 0 {
+		return
+	}
+
+	event := ChangeEvent{Op: op, Table: table, Time: now, policy: policy}
+	if record != nil {
+		event.Record = copyRecord(record)
+	}
+	for _, ch := range db.feed.subs {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package db
+
+import "testing"
+
+func TestSubscribe(t *testing.T) {
+	database := NewDatabase()
+	events, cancel := database.Subscribe(16)
+
+	if err := database.CreateTable(&Table{Name: "users", Columns: []Column{{Name: "name", Type: "string"}}}); err != nil {
+		t.Fatal(err)
+	}
+	id, err := database.InsertRecord("users", map[string]any{"name": "Ann"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.UpdateRecord("users", map[string]any{"id": id, "name": "Anne"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.DeleteRecord("users", id); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.DeleteTable("users"); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	cancel() // cancelling twice is safe
+
+	want := []struct {
+		op   ChangeOp
+		name any
+	}{
+		{OpCreateTable, nil},
+		{OpInsert, "Ann"},
+		{OpUpdate, "Anne"},
+		{OpDelete, "Anne"},
+		{OpDeleteTable, nil},
+	}
+	i := 0
+	for ev := range events {
+		if i >= len(want) {
+			t.Fatalf("unexpected event %+v", ev)
+		}
+		if ev.Op != want[i].op || ev.Table != "users" || ev.Record["name"] != want[i].name {
+			t.Errorf("event %d = %+v, want %s with name %v", i, ev, want[i].op, want[i].name)
+		}
+		i++
+	}
+	if i != len(want) {
+		t.Errorf("got %d events, want %d", i, len(want))
+	}
+}
+
+func TestSubscribeDropsWhenFull(t *testing.T) {
+	database := NewDatabase()
+	events, cancel := database.Subscribe(1)
+	defer cancel()
+
+	if err := database.CreateTable(&Table{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	// The buffer is full; this event is dropped rather than blocking
+	if err := database.CreateTable(&Table{Name: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if ev := <-events; ev.Table != "a" {
+		t.Errorf("first event for table %s, want a", ev.Table)
+	}
+	select {
+	case ev := <-events:
+		t.Errorf("unexpected event %+v", ev)
+	default:
+	}
+}
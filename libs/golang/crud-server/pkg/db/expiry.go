@@ -0,0 +1,178 @@
+package db
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Reserved record fields used by expiry
+const (
+	// FieldExpiresAt holds the RFC 3339 time at which a record expires.
+	// Setting it to null on update removes the expiry.
+	FieldExpiresAt = "_expires_at"
+	// FieldTTL may be written instead of FieldExpiresAt as a duration such
+	// as "10m" or a number of seconds. It is stored as FieldExpiresAt.
+	FieldTTL = "_ttl"
+	// FieldDeletedAt is set on records soft-deleted by expiry. Such records
+	// are hidden from reads but kept in snapshots.
+	FieldDeletedAt = "_deleted_at"
+)
+
+// Expire modes for Table.ExpireMode
+const (
+	// ExpireDelete removes expired records
+	ExpireDelete = "delete"
+	// ExpireSoft keeps expired records but marks them with FieldDeletedAt
+	ExpireSoft = "soft"
+)
+
+// live reports whether a record has not been soft-deleted
+func live(record map[string]any) bool {
+	_, deleted := record[FieldDeletedAt]
+	return !deleted
+}
+
+// expiresAt returns a record's expiry time, if it has one
+func expiresAt(record map[string]any) (time.Time, bool) {
+	s, ok := record[FieldExpiresAt].(string)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	return t, err == nil
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// setExpiry normalizes the expiry fields of a record being written, turning
+// _ttl into _expires_at. Inserted records without either get the table's
+// TTL; an explicit null _expires_at opts out of it.
+func (t *tableData) setExpiry(record map[string]any, now time.Time, insert bool) error {
+	if v, ok := record[FieldTTL]; ok {
+		delete(record, FieldTTL)
+		ttl, err := parseTTL(v)
+		if err != nil {
+			return err
+		}
+		record[FieldExpiresAt] = formatTime(now.Add(ttl))
+		return nil
+	}
+
+	if v, ok := record[FieldExpiresAt]; ok {
+		if v == nil {
+			if insert {
+				delete(record, FieldExpiresAt)
+			}
+			return nil
+		}
+		s, _ := v.(string)
+		at, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return fmt.Errorf("invalid %s %v: expected an RFC 3339 time", FieldExpiresAt, v)
+		}
+		record[FieldExpiresAt] = formatTime(at)
+		return nil
+	}
+
+	if insert && t.ttl > 0 {
+		record[FieldExpiresAt] = formatTime(now.Add(t.ttl))
+	}
+	return nil
+}
+
+// parseTTL accepts a duration string or a number of seconds
+func parseTTL(v any) (time.Duration, error) {
+	var ttl time.Duration
+	switch v := v.(type) {
+	case string:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s %q: expected a duration such as 30m", FieldTTL, v)
+		}
+		ttl = d
+	default:
+		secs, ok := toFloat(v)
+		if !ok {
+			return 0, fmt.Errorf("invalid %s %v: expected a duration or a number of seconds", FieldTTL, v)
+		}
+		ttl = time.Duration(secs * float64(time.Second))
+	}
+	if ttl <= 0 {
+		return 0, fmt.Errorf("invalid %s %v: must be positive", FieldTTL, v)
+	}
+	return ttl, nil
+}
+
+// ExpireRecords removes every record whose expiry time is not after now,
+// or soft-deletes it in tables with ExpireSoft, and publishes an OpExpire
+// event for each. It returns how many records expired.
+func (db *Database) ExpireRecords(now time.Time) int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	total := 0
+	for name, t := range db.tables {
+		kept := t.records[:0]
+		for _, r := range t.records {
+			at, ok := expiresAt(r)
+			if !ok || !live(r) || at.After(now) {
+				kept = append(kept, r)
+				continue
+			}
+			total++
+			t.expired++
+
+			if t.table.ExpireMode == ExpireSoft {
+				// Replace rather than mutate the map, which readers of
+				// GetRecords may still hold
+				r = copyRecord(r)
+				r[FieldDeletedAt] = formatTime(now)
+				kept = append(kept, r)
+				db.publish(OpExpire, name, r)
+				continue
+			}
+			db.publish(OpExpire, name, r)
+			if t.index != nil {
+				id, _ := recordID(r["id"])
+				t.index.remove(id)
+			}
+		}
+		clear(t.records[len(kept):])
+		t.records = kept
+	}
+	db.lastSweep = now
+	return total
+}
+
+// StartExpiry runs ExpireRecords every interval in the background until the
+// returned function is called. The stop function waits for a sweep in
+// progress to finish.
+func (db *Database) StartExpiry(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				db.ExpireRecords(now)
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+		})
+	}
+}
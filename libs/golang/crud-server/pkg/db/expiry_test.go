@@ -0,0 +1,179 @@
+package db
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetExpiry(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	table := &tableData{ttl: time.Hour}
+
+	tests := []struct {
+		name   string
+		record map[string]any
+		insert bool
+		want   any // expected _expires_at, or nil when absent
+		err    string
+	}{
+		{"table ttl on insert", map[string]any{}, true, "2026-01-02T04:04:05Z", ""},
+		{"no table ttl on update", map[string]any{}, false, nil, ""},
+		{"ttl string", map[string]any{"_ttl": "10m"}, true, "2026-01-02T03:14:05Z", ""},
+		{"ttl seconds", map[string]any{"_ttl": 30.0}, false, "2026-01-02T03:04:35Z", ""},
+		{"explicit time", map[string]any{"_expires_at": "2026-05-01T00:00:00+02:00"}, true, "2026-04-30T22:00:00Z", ""},
+		{"null opts out on insert", map[string]any{"_expires_at": nil}, true, nil, ""},
+		{"bad ttl", map[string]any{"_ttl": "soon"}, true, nil, "invalid _ttl"},
+		{"negative ttl", map[string]any{"_ttl": -5.0}, true, nil, "must be positive"},
+		{"bad time", map[string]any{"_expires_at": "tomorrow"}, true, nil, "invalid _expires_at"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := table.setExpiry(tt.record, now, tt.insert)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := tt.record["_ttl"]; ok {
+				t.Error("_ttl was not removed")
+			}
+			if got := tt.record["_expires_at"]; got != tt.want {
+				t.Errorf("_expires_at = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpireRecords(t *testing.T) {
+	tests := []struct {
+		name string
+		mode string
+	}{
+		{"delete", ""},
+		{"soft", ExpireSoft},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database := NewDatabase()
+			err := database.CreateTable(&Table{
+				Name:          "sessions",
+				Columns:       []Column{{Name: "user", Type: "string"}},
+				SearchColumns: []string{"user"},
+				TTL:           "1h",
+				ExpireMode:    tt.mode,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			short, _ := database.InsertRecord("sessions", map[string]any{"user": "ann", "_ttl": "1m"})
+			long, _ := database.InsertRecord("sessions", map[string]any{"user": "bob"})
+			forever, _ := database.InsertRecord("sessions", map[string]any{"user": "cy", "_expires_at": nil})
+
+			events, cancel := database.Subscribe(16)
+			defer cancel()
+
+			if n := database.ExpireRecords(time.Now()); n != 0 {
+				t.Fatalf("expired %d records before any expiry time", n)
+			}
+			if n := database.ExpireRecords(time.Now().Add(30 * time.Minute)); n != 1 {
+				t.Fatalf("expired %d records, want 1", n)
+			}
+			ev := <-events
+			if ev.Op != OpExpire || ev.Record["id"] != short {
+				t.Errorf("event = %+v, want expire of record %d", ev, short)
+			}
+
+			if _, err := database.GetRecord("sessions", short); err == nil {
+				t.Error("expired record is still readable")
+			}
+			if _, err := database.UpdateRecord("sessions", map[string]any{"id": short, "user": "x"}); err == nil {
+				t.Error("expired record can still be updated")
+			}
+			if results, _ := database.Search("sessions", "ann", 0); len(results) != 0 {
+				t.Errorf("search found expired record: %v", results)
+			}
+			records, _ := database.FindRecords("sessions", nil)
+			if len(records) != 2 {
+				t.Errorf("FindRecords returned %d records, want 2", len(records))
+			}
+
+			// Extending the TTL on update keeps bob past the table TTL
+			if _, err := database.UpdateRecord("sessions", map[string]any{"id": long, "_ttl": "3h"}); err != nil {
+				t.Fatal(err)
+			}
+			if n := database.ExpireRecords(time.Now().Add(2 * time.Hour)); n != 0 {
+				t.Errorf("expired %d records after extending the TTL", n)
+			}
+			if _, err := database.GetRecord("sessions", forever); err != nil {
+				t.Errorf("record without expiry: %v", err)
+			}
+
+			stats, err := database.TableStats("sessions")
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantSoft := 0
+			if tt.mode == ExpireSoft {
+				wantSoft = 1
+			}
+			if stats.Records != 2 || stats.Expiring != 1 || stats.Expired != 1 || stats.SoftDeleted != wantSoft {
+				t.Errorf("stats = %+v", stats)
+			}
+			if stats.NextExpiry == nil || stats.LastSweep == nil {
+				t.Errorf("stats missing times: %+v", stats)
+			}
+
+			if tt.mode == ExpireSoft {
+				deleted, _ := database.FindRecords("sessions", []Filter{Where(FieldDeletedAt, OpGte, "2000-01-01")})
+				if len(deleted) != 1 || deleted[0]["user"] != "ann" {
+					t.Errorf("soft-deleted records = %v", deleted)
+				}
+				if snap := database.Snapshot(); len(snap.Tables[0].Records) != 3 {
+					t.Errorf("snapshot has %d records, want 3", len(snap.Tables[0].Records))
+				}
+			}
+		})
+	}
+}
+
+func TestInvalidTTL(t *testing.T) {
+	database := NewDatabase()
+	if err := database.CreateTable(&Table{Name: "a", TTL: "often"}); err == nil {
+		t.Error("created a table with an invalid ttl")
+	}
+	if err := database.CreateTable(&Table{Name: "b", ExpireMode: "archive"}); err == nil {
+		t.Error("created a table with an invalid expire_mode")
+	}
+}
+
+func TestStartExpiry(t *testing.T) {
+	database := NewDatabase()
+	if err := database.CreateTable(&Table{Name: "jobs"}); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Second).Format(time.RFC3339)
+	if _, err := database.InsertRecord("jobs", map[string]any{"_expires_at": past}); err != nil {
+		t.Fatal(err)
+	}
+
+	events, cancel := database.Subscribe(1)
+	defer cancel()
+	stop := database.StartExpiry(time.Millisecond)
+	defer stop()
+
+	select {
+	case ev := <-events:
+		if ev.Op != OpExpire {
+			t.Errorf("event = %+v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("record did not expire")
+	}
+	stop()
+	stop() // stopping twice is safe
+}
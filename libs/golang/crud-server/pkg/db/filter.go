@@ -0,0 +1,164 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Filter operators
+const (
+	OpEq       = "="
+	OpNe       = "!="
+	OpGt       = ">"
+	OpGte      = ">="
+	OpLt       = "<"
+	OpLte      = "<="
+	OpContains = "~"
+)
+
+// operators is ordered so that two-character operators are matched before
+// their one-character prefixes
+var operators = []string{OpNe, OpGte, OpLte, OpEq, OpGt, OpLt, OpContains}
+
+// Filter is a single column condition such as "age>=30". Values are
+// compared numerically when both sides are numbers and as strings otherwise.
+type Filter struct {
+	Column string `json:"column"`
+	Op     string `json:"op"`
+	Value  string `json:"value"`
+}
+
+// Where builds a filter, formatting value with fmt.Sprint
+func Where(column, op string, value any) Filter {
+	return Filter{Column: column, Op: op, Value: fmt.Sprint(value)}
+}
+
+// ParseFilter parses an expression of the form <column><op><value>
+func ParseFilter(expr string) (Filter, error) {
+	for i := 0; i < len(expr); i++ {
+		for _, op := range operators {
+			if !strings.HasPrefix(expr[i:], op) {
+				continue
+			}
+			column := strings.TrimSpace(expr[:i])
+			if column == "" {
+				return Filter{}, fmt.Errorf("invalid filter %q: missing column", expr)
+			}
+			return Filter{
+				Column: column,
+				Op:     op,
+				Value:  strings.TrimSpace(expr[i+len(op):]),
+			}, nil
+		}
+	}
+	return Filter{}, fmt.Errorf("invalid filter %q: expected <column><op><value> with op one of %s", expr, strings.Join(operators, " "))
+}
+
+// String renders the filter in the form accepted by ParseFilter
+func (f Filter) String() string {
+	return f.Column + f.Op + f.Value
+}
+
+// Match reports whether record satisfies the filter. A missing column only
+// satisfies "!=".
+func (f Filter) Match(record map[string]any) bool {
+	v, ok := record[f.Column]
+	if !ok || v == nil {
+		return f.Op == OpNe
+	}
+
+	if f.Op == OpContains {
+		return strings.Contains(strings.ToLower(fmt.Sprint(v)), strings.ToLower(f.Value))
+	}
+
+	var cmp int
+	if n, ok := toFloat(v); ok {
+		want, err := strconv.ParseFloat(f.Value, 64)
+		if err != nil {
+			return f.Op == OpNe
+		}
+		switch {
+		case n < want:
+			cmp = -1
+		case n > want:
+			cmp = 1
+		}
+	} else {
+		cmp = strings.Compare(fmt.Sprint(v), f.Value)
+	}
+
+	switch f.Op {
+	case OpEq:
+		return cmp == 0
+	case OpNe:
+		return cmp != 0
+	case OpGt:
+		return cmp > 0
+	case OpGte:
+		return cmp >= 0
+	case OpLt:
+		return cmp < 0
+	case OpLte:
+		return cmp <= 0
+	}
+	return false
+}
+
+// matchAll reports whether record satisfies every filter
+func matchAll(record map[string]any, filters []Filter) bool {
+	for _, f := range filters {
+		if !f.Match(record) {
+			return false
+		}
+	}
+	return true
+}
+
+// includesDeleted reports whether filters ask for soft-deleted records by
+// naming the _deleted_at column
+func includesDeleted(filters []Filter) bool {
+	for _, f := range filters {
+		if f.Column == FieldDeletedAt {
+			return true
+		}
+	}
+	return false
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// FindRecords returns the records of a table matching every filter.
+// Soft-deleted records are only included when a filter names _deleted_at.
+func (db *Database) FindRecords(tableName string, filters []Filter) ([]map[string]any, error) {
+	return db.findRecords(unrestricted, tableName, filters)
+}
+
+func (db *Database) findRecords(subject Subject, tableName string, filters []Filter) ([]map[string]any, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	tableData, exists := db.tables[tableName]
+	if !exists {
+		return nil, fmt.Errorf("table %s not found", tableName)
+	}
+
+	withDeleted := includesDeleted(filters)
+	result := make([]map[string]any, 0)
+	for _, r := range tableData.records {
+		if (withDeleted || live(r)) && matchAll(r, filters) && tableData.canRead(subject, r) {
+			result = append(result, copyRecord(r))
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,65 @@
+package db
+
+import "testing"
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		expr     string
+		expected Filter
+		wantErr  bool
+	}{
+		{expr: "age>=30", expected: Filter{Column: "age", Op: OpGte, Value: "30"}},
+		{expr: "name!=Bob", expected: Filter{Column: "name", Op: OpNe, Value: "Bob"}},
+		{expr: "name = Bob Smith", expected: Filter{Column: "name", Op: OpEq, Value: "Bob Smith"}},
+		{expr: "note~a=b", expected: Filter{Column: "note", Op: OpContains, Value: "a=b"}},
+		{expr: "age<5", expected: Filter{Column: "age", Op: OpLt, Value: "5"}},
+		{expr: "=5", wantErr: true},
+		{expr: "age", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := ParseFilter(tt.expr)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("expected %+v, got %+v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	record := map[string]any{"name": "Alice", "age": float64(31), "id": 4}
+
+	tests := []struct {
+		filter   Filter
+		expected bool
+	}{
+		{Where("age", OpGt, 30), true},
+		{Where("age", OpLte, 30), false},
+		{Where("age", OpEq, "31"), true},
+		{Where("id", OpEq, 4), true},
+		{Where("name", OpEq, "Alice"), true},
+		{Where("name", OpContains, "lic"), true},
+		{Where("name", OpLt, "Bob"), true},
+		{Where("missing", OpEq, "x"), false},
+		{Where("missing", OpNe, "x"), true},
+		{Where("age", OpEq, "old"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter.String(), func(t *testing.T) {
+			if got := tt.filter.Match(record); got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
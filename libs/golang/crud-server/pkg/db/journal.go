@@ -0,0 +1,428 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Files in a persistence directory
+const (
+	SnapshotFile = "snapshot.json"
+	JournalFile  = "journal.ndjson"
+)
+
+// ErrNotPersistent is returned by Compact when Persist was never called
+var ErrNotPersistent = errors.New("persistence is not enabled")
+
+// PersistOptions control how a Database is kept on disk
+type PersistOptions struct {
+	// Sync flushes the journal to stable storage after every change.
+	// Without it a crash of the machine, but not of the process, can lose
+	// the most recent changes.
+	Sync bool
+}
+
+// StorageStats describes the files of a persisted database
+type StorageStats struct {
+	Dir string `json:"dir"`
+	// JournalEntries counts the changes written since the last compaction
+	JournalEntries int   `json:"journal_entries"`
+	JournalBytes   int64 `json:"journal_bytes"`
+	SnapshotBytes  int64 `json:"snapshot_bytes"`
+	// LastCompaction is when the snapshot was last written
+	LastCompaction *time.Time `json:"last_compaction,omitempty"`
+	// Error is set when a change could not be journaled. Further changes
+	// are kept in memory only until a compaction succeeds.
+	Error string `json:"error,omitempty"`
+}
+
+// CompactResult describes a compaction
+type CompactResult struct {
+	Tables  int `json:"tables"`
+	Records int `json:"records"`
+	// JournalEntries counts the journal entries folded into the snapshot
+	JournalEntries int   `json:"journal_entries"`
+	SnapshotBytes  int64 `json:"snapshot_bytes"`
+}
+
+// journalEntry is one line of the journal. Record changes carry the whole
+// record and the table's next id, so replaying an entry twice is harmless
+// and ids are not reused after a restart. Table creation carries the
+// definition and, for restored tables, the records.
+type journalEntry struct {
+	Op      ChangeOp         `json:"op"`
+	Table   string           `json:"table"`
+	Time    time.Time        `json:"time"`
+	Record  map[string]any   `json:"record,omitempty"`
+	NextID  int              `json:"next_id,omitempty"`
+	Def     *Table           `json:"def,omitempty"`
+	Records []map[string]any `json:"records,omitempty"`
+}
+
+// journal appends changes to the journal file. It is only used with db.mu
+// held.
+type journal struct {
+	dir  string
+	file *os.File
+	sync bool
+
+	entries       int
+	size          int64
+	snapshotBytes int64
+	compacted     time.Time
+	// err is the first failed write since the last compaction
+	err error
+}
+
+// Persist loads the tables stored in dir, creating it when missing, and
+// from then on journals every change there. Call it once, before the
+// database is used. The journal grows until Compact folds it into the
+// snapshot.
+func (db *Database) Persist(dir string, opts PersistOptions) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.journal != nil {
+		return fmt.Errorf("database is already persisted in %s", db.journal.dir)
+	}
+	if len(db.tables) > 0 {
+		return errors.New("persist must be called before any tables are created")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	j := &journal{dir: dir, sync: opts.Sync}
+	if err := db.loadSnapshot(j); err != nil {
+		return err
+	}
+	if err := db.replayJournal(j); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, JournalFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	j.file = file
+	db.journal = j
+	return nil
+}
+
+func (db *Database) loadSnapshot(j *journal) error {
+	path := filepath.Join(j.dir, SnapshotFile)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	for _, ts := range snap.Tables {
+		records, maxID, err := snapshotRecords(ts)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+		t, err := newTableDataFrom(ts.Table, records, max(ts.NextID, maxID+1))
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+		db.tables[ts.Table.Name] = t
+	}
+	db.schemaVersion.Add(1)
+	j.snapshotBytes = int64(len(data))
+	j.compacted = snap.CreatedAt
+	return nil
+}
+
+// replayJournal applies the journal on top of the snapshot. A final line
+// without a newline is the remains of an interrupted write and is cut off.
+func (db *Database) replayJournal(j *journal) error {
+	path := filepath.Join(j.dir, JournalFile)
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var offset int64
+	for line := 1; ; line++ {
+		data, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(bytes.TrimSpace(data)) > 0 {
+				if err := os.Truncate(path, offset); err != nil {
+					return err
+				}
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		var e journalEntry
+		if err := json.Unmarshal(data, &e); err != nil {
+			return fmt.Errorf("%s line %d: %w", path, line, err)
+		}
+		if err := db.replay(e); err != nil {
+			return fmt.Errorf("%s line %d: %w", path, line, err)
+		}
+		offset += int64(len(data))
+		j.entries++
+	}
+	j.size = offset
+	return nil
+}
+
+// replay applies one journal entry. It is called with db.mu held.
+func (db *Database) replay(e journalEntry) error {
+	switch e.Op {
+	case OpCreateTable:
+		if e.Def == nil {
+			return fmt.Errorf("create_table entry for %s without a definition", e.Table)
+		}
+		records, maxID, err := snapshotRecords(TableSnapshot{Table: *e.Def, Records: e.Records})
+		if err != nil {
+			return err
+		}
+		t, err := newTableDataFrom(*e.Def, records, max(e.NextID, maxID+1))
+		if err != nil {
+			return err
+		}
+		t.modified = e.Time
+		db.tables[e.Table] = t
+		db.schemaVersion.Add(1)
+		return nil
+	case OpDeleteTable:
+		delete(db.tables, e.Table)
+		db.schemaVersion.Add(1)
+		return nil
+	}
+
+	t, exists := db.tables[e.Table]
+	if !exists {
+		return fmt.Errorf("%s entry for unknown table %s", e.Op, e.Table)
+	}
+	id, ok := recordID(e.Record["id"])
+	if !ok {
+		return fmt.Errorf("%s entry for table %s without a valid record id", e.Op, e.Table)
+	}
+	record := copyRecord(e.Record)
+	record["id"] = id
+
+	i := t.find(id)
+	remove := e.Op == OpDelete || (e.Op == OpExpire && live(record))
+	switch {
+	case remove && i >= 0:
+		t.records = append(t.records[:i], t.records[i+1:]...)
+		if t.index != nil {
+			t.index.remove(id)
+		}
+	case !remove:
+		if i >= 0 {
+			t.records[i] = record
+		} else {
+			t.records = append(t.records, record)
+		}
+		if t.index != nil {
+			t.index.add(id, record)
+		}
+	}
+	if e.Op == OpExpire {
+		t.expired++
+	}
+	t.nextID = max(t.nextID, e.NextID, id+1)
+	t.modified = e.Time
+	return nil
+}
+
+// appendEntry journals a change. It is called from publish with db.mu held.
+// After a failed write nothing more is appended, because a torn line would
+// hide every later entry; the next compaction writes everything out again.
+func (db *Database) appendEntry(op ChangeOp, table string, record map[string]any, now time.Time) {
+	j := db.journal
+	if j.err != nil {
+		return
+	}
+
+	e := journalEntry{Op: op, Table: table, Time: now, Record: record}
+	if t, exists := db.tables[table]; exists && op != OpDeleteTable {
+		e.NextID = t.nextID
+		if op == OpCreateTable {
+			def := *t.table
+			e.Def = &def
+			e.Records = t.records
+		}
+	}
+
+	data, err := json.Marshal(e)
+	if err == nil {
+		data = append(data, '\n')
+		_, err = j.file.Write(data)
+	}
+	if err == nil && j.sync {
+		err = j.file.Sync()
+	}
+	if err != nil {
+		j.err = fmt.Errorf("journaling %s on %s: %w", op, table, err)
+		return
+	}
+	j.entries++
+	j.size += int64(len(data))
+}
+
+// Compact writes every table to a new snapshot and empties the journal.
+// Writers wait until it finishes. The snapshot replaces the old one
+// atomically, and a crash before the journal is emptied only means its
+// entries are replayed again, which leaves the same state.
+func (db *Database) Compact() (CompactResult, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	j := db.journal
+	if j == nil {
+		return CompactResult{}, ErrNotPersistent
+	}
+
+	snap := db.snapshot()
+	result := CompactResult{Tables: len(snap.Tables), JournalEntries: j.entries}
+	for _, t := range snap.Tables {
+		result.Records += len(t.Records)
+	}
+
+	size, err := writeFileAtomic(filepath.Join(j.dir, SnapshotFile), 0o644, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(snap)
+	})
+	if err != nil {
+		return result, err
+	}
+	result.SnapshotBytes = size
+	j.snapshotBytes = size
+	j.compacted = snap.CreatedAt
+
+	if err := j.file.Truncate(0); err != nil {
+		j.err = fmt.Errorf("emptying journal: %w", err)
+		return result, j.err
+	}
+	if j.sync {
+		if err := j.file.Sync(); err != nil {
+			j.err = fmt.Errorf("emptying journal: %w", err)
+			return result, j.err
+		}
+	}
+	j.entries = 0
+	j.size = 0
+	j.err = nil
+	return result, nil
+}
+
+// writeFileAtomic writes a file through a temporary file in the same
+// directory, syncs it and renames it into place
+func writeFileAtomic(path string, perm fs.FileMode, write func(io.Writer) error) (int64, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	err = tmp.Chmod(perm)
+	if err == nil {
+		err = write(w)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	info, err := os.Stat(tmp.Name())
+	if err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, err
+	}
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return info.Size(), nil
+}
+
+// CheckStorage reports whether changes are being persisted. It returns nil
+// for databases that are not persisted.
+func (db *Database) CheckStorage() error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	j := db.journal
+	if j == nil {
+		return nil
+	}
+	if j.err != nil {
+		return j.err
+	}
+	if _, err := j.file.Stat(); err != nil {
+		return fmt.Errorf("journal: %w", err)
+	}
+	if _, err := os.Stat(filepath.Join(j.dir, JournalFile)); err != nil {
+		return fmt.Errorf("journal: %w", err)
+	}
+	return nil
+}
+
+func (j *journal) stats() *StorageStats {
+	stats := &StorageStats{
+		Dir:            j.dir,
+		JournalEntries: j.entries,
+		JournalBytes:   j.size,
+		SnapshotBytes:  j.snapshotBytes,
+	}
+	if !j.compacted.IsZero() {
+		compacted := j.compacted.UTC()
+		stats.LastCompaction = &compacted
+	}
+	if j.err != nil {
+		stats.Error = j.err.Error()
+	}
+	return stats
+}
+
+// Close closes the journal of a persisted database. Changes made afterwards
+// are not persisted.
+func (db *Database) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.journal == nil {
+		return nil
+	}
+	err := db.journal.file.Close()
+	db.journal = nil
+	return err
+}
@@ -0,0 +1,225 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func openPersisted(t *testing.T, dir string) *Database {
+	t.Helper()
+	database := NewDatabase()
+	if err := database.Persist(dir, PersistOptions{Sync: true}); err != nil {
+		t.Fatalf("Persist failed: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return database
+}
+
+func TestPersist(t *testing.T) {
+	dir := t.TempDir()
+	database := openPersisted(t, dir)
+
+	if err := database.CreateTable(&Table{Name: "notes", Columns: []Column{{Name: "body", Type: "string"}}, SearchColumns: []string{"body"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.CreateTable(&Table{Name: "scratch"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{"first", "second", "third"} {
+		if _, err := database.InsertRecord("notes", map[string]any{"body": body}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := database.UpdateRecord("notes", map[string]any{"id": 2, "body": "second edited"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.DeleteRecord("notes", 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.DeleteTable("scratch"); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(t *testing.T, d *Database, wantNextID int) {
+		t.Helper()
+		if tables := d.ListTables(); !reflect.DeepEqual(tables, []string{"notes"}) {
+			t.Errorf("tables = %v", tables)
+		}
+		records, _ := d.GetRecords("notes")
+		if len(records) != 2 || records[1]["body"] != "second edited" {
+			t.Errorf("records = %v", records)
+		}
+		if results, _ := d.Search("notes", "edited", 0); len(results) != 1 {
+			t.Errorf("search index was not rebuilt: %v", results)
+		}
+		id, err := d.InsertRecord("notes", map[string]any{"body": "next"})
+		if err != nil || id != wantNextID {
+			t.Errorf("next id = %d, %v, want %d", id, err, wantNextID)
+		}
+	}
+
+	// Reopen from the journal alone; check inserts one more record
+	database.Close()
+	reopened := openPersisted(t, dir)
+	check(t, reopened, 4)
+	if stats := reopened.Stats().Storage; stats == nil || stats.JournalEntries != 9 {
+		t.Errorf("storage stats = %+v", stats)
+	}
+
+	// Compact, then reopen from the snapshot
+	result, err := reopened.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Tables != 1 || result.Records != 3 || result.JournalEntries != 9 || result.SnapshotBytes == 0 {
+		t.Errorf("compact result = %+v", result)
+	}
+	if info, err := os.Stat(filepath.Join(dir, JournalFile)); err != nil || info.Size() != 0 {
+		t.Errorf("journal after compaction: %v, %v", info, err)
+	}
+	if err := reopened.DeleteRecord("notes", 4); err != nil {
+		t.Fatal(err)
+	}
+	reopened.Close()
+	check(t, openPersisted(t, dir), 5)
+}
+
+func TestPersistTruncate(t *testing.T) {
+	tests := []struct {
+		name    string
+		subject Subject
+		removed int
+		left    int
+	}{
+		{"admin", Subject{ID: "root", Admin: true}, 3, 0},
+		{"owner", Subject{ID: "ann"}, 2, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			database := openPersisted(t, dir)
+			err := database.CreateTable(&Table{
+				Name:       "notes",
+				Columns:    []Column{{Name: "owner", Type: "string"}, {Name: "text", Type: "string"}},
+				Policy:     &RowPolicy{OwnerColumn: "owner"},
+				TTL:        "1h",
+				ExpireMode: ExpireSoft,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ann, bob := database.As(Subject{ID: "ann"}), database.As(Subject{ID: "bob"})
+			for _, insert := range []struct {
+				scope  *Scope
+				record map[string]any
+			}{
+				{ann, map[string]any{"text": "expired", "_ttl": "1m"}},
+				{ann, map[string]any{"text": "live"}},
+				{bob, map[string]any{"text": "bob's"}},
+			} {
+				if _, err := insert.scope.InsertRecord("notes", insert.record); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if n := database.ExpireRecords(time.Now().Add(30 * time.Minute)); n != 1 {
+				t.Fatalf("expired %d records, want 1", n)
+			}
+
+			n, err := database.As(tt.subject).TruncateTable("notes")
+			if err != nil || n != tt.removed {
+				t.Fatalf("TruncateTable = %d, %v, want %d", n, err, tt.removed)
+			}
+			database.Close()
+			// Soft-deleted records count, since the snapshot keeps them
+			if records := openPersisted(t, dir).Snapshot().Tables[0].Records; len(records) != tt.left {
+				t.Errorf("records after replay = %v, want %d", records, tt.left)
+			}
+		})
+	}
+}
+
+func TestPersistRecovery(t *testing.T) {
+	dir := t.TempDir()
+	database := openPersisted(t, dir)
+	if err := database.CreateTable(&Table{Name: "t"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.InsertRecord("t", map[string]any{"v": 1}); err != nil {
+		t.Fatal(err)
+	}
+	database.Close()
+
+	journalPath := filepath.Join(dir, JournalFile)
+	journal, err := os.ReadFile(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An interrupted write leaves a partial last line, which is dropped
+	if err := os.WriteFile(journalPath, append(journal, `{"op":"insert","tab`...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	recovered := openPersisted(t, dir)
+	if records, _ := recovered.GetRecords("t"); len(records) != 1 {
+		t.Errorf("records after recovery = %v", records)
+	}
+	if data, _ := os.ReadFile(journalPath); string(data) != string(journal) {
+		t.Errorf("partial line was not cut off: %q", data)
+	}
+	recovered.Close()
+
+	// Entries replayed on top of a snapshot that already has them, as after
+	// a crash during compaction, leave the same state
+	compacting := openPersisted(t, dir)
+	if _, err := compacting.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	compacting.Close()
+	if err := os.WriteFile(journalPath, journal, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if records, _ := openPersisted(t, dir).GetRecords("t"); len(records) != 1 {
+		t.Errorf("records after double replay = %v", records)
+	}
+
+	// Corruption before the last line is an error
+	if err := os.WriteFile(journalPath, append([]byte("not json\n"), journal...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewDatabase().Persist(dir, PersistOptions{}); err == nil {
+		t.Error("loaded a corrupt journal")
+	}
+}
+
+func TestPersistErrors(t *testing.T) {
+	if _, err := NewDatabase().Compact(); !errors.Is(err, ErrNotPersistent) {
+		t.Errorf("Compact without persistence = %v", err)
+	}
+	if err := NewDatabase().CheckStorage(); err != nil {
+		t.Errorf("CheckStorage without persistence = %v", err)
+	}
+
+	database := NewDatabase()
+	if err := database.CreateTable(&Table{Name: "t"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.Persist(t.TempDir(), PersistOptions{}); err == nil {
+		t.Error("persisted a database that already has tables")
+	}
+
+	dir := t.TempDir()
+	persisted := openPersisted(t, dir)
+	if err := persisted.CheckStorage(); err != nil {
+		t.Errorf("CheckStorage = %v", err)
+	}
+	if err := os.Remove(filepath.Join(dir, JournalFile)); err != nil {
+		t.Fatal(err)
+	}
+	if err := persisted.CheckStorage(); err == nil {
+		t.Error("CheckStorage did not notice the missing journal")
+	}
+}
@@ -0,0 +1,207 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Row policy read modes
+const (
+	PolicyOwner = "owner"
+	PolicyAll   = "all"
+)
+
+// ErrForbidden is returned when a row policy forbids a change
+var ErrForbidden = errors.New("permission denied")
+
+// RowPolicy restricts which records of a table a subject may see and
+// change. Each record belongs to the subject named in its owner column,
+// which inserts fill in. Only the owner and admins may update or delete a
+// record.
+type RowPolicy struct {
+	// OwnerColumn is a string column of the table
+	OwnerColumn string `json:"owner_column"`
+	// Read is PolicyOwner (the default) to show subjects only their own
+	// records, or PolicyAll to show every record
+	Read string `json:"read,omitempty"`
+}
+
+// Subject is the identity records are accessed as
+type Subject struct {
+	ID string `json:"id"`
+	// Admin bypasses row policies
+	Admin bool `json:"admin,omitempty"`
+}
+
+// unrestricted is the subject of the Database methods themselves
+var unrestricted = Subject{Admin: true}
+
+type subjectKey struct{}
+
+// WithSubject returns a context carrying subject, for Database.For
+func WithSubject(ctx context.Context, subject Subject) context.Context {
+	return context.WithValue(ctx, subjectKey{}, subject)
+}
+
+// SubjectFrom returns the subject stored in ctx by WithSubject
+func SubjectFrom(ctx context.Context) (Subject, bool) {
+	subject, ok := ctx.Value(subjectKey{}).(Subject)
+	return subject, ok
+}
+
+// validatePolicy checks a table's row policy against its columns
+func validatePolicy(table *Table) error {
+	p := table.Policy
+	if p == nil {
+		return nil
+	}
+	if !hasStringColumn(table, p.OwnerColumn) {
+		return fmt.Errorf("policy owner column %q must be a string column of table %s", p.OwnerColumn, table.Name)
+	}
+	switch p.Read {
+	case "", PolicyOwner, PolicyAll:
+		return nil
+	}
+	return fmt.Errorf("invalid policy read mode %q for table %s: expected %s or %s", p.Read, table.Name, PolicyOwner, PolicyAll)
+}
+
+// owns reports whether the policy lets subject change record
+func (p *RowPolicy) owns(subject Subject, record map[string]any) bool {
+	if p == nil || subject.Admin {
+		return true
+	}
+	owner, _ := record[p.OwnerColumn].(string)
+	return subject.ID != "" && owner == subject.ID
+}
+
+// canRead reports whether the policy lets subject see record
+func (p *RowPolicy) canRead(subject Subject, record map[string]any) bool {
+	if p != nil && p.Read == PolicyAll {
+		return true
+	}
+	return p.owns(subject, record)
+}
+
+func (t *tableData) owns(subject Subject, record map[string]any) bool {
+	return t.table.Policy.owns(subject, record)
+}
+
+func (t *tableData) canRead(subject Subject, record map[string]any) bool {
+	return t.table.Policy.canRead(subject, record)
+}
+
+// stampOwner makes subject the owner of a record about to be inserted.
+// Admins may insert records on behalf of someone else.
+func (t *tableData) stampOwner(subject Subject, record map[string]any) error {
+	p := t.table.Policy
+	if p == nil || subject.Admin {
+		return nil
+	}
+	if subject.ID == "" {
+		return fmt.Errorf("%w: inserting into %s needs an authenticated subject", ErrForbidden, t.table.Name)
+	}
+	if owner, ok := record[p.OwnerColumn]; ok && owner != subject.ID {
+		return fmt.Errorf("%w: %s must be %q", ErrForbidden, p.OwnerColumn, subject.ID)
+	}
+	record[p.OwnerColumn] = subject.ID
+	return nil
+}
+
+// checkChanges rejects changes that would hand a record to another subject
+func (t *tableData) checkChanges(subject Subject, changes map[string]any) error {
+	p := t.table.Policy
+	if p == nil || subject.Admin {
+		return nil
+	}
+	if owner, ok := changes[p.OwnerColumn]; ok && owner != subject.ID {
+		return fmt.Errorf("%w: only admins can change %s", ErrForbidden, p.OwnerColumn)
+	}
+	return nil
+}
+
+// Scope accesses records as one subject, applying the row policies of the
+// tables. Tables without a policy are unaffected.
+type Scope struct {
+	db      *Database
+	subject Subject
+}
+
+// As returns a scope acting as subject
+func (db *Database) As(subject Subject) *Scope {
+	return &Scope{db: db, subject: subject}
+}
+
+// For returns a scope acting as the subject stored in ctx, or an
+// unrestricted one when ctx carries no subject
+func (db *Database) For(ctx context.Context) *Scope {
+	subject, ok := SubjectFrom(ctx)
+	if !ok {
+		subject = unrestricted
+	}
+	return db.As(subject)
+}
+
+// Subject returns the subject the scope acts as
+func (s *Scope) Subject() Subject {
+	return s.subject
+}
+
+// CanSee reports whether the subject may see a change event, applying the
+// table's row policy as it was when the change was made
+func (s *Scope) CanSee(ev ChangeEvent) bool {
+	return ev.Record == nil || ev.policy.canRead(s.subject, ev.Record)
+}
+
+// GetRecords is Database.GetRecords limited to the readable records
+func (s *Scope) GetRecords(tableName string) ([]map[string]any, error) {
+	return s.db.getRecords(s.subject, tableName)
+}
+
+// GetRecord is Database.GetRecord. Records the subject may not read are
+// not found.
+func (s *Scope) GetRecord(tableName string, id any) (map[string]any, error) {
+	return s.db.getRecord(s.subject, tableName, id)
+}
+
+// FindRecords is Database.FindRecords limited to the readable records
+func (s *Scope) FindRecords(tableName string, filters []Filter) ([]map[string]any, error) {
+	return s.db.findRecords(s.subject, tableName, filters)
+}
+
+// Search is Database.Search limited to the readable records
+func (s *Scope) Search(tableName, query string, limit int) ([]SearchResult, error) {
+	return s.db.search(s.subject, tableName, query, limit)
+}
+
+// Aggregate is Database.Aggregate over the readable records
+func (s *Scope) Aggregate(tableName string, q AggregateQuery) ([]AggregateGroup, error) {
+	return s.db.aggregate(s.subject, tableName, q)
+}
+
+// InsertRecord is Database.InsertRecord, making the subject the owner
+func (s *Scope) InsertRecord(tableName string, record map[string]any) (int, error) {
+	return s.db.insertRecord(s.subject, tableName, record)
+}
+
+// UpdateRecord is Database.UpdateRecord for records the subject owns
+func (s *Scope) UpdateRecord(tableName string, record map[string]any) (map[string]any, error) {
+	return s.db.updateRecord(s.subject, tableName, record)
+}
+
+// UpsertRecord is Database.UpsertRecord. Key columns are matched against
+// the readable records, and matching a record the subject does not own is
+// an error.
+func (s *Scope) UpsertRecord(tableName string, key []string, record map[string]any, dryRun bool) (UpsertResult, error) {
+	return s.db.upsertRecord(s.subject, tableName, key, record, dryRun)
+}
+
+// DeleteRecord is Database.DeleteRecord for records the subject owns
+func (s *Scope) DeleteRecord(tableName string, id any) error {
+	return s.db.deleteRecord(s.subject, tableName, id)
+}
+
+// TruncateTable deletes every record the subject owns
+func (s *Scope) TruncateTable(name string) (int, error) {
+	return s.db.truncateTable(s.subject, name)
+}
@@ -0,0 +1,228 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestRowPolicyValidation(t *testing.T) {
+	columns := []Column{{Name: "owner", Type: "string"}, {Name: "count", Type: "number"}}
+	tests := []struct {
+		policy RowPolicy
+		err    string
+	}{
+		{RowPolicy{OwnerColumn: "owner"}, ""},
+		{RowPolicy{OwnerColumn: "owner", Read: PolicyAll}, ""},
+		{RowPolicy{OwnerColumn: "count"}, "must be a string column"},
+		{RowPolicy{OwnerColumn: "missing"}, "must be a string column"},
+		{RowPolicy{OwnerColumn: "owner", Read: "friends"}, "invalid policy read mode"},
+	}
+	for _, tt := range tests {
+		policy := tt.policy
+		err := NewDatabase().CreateTable(&Table{Name: "notes", Columns: columns, Policy: &policy})
+		if tt.err == "" && err != nil {
+			t.Errorf("%+v: %v", tt.policy, err)
+		}
+		if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%+v: error = %v, want %q", tt.policy, err, tt.err)
+		}
+	}
+}
+
+// policyDB has a private notes table and a public posts table, each with a
+// record owned by ann and one owned by bob
+func policyDB(t *testing.T) *Database {
+	t.Helper()
+	database := NewDatabase()
+	columns := []Column{{Name: "owner", Type: "string"}, {Name: "text", Type: "string"}}
+	tables := []*Table{
+		{Name: "notes", Columns: columns, SearchColumns: []string{"text"}, Policy: &RowPolicy{OwnerColumn: "owner"}},
+		{Name: "posts", Columns: columns, Policy: &RowPolicy{OwnerColumn: "owner", Read: PolicyAll}},
+	}
+	for _, table := range tables {
+		if err := database.CreateTable(table); err != nil {
+			t.Fatal(err)
+		}
+		for _, who := range []string{"ann", "bob"} {
+			if _, err := database.As(Subject{ID: who}).InsertRecord(table.Name, map[string]any{"text": "hello from " + who}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return database
+}
+
+func owners(records []map[string]any) string {
+	var names []string
+	for _, r := range records {
+		names = append(names, r["owner"].(string))
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func TestRowPolicyReads(t *testing.T) {
+	database := policyDB(t)
+
+	tests := []struct {
+		subject      Subject
+		notes, posts string
+	}{
+		{Subject{ID: "ann"}, "ann", "ann,bob"},
+		{Subject{ID: "bob"}, "bob", "ann,bob"},
+		{Subject{ID: "eve"}, "", "ann,bob"},
+		{Subject{}, "", "ann,bob"},
+		{Subject{ID: "root", Admin: true}, "ann,bob", "ann,bob"},
+	}
+	for _, tt := range tests {
+		scope := database.As(tt.subject)
+		notes, err := scope.FindRecords("notes", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		posts, err := scope.GetRecords("posts")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if owners(notes) != tt.notes || owners(posts) != tt.posts {
+			t.Errorf("%+v sees notes of %q and posts of %q, want %q and %q", tt.subject, owners(notes), owners(posts), tt.notes, tt.posts)
+		}
+
+		results, err := scope.Search("notes", "hello", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != len(notes) {
+			t.Errorf("%+v finds %d notes, want %d", tt.subject, len(results), len(notes))
+		}
+		groups, err := scope.Aggregate("notes", AggregateQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if groups[0].Values["count"] != len(notes) {
+			t.Errorf("%+v counts %v notes, want %d", tt.subject, groups[0].Values["count"], len(notes))
+		}
+	}
+
+	// Bob's note (id 2) does not exist for ann
+	if _, err := database.As(Subject{ID: "ann"}).GetRecord("notes", 2); err == nil || !strings.HasSuffix(err.Error(), "not found") {
+		t.Errorf("ann reading bob's note: %v", err)
+	}
+	// Without a subject in the context, access is unrestricted
+	if records, _ := database.For(context.Background()).GetRecords("notes"); len(records) != 2 {
+		t.Errorf("unrestricted scope sees %d notes", len(records))
+	}
+	ctx := WithSubject(context.Background(), Subject{ID: "bob"})
+	if records, _ := database.For(ctx).GetRecords("notes"); owners(records) != "bob" {
+		t.Errorf("bob's context sees notes of %q", owners(records))
+	}
+}
+
+func TestRowPolicyWrites(t *testing.T) {
+	database := policyDB(t)
+	ann, bob, eve := database.As(Subject{ID: "ann"}), database.As(Subject{ID: "bob"}), database.As(Subject{})
+	admin := database.As(Subject{ID: "root", Admin: true})
+
+	tests := []struct {
+		name      string
+		do        func() error
+		forbidden bool
+		notFound  bool
+	}{
+		{"ann updates her note", func() error {
+			_, err := ann.UpdateRecord("notes", map[string]any{"id": 1, "text": "edited"})
+			return err
+		}, false, false},
+		{"ann updates bob's note", func() error {
+			_, err := ann.UpdateRecord("notes", map[string]any{"id": 2, "text": "mine now"})
+			return err
+		}, false, true},
+		{"ann updates bob's post", func() error {
+			_, err := ann.UpdateRecord("posts", map[string]any{"id": 2, "text": "mine now"})
+			return err
+		}, true, false},
+		{"ann gives her note away", func() error {
+			_, err := ann.UpdateRecord("notes", map[string]any{"id": 1, "owner": "bob"})
+			return err
+		}, true, false},
+		{"ann inserts as bob", func() error {
+			_, err := ann.InsertRecord("notes", map[string]any{"owner": "bob"})
+			return err
+		}, true, false},
+		{"anonymous inserts", func() error {
+			_, err := eve.InsertRecord("notes", map[string]any{"text": "hi"})
+			return err
+		}, true, false},
+		{"ann deletes bob's note", func() error { return ann.DeleteRecord("notes", 2) }, false, true},
+		{"ann deletes bob's post", func() error { return ann.DeleteRecord("posts", 2) }, true, false},
+		{"ann upserts over bob's post", func() error {
+			_, err := ann.UpsertRecord("posts", []string{"text"}, map[string]any{"text": "hello from bob"}, false)
+			return err
+		}, true, false},
+		{"admin gives bob's note to ann", func() error {
+			_, err := admin.UpdateRecord("notes", map[string]any{"id": 2, "owner": "ann"})
+			return err
+		}, false, false},
+		{"bob deletes the note he gave away", func() error { return bob.DeleteRecord("notes", 2) }, false, true},
+		{"ann deletes the note she was given", func() error { return ann.DeleteRecord("notes", 2) }, false, false},
+	}
+	for _, tt := range tests {
+		err := tt.do()
+		switch {
+		case tt.forbidden && !errors.Is(err, ErrForbidden):
+			t.Errorf("%s: error = %v, want permission denied", tt.name, err)
+		case tt.notFound && (err == nil || !strings.HasSuffix(err.Error(), "not found")):
+			t.Errorf("%s: error = %v, want not found", tt.name, err)
+		case !tt.forbidden && !tt.notFound && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+
+	// Upserting a note only matches the subject's own notes, so bob gets a
+	// copy of ann's text instead of taking over her record
+	res, err := bob.UpsertRecord("notes", []string{"text"}, map[string]any{"text": "edited"}, false)
+	if err != nil || res.Action != UpsertCreated || res.Record["owner"] != "bob" {
+		t.Errorf("bob's upsert = %+v, %v", res, err)
+	}
+
+	// Truncating only removes the subject's own records
+	if n, err := bob.TruncateTable("posts"); err != nil || n != 1 {
+		t.Errorf("bob truncated %d posts, %v", n, err)
+	}
+	if records, _ := database.GetRecords("posts"); owners(records) != "ann" {
+		t.Errorf("posts left after bob's truncate: %q", owners(records))
+	}
+}
+
+func TestRowPolicyEvents(t *testing.T) {
+	database := policyDB(t)
+	events, cancel := database.Subscribe(8)
+	defer cancel()
+
+	database.As(Subject{ID: "ann"}).InsertRecord("notes", map[string]any{"text": "secret"})
+	database.As(Subject{ID: "bob"}).InsertRecord("posts", map[string]any{"text": "public"})
+	if err := database.DeleteTable("notes"); err != nil {
+		t.Fatal(err)
+	}
+
+	ann, bob := database.As(Subject{ID: "ann"}), database.As(Subject{ID: "bob"})
+	var annSees, bobSees []string
+	for range 3 {
+		ev := <-events
+		if ann.CanSee(ev) {
+			annSees = append(annSees, string(ev.Op)+" "+ev.Table)
+		}
+		if bob.CanSee(ev) {
+			bobSees = append(bobSees, string(ev.Op)+" "+ev.Table)
+		}
+	}
+	if got := strings.Join(annSees, ", "); got != "insert notes, insert posts, delete_table notes" {
+		t.Errorf("ann sees %s", got)
+	}
+	if got := strings.Join(bobSees, ", "); got != "insert posts, delete_table notes" {
+		t.Errorf("bob sees %s", got)
+	}
+}
@@ -0,0 +1,236 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// SearchResult is a single full-text search hit
+type SearchResult struct {
+	Record     map[string]any    `json:"record"`
+	Score      float64           `json:"score"`
+	Highlights map[string]string `json:"highlights,omitempty"`
+}
+
+// Highlight markers wrapped around matched words in SearchResult.Highlights.
+// The text around them is HTML escaped, so highlights can be inserted into
+// a page as they are.
+const (
+	HighlightStart = "<mark>"
+	HighlightEnd   = "</mark>"
+)
+
+// ErrNoSearchColumns is returned by Search for a table without search columns
+var ErrNoSearchColumns = errors.New("no search columns")
+
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "but": true, "by": true, "for": true, "from": true, "has": true,
+	"have": true, "he": true, "her": true, "his": true, "i": true, "if": true,
+	"in": true, "into": true, "is": true, "it": true, "its": true, "no": true,
+	"not": true, "of": true, "on": true, "or": true, "she": true, "so": true,
+	"such": true, "that": true, "the": true, "their": true, "then": true,
+	"there": true, "these": true, "they": true, "this": true, "to": true,
+	"was": true, "we": true, "were": true, "will": true, "with": true, "you": true,
+}
+
+// token is a word found in a piece of text along with its byte offsets
+type token struct {
+	term       string
+	start, end int
+}
+
+// tokenize splits text into lower-cased, stemmed terms, dropping stop words.
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	flush := func(end int) {
+		if start < 0 {
+			return
+		}
+		word := strings.ToLower(text[start:end])
+		if !stopWords[word] {
+			tokens = append(tokens, token{term: stem(word), start: start, end: end})
+		}
+		start = -1
+	}
+
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		flush(i)
+	}
+	flush(len(text))
+	return tokens
+}
+
+// searchIndex is an inverted index over a table's search columns
+type searchIndex struct {
+	columns  []string
+	postings map[string]map[int]int // term -> record id -> term frequency
+	docTerms map[int][]string       // record id -> distinct terms
+	docLen   map[int]int            // record id -> total terms
+}
+
+func newSearchIndex(columns []string) *searchIndex {
+	return &searchIndex{
+		columns:  columns,
+		postings: make(map[string]map[int]int),
+		docTerms: make(map[int][]string),
+		docLen:   make(map[int]int),
+	}
+}
+
+// add indexes a record, replacing any previous entry for the same id
+func (idx *searchIndex) add(id int, record map[string]any) {
+	idx.remove(id)
+
+	freqs := make(map[string]int)
+	length := 0
+	for _, col := range idx.columns {
+		text, ok := record[col].(string)
+		if !ok {
+			continue
+		}
+		for _, tok := range tokenize(text) {
+			freqs[tok.term]++
+			length++
+		}
+	}
+	if length == 0 {
+		return
+	}
+
+	terms := make([]string, 0, len(freqs))
+	for term, n := range freqs {
+		if idx.postings[term] == nil {
+			idx.postings[term] = make(map[int]int)
+		}
+		idx.postings[term][id] = n
+		terms = append(terms, term)
+	}
+	idx.docTerms[id] = terms
+	idx.docLen[id] = length
+}
+
+// remove drops a record from the index
+func (idx *searchIndex) remove(id int) {
+	for _, term := range idx.docTerms[id] {
+		delete(idx.postings[term], id)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+	delete(idx.docTerms, id)
+	delete(idx.docLen, id)
+}
+
+// search scores every record containing at least one query term using
+// TF-IDF normalized by document length.
+func (idx *searchIndex) search(terms []string) map[int]float64 {
+	scores := make(map[int]float64)
+	docs := float64(len(idx.docLen))
+	for _, term := range terms {
+		posting := idx.postings[term]
+		if len(posting) == 0 {
+			continue
+		}
+		idf := math.Log(1 + docs/float64(len(posting)))
+		for id, tf := range posting {
+			scores[id] += float64(tf) * idf / math.Sqrt(float64(idx.docLen[id]))
+		}
+	}
+	return scores
+}
+
+// highlight HTML escapes text and wraps every word whose term is in terms
+// with the highlight markers. It reports whether anything matched.
+func highlight(text string, terms map[string]bool) (string, bool) {
+	var b strings.Builder
+	last := 0
+	matched := false
+	for _, tok := range tokenize(text) {
+		if !terms[tok.term] {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[last:tok.start]))
+		b.WriteString(HighlightStart)
+		b.WriteString(html.EscapeString(text[tok.start:tok.end]))
+		b.WriteString(HighlightEnd)
+		last = tok.end
+		matched = true
+	}
+	b.WriteString(html.EscapeString(text[last:]))
+	return b.String(), matched
+}
+
+// Search runs a full-text query against the table's search columns and
+// returns matching records ordered by relevance. A limit of zero or less
+// returns every match.
+func (db *Database) Search(tableName, query string, limit int) ([]SearchResult, error) {
+	return db.search(unrestricted, tableName, query, limit)
+}
+
+func (db *Database) search(subject Subject, tableName, query string, limit int) ([]SearchResult, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	tableData, exists := db.tables[tableName]
+	if !exists {
+		return nil, fmt.Errorf("table %s not found", tableName)
+	}
+	if tableData.index == nil {
+		return nil, fmt.Errorf("table %s has %w", tableName, ErrNoSearchColumns)
+	}
+
+	queryTerms := make(map[string]bool)
+	terms := make([]string, 0)
+	for _, tok := range tokenize(query) {
+		if !queryTerms[tok.term] {
+			queryTerms[tok.term] = true
+			terms = append(terms, tok.term)
+		}
+	}
+
+	scores := tableData.index.search(terms)
+	results := make([]SearchResult, 0, len(scores))
+	for _, r := range tableData.records {
+		id, _ := recordID(r["id"])
+		score, ok := scores[id]
+		if !ok || !live(r) || !tableData.canRead(subject, r) {
+			continue
+		}
+
+		result := SearchResult{Record: copyRecord(r), Score: score}
+		for _, col := range tableData.index.columns {
+			text, ok := r[col].(string)
+			if !ok {
+				continue
+			}
+			if marked, ok := highlight(text, queryTerms); ok {
+				if result.Highlights == nil {
+					result.Highlights = make(map[string]string)
+				}
+				result.Highlights[col] = marked
+			}
+		}
+		results = append(results, result)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
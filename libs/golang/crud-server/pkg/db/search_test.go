@@ -0,0 +1,202 @@
+package db
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestStem(t *testing.T) {
+	tests := []struct {
+		word     string
+		expected string
+	}{
+		{"caresses", "caress"},
+		{"ponies", "poni"},
+		{"cats", "cat"},
+		{"agreed", "agre"},
+		{"hopping", "hop"},
+		{"running", "run"},
+		{"happy", "happi"},
+		{"relational", "relat"},
+		{"generalization", "gener"},
+		{"hopefulness", "hope"},
+		{"go", "go"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.word, func(t *testing.T) {
+			if got := stem(tt.word); got != tt.expected {
+				t.Errorf("stem(%q) = %q, expected %q", tt.word, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		expected []string
+	}{
+		{
+			name:     "drops stop words and punctuation",
+			text:     "The cat, and the hat!",
+			expected: []string{"cat", "hat"},
+		},
+		{
+			name:     "stems and lowercases",
+			text:     "Running Dogs",
+			expected: []string{"run", "dog"},
+		},
+		{
+			name:     "keeps digits",
+			text:     "room 101",
+			expected: []string{"room", "101"},
+		},
+		{
+			name:     "empty",
+			text:     "",
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, tok := range tokenize(tt.text) {
+				got = append(got, tok.term)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("tokenize(%q) = %v, expected %v", tt.text, got, tt.expected)
+			}
+		})
+	}
+}
+
+func newSearchDB(t *testing.T) *Database {
+	t.Helper()
+	d := NewDatabase()
+	err := d.CreateTable(&Table{
+		Name: "notes",
+		Columns: []Column{
+			{Name: "title", Type: "string"},
+			{Name: "body", Type: "string"},
+			{Name: "stars", Type: "number"},
+		},
+		SearchColumns: []string{"title", "body"},
+	})
+	if err != nil {
+		t.Fatalf("CreateTable failed: %v", err)
+	}
+	return d
+}
+
+func TestSearch(t *testing.T) {
+	d := newSearchDB(t)
+	records := []map[string]any{
+		{"title": "Gardening tips", "body": "Water the plants early"},
+		{"title": "Cooking", "body": "Boil water, add pasta"},
+		{"title": "Watering schedule", "body": "Water daily; water twice in summer"},
+	}
+	for _, r := range records {
+		if _, err := d.InsertRecord("notes", r); err != nil {
+			t.Fatalf("InsertRecord failed: %v", err)
+		}
+	}
+
+	t.Run("ranks by relevance", func(t *testing.T) {
+		results, err := d.Search("notes", "watering", 0)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if len(results) != 3 {
+			t.Fatalf("expected 3 results, got %d", len(results))
+		}
+		if results[0].Record["id"] != 3 {
+			t.Errorf("expected record 3 first, got %v", results[0].Record["id"])
+		}
+	})
+
+	t.Run("highlights matches", func(t *testing.T) {
+		results, err := d.Search("notes", "pasta", 0)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if len(results) != 1 {
+			t.Fatalf("expected 1 result, got %d", len(results))
+		}
+		expected := "Boil water, add <mark>pasta</mark>"
+		if got := results[0].Highlights["body"]; got != expected {
+			t.Errorf("expected highlight %q, got %q", expected, got)
+		}
+	})
+
+	t.Run("escapes highlights", func(t *testing.T) {
+		id, err := d.InsertRecord("notes", map[string]any{"title": "Script", "body": `<script>alert("x")</script> & gnocchi`})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer d.DeleteRecord("notes", id)
+		results, err := d.Search("notes", "gnocchi", 0)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if len(results) != 1 {
+			t.Fatalf("expected 1 result, got %d", len(results))
+		}
+		expected := "&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt; &amp; <mark>gnocchi</mark>"
+		if got := results[0].Highlights["body"]; got != expected {
+			t.Errorf("expected highlight %q, got %q", expected, got)
+		}
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		results, err := d.Search("notes", "water", 1)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if len(results) != 1 {
+			t.Errorf("expected 1 result, got %d", len(results))
+		}
+	})
+
+	t.Run("follows updates and deletes", func(t *testing.T) {
+		if _, err := d.UpdateRecord("notes", map[string]any{"id": float64(2), "body": "Boil rice"}); err != nil {
+			t.Fatalf("UpdateRecord failed: %v", err)
+		}
+		if err := d.DeleteRecord("notes", float64(1)); err != nil {
+			t.Fatalf("DeleteRecord failed: %v", err)
+		}
+		results, err := d.Search("notes", "water", 0)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if len(results) != 1 || results[0].Record["id"] != 3 {
+			t.Errorf("expected only record 3, got %v", results)
+		}
+	})
+}
+
+func TestSearchErrors(t *testing.T) {
+	d := newSearchDB(t)
+	if err := d.CreateTable(&Table{Name: "plain", Columns: []Column{{Name: "n", Type: "number"}}}); err != nil {
+		t.Fatalf("CreateTable failed: %v", err)
+	}
+
+	if _, err := d.Search("missing", "x", 0); err == nil {
+		t.Error("expected error for missing table")
+	}
+	if _, err := d.Search("plain", "x", 0); !errors.Is(err, ErrNoSearchColumns) {
+		t.Errorf("table without search columns: error = %v, want ErrNoSearchColumns", err)
+	}
+
+	err := d.CreateTable(&Table{
+		Name:          "bad",
+		Columns:       []Column{{Name: "n", Type: "number"}},
+		SearchColumns: []string{"n"},
+	})
+	if err == nil {
+		t.Error("expected error for non-string search column")
+	}
+}
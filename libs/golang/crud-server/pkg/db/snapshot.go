@@ -0,0 +1,185 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// TableSnapshot is the full state of one table
+type TableSnapshot struct {
+	Table   Table            `json:"table"`
+	Records []map[string]any `json:"records"`
+	NextID  int              `json:"next_id"`
+}
+
+// Snapshot is a point-in-time copy of every table in a Database
+type Snapshot struct {
+	CreatedAt time.Time       `json:"created_at"`
+	Tables    []TableSnapshot `json:"tables"`
+}
+
+// Snapshot copies every table while holding the read lock, so the result is
+// consistent across tables. Writers are only blocked for the in-memory copy;
+// encoding and compressing the snapshot happen after the lock is released.
+func (db *Database) Snapshot() *Snapshot {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.snapshot()
+}
+
+// snapshot copies every table. It is called with db.mu held.
+func (db *Database) snapshot() *Snapshot {
+	snap := &Snapshot{
+		CreatedAt: time.Now().UTC(),
+		Tables:    make([]TableSnapshot, 0, len(db.tables)),
+	}
+	for _, t := range db.tables {
+		records := make([]map[string]any, len(t.records))
+		for i, r := range t.records {
+			records[i] = copyRecord(r)
+		}
+		table := *t.table
+		table.Columns = append([]Column(nil), table.Columns...)
+		table.SearchColumns = append([]string(nil), table.SearchColumns...)
+		snap.Tables = append(snap.Tables, TableSnapshot{
+			Table:   table,
+			Records: records,
+			NextID:  t.nextID,
+		})
+	}
+
+	sort.Slice(snap.Tables, func(i, j int) bool {
+		return snap.Tables[i].Table.Name < snap.Tables[j].Table.Name
+	})
+	return snap
+}
+
+// ConflictStrategy decides what RestoreTable does when the table already
+// exists
+type ConflictStrategy string
+
+const (
+	// ConflictFail refuses to touch an existing table
+	ConflictFail ConflictStrategy = "fail"
+	// ConflictSkip leaves an existing table as it is
+	ConflictSkip ConflictStrategy = "skip"
+	// ConflictReplace drops an existing table and restores the snapshot
+	ConflictReplace ConflictStrategy = "replace"
+	// ConflictMerge keeps an existing table, overwriting records with the
+	// same id and adding the rest
+	ConflictMerge ConflictStrategy = "merge"
+)
+
+// ParseConflictStrategy validates a strategy name. An empty name means
+// ConflictFail.
+func ParseConflictStrategy(name string) (ConflictStrategy, error) {
+	switch s := ConflictStrategy(name); s {
+	case "":
+		return ConflictFail, nil
+	case ConflictFail, ConflictSkip, ConflictReplace, ConflictMerge:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown conflict strategy %q (expected fail, skip, replace or merge)", name)
+}
+
+// RestoreResult describes what RestoreTable did with one table
+type RestoreResult struct {
+	Table   string `json:"table"`
+	Action  string `json:"action"` // created, skipped, replaced or merged
+	Records int    `json:"records"`
+}
+
+// RestoreTable loads a table snapshot, keeping record ids as they were
+func (db *Database) RestoreTable(snap TableSnapshot, strategy ConflictStrategy) (RestoreResult, error) {
+	result := RestoreResult{Table: snap.Table.Name}
+
+	records, maxID, err := snapshotRecords(snap)
+	if err != nil {
+		return result, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	existing, exists := db.tables[snap.Table.Name]
+	if exists {
+		switch strategy {
+		case ConflictSkip:
+			result.Action = "skipped"
+			return result, nil
+		case ConflictReplace:
+			result.Action = "replaced"
+		case ConflictMerge:
+			existing.nextID = max(existing.nextID, snap.NextID, maxID+1)
+			for _, r := range records {
+				if i := existing.find(r["id"]); i >= 0 {
+					existing.records[i] = r
+					db.publish(OpUpdate, snap.Table.Name, r)
+				} else {
+					existing.records = append(existing.records, r)
+					db.publish(OpInsert, snap.Table.Name, r)
+				}
+				if existing.index != nil {
+					existing.index.add(r["id"].(int), r)
+				}
+			}
+			result.Action = "merged"
+			result.Records = len(records)
+			return result, nil
+		default:
+			return result, fmt.Errorf("table %s already exists", snap.Table.Name)
+		}
+	} else {
+		result.Action = "created"
+	}
+
+	data, err := newTableDataFrom(snap.Table, records, max(snap.NextID, maxID+1))
+	if err != nil {
+		return result, err
+	}
+
+	if exists {
+		db.publish(OpDeleteTable, snap.Table.Name, nil)
+	}
+	db.tables[snap.Table.Name] = data
+	db.schemaVersion.Add(1)
+	db.publish(OpCreateTable, snap.Table.Name, nil)
+	result.Records = len(records)
+	return result, nil
+}
+
+// snapshotRecords copies a snapshot's records with their ids normalized to
+// int and returns the largest id
+func snapshotRecords(snap TableSnapshot) ([]map[string]any, int, error) {
+	records := make([]map[string]any, 0, len(snap.Records))
+	maxID := 0
+	for _, r := range snap.Records {
+		id, ok := recordID(r["id"])
+		if !ok {
+			return nil, 0, fmt.Errorf("table %s: record without a valid id", snap.Table.Name)
+		}
+		record := copyRecord(r)
+		record["id"] = id
+		records = append(records, record)
+		maxID = max(maxID, id)
+	}
+	return records, maxID, nil
+}
+
+// newTableDataFrom builds a table's storage from a definition and records
+// whose ids are already normalized
+func newTableDataFrom(table Table, records []map[string]any, nextID int) (*tableData, error) {
+	data, err := newTableData(&table)
+	if err != nil {
+		return nil, err
+	}
+	data.records = records
+	data.nextID = nextID
+	if data.index != nil {
+		for _, r := range records {
+			data.index.add(r["id"].(int), r)
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,195 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// TableStats summarizes a table's records, their expiry, its search index
+// and recent changes
+type TableStats struct {
+	Table string `json:"table"`
+	// Records counts the records visible to reads
+	Records int `json:"records"`
+	// Expiring counts the visible records that have an expiry time
+	Expiring int `json:"expiring"`
+	// SoftDeleted counts records soft-deleted by expiry
+	SoftDeleted int `json:"soft_deleted"`
+	// Expired counts the records expired since the table was created or
+	// restored
+	Expired    uint64     `json:"expired"`
+	TTL        string     `json:"ttl,omitempty"`
+	ExpireMode string     `json:"expire_mode,omitempty"`
+	NextExpiry *time.Time `json:"next_expiry,omitempty"`
+	// LastSweep is when the database last looked for expired records
+	LastSweep *time.Time `json:"last_sweep,omitempty"`
+
+	// ApproxBytes estimates the memory held by the table's records,
+	// including soft-deleted ones
+	ApproxBytes int64 `json:"approx_bytes"`
+	// IndexTerms and IndexPostings measure the full-text index: distinct
+	// terms and (term, record) pairs
+	IndexTerms    int `json:"index_terms,omitempty"`
+	IndexPostings int `json:"index_postings,omitempty"`
+	// Mutations counts changes by kind since the server started
+	Mutations map[ChangeOp]uint64 `json:"mutations"`
+	// MutationsLastMinute counts the changes made in the last 60 seconds
+	MutationsLastMinute uint64 `json:"mutations_last_minute"`
+	// LastModified is when the table or one of its records last changed
+	LastModified *time.Time `json:"last_modified,omitempty"`
+}
+
+// Stats summarizes the whole database
+type Stats struct {
+	Tables      []TableStats `json:"tables"`
+	Records     int          `json:"records"`
+	ApproxBytes int64        `json:"approx_bytes"`
+	// Storage is nil unless the database is persisted
+	Storage *StorageStats `json:"storage,omitempty"`
+}
+
+// TableStats returns statistics for one table
+func (db *Database) TableStats(name string) (*TableStats, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	t, exists := db.tables[name]
+	if !exists {
+		return nil, fmt.Errorf("table %s not found", name)
+	}
+	return db.tableStats(name, t, time.Now()), nil
+}
+
+// Stats returns statistics for every table, ordered by name, and for the
+// storage
+func (db *Database) Stats() *Stats {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	now := time.Now()
+	stats := &Stats{Tables: make([]TableStats, 0, len(db.tables))}
+	for name, t := range db.tables {
+		ts := db.tableStats(name, t, now)
+		stats.Tables = append(stats.Tables, *ts)
+		stats.Records += ts.Records
+		stats.ApproxBytes += ts.ApproxBytes
+	}
+	sort.Slice(stats.Tables, func(i, j int) bool {
+		return stats.Tables[i].Table < stats.Tables[j].Table
+	})
+	if db.journal != nil {
+		stats.Storage = db.journal.stats()
+	}
+	return stats
+}
+
+// tableStats is called with db.mu held
+func (db *Database) tableStats(name string, t *tableData, now time.Time) *TableStats {
+	stats := &TableStats{
+		Table:               name,
+		Expired:             t.expired,
+		TTL:                 t.table.TTL,
+		ExpireMode:          t.table.ExpireMode,
+		Mutations:           make(map[ChangeOp]uint64, len(t.mutations)),
+		MutationsLastMinute: t.recent.total(now),
+	}
+	for _, r := range t.records {
+		stats.ApproxBytes += approxSize(r)
+		if !live(r) {
+			stats.SoftDeleted++
+			continue
+		}
+		stats.Records++
+		if at, ok := expiresAt(r); ok {
+			stats.Expiring++
+			if stats.NextExpiry == nil || at.Before(*stats.NextExpiry) {
+				stats.NextExpiry = &at
+			}
+		}
+	}
+	if t.index != nil {
+		stats.IndexTerms = len(t.index.postings)
+		for _, ids := range t.index.postings {
+			stats.IndexPostings += len(ids)
+		}
+	}
+	for op, n := range t.mutations {
+		stats.Mutations[op] = n
+	}
+	if !t.modified.IsZero() {
+		modified := t.modified.UTC()
+		stats.LastModified = &modified
+	}
+	if !db.lastSweep.IsZero() {
+		sweep := db.lastSweep.UTC()
+		stats.LastSweep = &sweep
+	}
+	return stats
+}
+
+// track counts a change to the table. It is called with db.mu held.
+func (t *tableData) track(op ChangeOp, now time.Time) {
+	if t.mutations == nil {
+		t.mutations = make(map[ChangeOp]uint64)
+	}
+	t.mutations[op]++
+	t.recent.add(now)
+	t.modified = now
+}
+
+// rateWindow counts events in one-second buckets over the last minute
+type rateWindow struct {
+	seconds [60]int64 // the unix second each bucket currently counts
+	counts  [60]uint64
+}
+
+func (w *rateWindow) add(now time.Time) {
+	sec := now.Unix()
+	i := sec % 60
+	if w.seconds[i] != sec {
+		w.seconds[i] = sec
+		w.counts[i] = 0
+	}
+	w.counts[i]++
+}
+
+// total returns the number of events in the 60 seconds up to now
+func (w *rateWindow) total(now time.Time) uint64 {
+	sec := now.Unix()
+	var n uint64
+	for i, s := range w.seconds {
+		if age := sec - s; age >= 0 && age < 60 {
+			n += w.counts[i]
+		}
+	}
+	return n
+}
+
+// approxSize estimates the bytes a decoded JSON value occupies on a 64-bit
+// platform, counting headers but not allocator overhead
+func approxSize(v any) int64 {
+	const (
+		ifaceSize  = 16
+		stringSize = 16
+		sliceSize  = 24
+		mapSize    = 48
+	)
+	switch v := v.(type) {
+	case string:
+		return stringSize + int64(len(v))
+	case map[string]any:
+		n := int64(mapSize)
+		for k, item := range v {
+			n += stringSize + int64(len(k)) + ifaceSize + approxSize(item)
+		}
+		return n
+	case []any:
+		n := int64(sliceSize)
+		for _, item := range v {
+			n += ifaceSize + approxSize(item)
+		}
+		return n
+	}
+	return 8
+}
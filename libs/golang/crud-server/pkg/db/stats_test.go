@@ -0,0 +1,70 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	database := NewDatabase()
+	if err := database.CreateTable(&Table{Name: "notes", Columns: []Column{{Name: "body", Type: "string"}}, SearchColumns: []string{"body"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.CreateTable(&Table{Name: "empty"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{"red fox", "red hen"} {
+		if _, err := database.InsertRecord("notes", map[string]any{"body": body}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := database.UpdateRecord("notes", map[string]any{"id": 2, "body": "blue hen"}); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := database.TableStats("notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Records != 2 || stats.IndexTerms != 4 || stats.IndexPostings != 4 {
+		t.Errorf("counts = %+v", stats)
+	}
+	if stats.Mutations[OpCreateTable] != 1 || stats.Mutations[OpInsert] != 2 || stats.Mutations[OpUpdate] != 1 || stats.MutationsLastMinute != 4 {
+		t.Errorf("mutations = %v, last minute %d", stats.Mutations, stats.MutationsLastMinute)
+	}
+	if stats.LastModified == nil || time.Since(*stats.LastModified) > time.Minute {
+		t.Errorf("last modified = %v", stats.LastModified)
+	}
+	if want := approxSize(map[string]any{"id": 1, "body": "red fox"}) + approxSize(map[string]any{"id": 2, "body": "blue hen"}); stats.ApproxBytes != want {
+		t.Errorf("approx bytes = %d, want %d", stats.ApproxBytes, want)
+	}
+
+	all := database.Stats()
+	if len(all.Tables) != 2 || all.Tables[0].Table != "empty" || all.Records != 2 || all.ApproxBytes != stats.ApproxBytes || all.Storage != nil {
+		t.Errorf("database stats = %+v", all)
+	}
+}
+
+func TestRateWindow(t *testing.T) {
+	var w rateWindow
+	start := time.Unix(1000, 0)
+	for i := 0; i < 90; i++ {
+		w.add(start.Add(time.Duration(i) * time.Second))
+	}
+	w.add(start.Add(89 * time.Second))
+
+	tests := []struct {
+		at   time.Duration
+		want uint64
+	}{
+		{89 * time.Second, 61},
+		{100 * time.Second, 50},
+		{148 * time.Second, 2},
+		{149 * time.Second, 0},
+	}
+	for _, tt := range tests {
+		if got := w.total(start.Add(tt.at)); got != tt.want {
+			t.Errorf("total at +%v = %d, want %d", tt.at, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Upsert actions
+const (
+	UpsertCreated   = "created"
+	UpsertUpdated   = "updated"
+	UpsertUnchanged = "unchanged"
+)
+
+// UpsertResult describes what UpsertRecord did, or would do in a dry run
+type UpsertResult struct {
+	// ID is zero when a dry run would create the record
+	ID     int            `json:"id"`
+	Action string         `json:"action"`
+	Record map[string]any `json:"record"`
+	// Previous is the stored record before an update
+	Previous map[string]any `json:"previous,omitempty"`
+}
+
+// UpsertRecord merges record into the live record whose key columns equal
+// the record's, or inserts it when there is none, so applying the same
+// record twice leaves one copy. Without key columns every field of the
+// record except id and the reserved underscore fields is the key. With
+// dryRun nothing is written.
+func (db *Database) UpsertRecord(tableName string, key []string, record map[string]any, dryRun bool) (UpsertResult, error) {
+	return db.upsertRecord(unrestricted, tableName, key, record, dryRun)
+}
+
+func (db *Database) upsertRecord(subject Subject, tableName string, key []string, record map[string]any, dryRun bool) (UpsertResult, error) {
+	changes := copyRecord(record)
+	delete(changes, "id")
+
+	if len(key) == 0 {
+		for k := range changes {
+			if !strings.HasPrefix(k, "_") {
+				key = append(key, k)
+			}
+		}
+		sort.Strings(key)
+		if len(key) == 0 {
+			return UpsertResult{}, errors.New("upsert needs key columns or a record with fields")
+		}
+	}
+	for _, col := range key {
+		if _, ok := changes[col]; !ok {
+			return UpsertResult{}, fmt.Errorf("upsert key column %s is missing from the record", col)
+		}
+	}
+
+	if dryRun {
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+	} else {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+	}
+
+	t, exists := db.tables[tableName]
+	if !exists {
+		return UpsertResult{}, fmt.Errorf("table %s not found", tableName)
+	}
+
+	match := -1
+	for i, r := range t.records {
+		if !live(r) || !keyMatches(r, changes, key) || !t.canRead(subject, r) {
+			continue
+		}
+		if match >= 0 {
+			return UpsertResult{}, fmt.Errorf("upsert key (%s) matches more than one record", strings.Join(key, ", "))
+		}
+		match = i
+	}
+
+	now := time.Now()
+	if match < 0 {
+		if err := t.stampOwner(subject, changes); err != nil {
+			return UpsertResult{}, err
+		}
+		if err := t.setExpiry(changes, now, true); err != nil {
+			return UpsertResult{}, err
+		}
+		if dryRun {
+			return UpsertResult{Action: UpsertCreated, Record: changes}, nil
+		}
+		id := db.insert(tableName, t, changes)
+		return UpsertResult{ID: id, Action: UpsertCreated, Record: copyRecord(changes)}, nil
+	}
+
+	existing := t.records[match]
+	if !t.owns(subject, existing) {
+		return UpsertResult{}, fmt.Errorf("%w: the record matching the upsert key belongs to someone else", ErrForbidden)
+	}
+	if err := t.checkChanges(subject, changes); err != nil {
+		return UpsertResult{}, err
+	}
+	if err := t.setExpiry(changes, now, false); err != nil {
+		return UpsertResult{}, err
+	}
+	id, _ := recordID(existing["id"])
+	result := UpsertResult{ID: id, Action: UpsertUnchanged, Record: copyRecord(existing)}
+	for k, v := range changes {
+		current, ok := existing[k]
+		if k == FieldExpiresAt && v == nil {
+			if ok {
+				result.Action = UpsertUpdated
+			}
+			continue
+		}
+		if !ok || !valuesEqual(current, v) {
+			result.Action = UpsertUpdated
+		}
+	}
+	if result.Action == UpsertUnchanged {
+		return result, nil
+	}
+
+	result.Previous = result.Record
+	if dryRun {
+		merged := copyRecord(existing)
+		for k, v := range changes {
+			if k == FieldExpiresAt && v == nil {
+				delete(merged, k)
+			} else {
+				merged[k] = v
+			}
+		}
+		result.Record = merged
+		return result, nil
+	}
+	db.merge(tableName, t, match, changes)
+	result.Record = copyRecord(existing)
+	return result, nil
+}
+
+// keyMatches reports whether a stored record has the given values in every
+// key column
+func keyMatches(stored, record map[string]any, key []string) bool {
+	for _, col := range key {
+		v, ok := stored[col]
+		if !ok || !valuesEqual(v, record[col]) {
+			return false
+		}
+	}
+	return true
+}
+
+// valuesEqual compares values the way grouping does, so 1 equals 1.0
+func valuesEqual(a, b any) bool {
+	return groupValue(a) == groupValue(b)
+}
+
+// TruncateTable deletes every record of a table, including soft-deleted
+// ones, and returns how many it removed. Ids are not reused afterwards.
+func (db *Database) TruncateTable(name string) (int, error) {
+	return db.truncateTable(unrestricted, name)
+}
+
+func (db *Database) truncateTable(subject Subject, name string) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	t, exists := db.tables[name]
+	if !exists {
+		return 0, fmt.Errorf("table %s not found", name)
+	}
+
+	if !subject.Admin && t.table.Policy != nil {
+		return db.deleteOwned(subject, name, t), nil
+	}
+
+	// Soft-deleted records are journaled too, or replay would bring them
+	// back
+	n := len(t.records)
+	for _, r := range t.records {
+		db.publish(OpDelete, name, r)
+	}
+	t.records = []map[string]any{}
+	if t.index != nil {
+		t.index = newSearchIndex(t.table.SearchColumns)
+	}
+	return n, nil
+}
+
+// deleteOwned removes the records of a table that subject owns. It is
+// called with db.mu held.
+func (db *Database) deleteOwned(subject Subject, name string, t *tableData) int {
+	kept := t.records[:0]
+	n := 0
+	for _, r := range t.records {
+		if !t.owns(subject, r) {
+			kept = append(kept, r)
+			continue
+		}
+		db.publish(OpDelete, name, r)
+		if t.index != nil {
+			id, _ := recordID(r["id"])
+			t.index.remove(id)
+		}
+		n++
+	}
+	clear(t.records[len(kept):])
+	t.records = kept
+	return n
+}
@@ -0,0 +1,116 @@
+package db
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUpsertRecord(t *testing.T) {
+	database := NewDatabase()
+	if err := database.CreateTable(&Table{Name: "users"}); err != nil {
+		t.Fatal(err)
+	}
+	events, cancel := database.Subscribe(16)
+	defer cancel()
+
+	key := []string{"email"}
+	steps := []struct {
+		name   string
+		key    []string
+		record map[string]any
+		dryRun bool
+		action string
+		id     int
+		err    string
+	}{
+		{"dry run create", key, map[string]any{"email": "ann@example.com", "age": 30.0}, true, UpsertCreated, 0, ""},
+		{"create", key, map[string]any{"email": "ann@example.com", "age": 30.0}, false, UpsertCreated, 1, ""},
+		{"same again", key, map[string]any{"email": "ann@example.com", "age": 30}, false, UpsertUnchanged, 1, ""},
+		{"dry run update", key, map[string]any{"email": "ann@example.com", "age": 31.0}, true, UpsertUpdated, 1, ""},
+		{"update", key, map[string]any{"email": "ann@example.com", "age": 31.0, "id": 99}, false, UpsertUpdated, 1, ""},
+		{"whole record key", nil, map[string]any{"email": "bob@example.com", "age": 20.0}, false, UpsertCreated, 2, ""},
+		{"whole record key again", nil, map[string]any{"email": "bob@example.com", "age": 20.0}, false, UpsertUnchanged, 2, ""},
+		{"missing key column", key, map[string]any{"age": 1.0}, false, "", 0, "missing from the record"},
+		{"empty record", nil, map[string]any{}, false, "", 0, "needs key columns"},
+	}
+
+	for i, step := range steps {
+		res, err := database.UpsertRecord("users", step.key, step.record, step.dryRun)
+		if step.err != "" {
+			if err == nil || !strings.Contains(err.Error(), step.err) {
+				t.Fatalf("step %d (%s): error = %v, want %q", i, step.name, err, step.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("step %d (%s): %v", i, step.name, err)
+		}
+		if res.Action != step.action || res.ID != step.id {
+			t.Errorf("step %d (%s): got %s of %d, want %s of %d", i, step.name, res.Action, res.ID, step.action, step.id)
+		}
+		if step.name == "update" && (res.Previous["age"] != 30.0 || res.Record["age"] != 31.0) {
+			t.Errorf("update result = %+v", res)
+		}
+		if step.name == "dry run update" && res.Record["age"] != 31.0 {
+			t.Errorf("dry run update result = %+v", res)
+		}
+	}
+
+	// Dry runs and unchanged upserts publish nothing
+	var ops []ChangeOp
+	for len(events) > 0 {
+		ops = append(ops, (<-events).Op)
+	}
+	want := []ChangeOp{OpInsert, OpUpdate, OpInsert}
+	if len(ops) != len(want) {
+		t.Fatalf("events = %v, want %v", ops, want)
+	}
+	for i := range want {
+		if ops[i] != want[i] {
+			t.Errorf("events = %v, want %v", ops, want)
+		}
+	}
+
+	if record, _ := database.GetRecord("users", 1); record["age"] != 31.0 {
+		t.Errorf("stored record = %v", record)
+	}
+
+	for _, email := range []string{"x1", "x2"} {
+		if _, err := database.InsertRecord("users", map[string]any{"email": email, "kind": "x"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := database.UpsertRecord("users", []string{"kind"}, map[string]any{"kind": "x"}, false)
+	if err == nil || !strings.Contains(err.Error(), "matches more than one record") {
+		t.Errorf("ambiguous key error = %v", err)
+	}
+}
+
+func TestTruncateTable(t *testing.T) {
+	database := NewDatabase()
+	if err := database.CreateTable(&Table{Name: "notes", Columns: []Column{{Name: "body", Type: "string"}}, SearchColumns: []string{"body"}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{"one", "two"} {
+		if _, err := database.InsertRecord("notes", map[string]any{"body": body}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := database.TruncateTable("notes")
+	if err != nil || n != 2 {
+		t.Fatalf("TruncateTable = %d, %v", n, err)
+	}
+	if records, _ := database.GetRecords("notes"); len(records) != 0 {
+		t.Errorf("records left: %v", records)
+	}
+	if results, _ := database.Search("notes", "one", 0); len(results) != 0 {
+		t.Errorf("search still finds truncated records: %v", results)
+	}
+	if id, _ := database.InsertRecord("notes", map[string]any{"body": "three"}); id != 3 {
+		t.Errorf("id after truncate = %d, want 3", id)
+	}
+	if _, err := database.TruncateTable("missing"); err == nil {
+		t.Error("truncated a missing table")
+	}
+}
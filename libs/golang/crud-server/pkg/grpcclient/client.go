@@ -0,0 +1,359 @@
+// Package grpcclient is a Go client for the crud-server gRPC API.
+//
+// It talks gRPC over unencrypted HTTP/2 using only the standard library, so
+// it needs no generated code. Errors returned by calls are *Error values
+// that match pkg/client's ErrNotFound, ErrConflict and ErrValidation with
+// errors.Is.
+package grpcclient
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dae-go/crud-server/pkg/client"
+	"github.com/dae-go/crud-server/pkg/crudpb"
+	"github.com/dae-go/crud-server/pkg/db"
+)
+
+// Client calls the Crud service of one server
+type Client struct {
+	baseURL   string
+	client    *http.Client
+	userAgent string
+	authToken string
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithTransport replaces the default unencrypted HTTP/2 transport, e.g. with
+// one that uses TLS
+func WithTransport(rt http.RoundTripper) Option {
+	return func(c *Client) {
+		c.client.Transport = rt
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent with every call
+func WithUserAgent(ua string) Option {
+	return func(c *Client) {
+		c.userAgent = ua
+	}
+}
+
+// WithAuthToken sends token as a bearer token with every call
+func WithAuthToken(token string) Option {
+	return func(c *Client) {
+		c.authToken = token
+	}
+}
+
+// NewClient creates a client for the server at addr, given as host:port or
+// as an http:// or https:// URL
+func NewClient(addr string, opts ...Option) *Client {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	transport := &http.Transport{Protocols: new(http.Protocols)}
+	transport.Protocols.SetUnencryptedHTTP2(true)
+	transport.Protocols.SetHTTP2(true)
+
+	c := &Client{
+		baseURL:   strings.TrimSuffix(addr, "/"),
+		client:    &http.Client{Transport: transport},
+		userAgent: "crud-server-grpcclient",
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Error is a non-OK gRPC status returned by the server
+type Error struct {
+	Code    crudpb.Code
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rpc error: code = %s desc = %s", e.Code, e.Message)
+}
+
+// Unwrap maps the status code onto pkg/client's sentinel errors
+func (e *Error) Unwrap() error {
+	switch e.Code {
+	case crudpb.NotFound:
+		return client.ErrNotFound
+	case crudpb.AlreadyExists:
+		return client.ErrConflict
+	case crudpb.InvalidArgument:
+		return client.ErrValidation
+	case crudpb.Unauthenticated:
+		return client.ErrUnauthorized
+	case crudpb.PermissionDenied:
+		return client.ErrForbidden
+	}
+	return nil
+}
+
+// stream is an in-progress call whose response messages are read one at a
+// time
+type stream struct {
+	resp *http.Response
+	err  error
+}
+
+// start sends a request message and waits for the response headers
+func (c *Client) start(ctx context.Context, method string, req crudpb.Message) (*stream, error) {
+	var body bytes.Buffer
+	if err := crudpb.WriteFrame(&body, req); err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+method, &body)
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/grpc")
+	httpReq.Header.Set("Te", "trailers")
+	httpReq.Header.Set("User-Agent", c.userAgent)
+	if c.authToken != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.authToken)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		httpReq.Header.Set("Grpc-Timeout", crudpb.FormatTimeout(time.Until(deadline)))
+	}
+
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, &Error{Code: crudpb.Unavailable, Message: fmt.Sprintf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))}
+	}
+	// A trailers-only response carries the status in the headers
+	if err := statusFrom(resp.Header); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return &stream{resp: resp}, nil
+}
+
+// recv reads the next response message into m. It returns io.EOF once the
+// call completed successfully.
+func (s *stream) recv(m crudpb.Message) error {
+	if s.err != nil {
+		return s.err
+	}
+	data, err := crudpb.ReadFrame(s.resp.Body)
+	if err == io.EOF {
+		// The status arrives in the trailers after the last message
+		s.err = statusFrom(s.resp.Trailer)
+		if s.err == nil {
+			s.err = io.EOF
+		}
+		s.resp.Body.Close()
+		return s.err
+	}
+	if err != nil {
+		s.err = err
+		s.resp.Body.Close()
+		return err
+	}
+	if err := m.Unmarshal(data); err != nil {
+		s.err = err
+		s.resp.Body.Close()
+		return err
+	}
+	return nil
+}
+
+func (s *stream) close() error {
+	return s.resp.Body.Close()
+}
+
+// statusFrom returns the error described by a grpc-status header, or nil
+// for OK or a missing status
+func statusFrom(h http.Header) error {
+	v := h.Get("Grpc-Status")
+	if v == "" || v == "0" {
+		return nil
+	}
+	code, err := strconv.ParseUint(v, 10, 32)
+	if err != nil {
+		return &Error{Code: crudpb.Unknown, Message: "invalid grpc-status " + v}
+	}
+	return &Error{Code: crudpb.Code(code), Message: crudpb.DecodeMessage(h.Get("Grpc-Message"))}
+}
+
+// unary runs a call with a single response message
+func (c *Client) unary(ctx context.Context, method string, req, resp crudpb.Message) error {
+	s, err := c.start(ctx, method, req)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+	if err := s.recv(resp); err != nil {
+		if err == io.EOF {
+			return &Error{Code: crudpb.Internal, Message: "server sent no response message"}
+		}
+		return err
+	}
+	// Drain to the trailers so a failure after the message is reported
+	var extra crudpb.DeleteRecordResponse
+	if err := s.recv(&extra); err != io.EOF {
+		if err == nil {
+			return &Error{Code: crudpb.Internal, Message: "server sent more than one response message"}
+		}
+		return err
+	}
+	return nil
+}
+
+// ListTables returns the names of all tables
+func (c *Client) ListTables(ctx context.Context) ([]string, error) {
+	var resp crudpb.ListTablesResponse
+	if err := c.unary(ctx, crudpb.MethodListTables, &crudpb.ListTablesRequest{}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Names, nil
+}
+
+// GetTable returns a table's definition
+func (c *Client) GetTable(ctx context.Context, name string) (*db.Table, error) {
+	var resp crudpb.Table
+	if err := c.unary(ctx, crudpb.MethodGetTable, &crudpb.GetTableRequest{Name: name}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.DB(), nil
+}
+
+// CreateTable creates a table
+func (c *Client) CreateTable(ctx context.Context, table *db.Table) error {
+	var resp crudpb.Table
+	return c.unary(ctx, crudpb.MethodCreateTable, &crudpb.CreateTableRequest{Table: crudpb.TableFromDB(table)}, &resp)
+}
+
+// DeleteTable deletes a table and its records
+func (c *Client) DeleteTable(ctx context.Context, name string) error {
+	var resp crudpb.DeleteTableResponse
+	return c.unary(ctx, crudpb.MethodDeleteTable, &crudpb.DeleteTableRequest{Name: name}, &resp)
+}
+
+// GetRecord returns one record. Its "id" is an int64.
+func (c *Client) GetRecord(ctx context.Context, table string, id int64) (map[string]any, error) {
+	var resp crudpb.Record
+	if err := c.unary(ctx, crudpb.MethodGetRecord, &crudpb.GetRecordRequest{Table: table, ID: id}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Map(), nil
+}
+
+// InsertRecord inserts a record and returns it with its generated id
+func (c *Client) InsertRecord(ctx context.Context, table string, record map[string]any) (map[string]any, error) {
+	fields := make(map[string]any, len(record))
+	for k, v := range record {
+		if k != "id" {
+			fields[k] = v
+		}
+	}
+	var resp crudpb.Record
+	if err := c.unary(ctx, crudpb.MethodInsertRecord, &crudpb.InsertRecordRequest{Table: table, Fields: fields}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Map(), nil
+}
+
+// UpdateRecord changes the given fields of a record and returns the result
+func (c *Client) UpdateRecord(ctx context.Context, table string, id int64, fields map[string]any) (map[string]any, error) {
+	var resp crudpb.Record
+	if err := c.unary(ctx, crudpb.MethodUpdateRecord, &crudpb.UpdateRecordRequest{Table: table, ID: id, Fields: fields}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Map(), nil
+}
+
+// DeleteRecord deletes a record
+func (c *Client) DeleteRecord(ctx context.Context, table string, id int64) error {
+	var resp crudpb.DeleteRecordResponse
+	return c.unary(ctx, crudpb.MethodDeleteRecord, &crudpb.DeleteRecordRequest{Table: table, ID: id}, &resp)
+}
+
+// ListOptions narrows ListRecords
+type ListOptions struct {
+	Where  []db.Filter
+	Offset int
+	// Limit of zero means no limit
+	Limit int
+}
+
+// RecordStream receives the records of a ListRecords call
+type RecordStream struct {
+	s *stream
+}
+
+// Recv returns the next record, or io.EOF after the last one
+func (rs *RecordStream) Recv() (map[string]any, error) {
+	var m crudpb.Record
+	if err := rs.s.recv(&m); err != nil {
+		return nil, err
+	}
+	return m.Map(), nil
+}
+
+// Close abandons the stream
+func (rs *RecordStream) Close() error {
+	return rs.s.close()
+}
+
+// ListRecords streams the records of a table that match opts
+func (c *Client) ListRecords(ctx context.Context, table string, opts ListOptions) (*RecordStream, error) {
+	req := &crudpb.ListRecordsRequest{Table: table, Offset: int32(opts.Offset), Limit: int32(opts.Limit)}
+	for _, f := range opts.Where {
+		req.Where = append(req.Where, f.String())
+	}
+	s, err := c.start(ctx, crudpb.MethodListRecords, req)
+	if err != nil {
+		return nil, err
+	}
+	return &RecordStream{s: s}, nil
+}
+
+// WatchStream receives the changes of a Watch call
+type WatchStream struct {
+	s *stream
+}
+
+// Recv blocks until the next change. It returns the context's error once
+// the watch's context is cancelled.
+func (ws *WatchStream) Recv() (db.ChangeEvent, error) {
+	var m crudpb.ChangeEvent
+	if err := ws.s.recv(&m); err != nil {
+		return db.ChangeEvent{}, err
+	}
+	return m.DB(), nil
+}
+
+// Close stops the watch
+func (ws *WatchStream) Close() error {
+	return ws.s.close()
+}
+
+// Watch streams changes to the given tables, or to every table when none
+// are given, until ctx is cancelled. Every change made after Watch returns
+// is delivered, unless the stream falls so far behind that the server
+// drops changes for it.
+func (c *Client) Watch(ctx context.Context, tables ...string) (*WatchStream, error) {
+	s, err := c.start(ctx, crudpb.MethodWatch, &crudpb.WatchRequest{Tables: tables})
+	if err != nil {
+		return nil, err
+	}
+	return &WatchStream{s: s}, nil
+}
@@ -0,0 +1,286 @@
+package grpcclient_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dae-go/crud-server/internal/grpcserver"
+	"github.com/dae-go/crud-server/pkg/client"
+	"github.com/dae-go/crud-server/pkg/crudpb"
+	"github.com/dae-go/crud-server/pkg/db"
+	"github.com/dae-go/crud-server/pkg/grpcclient"
+	"github.com/dae-go/crud-server/pkg/server"
+)
+
+// newServer starts the gRPC service on an unencrypted HTTP/2 test server
+func newServer(t *testing.T) (*db.Database, *grpcclient.Client) {
+	t.Helper()
+	database, url := startServer(t)
+	return database, grpcclient.NewClient(url)
+}
+
+func startServer(t *testing.T, opts ...grpcserver.Option) (*db.Database, string) {
+	t.Helper()
+	database := db.NewDatabase()
+	srv := httptest.NewUnstartedServer(grpcserver.NewServer(database, opts...))
+	srv.Config.Protocols = new(http.Protocols)
+	srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return database, srv.URL
+}
+
+func TestCRUD(t *testing.T) {
+	_, c := newServer(t)
+	ctx := context.Background()
+
+	table := &db.Table{
+		Name:    "users",
+		Columns: []db.Column{{Name: "name", Type: "string"}, {Name: "age", Type: "number"}},
+	}
+	if err := c.CreateTable(ctx, table); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateTable(ctx, table); !errors.Is(err, client.ErrConflict) {
+		t.Errorf("duplicate CreateTable error = %v, want ErrConflict", err)
+	}
+
+	names, err := c.ListTables(ctx)
+	if err != nil || !reflect.DeepEqual(names, []string{"users"}) {
+		t.Fatalf("ListTables = %v, %v", names, err)
+	}
+	got, err := c.GetTable(ctx, "users")
+	if err != nil || !reflect.DeepEqual(got.Columns, table.Columns) {
+		t.Fatalf("GetTable = %+v, %v", got, err)
+	}
+
+	inserted, err := c.InsertRecord(ctx, "users", map[string]any{"name": "Ann", "age": 31.0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"id": int64(1), "name": "Ann", "age": 31.0}
+	if !reflect.DeepEqual(inserted, want) {
+		t.Errorf("InsertRecord = %v, want %v", inserted, want)
+	}
+
+	updated, err := c.UpdateRecord(ctx, "users", 1, map[string]any{"age": 32.0})
+	if err != nil || updated["age"] != 32.0 || updated["name"] != "Ann" {
+		t.Errorf("UpdateRecord = %v, %v", updated, err)
+	}
+	if record, err := c.GetRecord(ctx, "users", 1); err != nil || record["age"] != 32.0 {
+		t.Errorf("GetRecord = %v, %v", record, err)
+	}
+
+	if err := c.DeleteRecord(ctx, "users", 1); err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.GetRecord(ctx, "users", 1)
+	var rpcErr *grpcclient.Error
+	if !errors.As(err, &rpcErr) || rpcErr.Code != crudpb.NotFound || !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("GetRecord after delete error = %v, want NotFound", err)
+	}
+
+	if err := c.DeleteTable(ctx, "users"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetTable(ctx, "users"); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("GetTable after delete error = %v", err)
+	}
+}
+
+func TestListRecords(t *testing.T) {
+	database, c := newServer(t)
+	if err := database.CreateTable(&db.Table{Name: "nums", Columns: []db.Column{{Name: "n", Type: "number"}}}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 10; i++ {
+		if _, err := database.InsertRecord("nums", map[string]any{"n": float64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		opts grpcclient.ListOptions
+		want []float64
+	}{
+		{"all", grpcclient.ListOptions{}, []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}},
+		{"filtered", grpcclient.ListOptions{Where: []db.Filter{db.Where("n", db.OpGt, 7)}}, []float64{8, 9, 10}},
+		{"paged", grpcclient.ListOptions{Offset: 2, Limit: 3}, []float64{3, 4, 5}},
+		{"past the end", grpcclient.ListOptions{Offset: 20}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream, err := c.ListRecords(context.Background(), "nums", tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []float64
+			for {
+				record, err := stream.Recv()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, record["n"].(float64))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	stream, err := c.ListRecords(context.Background(), "missing", grpcclient.ListOptions{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("ListRecords on a missing table error = %v", err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	database, c := newServer(t)
+	if err := database.CreateTable(&db.Table{Name: "users", Columns: []db.Column{{Name: "name", Type: "string"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := c.Watch(ctx, "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := database.CreateTable(&db.Table{Name: "other"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.InsertRecord("users", map[string]any{"name": "Ann"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.DeleteRecord("users", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []db.ChangeOp{db.OpInsert, db.OpDelete} {
+		ev, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev.Op != want || ev.Table != "users" || ev.Record["name"] != "Ann" || ev.Record["id"] != int64(1) {
+			t.Errorf("event = %+v, want %s of Ann", ev, want)
+		}
+		if time.Since(ev.Time) > time.Minute {
+			t.Errorf("event time = %v", ev.Time)
+		}
+	}
+
+	cancel()
+	if _, err := stream.Recv(); err == nil {
+		t.Error("Recv after cancel succeeded")
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	database, url := startServer(t, grpcserver.WithAuthenticator(server.TokenAuthenticator(map[string]db.Subject{
+		"ann-token":  {ID: "ann"},
+		"bob-token":  {ID: "bob"},
+		"root-token": {ID: "root", Admin: true},
+	})))
+	err := database.CreateTable(&db.Table{
+		Name:    "notes",
+		Columns: []db.Column{{Name: "owner", Type: "string"}, {Name: "text", Type: "string"}},
+		Policy:  &db.RowPolicy{OwnerColumn: "owner"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	as := func(token string) *grpcclient.Client {
+		return grpcclient.NewClient(url, grpcclient.WithAuthToken(token))
+	}
+	ann, bob, root := as("ann-token"), as("bob-token"), as("root-token")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Ann's watch only sees her own notes
+	stream, err := ann.Watch(ctx, "notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bob.InsertRecord(ctx, "notes", map[string]any{"text": "bob's note"}); err != nil {
+		t.Fatal(err)
+	}
+	note, err := ann.InsertRecord(ctx, "notes", map[string]any{"text": "ann's note"})
+	if err != nil || note["owner"] != "ann" {
+		t.Fatalf("ann's InsertRecord = %v, %v", note, err)
+	}
+	if ev, err := stream.Recv(); err != nil || ev.Record["owner"] != "ann" {
+		t.Errorf("ann's watch got %+v, %v, want her own note", ev, err)
+	}
+
+	tests := []struct {
+		name string
+		call func() error
+		want error
+	}{
+		{"invalid token", func() error { _, err := as("wrong").ListTables(ctx); return err }, client.ErrUnauthorized},
+		{"table as a user", func() error {
+			return ann.CreateTable(ctx, &db.Table{Name: "x", Columns: []db.Column{{Name: "a", Type: "string"}}})
+		}, client.ErrForbidden},
+		{"table deleted by a user", func() error { return bob.DeleteTable(ctx, "notes") }, client.ErrForbidden},
+		{"someone else's record", func() error { _, err := ann.GetRecord(ctx, "notes", 1); return err }, client.ErrNotFound},
+		{"someone else's delete", func() error { return ann.DeleteRecord(ctx, "notes", 1) }, client.ErrNotFound},
+		{"anonymous insert", func() error {
+			_, err := grpcclient.NewClient(url).InsertRecord(ctx, "notes", map[string]any{"text": "anonymous"})
+			return err
+		}, client.ErrForbidden},
+		{"admin reads any record", func() error { _, err := root.GetRecord(ctx, "notes", 1); return err }, nil},
+	}
+	for _, tt := range tests {
+		if err := tt.call(); !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	records, err := bob.ListRecords(ctx, "notes", grpcclient.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var texts []string
+	for {
+		record, err := records.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		texts = append(texts, record["text"].(string))
+	}
+	if !reflect.DeepEqual(texts, []string{"bob's note"}) {
+		t.Errorf("bob lists %q", texts)
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	_, c := newServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	stream, err := c.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nothing changes, so the call ends when the deadline passes
+	if _, err := stream.Recv(); err == nil {
+		t.Error("Recv succeeded without any change")
+	}
+}
@@ -0,0 +1,92 @@
+package msgpack
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMarshal(t *testing.T) {
+	tests := []struct {
+		name string
+		in   any
+		want []byte
+	}{
+		{"nil", nil, []byte{0xc0}},
+		{"true", true, []byte{0xc3}},
+		{"positive fixint", 5, []byte{0x05}},
+		{"negative fixint", -1, []byte{0xff}},
+		{"uint8", 200, []byte{0xcc, 0xc8}},
+		{"int16", -300, []byte{0xd1, 0xfe, 0xd4}},
+		{"float64", 1.5, []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{"fixstr", "hi", []byte{0xa2, 'h', 'i'}},
+		{"bin", []byte{1, 2}, []byte{0xc4, 0x02, 1, 2}},
+		{"array", []any{1, "a"}, []byte{0x92, 0x01, 0xa1, 'a'}},
+		{"sorted map", map[string]any{"b": 2, "a": 1}, []byte{0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0x02}},
+		{"nil slice", []any(nil), []byte{0xc0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Marshal(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("got % x, want % x", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := Marshal(map[int]any{1: 1}); err == nil {
+		t.Error("encoded a map with int keys")
+	}
+	if _, err := Marshal(make(chan int)); err == nil {
+		t.Error("encoded a channel")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	long := strings.Repeat("x", 70000)
+	in := map[string]any{
+		"id":     int64(42),
+		"big":    int64(math.MaxInt64),
+		"huge":   uint64(math.MaxUint64),
+		"neg":    int64(math.MinInt64),
+		"ratio":  0.25,
+		"name":   "Ann",
+		"long":   long,
+		"active": false,
+		"none":   nil,
+		"tags":   []any{"a", int64(-5), []any{}},
+		"nested": map[string]any{"k": "v"},
+		"raw":    []byte("bytes"),
+	}
+	data, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, in) {
+		t.Errorf("round trip = %v", got)
+	}
+
+	for i := 1; i < len(data); i += 997 {
+		if _, err := Unmarshal(data[:i]); err == nil {
+			t.Fatalf("decoded %d truncated bytes", i)
+		}
+	}
+	if _, err := Unmarshal(append(data, 0xc0)); err == nil {
+		t.Error("decoded trailing data")
+	}
+	if _, err := Unmarshal([]byte{0x81, 0x01, 0x01}); err == nil {
+		t.Error("decoded a map with an int key")
+	}
+	if _, err := Unmarshal([]byte{0xdd, 0xff, 0xff, 0xff, 0xff}); err == nil {
+		t.Error("decoded an array longer than its data")
+	}
+}
@@ -0,0 +1,344 @@
+package seed
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"sort"
+	"strings"
+
+	"github.com/dae-go/crud-server/pkg/client"
+	"github.com/dae-go/crud-server/pkg/db"
+)
+
+// Options control how Apply seeds a server
+type Options struct {
+	// Clear truncates each table before seeding it
+	Clear bool
+	// DryRun reports what would change without writing anything
+	DryRun bool
+	// RandSeed seeds the fake data generator. The same seed generates the
+	// same records, so generated data is upserted rather than duplicated.
+	RandSeed uint64
+	// Out receives progress and, in dry runs, a diff of each change. Nil
+	// discards it.
+	Out io.Writer
+}
+
+// Summary counts what Apply did, or would do in a dry run
+type Summary struct {
+	Deleted   int `json:"deleted"`
+	Created   int `json:"created"`
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
+}
+
+func (s Summary) String() string {
+	text := fmt.Sprintf("%d created, %d updated, %d unchanged", s.Created, s.Updated, s.Unchanged)
+	if s.Deleted > 0 {
+		text = fmt.Sprintf("%d deleted, %s", s.Deleted, text)
+	}
+	return text
+}
+
+func (s *Summary) add(other Summary) {
+	s.Deleted += other.Deleted
+	s.Created += other.Created
+	s.Updated += other.Updated
+	s.Unchanged += other.Unchanged
+}
+
+type applier struct {
+	ctx  context.Context
+	c    *client.Client
+	opts Options
+	out  io.Writer
+	rng  *rand.Rand
+	// refs maps "table.label" to the labelled record's id, or to a
+	// placeholder for a record a dry run would create
+	refs map[string]any
+	// ids holds the ids of each table's seeded records, which generated
+	// reference columns pick from
+	ids map[string][]any
+}
+
+// Apply upserts the records of f, table by table in file order, and returns
+// the combined summary. It stops at the first error.
+func Apply(ctx context.Context, c *client.Client, f *File, opts Options) (Summary, error) {
+	a := &applier{
+		ctx:  ctx,
+		c:    c,
+		opts: opts,
+		out:  opts.Out,
+		rng:  rand.New(rand.NewPCG(opts.RandSeed, 0)),
+		refs: map[string]any{},
+		ids:  map[string][]any{},
+	}
+	if a.out == nil {
+		a.out = io.Discard
+	}
+
+	var total Summary
+	for _, s := range f.Seeds {
+		fmt.Fprintf(a.out, "Seeding table '%s'...\n", s.Table)
+		summary, err := a.seed(s)
+		total.add(summary)
+		if err != nil {
+			return total, fmt.Errorf("seeding table %s: %w", s.Table, err)
+		}
+		fmt.Fprintf(a.out, "  %s\n", summary)
+	}
+	return total, nil
+}
+
+func (a *applier) seed(s Seed) (Summary, error) {
+	var summary Summary
+	if a.opts.Clear {
+		n, err := a.clear(s.Table)
+		if err != nil {
+			return summary, err
+		}
+		summary.Deleted = n
+	}
+
+	for i, record := range s.Records {
+		if err := a.upsert(s, record, &summary); err != nil {
+			return summary, fmt.Errorf("record %d: %w", i+1, err)
+		}
+	}
+
+	if s.Generate == nil || s.Generate.Count == 0 {
+		return summary, nil
+	}
+	generated, err := a.generate(s)
+	if err != nil {
+		return summary, err
+	}
+	for i, record := range generated {
+		if err := a.upsert(s, record, &summary); err != nil {
+			return summary, fmt.Errorf("generated record %d: %w", i+1, err)
+		}
+	}
+	return summary, nil
+}
+
+// clear truncates a table, or in a dry run counts what truncating would
+// delete
+func (a *applier) clear(table string) (int, error) {
+	if !a.opts.DryRun {
+		return a.c.TruncateTableContext(a.ctx, table)
+	}
+
+	groups, err := a.c.AggregateContext(a.ctx, table, db.AggregateQuery{})
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	if len(groups) > 0 {
+		if count, ok := groups[0].Values[db.AggCount].(float64); ok {
+			n = int(count)
+		}
+	}
+	if n > 0 {
+		fmt.Fprintf(a.out, "  - %d existing records\n", n)
+	}
+	return n, nil
+}
+
+func (a *applier) upsert(s Seed, record map[string]any, summary *Summary) error {
+	label, _ := record[FieldLabel].(string)
+	fields := make(map[string]any, len(record))
+	for k, v := range record {
+		if k != FieldLabel {
+			fields[k] = a.resolve(v)
+		}
+	}
+
+	var result *db.UpsertResult
+	if a.opts.DryRun && a.opts.Clear {
+		// The table would be empty, so every record would be created
+		result = &db.UpsertResult{Action: db.UpsertCreated, Record: fields}
+	} else {
+		var err error
+		result, err = a.c.UpsertRecordContext(a.ctx, s.Table, s.Key, fields, a.opts.DryRun)
+		if err != nil {
+			return err
+		}
+	}
+
+	var id any = result.ID
+	if result.ID == 0 {
+		id = placeholder(s.Table, label)
+	}
+	if label != "" {
+		a.refs[s.Table+"."+label] = id
+	}
+	a.ids[s.Table] = append(a.ids[s.Table], id)
+
+	switch result.Action {
+	case db.UpsertCreated:
+		summary.Created++
+		if a.opts.DryRun {
+			fmt.Fprintf(a.out, "  + %s\n", formatValue(fields))
+		}
+	case db.UpsertUpdated:
+		summary.Updated++
+		if a.opts.DryRun {
+			for _, change := range diff(result.Previous, result.Record) {
+				fmt.Fprintf(a.out, "  ~ #%d %s\n", result.ID, change)
+			}
+		}
+	default:
+		summary.Unchanged++
+	}
+	return nil
+}
+
+// placeholder stands in for the id of a record a dry run would create
+func placeholder(table, label string) string {
+	if label == "" {
+		return fmt.Sprintf("<new %s record>", table)
+	}
+	return fmt.Sprintf("<new %s.%s>", table, label)
+}
+
+// resolve replaces references in v with the ids they point to. Validate has
+// already checked that every reference has a target.
+func (a *applier) resolve(v any) any {
+	if target, ok := asRef(v); ok {
+		return a.refs[target]
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		resolved := make(map[string]any, len(v))
+		for k, item := range v {
+			resolved[k] = a.resolve(item)
+		}
+		return resolved
+	case []any:
+		resolved := make([]any, len(v))
+		for i, item := range v {
+			resolved[i] = a.resolve(item)
+		}
+		return resolved
+	}
+	return v
+}
+
+// generate builds the fake records of a seed. Columns listed in Values pick
+// from their choices, reference columns pick from the referenced table's
+// ids, and other columns get a fake value for their type.
+func (a *applier) generate(s Seed) ([]map[string]any, error) {
+	table, err := a.c.GetTableContext(a.ctx, s.Table)
+	if err != nil {
+		return nil, err
+	}
+	columns := append([]db.Column(nil), table.Columns...)
+	extra := make([]string, 0, len(s.Generate.Values))
+	for name := range s.Generate.Values {
+		if !hasColumn(columns, name) {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+	for _, name := range extra {
+		columns = append(columns, db.Column{Name: name})
+	}
+	if len(columns) == 0 {
+		return nil, errors.New("cannot generate records for a table without columns; list some under generate.values")
+	}
+
+	records := make([]map[string]any, s.Generate.Count)
+	for n := range records {
+		record := make(map[string]any, len(columns))
+		for _, col := range columns {
+			if choices, ok := s.Generate.Values[col.Name]; ok {
+				record[col.Name] = choices[a.rng.IntN(len(choices))]
+				continue
+			}
+			if col.Ref != "" {
+				ids, err := a.tableIDs(col.Ref)
+				if err != nil {
+					return nil, err
+				}
+				if len(ids) > 0 {
+					record[col.Name] = ids[a.rng.IntN(len(ids))]
+				}
+				continue
+			}
+			record[col.Name] = fakeValue(a.rng, col, len(s.Records)+n+1)
+		}
+		records[n] = record
+	}
+	return records, nil
+}
+
+func hasColumn(columns []db.Column, name string) bool {
+	for _, col := range columns {
+		if col.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// tableIDs returns the ids seeded into a table during this run, or the ids
+// of its existing records when nothing has been seeded into it yet
+func (a *applier) tableIDs(table string) ([]any, error) {
+	if ids, ok := a.ids[table]; ok {
+		return ids, nil
+	}
+	records, err := a.c.GetRecordsContext(a.ctx, table)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]any, 0, len(records))
+	for _, r := range records {
+		ids = append(ids, r["id"])
+	}
+	a.ids[table] = ids
+	return ids, nil
+}
+
+// diff lists the fields that differ between two versions of a record
+func diff(before, after map[string]any) []string {
+	fields := make([]string, 0, len(after))
+	for k := range after {
+		fields = append(fields, k)
+	}
+	for k := range before {
+		if _, ok := after[k]; !ok {
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+
+	var changes []string
+	for _, k := range fields {
+		old, hadOld := before[k]
+		current, hasCurrent := after[k]
+		switch {
+		case !hadOld:
+			changes = append(changes, fmt.Sprintf("%s: (none) -> %s", k, formatValue(current)))
+		case !hasCurrent:
+			changes = append(changes, fmt.Sprintf("%s: %s -> (none)", k, formatValue(old)))
+		case formatValue(old) != formatValue(current):
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", k, formatValue(old), formatValue(current)))
+		}
+	}
+	return changes
+}
+
+// formatValue renders a value as compact JSON for the dry run diff
+func formatValue(v any) string {
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return fmt.Sprint(v)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,70 @@
+package seed
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/dae-go/crud-server/pkg/db"
+)
+
+var (
+	firstNames = []string{"Ada", "Alan", "Barbara", "Claude", "Dennis", "Edsger", "Frances", "Grace", "Hedy", "John", "Ken", "Linus", "Margaret", "Niklaus", "Radia", "Tim"}
+	lastNames  = []string{"Allen", "Berners-Lee", "Dijkstra", "Hamilton", "Hopper", "Lamarr", "Liskov", "Lovelace", "McCarthy", "Perlman", "Ritchie", "Shannon", "Thompson", "Torvalds", "Turing", "Wirth"}
+	cities     = []string{"Amsterdam", "Berlin", "Buenos Aires", "Cairo", "Lagos", "Lisbon", "Melbourne", "Montreal", "Mumbai", "Osaka", "Paris", "Seoul"}
+	words      = []string{"alpha", "bravo", "copper", "delta", "ember", "falcon", "granite", "harbor", "indigo", "juniper", "kestrel", "lumen", "meadow", "nova", "orbit", "prism", "quartz", "river", "summit", "tundra"}
+)
+
+// fakeBaseTime anchors generated dates so that runs with the same seed
+// produce the same records
+var fakeBaseTime = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// fakeValue generates a value for a column from its type and, for strings
+// and ints, from hints in its name. n is the record's position among the
+// generated records and keeps values such as emails unique.
+func fakeValue(rng *rand.Rand, col db.Column, n int) any {
+	name := strings.ToLower(col.Name)
+	switch col.Type {
+	case "int", "integer":
+		switch {
+		case strings.Contains(name, "age"):
+			return 18 + rng.IntN(70)
+		case strings.Contains(name, "year"):
+			return 1990 + rng.IntN(35)
+		}
+		return rng.IntN(1000)
+	case "number", "float":
+		return math.Round(rng.Float64()*100000) / 100
+	case "bool", "boolean":
+		return rng.IntN(2) == 0
+	}
+
+	first := firstNames[rng.IntN(len(firstNames))]
+	last := lastNames[rng.IntN(len(lastNames))]
+	switch {
+	case strings.Contains(name, "email"):
+		return fmt.Sprintf("%s.%s%d@example.com", strings.ToLower(first), strings.ToLower(last), n)
+	case strings.Contains(name, "first"):
+		return first
+	case strings.Contains(name, "last"):
+		return last
+	case strings.Contains(name, "name"):
+		return first + " " + last
+	case strings.Contains(name, "city"):
+		return cities[rng.IntN(len(cities))]
+	case strings.Contains(name, "phone"):
+		return fmt.Sprintf("+1-555-%04d", rng.IntN(10000))
+	case strings.Contains(name, "url") || strings.Contains(name, "website"):
+		return fmt.Sprintf("https://%s.example.com/%d", words[rng.IntN(len(words))], n)
+	case strings.HasSuffix(name, "_at") || strings.Contains(name, "date") || strings.Contains(name, "time"):
+		return fakeBaseTime.Add(time.Duration(rng.IntN(365*24*60)) * time.Minute).Format(time.RFC3339)
+	}
+	count := 2 + rng.IntN(4)
+	picked := make([]string, count)
+	for i := range picked {
+		picked[i] = words[rng.IntN(len(words))]
+	}
+	return strings.Join(picked, " ")
+}
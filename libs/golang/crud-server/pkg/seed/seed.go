@@ -0,0 +1,211 @@
+// Package seed applies declarative seed files to a crud-server.
+//
+// A seed file lists tables and the records they should contain:
+//
+//	seeds:
+//	  - table: users
+//	    key: email
+//	    records:
+//	      - {_label: ann, name: Ann, email: ann@example.com}
+//	  - table: orders
+//	    key: [user_id, number]
+//	    records:
+//	      - {user_id: {$ref: users.ann}, number: 1, total: 20}
+//	    generate: {count: 100}
+//
+// Records are upserted by their key columns, so applying a file twice
+// leaves a single copy of each record. A record's _label names it for
+// {"$ref": "table.label"} values in later records, which resolve to its id.
+// generate adds fake records built from the table's column types.
+package seed
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Record fields with a meaning to the seeder
+const (
+	// FieldLabel names a record for references. It is not stored.
+	FieldLabel = "_label"
+	// RefKey is the only key of a reference object, {"$ref": "table.label"}
+	RefKey = "$ref"
+)
+
+// File is a parsed seed file
+type File struct {
+	Seeds []Seed `json:"seeds"`
+}
+
+// Seed describes the records of one table
+type Seed struct {
+	Table string `json:"table"`
+	// Key lists the columns that identify a record. When empty, every field
+	// of a record is its key.
+	Key     Key              `json:"key,omitempty"`
+	Records []map[string]any `json:"records,omitempty"`
+	// Generate adds fake records after Records
+	Generate *Generate `json:"generate,omitempty"`
+}
+
+// Key is a list of column names. In a seed file it may also be written as a
+// single string.
+type Key []string
+
+func (k *Key) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*k = Key{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return errors.New("key must be a column name or a list of column names")
+	}
+	*k = list
+	return nil
+}
+
+// Generate describes fake records for load testing
+type Generate struct {
+	Count int `json:"count"`
+	// Values restricts columns to a list of choices, which may include
+	// references
+	Values map[string][]any `json:"values,omitempty"`
+}
+
+// Load reads a seed file, parsing it as YAML when the name ends in .yaml or
+// .yml and as JSON otherwise
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return ParseYAML(data)
+	}
+	return ParseJSON(data)
+}
+
+// ParseJSON parses and validates a JSON seed file
+func ParseJSON(data []byte) (*File, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var f File
+	if err := dec.Decode(&f); err != nil {
+		return nil, err
+	}
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// ParseYAML parses and validates a YAML seed file. Only the YAML subset
+// described in yaml.go is supported.
+func ParseYAML(data []byte) (*File, error) {
+	doc, err := parseYAML(data)
+	if err != nil {
+		return nil, err
+	}
+	// Going through JSON keeps both formats on the same decoding rules
+	converted, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJSON(converted)
+}
+
+// Validate checks table names and labels, and that every reference points
+// to a record labelled earlier in the file
+func (f *File) Validate() error {
+	labels := map[string]bool{}
+	for i, s := range f.Seeds {
+		if s.Table == "" {
+			return fmt.Errorf("seed %d: table is required", i+1)
+		}
+		for j, record := range s.Records {
+			// A record may reference records before it but not itself
+			for col, v := range record {
+				if col == FieldLabel {
+					continue
+				}
+				if err := checkRefs(v, labels); err != nil {
+					return fmt.Errorf("%s record %d, column %s: %w", s.Table, j+1, col, err)
+				}
+			}
+			if raw, ok := record[FieldLabel]; ok {
+				label, ok := raw.(string)
+				if !ok || label == "" {
+					return fmt.Errorf("%s record %d: %s must be a non-empty string", s.Table, j+1, FieldLabel)
+				}
+				name := s.Table + "." + label
+				if labels[name] {
+					return fmt.Errorf("%s record %d: duplicate label %q", s.Table, j+1, label)
+				}
+				labels[name] = true
+			}
+		}
+		if g := s.Generate; g != nil {
+			if g.Count < 0 {
+				return fmt.Errorf("%s: generate count must not be negative", s.Table)
+			}
+			for col, choices := range g.Values {
+				if len(choices) == 0 {
+					return fmt.Errorf("%s: generate values for %s must not be empty", s.Table, col)
+				}
+				for _, v := range choices {
+					if err := checkRefs(v, labels); err != nil {
+						return fmt.Errorf("%s: generate values for %s: %w", s.Table, col, err)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// asRef returns the target of a reference object
+func asRef(v any) (string, bool) {
+	m, ok := v.(map[string]any)
+	if !ok || len(m) != 1 {
+		return "", false
+	}
+	target, ok := m[RefKey].(string)
+	return target, ok
+}
+
+// checkRefs checks the references in v against the labels defined so far
+func checkRefs(v any, labels map[string]bool) error {
+	if target, ok := asRef(v); ok {
+		table, label, found := strings.Cut(target, ".")
+		if !found || table == "" || label == "" {
+			return fmt.Errorf("reference %q must have the form table.label", target)
+		}
+		if !labels[target] {
+			return fmt.Errorf("reference %q does not match a record labelled earlier in the file", target)
+		}
+		return nil
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		for _, item := range v {
+			if err := checkRefs(item, labels); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, item := range v {
+			if err := checkRefs(item, labels); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
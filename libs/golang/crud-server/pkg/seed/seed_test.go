@@ -0,0 +1,145 @@
+package seed
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dae-go/crud-server/pkg/client"
+	"github.com/dae-go/crud-server/pkg/db"
+	"github.com/dae-go/crud-server/pkg/server"
+)
+
+const testSeedFile = `
+seeds:
+  - table: users
+    key: email
+    records:
+      - {_label: ann, name: Ann, email: ann@example.com, age: 30}
+      - _label: bob
+        name: Bob
+        email: bob@example.com
+        age: 41
+  - table: orders
+    key: [user_id, number]
+    records:
+      - {user_id: {$ref: users.ann}, number: 1, total: 20}
+      - {user_id: {$ref: users.bob}, number: 1, total: 35.5}
+    generate:
+      count: 5
+      values:
+        status: [open, shipped]
+`
+
+func newTestClient(t *testing.T) *client.Client {
+	t.Helper()
+	srv := httptest.NewServer(server.New())
+	t.Cleanup(srv.Close)
+
+	c := client.NewClient(srv.URL)
+	tables := []*db.Table{
+		{Name: "users", Columns: []db.Column{{Name: "name", Type: "string"}, {Name: "email", Type: "string"}, {Name: "age", Type: "int"}}},
+		{Name: "orders", Columns: []db.Column{{Name: "user_id", Type: "int", Ref: "users"}, {Name: "number", Type: "int"}, {Name: "total", Type: "number"}}},
+	}
+	for _, table := range tables {
+		if err := c.CreateTable(table); err != nil {
+			t.Fatalf("CreateTable failed: %v", err)
+		}
+	}
+	return c
+}
+
+func TestApply(t *testing.T) {
+	c := newTestClient(t)
+	f, err := ParseYAML([]byte(testSeedFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	var out bytes.Buffer
+	got, err := Apply(ctx, c, f, Options{DryRun: true, Out: &out})
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if want := (Summary{Created: 9}); got != want {
+		t.Errorf("dry run summary = %+v, want %+v", got, want)
+	}
+	if !strings.Contains(out.String(), `+ {"number":1,"total":20,"user_id":"<new users.ann>"}`) {
+		t.Errorf("dry run output does not show the placeholder reference:\n%s", out.String())
+	}
+	if records, _ := c.GetRecords("users"); len(records) != 0 {
+		t.Fatalf("dry run wrote records: %v", records)
+	}
+
+	if got, err = Apply(ctx, c, f, Options{}); err != nil {
+		t.Fatalf("first apply: %v", err)
+	}
+	if want := (Summary{Created: 9}); got != want {
+		t.Errorf("first apply summary = %+v, want %+v", got, want)
+	}
+	orders, err := c.FindRecords("orders", db.Where("number", db.OpEq, "1"), db.Where("total", db.OpEq, "35.5"))
+	if err != nil || len(orders) != 1 {
+		t.Fatalf("orders for bob = %v, %v", orders, err)
+	}
+	if bob, err := c.GetRecord("users", orders[0]["user_id"]); err != nil || bob["name"] != "Bob" {
+		t.Errorf("reference resolved to %v, %v", bob, err)
+	}
+
+	// Re-applying is idempotent, including the generated records
+	if got, err = Apply(ctx, c, f, Options{}); err != nil {
+		t.Fatalf("second apply: %v", err)
+	}
+	if want := (Summary{Unchanged: 9}); got != want {
+		t.Errorf("second apply summary = %+v, want %+v", got, want)
+	}
+
+	f.Seeds[0].Records[0]["age"] = 31
+	out.Reset()
+	if got, err = Apply(ctx, c, f, Options{DryRun: true, Out: &out}); err != nil {
+		t.Fatalf("dry run update: %v", err)
+	}
+	if want := (Summary{Updated: 1, Unchanged: 8}); got != want {
+		t.Errorf("dry run update summary = %+v, want %+v", got, want)
+	}
+	if !strings.Contains(out.String(), "~ #1 age: 30 -> 31") {
+		t.Errorf("dry run output does not show the change:\n%s", out.String())
+	}
+
+	if got, err = Apply(ctx, c, f, Options{Clear: true}); err != nil {
+		t.Fatalf("clear: %v", err)
+	}
+	if want := (Summary{Deleted: 9, Created: 9}); got != want {
+		t.Errorf("clear summary = %+v, want %+v", got, want)
+	}
+	if records, _ := c.GetRecords("orders"); len(records) != 7 {
+		t.Errorf("orders after clear = %d, want 7", len(records))
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"missing table", `{"seeds": [{"records": []}]}`, "table is required"},
+		{"unknown field", `{"seeds": [{"table": "a", "rows": []}]}`, "unknown field"},
+		{"bad key", `{"seeds": [{"table": "a", "key": 1}]}`, "key must be"},
+		{"forward reference", `{"seeds": [{"table": "a", "records": [{"x": {"$ref": "a.b"}}, {"_label": "b"}]}]}`, "labelled earlier"},
+		{"self reference", `{"seeds": [{"table": "a", "records": [{"_label": "b", "x": {"$ref": "a.b"}}]}]}`, "labelled earlier"},
+		{"malformed reference", `{"seeds": [{"table": "a", "records": [{"x": {"$ref": "ab"}}]}]}`, "table.label"},
+		{"duplicate label", `{"seeds": [{"table": "a", "records": [{"_label": "b"}, {"_label": "b"}]}]}`, "duplicate label"},
+		{"empty choices", `{"seeds": [{"table": "a", "generate": {"count": 1, "values": {"x": []}}}]}`, "must not be empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseJSON([]byte(tt.in))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
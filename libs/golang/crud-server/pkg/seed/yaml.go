@@ -0,0 +1,518 @@
+package seed
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The seed file YAML support covers the subset such files need: block
+// mappings and sequences, single-line flow collections, plain and quoted
+// scalars, literal (|) and folded (>) block scalars, and comments. Anchors,
+// aliases, tags, multi-line flow collections and multiple documents are
+// rejected. Numbers decode as float64, like encoding/json.
+
+type yamlLine struct {
+	num    int // 1-based, for error messages
+	indent int
+	raw    string // without the trailing newline
+	text   string // without indentation and comments; empty for blank lines
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+func yamlError(num int, format string, args ...any) error {
+	return fmt.Errorf("yaml: line %d: %s", num, fmt.Sprintf(format, args...))
+}
+
+// parseYAML decodes a YAML document into maps, slices and scalars
+func parseYAML(data []byte) (any, error) {
+	p := &yamlParser{}
+	for i, raw := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimLeft(raw, " ")
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, yamlError(i+1, "tabs are not allowed in indentation")
+		}
+		text := strings.TrimSpace(stripComment(trimmed))
+		if text == "---" || text == "..." {
+			if len(raw) != len(trimmed) {
+				return nil, yamlError(i+1, "document markers must not be indented")
+			}
+			if p.hasContent() && text == "---" {
+				return nil, yamlError(i+1, "multiple documents are not supported")
+			}
+			text = ""
+		}
+		p.lines = append(p.lines, yamlLine{num: i + 1, indent: len(raw) - len(trimmed), raw: raw, text: text})
+	}
+
+	if !p.skipBlank() {
+		return nil, nil
+	}
+	v, err := p.block(p.lines[p.pos].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.skipBlank() {
+		return nil, yamlError(p.lines[p.pos].num, "unexpected content %q", p.lines[p.pos].text)
+	}
+	return v, nil
+}
+
+func (p *yamlParser) hasContent() bool {
+	for _, l := range p.lines {
+		if l.text != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// skipBlank moves past blank and comment-only lines and reports whether a
+// line with content remains
+func (p *yamlParser) skipBlank() bool {
+	for p.pos < len(p.lines) && p.lines[p.pos].text == "" {
+		p.pos++
+	}
+	return p.pos < len(p.lines)
+}
+
+// stripComment removes a trailing comment outside of quotes
+func stripComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || strings.ContainsRune(" [{,:-", rune(s[i-1])) {
+				quote = c
+			}
+		case c == '#' && (i == 0 || s[i-1] == ' '):
+			return s[:i]
+		}
+	}
+	return s
+}
+
+func isSeqItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// block parses the node starting at the current line, which is indented by
+// indent
+func (p *yamlParser) block(indent int) (any, error) {
+	l := p.lines[p.pos]
+	if isSeqItem(l.text) {
+		return p.sequence(indent)
+	}
+	if _, _, ok, err := splitKey(l.text, l.num); err != nil {
+		return nil, err
+	} else if ok {
+		return p.mapping(indent)
+	}
+	p.pos++
+	return p.inline(l.text, l.num, indent)
+}
+
+func (p *yamlParser) sequence(indent int) (any, error) {
+	items := []any{}
+	for p.skipBlank() {
+		l := p.lines[p.pos]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent {
+			return nil, yamlError(l.num, "unexpected indentation")
+		}
+		if !isSeqItem(l.text) {
+			break
+		}
+
+		rest := strings.TrimLeft(l.text[1:], " ")
+		if rest == "" {
+			p.pos++
+			item, err := p.nested(indent, l.num)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			continue
+		}
+
+		// Parse "- key: value" and "- - item" as if the rest started its
+		// own line at the column where it appears
+		col := l.indent + len(l.text) - len(rest)
+		if _, _, isKey, _ := splitKey(rest, l.num); isKey || isSeqItem(rest) {
+			p.lines[p.pos] = yamlLine{num: l.num, indent: col, raw: l.raw, text: rest}
+			item, err := p.block(col)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			continue
+		}
+		p.pos++
+		item, err := p.inline(rest, l.num, indent)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (p *yamlParser) mapping(indent int) (any, error) {
+	m := map[string]any{}
+	for p.skipBlank() {
+		l := p.lines[p.pos]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent {
+			return nil, yamlError(l.num, "unexpected indentation")
+		}
+		if isSeqItem(l.text) {
+			return nil, yamlError(l.num, "expected a mapping key, found a sequence item")
+		}
+		key, rest, ok, err := splitKey(l.text, l.num)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, yamlError(l.num, "expected key: value, found %q", l.text)
+		}
+		if _, dup := m[key]; dup {
+			return nil, yamlError(l.num, "duplicate key %q", key)
+		}
+		p.pos++
+
+		var value any
+		if rest == "" {
+			// A sequence may sit at the same indentation as its key
+			if p.skipBlank() && p.lines[p.pos].indent == indent && isSeqItem(p.lines[p.pos].text) {
+				value, err = p.sequence(indent)
+			} else {
+				value, err = p.nested(indent, l.num)
+			}
+		} else {
+			value, err = p.inline(rest, l.num, indent)
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[key] = value
+	}
+	return m, nil
+}
+
+// nested parses the block indented deeper than parent that follows a key or
+// dash with nothing after it, or returns nil when there is none
+func (p *yamlParser) nested(parent, num int) (any, error) {
+	if !p.skipBlank() || p.lines[p.pos].indent <= parent {
+		return nil, nil
+	}
+	return p.block(p.lines[p.pos].indent)
+}
+
+// inline parses a value written on the same line as its key or dash.
+// Block scalars continue on the lines indented deeper than parent.
+func (p *yamlParser) inline(s string, num, parent int) (any, error) {
+	if s[0] == '|' || s[0] == '>' {
+		return p.blockScalar(s, num, parent)
+	}
+	return parseScalarOrFlow(s, num)
+}
+
+func (p *yamlParser) blockScalar(header string, num, parent int) (any, error) {
+	folded := header[0] == '>'
+	chomp := header[1:]
+	if chomp != "" && chomp != "-" && chomp != "+" {
+		return nil, yamlError(num, "unsupported block scalar header %q", header)
+	}
+
+	var lines []string
+	indent := -1
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		blank := strings.TrimSpace(l.raw) == ""
+		if !blank && l.indent <= parent {
+			break
+		}
+		if !blank && indent < 0 {
+			indent = l.indent
+		}
+		if !blank && l.indent < indent {
+			return nil, yamlError(l.num, "block scalar line is less indented than the first")
+		}
+		if blank {
+			lines = append(lines, "")
+		} else {
+			lines = append(lines, l.raw[indent:])
+		}
+		p.pos++
+	}
+
+	// Trailing blank lines only matter for keep chomping
+	trailing := 0
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+		trailing++
+	}
+
+	var text string
+	if folded {
+		var b strings.Builder
+		for i, line := range lines {
+			switch {
+			case line == "":
+				b.WriteByte('\n')
+			case i == 0 || lines[i-1] == "":
+			default:
+				b.WriteByte(' ')
+			}
+			b.WriteString(line)
+		}
+		text = b.String()
+	} else {
+		text = strings.Join(lines, "\n")
+	}
+
+	switch {
+	case len(lines) == 0:
+		return "", nil
+	case chomp == "-":
+		return text, nil
+	case chomp == "+":
+		return text + "\n" + strings.Repeat("\n", trailing), nil
+	}
+	return text + "\n", nil
+}
+
+// splitKey splits "key: value" into its key and trimmed value. ok is false
+// when the line is not a mapping entry.
+func splitKey(text string, num int) (key, rest string, ok bool, err error) {
+	if text[0] == '"' || text[0] == '\'' {
+		quoted, n, err := scanQuoted(text, num)
+		if err != nil {
+			return "", "", false, err
+		}
+		after := strings.TrimLeft(text[n:], " ")
+		if !strings.HasPrefix(after, ":") {
+			return "", "", false, nil
+		}
+		after = after[1:]
+		if after != "" && after[0] != ' ' {
+			return "", "", false, nil
+		}
+		return quoted, strings.TrimSpace(after), true, nil
+	}
+	if text[0] == '[' || text[0] == '{' {
+		return "", "", false, nil
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			key = strings.TrimSpace(text[:i])
+			if key == "" {
+				return "", "", false, yamlError(num, "empty mapping key")
+			}
+			return key, strings.TrimSpace(text[i+1:]), true, nil
+		}
+	}
+	return "", "", false, nil
+}
+
+// scanQuoted reads the quoted string at the start of s and returns its value
+// and length
+func scanQuoted(s string, num int) (string, int, error) {
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		switch {
+		case quote == '"' && s[i] == '\\':
+			i++
+		case quote == '\'' && s[i] == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++
+		case s[i] == quote:
+			if quote == '\'' {
+				return strings.ReplaceAll(s[1:i], "''", "'"), i + 1, nil
+			}
+			v, err := strconv.Unquote(s[:i+1])
+			if err != nil {
+				return "", 0, yamlError(num, "invalid double-quoted string %s", s[:i+1])
+			}
+			return v, i + 1, nil
+		}
+	}
+	return "", 0, yamlError(num, "unterminated quoted string")
+}
+
+func parseScalarOrFlow(s string, num int) (any, error) {
+	switch s[0] {
+	case '&', '*', '!':
+		return nil, yamlError(num, "anchors, aliases and tags are not supported")
+	case '"', '\'':
+		v, n, err := scanQuoted(s, num)
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(s[n:]) != "" {
+			return nil, yamlError(num, "unexpected text after quoted string: %q", s[n:])
+		}
+		return v, nil
+	case '[', '{':
+		f := &flowParser{s: s, num: num}
+		v, err := f.value()
+		if err != nil {
+			return nil, err
+		}
+		f.space()
+		if f.i < len(f.s) {
+			return nil, yamlError(num, "unexpected text after flow collection: %q", f.s[f.i:])
+		}
+		return v, nil
+	}
+	return plainScalar(s), nil
+}
+
+// plainScalar resolves an unquoted scalar to null, a bool, a number or a
+// string
+func plainScalar(s string) any {
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+	// Only digits, signs, dots and exponents form numbers; this keeps
+	// ParseFloat from accepting words such as "inf" or "nan"
+	if strings.IndexFunc(s, func(r rune) bool { return !strings.ContainsRune("0123456789+-.eE_", r) }) < 0 {
+		if n, err := strconv.ParseFloat(s, 64); err == nil && !strings.Contains(s, "_") {
+			return n
+		}
+	}
+	return s
+}
+
+// flowParser reads single-line flow collections such as [a, {b: 1}]
+type flowParser struct {
+	s   string
+	i   int
+	num int
+}
+
+func (f *flowParser) space() {
+	for f.i < len(f.s) && f.s[f.i] == ' ' {
+		f.i++
+	}
+}
+
+func (f *flowParser) value() (any, error) {
+	f.space()
+	if f.i >= len(f.s) {
+		return nil, yamlError(f.num, "unexpected end of flow collection (multi-line flow collections are not supported)")
+	}
+	switch c := f.s[f.i]; c {
+	case '[':
+		f.i++
+		list := []any{}
+		for {
+			f.space()
+			if f.i < len(f.s) && f.s[f.i] == ']' {
+				f.i++
+				return list, nil
+			}
+			v, err := f.value()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			if err := f.separator(']'); err != nil {
+				return nil, err
+			}
+		}
+	case '{':
+		f.i++
+		m := map[string]any{}
+		for {
+			f.space()
+			if f.i < len(f.s) && f.s[f.i] == '}' {
+				f.i++
+				return m, nil
+			}
+			key, err := f.key()
+			if err != nil {
+				return nil, err
+			}
+			v, err := f.value()
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+			if err := f.separator('}'); err != nil {
+				return nil, err
+			}
+		}
+	case '"', '\'':
+		v, n, err := scanQuoted(f.s[f.i:], f.num)
+		f.i += n
+		return v, err
+	default:
+		start := f.i
+		for f.i < len(f.s) && !strings.ContainsRune(",]}", rune(f.s[f.i])) {
+			f.i++
+		}
+		return plainScalar(strings.TrimSpace(f.s[start:f.i])), nil
+	}
+}
+
+// key reads a flow mapping key and the colon after it
+func (f *flowParser) key() (string, error) {
+	var key string
+	if c := f.s[f.i]; c == '"' || c == '\'' {
+		v, n, err := scanQuoted(f.s[f.i:], f.num)
+		if err != nil {
+			return "", err
+		}
+		key = v
+		f.i += n
+		f.space()
+	} else {
+		start := f.i
+		for f.i < len(f.s) && f.s[f.i] != ':' && !strings.ContainsRune(",}", rune(f.s[f.i])) {
+			f.i++
+		}
+		key = strings.TrimSpace(f.s[start:f.i])
+	}
+	if f.i >= len(f.s) || f.s[f.i] != ':' {
+		return "", yamlError(f.num, "expected ':' after flow mapping key %q", key)
+	}
+	f.i++
+	return key, nil
+}
+
+// separator consumes the comma after an item, or checks for the closing
+// bracket
+func (f *flowParser) separator(closing byte) error {
+	f.space()
+	if f.i >= len(f.s) {
+		return yamlError(f.num, "unterminated flow collection (multi-line flow collections are not supported)")
+	}
+	switch f.s[f.i] {
+	case ',':
+		f.i++
+		return nil
+	case closing:
+		return nil
+	}
+	return yamlError(f.num, "expected ',' or '%c' in flow collection, found %q", closing, f.s[f.i:])
+}
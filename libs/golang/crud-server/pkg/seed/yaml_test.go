@@ -0,0 +1,77 @@
+package seed
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want any
+	}{
+		{"empty", "# nothing here\n", nil},
+		{"scalars", "a: 1\nb: -2.5\nc: true\nd: ~\ne: hello world\nf: \"quoted # not a comment\"\ng: 'it''s'\nh: 10:30\n", map[string]any{
+			"a": 1.0, "b": -2.5, "c": true, "d": nil, "e": "hello world", "f": "quoted # not a comment", "g": "it's", "h": "10:30",
+		}},
+		{"comments", "---\na: x # trailing\n# full line\nb: y#not-a-comment\n", map[string]any{"a": "x", "b": "y#not-a-comment"}},
+		{"nested", "a:\n  b:\n    c: 1\n  d: [1, two, {e: f}]\n", map[string]any{
+			"a": map[string]any{"b": map[string]any{"c": 1.0}, "d": []any{1.0, "two", map[string]any{"e": "f"}}},
+		}},
+		{"sequence of maps", "items:\n- name: a\n  tags: []\n-   name: b\n    n: 2\n- plain\n-\n  - nested\n", map[string]any{
+			"items": []any{
+				map[string]any{"name": "a", "tags": []any{}},
+				map[string]any{"name": "b", "n": 2.0},
+				"plain",
+				[]any{"nested"},
+			},
+		}},
+		{"flow map with ref", "- {user: {$ref: users.ann}, \"q\": 'x, y'}\n", []any{
+			map[string]any{"user": map[string]any{"$ref": "users.ann"}, "q": "x, y"},
+		}},
+		{"literal block", "text: |\n  line one\n    indented\n\n  line three\nnext: 1\n", map[string]any{
+			"text": "line one\n  indented\n\nline three\n", "next": 1.0,
+		}},
+		{"folded block", "text: >-\n  one\n  two\n\n  three\n", map[string]any{"text": "one two\nthree"}},
+		{"empty value", "a:\nb: 2\n", map[string]any{"a": nil, "b": 2.0}},
+		{"not numbers", "a: inf\nb: 1_000\nc: 1.2.3\n", map[string]any{"a": "inf", "b": "1_000", "c": "1.2.3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseYAML([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v\nwant %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"tab indent", "a:\n\tb: 1\n", "line 2: tabs"},
+		{"bad indent", "a: 1\n  b: 2\n", "line 2: unexpected indentation"},
+		{"duplicate key", "a: 1\na: 2\n", "duplicate key"},
+		{"alias", "a: *ref\n", "not supported"},
+		{"multi-line flow", "a: [1,\n  2]\n", "multi-line"},
+		{"unterminated string", "a: \"x\n", "unterminated"},
+		{"two documents", "a: 1\n---\nb: 2\n", "multiple documents"},
+		{"mixed node", "a: 1\n- b\n", "sequence item"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseYAML([]byte(tt.in))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/dae-go/crud-server/pkg/db"
+)
+
+// Authenticator identifies the subject a request acts as. Returning an
+// error rejects the request with 401 Unauthorized.
+type Authenticator func(r *http.Request) (db.Subject, error)
+
+// adminActions bypass row policies, so with an authenticator only admins
+// may perform them. Statistics count every record, whoever owns it.
+var adminActions = map[Action]bool{
+	ActionCreateTable: true,
+	ActionDeleteTable: true,
+	ActionBackup:      true,
+	ActionRestore:     true,
+	ActionCompact:     true,
+	ActionStats:       true,
+	ActionTableStats:  true,
+}
+
+// WithAuthenticator authenticates every request except /health and /ready.
+// Record access then follows the tables' row policies for the subject, and
+// creating or deleting tables, statistics, backups, restores and
+// compaction need an admin. Without an authenticator every request acts
+// as an admin.
+func WithAuthenticator(auth Authenticator) Option {
+	return func(s *Server) {
+		s.auth = auth
+	}
+}
+
+// authenticate stores the request's subject in its context. It returns
+// false after rejecting the request.
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	if s.auth == nil || r.URL.Path == "/health" || r.URL.Path == "/ready" {
+		return r, true
+	}
+	subject, err := s.auth(r)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return r, false
+	}
+	return r.WithContext(db.WithSubject(r.Context(), subject)), true
+}
+
+// TokenAuthenticator looks bearer tokens up in tokens. Requests without an
+// Authorization header act as an anonymous subject, which only sees tables
+// without a row policy and records that policies make public.
+func TokenAuthenticator(tokens map[string]db.Subject) Authenticator {
+	return func(r *http.Request) (db.Subject, error) {
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			return db.Subject{}, nil
+		}
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok {
+			return db.Subject{}, errors.New("expected a bearer token")
+		}
+		subject, ok := tokens[token]
+		if !ok {
+			return db.Subject{}, errors.New("invalid token")
+		}
+		return subject, nil
+	}
+}
+
+// LoadTokens reads a JSON file mapping bearer tokens to subjects, e.g.
+// {"secret": {"id": "jane", "admin": true}}
+func LoadTokens(path string) (map[string]db.Subject, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tokens map[string]db.Subject
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	for token, subject := range tokens {
+		if token == "" || subject.ID == "" {
+			return nil, fmt.Errorf("reading %s: every token needs a subject id", path)
+		}
+	}
+	return tokens, nil
+}
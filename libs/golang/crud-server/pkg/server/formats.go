@@ -0,0 +1,168 @@
+package server
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dae-go/crud-server/pkg/db"
+	"github.com/dae-go/crud-server/pkg/msgpack"
+)
+
+// Record listing formats
+const (
+	formatJSON    = "json"
+	formatNDJSON  = "ndjson"
+	formatCSV     = "csv"
+	formatMsgPack = "msgpack"
+)
+
+// recordFormats maps the media types a client may ask for to a format
+var recordFormats = map[string]string{
+	"application/json":        formatJSON,
+	"application/x-ndjson":    formatNDJSON,
+	"application/ndjson":      formatNDJSON,
+	"application/jsonl":       formatNDJSON,
+	"text/csv":                formatCSV,
+	"application/msgpack":     formatMsgPack,
+	"application/x-msgpack":   formatMsgPack,
+	"application/vnd.msgpack": formatMsgPack,
+	"application/*":           formatJSON,
+	"*/*":                     formatJSON,
+}
+
+// formatContentTypes is the Content-Type sent for each format
+var formatContentTypes = map[string]string{
+	formatJSON:    "application/json",
+	formatNDJSON:  "application/x-ndjson",
+	formatCSV:     "text/csv; charset=utf-8",
+	formatMsgPack: "application/msgpack",
+}
+
+// negotiateFormat picks the listing format from the format query parameter,
+// which wins so links can choose it, or else from the Accept header. ok is
+// false when neither names a supported format.
+func negotiateFormat(r *http.Request) (format string, ok bool) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		_, ok := formatContentTypes[format]
+		return format, ok
+	}
+
+	accept := r.Header.Get("Accept")
+	if strings.TrimSpace(accept) == "" {
+		return formatJSON, true
+	}
+	bestQ := 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		candidate, known := recordFormats[mediaType]
+		if !known {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > bestQ {
+			format, bestQ = candidate, q
+		}
+	}
+	return format, format != ""
+}
+
+// writeRecords encodes records in the given format. columns orders the CSV
+// columns after id; fields missing from it follow in alphabetical order.
+func writeRecords(w http.ResponseWriter, format string, records []map[string]any, columns []db.Column) error {
+	w.Header().Set("Content-Type", formatContentTypes[format])
+
+	switch format {
+	case formatNDJSON:
+		bw := bufio.NewWriter(w)
+		enc := json.NewEncoder(bw)
+		for _, record := range records {
+			if err := enc.Encode(record); err != nil {
+				return err
+			}
+		}
+		return bw.Flush()
+	case formatCSV:
+		return writeCSV(w, records, columns)
+	case formatMsgPack:
+		data, err := msgpack.Marshal(records)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+	return json.NewEncoder(w).Encode(records)
+}
+
+func writeCSV(w http.ResponseWriter, records []map[string]any, columns []db.Column) error {
+	header := []string{"id"}
+	seen := map[string]bool{"id": true}
+	for _, col := range columns {
+		if !seen[col.Name] {
+			header = append(header, col.Name)
+			seen[col.Name] = true
+		}
+	}
+	var extra []string
+	for _, record := range records {
+		for k := range record {
+			if !seen[k] {
+				extra = append(extra, k)
+				seen[k] = true
+			}
+		}
+	}
+	sort.Strings(extra)
+	header = append(header, extra...)
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	row := make([]string, len(header))
+	for _, record := range records {
+		for i, col := range header {
+			row[i] = csvCell(record[col])
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvCell renders a field for CSV: missing and null fields are empty, and
+// nested values are written as JSON
+func csvCell(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int, bool:
+		return fmt.Sprint(v)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
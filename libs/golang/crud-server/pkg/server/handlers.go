@@ -0,0 +1,677 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/dae-go/crud-server/pkg/backup"
+	"github.com/dae-go/crud-server/pkg/db"
+)
+
+// HandleTable handles table CRUD operations
+func (s *Server) HandleTable(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		s.listTables(w, r)
+	case http.MethodPost:
+		s.createTable(w, r)
+	case http.MethodDelete:
+		s.deleteTable(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// cutPath splits the request path after prefix into a table name and the
+// rest. It splits the escaped path, so that names may contain escaped
+// slashes.
+func cutPath(r *http.Request, prefix string) (name, rest string, hasRest bool) {
+	name, rest, hasRest = strings.Cut(strings.TrimPrefix(r.URL.EscapedPath(), prefix), "/")
+	name, _ = url.PathUnescape(name)
+	rest, _ = url.PathUnescape(rest)
+	return name, rest, hasRest
+}
+
+// HandleTableSchema returns a single table's definition, or its statistics
+// under /table/{name}/stats
+func (s *Server) HandleTableSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	name, sub, hasSub := cutPath(r, "/table/")
+	if name == "" || (hasSub && sub != "stats") {
+		http.Error(w, "Invalid table name", http.StatusBadRequest)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	op := &Operation{Action: ActionGetTable, Table: name, Request: r}
+	if hasSub {
+		op.Action = ActionTableStats
+	}
+	if !s.runBefore(w, op) {
+		return
+	}
+
+	var result any
+	var err error
+	if hasSub {
+		result, err = s.DB.TableStats(name)
+	} else {
+		result, err = s.DB.GetTable(name)
+	}
+	s.runAfter(op, result, err)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// HandleTableData handles data CRUD operations
+func (s *Server) HandleTableData(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	// Extract table name and optional record id from path
+	tableName, id, hasID := cutPath(r, "/tables/")
+	if tableName == "" || (hasID && (id == "" || strings.Contains(id, "/"))) {
+		http.Error(w, "Invalid table name", http.StatusBadRequest)
+		return
+	}
+
+	if hasID {
+		// Record ids are integers, so these actions cannot shadow a record
+		switch {
+		case id == "aggregate" && r.Method == http.MethodGet:
+			s.aggregateRecords(w, r, tableName)
+		case id == "upsert" && r.Method == http.MethodPost:
+			s.upsertRecord(w, r, tableName)
+		case id == "truncate" && r.Method == http.MethodPost:
+			s.truncateTable(w, r, tableName)
+		case r.Method == http.MethodGet:
+			s.getRecord(w, r, tableName, id)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.getRecords(w, r, tableName)
+	case http.MethodPost:
+		s.createRecord(w, r, tableName)
+	case http.MethodPut:
+		s.updateRecord(w, r, tableName)
+	case http.MethodDelete:
+		s.deleteRecord(w, r, tableName)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Table operations
+
+func (s *Server) listTables(w http.ResponseWriter, r *http.Request) {
+	op := &Operation{Action: ActionListTables, Request: r}
+	if !s.runBefore(w, op) {
+		return
+	}
+	tables := s.DB.ListTables()
+	s.runAfter(op, tables, nil)
+	if err := json.NewEncoder(w).Encode(tables); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) createTable(w http.ResponseWriter, r *http.Request) {
+	var table db.Table
+	if err := json.NewDecoder(r.Body).Decode(&table); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if table.Name == "" || len(table.Columns) == 0 {
+		http.Error(w, "Table name and columns are required", http.StatusBadRequest)
+		return
+	}
+
+	op := &Operation{Action: ActionCreateTable, Table: table.Name, Schema: &table, Request: r}
+	if !s.runBefore(w, op) {
+		return
+	}
+	err := s.DB.CreateTable(&table)
+	s.runAfter(op, &table, err)
+	if err != nil {
+		if strings.HasSuffix(err.Error(), "already exists") {
+			http.Error(w, err.Error(), http.StatusConflict)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Table created successfully"})
+}
+
+func (s *Server) deleteTable(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Name == "" {
+		http.Error(w, "Table name is required", http.StatusBadRequest)
+		return
+	}
+
+	op := &Operation{Action: ActionDeleteTable, Table: req.Name, Request: r}
+	if !s.runBefore(w, op) {
+		return
+	}
+	err := s.DB.DeleteTable(req.Name)
+	s.runAfter(op, nil, err)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Table deleted successfully"})
+}
+
+// Data operations
+
+// recordResponse is returned by record writes so clients learn the
+// generated id and the stored record
+type recordResponse struct {
+	Message string                 `json:"message"`
+	ID      int                    `json:"id"`
+	Record  map[string]interface{} `json:"record"`
+}
+
+func (s *Server) getRecords(w http.ResponseWriter, r *http.Request, tableName string) {
+	if query := r.URL.Query().Get("q"); query != "" {
+		s.searchRecords(w, r, tableName, query)
+		return
+	}
+
+	format, ok := negotiateFormat(r)
+	if !ok {
+		http.Error(w, "Not acceptable: records are available as json, ndjson, csv or msgpack", http.StatusNotAcceptable)
+		return
+	}
+
+	filters := make([]db.Filter, 0)
+	for _, expr := range r.URL.Query()["where"] {
+		filter, err := db.ParseFilter(expr)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filters = append(filters, filter)
+	}
+
+	offset, err := queryInt(r, "offset")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := queryInt(r, "limit")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	op := &Operation{Action: ActionListRecords, Table: tableName, Request: r}
+	if !s.runBefore(w, op) {
+		return
+	}
+	records, err := s.DB.For(r.Context()).FindRecords(tableName, filters)
+	s.runAfter(op, records, err)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	// The total is reported before paging so clients can count pages
+	w.Header().Set("X-Total-Count", strconv.Itoa(len(records)))
+	records = records[min(offset, len(records)):]
+	if limit > 0 && len(records) > limit {
+		records = records[:limit]
+	}
+
+	var columns []db.Column
+	if format == formatCSV {
+		if table, err := s.DB.GetTable(tableName); err == nil {
+			columns = table.Columns
+		}
+	}
+	if err := writeRecords(w, format, records, columns); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) getRecord(w http.ResponseWriter, r *http.Request, tableName, id string) {
+	op := &Operation{Action: ActionGetRecord, Table: tableName, ID: id, Request: r}
+	if !s.runBefore(w, op) {
+		return
+	}
+	record, err := s.DB.For(r.Context()).GetRecord(tableName, id)
+	s.runAfter(op, record, err)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(record); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// aggregateRecords computes aggregates over the filtered records, e.g.
+// ?group_by=country&agg=count&agg=avg(age)&where=age>=18
+func (s *Server) aggregateRecords(w http.ResponseWriter, r *http.Request, tableName string) {
+	var q db.AggregateQuery
+	for _, v := range r.URL.Query()["group_by"] {
+		for _, col := range strings.Split(v, ",") {
+			if col = strings.TrimSpace(col); col != "" {
+				q.GroupBy = append(q.GroupBy, col)
+			}
+		}
+	}
+	for _, expr := range r.URL.Query()["agg"] {
+		agg, err := db.ParseAggregation(expr)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		q.Aggregations = append(q.Aggregations, agg)
+	}
+	for _, expr := range r.URL.Query()["where"] {
+		filter, err := db.ParseFilter(expr)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		q.Where = append(q.Where, filter)
+	}
+
+	op := &Operation{Action: ActionAggregate, Table: tableName, Request: r}
+	if !s.runBefore(w, op) {
+		return
+	}
+	groups, err := s.DB.For(r.Context()).Aggregate(tableName, q)
+	s.runAfter(op, groups, err)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(groups); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) searchRecords(w http.ResponseWriter, r *http.Request, tableName, query string) {
+	limit, err := queryInt(r, "limit")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	op := &Operation{Action: ActionSearch, Table: tableName, Request: r}
+	if !s.runBefore(w, op) {
+		return
+	}
+	results, err := s.DB.For(r.Context()).Search(tableName, query, limit)
+	s.runAfter(op, results, err)
+	if err != nil {
+		if errors.Is(err, db.ErrNoSearchColumns) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		}
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) createRecord(w http.ResponseWriter, r *http.Request, tableName string) {
+	var record map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	op := &Operation{Action: ActionCreateRecord, Table: tableName, Record: record, Request: r}
+	if !s.runBefore(w, op) {
+		return
+	}
+	record = op.Record
+
+	id, err := s.DB.For(r.Context()).InsertRecord(tableName, record)
+	if err == nil {
+		// Return the stored record, which includes any expiry the database set
+		if stored, err := s.DB.For(r.Context()).GetRecord(tableName, id); err == nil {
+			record = stored
+		}
+		record["id"] = id
+	}
+	s.runAfter(op, record, err)
+	if err != nil {
+		if errors.Is(err, db.ErrForbidden) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+		} else if strings.HasSuffix(err.Error(), "not found") {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(recordResponse{
+		Message: "Record created successfully",
+		ID:      id,
+		Record:  record,
+	})
+}
+
+func (s *Server) updateRecord(w http.ResponseWriter, r *http.Request, tableName string) {
+	var record map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	op := &Operation{Action: ActionUpdateRecord, Table: tableName, Record: record, Request: r}
+	if record["id"] != nil {
+		op.ID = fmt.Sprint(record["id"])
+	}
+	if !s.runBefore(w, op) {
+		return
+	}
+
+	updated, err := s.DB.For(r.Context()).UpdateRecord(tableName, op.Record)
+	s.runAfter(op, updated, err)
+	if err != nil {
+		if errors.Is(err, db.ErrForbidden) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+		} else if strings.HasSuffix(err.Error(), "not found") {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	id, _ := updated["id"].(int)
+	json.NewEncoder(w).Encode(recordResponse{
+		Message: "Record updated successfully",
+		ID:      id,
+		Record:  updated,
+	})
+}
+
+// upsertRecord inserts the posted record or merges it into the record with
+// the same key columns, e.g. ?key=email. dry_run=true reports the outcome
+// without writing.
+func (s *Server) upsertRecord(w http.ResponseWriter, r *http.Request, tableName string) {
+	var record map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var key []string
+	for _, v := range r.URL.Query()["key"] {
+		for _, col := range strings.Split(v, ",") {
+			if col = strings.TrimSpace(col); col != "" {
+				key = append(key, col)
+			}
+		}
+	}
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	op := &Operation{Action: ActionUpsertRecord, Table: tableName, Record: record, Request: r}
+	if !s.runBefore(w, op) {
+		return
+	}
+
+	result, err := s.DB.For(r.Context()).UpsertRecord(tableName, key, op.Record, dryRun)
+	s.runAfter(op, result, err)
+	if err != nil {
+		if errors.Is(err, db.ErrForbidden) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+		} else if strings.HasSuffix(err.Error(), "not found") {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	json.NewEncoder(w).Encode(result)
+}
+
+// truncateTable deletes every record of a table in one request
+func (s *Server) truncateTable(w http.ResponseWriter, r *http.Request, tableName string) {
+	op := &Operation{Action: ActionTruncate, Table: tableName, Request: r}
+	if !s.runBefore(w, op) {
+		return
+	}
+	n, err := s.DB.For(r.Context()).TruncateTable(tableName)
+	s.runAfter(op, n, err)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Table truncated successfully",
+		"deleted": n,
+	})
+}
+
+func (s *Server) deleteRecord(w http.ResponseWriter, r *http.Request, tableName string) {
+	var req struct {
+		ID interface{} `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.ID == nil {
+		http.Error(w, "ID is required", http.StatusBadRequest)
+		return
+	}
+
+	op := &Operation{Action: ActionDeleteRecord, Table: tableName, ID: fmt.Sprint(req.ID), Request: r}
+	if !s.runBefore(w, op) {
+		return
+	}
+	err := s.DB.For(r.Context()).DeleteRecord(tableName, req.ID)
+	s.runAfter(op, nil, err)
+	if errors.Is(err, db.ErrForbidden) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Record deleted successfully"})
+}
+
+// Backup operations
+
+// HandleBackup streams a compressed archive of every table
+func (s *Server) HandleBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	op := &Operation{Action: ActionBackup, Request: r}
+	if !s.runBefore(w, op) {
+		return
+	}
+	snap := s.DB.Snapshot()
+	s.runAfter(op, snap, nil)
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", backup.FileName(snap.CreatedAt)))
+	if err := backup.Write(w, snap); err != nil {
+		// Headers are already sent, so all we can do is log and abort
+		fmt.Printf("backup failed: %v\n", err)
+	}
+}
+
+// HandleRestore loads an uploaded archive. The strategy query parameter
+// chooses what happens to tables that already exist.
+func (s *Server) HandleRestore(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	strategy, err := db.ParseConflictStrategy(r.URL.Query().Get("strategy"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	op := &Operation{Action: ActionRestore, Request: r}
+	if !s.runBefore(w, op) {
+		return
+	}
+
+	snap, err := backup.Read(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Fail fast before touching anything when the strategy forbids conflicts
+	if strategy == db.ConflictFail {
+		for _, t := range snap.Tables {
+			if _, err := s.DB.GetTable(t.Table.Name); err == nil {
+				http.Error(w, fmt.Sprintf("table %s already exists", t.Table.Name), http.StatusConflict)
+				return
+			}
+		}
+	}
+
+	results := make([]db.RestoreResult, 0, len(snap.Tables))
+	for _, t := range snap.Tables {
+		result, err := s.DB.RestoreTable(t, strategy)
+		if err != nil {
+			s.runAfter(op, results, err)
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		results = append(results, result)
+	}
+	s.runAfter(op, results, nil)
+
+	json.NewEncoder(w).Encode(results)
+}
+
+// HandleStats reports statistics for every table and for the storage
+func (s *Server) HandleStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	op := &Operation{Action: ActionStats, Request: r}
+	if !s.runBefore(w, op) {
+		return
+	}
+	stats := s.DB.Stats()
+	s.runAfter(op, stats, nil)
+
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// HandleCompact folds the journal of a persisted database into a new
+// snapshot
+func (s *Server) HandleCompact(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	op := &Operation{Action: ActionCompact, Request: r}
+	if !s.runBefore(w, op) {
+		return
+	}
+	result, err := s.DB.Compact()
+	s.runAfter(op, result, err)
+	if errors.Is(err, db.ErrNotPersistent) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(result)
+}
+
+// HandleReady reports whether the server can accept writes. Unlike /health,
+// which only shows the process is up, it fails while changes cannot be
+// persisted.
+func (s *Server) HandleReady(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := s.DB.CheckStorage(); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"status": "unavailable", "error": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "ready"})
+}
+
+// queryInt parses an optional non-negative integer query parameter
+func queryInt(r *http.Request, name string) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("Invalid %s", name)
+	}
+	return n, nil
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/dae-go/crud-server/pkg/db"
+)
+
+// Action names an operation of the API
+type Action string
+
+// Actions passed to hooks
+const (
+	ActionListTables   Action = "list_tables"
+	ActionCreateTable  Action = "create_table"
+	ActionDeleteTable  Action = "delete_table"
+	ActionGetTable     Action = "get_table"
+	ActionTableStats   Action = "table_stats"
+	ActionListRecords  Action = "list_records"
+	ActionSearch       Action = "search"
+	ActionAggregate    Action = "aggregate"
+	ActionGetRecord    Action = "get_record"
+	ActionCreateRecord Action = "create_record"
+	ActionUpdateRecord Action = "update_record"
+	ActionUpsertRecord Action = "upsert_record"
+	ActionDeleteRecord Action = "delete_record"
+	ActionTruncate     Action = "truncate"
+	ActionBackup       Action = "backup"
+	ActionRestore      Action = "restore"
+	ActionStats        Action = "stats"
+	ActionCompact      Action = "compact"
+	ActionGraphQL      Action = "graphql"
+)
+
+// Operation describes a request to hooks
+type Operation struct {
+	Action Action
+	// Table is empty for actions that are not about one table
+	Table string
+	// ID is the record id for get, update and delete, as sent by the client
+	ID string
+	// Record is the decoded body of record writes. Before hooks may change
+	// it, e.g. to stamp the record with its owner.
+	Record map[string]any
+	// Schema is the definition sent to create a table
+	Schema *db.Table
+	// Subject is who the request acts as. It is an admin when the server
+	// has no authenticator.
+	Subject db.Subject
+	// Request is the HTTP request, with the prefix removed from its path
+	Request *http.Request
+}
+
+// BeforeHook runs before an operation. Returning an error rejects the
+// request with the error's message: a *StatusError chooses the status,
+// any other error is reported as 403 Forbidden.
+type BeforeHook func(op *Operation) error
+
+// AfterHook runs once an operation has finished, with its result and error,
+// before the response is written. GraphQL requests are the exception: their
+// hooks run after the response, with a nil result.
+type AfterHook func(op *Operation, result any, err error)
+
+// StatusError is an error with the HTTP status to report it with
+type StatusError struct {
+	Status int
+	Err    error
+}
+
+func (e *StatusError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// runBefore checks that the subject may perform the operation, runs the
+// before hooks and reports a rejection to the client. It returns false when
+// the request must not go on.
+func (s *Server) runBefore(w http.ResponseWriter, op *Operation) bool {
+	op.Subject = s.DB.For(op.Request.Context()).Subject()
+	if adminActions[op.Action] && !op.Subject.Admin {
+		http.Error(w, fmt.Sprintf("%s needs an admin", op.Action), http.StatusForbidden)
+		return false
+	}
+	for _, hook := range s.before {
+		if err := hook(op); err != nil {
+			status := http.StatusForbidden
+			var se *StatusError
+			if errors.As(err, &se) {
+				status = se.Status
+			}
+			http.Error(w, err.Error(), status)
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Server) runAfter(op *Operation, result any, err error) {
+	for _, hook := range s.after {
+		hook(op, result, err)
+	}
+}
+
+// hooked runs the hooks around a handler that does not report results
+func (s *Server) hooked(action Action, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		op := &Operation{Action: action, Request: r}
+		if !s.runBefore(w, op) {
+			return
+		}
+		next.ServeHTTP(w, r)
+		s.runAfter(op, nil, nil)
+	})
+}
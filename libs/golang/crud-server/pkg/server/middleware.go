@@ -0,0 +1,347 @@
+package server
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dae-go/crud-server/pkg/zstd"
+)
+
+// LoggingMiddleware logs all HTTP requests
+func LoggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Printf("[%s] %s %s\n", r.Method, r.URL.Path, r.RemoteAddr)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CORSConfig controls which browser origins may call the API
+type CORSConfig struct {
+	// AllowedOrigins lists origins such as https://app.example.com. "*"
+	// allows any origin and a single * inside an entry matches any text, as
+	// in https://*.example.com.
+	AllowedOrigins []string
+	// AllowedMethods defaults to the methods the API uses
+	AllowedMethods []string
+	// AllowedHeaders defaults to Content-Type and Authorization
+	AllowedHeaders []string
+	// ExposedHeaders lists response headers scripts may read. It defaults to
+	// X-Total-Count, which paged listings return.
+	ExposedHeaders []string
+	// AllowCredentials lets browsers send cookies and auth headers. The
+	// request's origin is echoed instead of "*" when it is set.
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight response
+	MaxAge time.Duration
+}
+
+// CORSMiddleware adds CORS headers for allowed origins and answers
+// preflight requests itself. Requests from other origins are passed on
+// without CORS headers, so browsers block them.
+func CORSMiddleware(next http.Handler, cfg CORSConfig) http.Handler {
+	methods := strings.Join(orDefault(cfg.AllowedMethods, []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}), ", ")
+	headers := strings.Join(orDefault(cfg.AllowedHeaders, []string{"Content-Type", "Authorization"}), ", ")
+	exposed := strings.Join(orDefault(cfg.ExposedHeaders, []string{"X-Total-Count"}), ", ")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if preflight {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		if allowed, wildcard := matchOrigin(cfg.AllowedOrigins, origin); allowed {
+			if wildcard && !cfg.AllowCredentials {
+				h.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				h.Set("Access-Control-Allow-Origin", origin)
+			}
+			if cfg.AllowCredentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if preflight {
+				h.Set("Access-Control-Allow-Methods", methods)
+				h.Set("Access-Control-Allow-Headers", headers)
+				if cfg.MaxAge > 0 {
+					h.Set("Access-Control-Max-Age", strconv.Itoa(int(cfg.MaxAge.Seconds())))
+				}
+			} else if exposed != "" {
+				h.Set("Access-Control-Expose-Headers", exposed)
+			}
+		}
+
+		if preflight {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func orDefault(values, fallback []string) []string {
+	if len(values) == 0 {
+		return fallback
+	}
+	return values
+}
+
+// matchOrigin reports whether origin is allowed, and whether it was allowed
+// by a bare "*"
+func matchOrigin(patterns []string, origin string) (allowed, wildcard bool) {
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+		if p == "*" {
+			return true, true
+		}
+		prefix, suffix, found := strings.Cut(p, "*")
+		if !found {
+			if strings.EqualFold(p, origin) {
+				return true, false
+			}
+			continue
+		}
+		if len(origin) > len(prefix)+len(suffix) &&
+			strings.HasPrefix(strings.ToLower(origin), strings.ToLower(prefix)) &&
+			strings.HasSuffix(strings.ToLower(origin), strings.ToLower(suffix)) {
+			return true, false
+		}
+	}
+	return false, false
+}
+
+// DefaultCompressMinSize is the smallest response CompressionMiddleware
+// compresses; below it the encoding overhead outweighs the savings
+const DefaultCompressMinSize = 1024
+
+var (
+	gzipWriters  = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+	flateWriters = sync.Pool{New: func() any {
+		w, _ := flate.NewWriter(io.Discard, flate.DefaultCompression)
+		return w
+	}}
+	zstdWriters = sync.Pool{New: func() any { return zstd.NewWriter(io.Discard) }}
+)
+
+// encodingRank orders the supported encodings for Accept-Encoding ties,
+// preferring the best ratio
+var encodingRank = map[string]int{"zstd": 3, "gzip": 2, "deflate": 1}
+
+// CompressionMiddleware compresses responses of at least minSize bytes with
+// zstd, gzip or deflate, whichever the client's Accept-Encoding prefers. Already
+// compressed and streaming content types are sent as they are.
+func CompressionMiddleware(next http.Handler, minSize int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding, minSize: minSize}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// acceptedEncoding picks zstd, gzip or deflate from an Accept-Encoding
+// header by quality, preferring them in that order on ties, or returns ""
+// when none is accepted. A wildcard stands for gzip, which every client
+// decodes.
+func acceptedEncoding(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if name == "*" {
+			name = "gzip"
+		}
+		if encodingRank[name] == 0 || q <= 0 {
+			continue
+		}
+		if q > bestQ || (q == bestQ && encodingRank[name] > encodingRank[best]) {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// compressWriter buffers the start of a response until it knows whether the
+// response is large enough and of a type worth compressing
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+
+	status  int
+	buf     []byte
+	decided bool
+	enc     interface {
+		io.WriteCloser
+		Flush() error
+	}
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.decided || status < 200 {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if cw.status != 0 {
+		return
+	}
+	cw.status = status
+	// Bodiless responses and ones we must not touch are passed on now
+	if status == http.StatusNoContent || status == http.StatusNotModified || !cw.compressible() {
+		cw.start(false)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.decided {
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		if !cw.compressible() {
+			cw.start(false)
+		} else {
+			cw.buf = append(cw.buf, p...)
+			if len(cw.buf) >= cw.minSize {
+				if err := cw.start(true); err != nil {
+					return 0, err
+				}
+			}
+			return len(p), nil
+		}
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// compressible reports whether the response headers allow compression
+func (cw *compressWriter) compressible() bool {
+	h := cw.Header()
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	contentType := strings.ToLower(h.Get("Content-Type"))
+	for _, prefix := range []string{"application/gzip", "application/zip", "application/grpc", "application/octet-stream", "image/", "audio/", "video/", "text/event-stream"} {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// start writes the headers and any buffered body, compressing from here on
+// when compress is set
+func (cw *compressWriter) start(compress bool) error {
+	cw.decided = true
+	if compress {
+		h := cw.Header()
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		switch cw.encoding {
+		case "zstd":
+			zw := zstdWriters.Get().(*zstd.Writer)
+			zw.Reset(cw.ResponseWriter)
+			cw.enc = zw
+		case "gzip":
+			gz := gzipWriters.Get().(*gzip.Writer)
+			gz.Reset(cw.ResponseWriter)
+			cw.enc = gz
+		default:
+			fl := flateWriters.Get().(*flate.Writer)
+			fl.Reset(cw.ResponseWriter)
+			cw.enc = fl
+		}
+	}
+	if cw.status != 0 {
+		cw.ResponseWriter.WriteHeader(cw.status)
+	}
+
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// Flush sends everything written so far, giving up on compression if the
+// size threshold has not been reached yet
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.start(false)
+	}
+	if cw.enc != nil {
+		cw.enc.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Close finishes the response once the handler has returned
+func (cw *compressWriter) Close() error {
+	// Anything still buffered is below the size threshold
+	if !cw.decided {
+		cw.start(false)
+	}
+	if cw.enc == nil {
+		return nil
+	}
+	err := cw.enc.Close()
+	switch enc := cw.enc.(type) {
+	case *gzip.Writer:
+		gzipWriters.Put(enc)
+	case *flate.Writer:
+		flateWriters.Put(enc)
+	case *zstd.Writer:
+		zstdWriters.Put(enc)
+	}
+	cw.enc = nil
+	return err
+}
+
+// Hijack passes connection takeovers through before anything is written
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	cw.decided = true
+	return http.NewResponseController(cw.ResponseWriter).Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/csv"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dae-go/crud-server/pkg/db"
+	"github.com/dae-go/crud-server/pkg/msgpack"
+)
+
+func TestCORSMiddleware(t *testing.T) {
+	handler := CORSMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}), CORSConfig{AllowedOrigins: []string{"https://app.example.com", " https://*.example.org"}, MaxAge: time.Minute})
+
+	tests := []struct {
+		name       string
+		method     string
+		origin     string
+		preflight  bool
+		wantStatus int
+		wantOrigin string
+	}{
+		{"no origin", http.MethodGet, "", false, http.StatusTeapot, ""},
+		{"allowed", http.MethodGet, "https://app.example.com", false, http.StatusTeapot, "https://app.example.com"},
+		{"wildcard subdomain", http.MethodGet, "https://a.example.org", false, http.StatusTeapot, "https://a.example.org"},
+		{"wildcard needs a subdomain", http.MethodGet, "https://.example.org", false, http.StatusTeapot, ""},
+		{"other origin", http.MethodGet, "https://evil.test", false, http.StatusTeapot, ""},
+		{"preflight", http.MethodOptions, "https://app.example.com", true, http.StatusNoContent, "https://app.example.com"},
+		{"preflight from other origin", http.MethodOptions, "https://evil.test", true, http.StatusNoContent, ""},
+		{"plain options", http.MethodOptions, "https://app.example.com", false, http.StatusTeapot, "https://app.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/tables/users", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight {
+				req.Header.Set("Access-Control-Request-Method", http.MethodPut)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("allowed origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if tt.preflight && tt.wantOrigin != "" {
+				if got := rec.Header().Get("Access-Control-Allow-Methods"); !strings.Contains(got, "PUT") {
+					t.Errorf("allowed methods = %q", got)
+				}
+				if got := rec.Header().Get("Access-Control-Max-Age"); got != "60" {
+					t.Errorf("max age = %q", got)
+				}
+			}
+			if !tt.preflight && tt.wantOrigin != "" && rec.Header().Get("Access-Control-Expose-Headers") != "X-Total-Count" {
+				t.Errorf("exposed headers = %q", rec.Header().Get("Access-Control-Expose-Headers"))
+			}
+		})
+	}
+
+	anyOrigin := CORSMiddleware(http.NotFoundHandler(), CORSConfig{AllowedOrigins: []string{"*"}})
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Origin", "https://x.test")
+	rec := httptest.NewRecorder()
+	anyOrigin.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("wildcard origin = %q, want *", got)
+	}
+
+	withCredentials := CORSMiddleware(http.NotFoundHandler(), CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true})
+	rec = httptest.NewRecorder()
+	withCredentials.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://x.test" || rec.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("credentialed response headers = %v", rec.Header())
+	}
+}
+
+func TestCompressionMiddleware(t *testing.T) {
+	large := strings.Repeat("compress me ", 200)
+	handler := CompressionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small":
+			w.Write([]byte("tiny"))
+		case "/archive":
+			w.Header().Set("Content-Type", "application/gzip")
+			w.Write([]byte(large))
+		case "/created":
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(large[:600]))
+			w.Write([]byte(large[600:]))
+		default:
+			w.Write([]byte(large))
+		}
+	}), DefaultCompressMinSize)
+
+	tests := []struct {
+		name         string
+		path         string
+		accept       string
+		wantEncoding string
+		wantStatus   int
+	}{
+		{"gzip", "/", "gzip, deflate", "gzip", http.StatusOK},
+		{"deflate preferred", "/", "gzip;q=0.5, deflate", "deflate", http.StatusOK},
+		{"zstd", "/", "gzip, deflate, br, zstd", "zstd", http.StatusOK},
+		{"gzip preferred over zstd", "/", "zstd;q=0.5, gzip", "gzip", http.StatusOK},
+		{"wildcard", "/", "*", "gzip", http.StatusOK},
+		{"refused", "/", "gzip;q=0, br", "", http.StatusOK},
+		{"no header", "/", "", "", http.StatusOK},
+		{"too small", "/small", "gzip", "", http.StatusOK},
+		{"already compressed", "/archive", "gzip", "", http.StatusOK},
+		{"status and split writes", "/created", "gzip", "gzip", http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept-Encoding", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Fatalf("encoding = %q, want %q", got, tt.wantEncoding)
+			}
+			var body io.Reader = rec.Body
+			switch tt.wantEncoding {
+			case "gzip":
+				zr, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = zr
+			case "deflate":
+				body = flate.NewReader(rec.Body)
+			case "zstd":
+				// The zstd package tests decoding; here the frame only has
+				// to be one
+				if !bytes.HasPrefix(rec.Body.Bytes(), []byte{0x28, 0xb5, 0x2f, 0xfd}) {
+					t.Fatalf("body is not a zstd frame: % x", rec.Body.Bytes()[:min(rec.Body.Len(), 8)])
+				}
+				return
+			}
+			data, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := large
+			if tt.path == "/small" {
+				want = "tiny"
+			}
+			if string(data) != want {
+				t.Errorf("body = %q", data)
+			}
+		})
+	}
+}
+
+func TestRecordFormats(t *testing.T) {
+	s := New()
+	if err := s.DB.CreateTable(&db.Table{Name: "users", Columns: []db.Column{{Name: "name", Type: "string"}, {Name: "age", Type: "int"}}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []map[string]any{
+		{"name": "Ann, Jr.", "age": 30.0},
+		{"name": "Bob", "tags": []any{"a"}},
+	} {
+		if _, err := s.DB.InsertRecord("users", r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler := s
+
+	get := func(target, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get("/tables/users", "text/html, application/json;q=0.9"); rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("json content type = %q", rec.Header().Get("Content-Type"))
+	}
+
+	rec := get("/tables/users", "application/x-ndjson")
+	if lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n"); len(lines) != 2 || !strings.HasPrefix(lines[0], "{") {
+		t.Errorf("ndjson body = %q", rec.Body.String())
+	}
+
+	rec = get("/tables/users", "application/json;q=0.5, text/csv")
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantRows := [][]string{
+		{"id", "name", "age", "tags"},
+		{"1", "Ann, Jr.", "30", ""},
+		{"2", "Bob", "", `["a"]`},
+	}
+	if !reflect.DeepEqual(rows, wantRows) {
+		t.Errorf("csv rows = %q", rows)
+	}
+
+	rec = get("/tables/users?format=msgpack&where=name=Bob", "")
+	if rec.Header().Get("Content-Type") != "application/msgpack" || rec.Header().Get("X-Total-Count") != "1" {
+		t.Errorf("msgpack headers = %v", rec.Header())
+	}
+	decoded, err := msgpack.Unmarshal(rec.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []any{map[string]any{"id": int64(2), "name": "Bob", "tags": []any{"a"}}}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("msgpack records = %v", decoded)
+	}
+
+	if rec := get("/tables/users", "text/html"); rec.Code != http.StatusNotAcceptable {
+		t.Errorf("unsupported Accept status = %d", rec.Code)
+	}
+	if rec := get("/tables/users?format=xml", ""); rec.Code != http.StatusNotAcceptable {
+		t.Errorf("unsupported format status = %d", rec.Code)
+	}
+	if rec := get("/tables/users", ""); !bytes.HasPrefix(rec.Body.Bytes(), []byte("[")) {
+		t.Errorf("default body = %q", rec.Body.String())
+	}
+}
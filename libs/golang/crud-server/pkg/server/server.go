@@ -0,0 +1,192 @@
+// Package server serves the CRUD API over HTTP. A Server is an http.Handler,
+// so it can run on its own or be mounted inside another service:
+//
+//	srv := server.New(
+//		server.WithPrefix("/crud"),
+//		server.WithMiddleware(server.LoggingMiddleware),
+//		server.WithBeforeHook(requireToken),
+//	)
+//	mux.Handle("/crud/", srv)
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/dae-go/crud-server/internal/graphql"
+	"github.com/dae-go/crud-server/pkg/db"
+)
+
+// Server holds the database and the routes of the API
+type Server struct {
+	DB *db.Database
+
+	prefix     string
+	middleware []Middleware
+	before     []BeforeHook
+	after      []AfterHook
+	auth       Authenticator
+
+	mux     *http.ServeMux
+	handler http.Handler
+}
+
+// Middleware wraps a handler, e.g. to add logging or authentication
+type Middleware func(http.Handler) http.Handler
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithDatabase serves db instead of a new in-memory database. Persist it
+// before passing it in to keep the tables on disk.
+func WithDatabase(d *db.Database) Option {
+	return func(s *Server) {
+		s.DB = d
+	}
+}
+
+// WithPrefix serves the API under prefix, e.g. /crud, so that
+// /crud/tables/users reaches the records of users
+func WithPrefix(prefix string) Option {
+	return func(s *Server) {
+		s.prefix = strings.TrimSuffix(prefix, "/")
+	}
+}
+
+// WithMiddleware wraps every request in middleware. The first one given
+// runs first; it sees the full path, before the prefix is removed.
+func WithMiddleware(middleware ...Middleware) Option {
+	return func(s *Server) {
+		s.middleware = append(s.middleware, middleware...)
+	}
+}
+
+// WithBeforeHook runs hook before each operation. Hooks run in the order
+// they were added, and the first error rejects the request.
+func WithBeforeHook(hook BeforeHook) Option {
+	return func(s *Server) {
+		s.before = append(s.before, hook)
+	}
+}
+
+// WithAfterHook runs hook after each operation, whether it succeeded or not
+func WithAfterHook(hook AfterHook) Option {
+	return func(s *Server) {
+		s.after = append(s.after, hook)
+	}
+}
+
+// New creates a server with the API routes registered
+func New(opts ...Option) *Server {
+	s := &Server{}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.DB == nil {
+		s.DB = db.NewDatabase()
+	}
+
+	s.mux = http.NewServeMux()
+	s.routes()
+
+	var handler http.Handler = http.HandlerFunc(s.serveMux)
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		handler = s.middleware[i](handler)
+	}
+	s.handler = handler
+	return s
+}
+
+// ServeHTTP serves a request through the middleware and routes
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// Handle registers an extra route, or replaces a built-in one, relative to
+// the prefix. It is not safe to call while the server is serving requests.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// HandleFunc registers an extra route function relative to the prefix
+func (s *Server) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	s.mux.HandleFunc(pattern, handler)
+}
+
+// serveMux removes the prefix, authenticates and dispatches to the routes
+func (s *Server) serveMux(w http.ResponseWriter, r *http.Request) {
+	if s.prefix != "" {
+		path, ok := strings.CutPrefix(r.URL.Path, s.prefix)
+		if !ok || (path != "" && path[0] != '/') {
+			http.NotFound(w, r)
+			return
+		}
+		if path == "" {
+			path = "/"
+		}
+
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = path
+		// Keep the escaped form, in which table names may hold slashes
+		r2.URL.RawPath, _ = strings.CutPrefix(r.URL.RawPath, s.prefix)
+		r = r2
+	}
+
+	r, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// routes registers the API on the mux
+func (s *Server) routes() {
+	// Table endpoints
+	s.mux.HandleFunc("/table", s.HandleTable)
+	s.mux.HandleFunc("/table/", s.HandleTableSchema)
+
+	// Table data endpoints - match any path starting with /tables/
+	s.mux.HandleFunc("/tables/", s.HandleTableData)
+
+	// Backup and restore
+	s.mux.HandleFunc("/admin/backup", s.HandleBackup)
+	s.mux.HandleFunc("/admin/restore", s.HandleRestore)
+
+	// Statistics and storage maintenance
+	s.mux.HandleFunc("/admin/stats", s.HandleStats)
+	s.mux.HandleFunc("/admin/compact", s.HandleCompact)
+
+	// GraphQL API generated from the table definitions. Hooks see each
+	// GraphQL request as a whole.
+	gql := graphql.NewHandler(s.DB)
+	s.mux.Handle("/graphql", s.hooked(ActionGraphQL, gql))
+	s.mux.Handle("/graphql/schema", s.hooked(ActionGraphQL, http.HandlerFunc(gql.ServeSDL)))
+
+	// Health check
+	s.mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "healthy"})
+	})
+
+	// Readiness check
+	s.mux.HandleFunc("/ready", s.HandleReady)
+
+	// Root endpoint
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"message":   "CRUD Server API",
+			"version":   "1.0",
+			"endpoints": "See README.md for API documentation",
+		})
+	})
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dae-go/crud-server/pkg/db"
+)
+
+func TestPrefix(t *testing.T) {
+	d := db.NewDatabase()
+	if err := d.CreateTable(&db.Table{Name: "users", Columns: []db.Column{{Name: "name", Type: "string"}}}); err != nil {
+		t.Fatal(err)
+	}
+	s := New(WithDatabase(d), WithPrefix("/crud/"))
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/crud/tables/users", http.StatusOK},
+		{"/crud/table/users", http.StatusOK},
+		{"/crud", http.StatusOK},
+		{"/crud/", http.StatusOK},
+		{"/tables/users", http.StatusNotFound},
+		{"/crudx/tables/users", http.StatusNotFound},
+		{"/crud/nothing", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.status {
+			t.Errorf("GET %s = %d, want %d", tt.path, rec.Code, tt.status)
+		}
+	}
+}
+
+func TestMiddlewareAndRoutes(t *testing.T) {
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name+" "+r.URL.Path)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	s := New(WithPrefix("/api"), WithMiddleware(trace("outer"), trace("inner")))
+	s.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("v2"))
+	})
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/version", nil))
+	if rec.Body.String() != "v2" {
+		t.Errorf("custom route body = %q", rec.Body.String())
+	}
+	if strings.Join(calls, ", ") != "outer /api/version, inner /api/version" {
+		t.Errorf("middleware calls = %v", calls)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	var after []string
+	s := New(
+		WithBeforeHook(func(op *Operation) error {
+			switch {
+			case op.Request.Header.Get("Authorization") == "":
+				return &StatusError{Status: http.StatusUnauthorized, Err: errors.New("missing token")}
+			case op.Action == ActionDeleteTable:
+				return errors.New("tables cannot be deleted")
+			case op.Action == ActionCreateRecord:
+				op.Record["owner"] = op.Request.Header.Get("Authorization")
+			}
+			return nil
+		}),
+		WithAfterHook(func(op *Operation, result any, err error) {
+			entry := string(op.Action) + " " + op.Table
+			if op.ID != "" {
+				entry += "/" + op.ID
+			}
+			if err != nil {
+				entry += " failed"
+			}
+			after = append(after, entry)
+		}),
+	)
+	if err := s.DB.CreateTable(&db.Table{Name: "notes", Columns: []db.Column{{Name: "text", Type: "string"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method, path, body, token string
+		status                    int
+	}{
+		{http.MethodGet, "/tables/notes", "", "", http.StatusUnauthorized},
+		{http.MethodPost, "/tables/notes", `{"text": "hi"}`, "ann", http.StatusCreated},
+		{http.MethodGet, "/tables/notes/1", "", "ann", http.StatusOK},
+		{http.MethodGet, "/tables/notes/2", "", "ann", http.StatusNotFound},
+		{http.MethodDelete, "/table", `{"name": "notes"}`, "ann", http.StatusForbidden},
+		{http.MethodPost, "/graphql", `{"query": "{ __typename }"}`, "", http.StatusUnauthorized},
+		{http.MethodGet, "/health", "", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		if tt.token != "" {
+			req.Header.Set("Authorization", tt.token)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s %s = %d, want %d: %s", tt.method, tt.path, rec.Code, tt.status, rec.Body.String())
+		}
+	}
+
+	record, err := s.DB.GetRecord("notes", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record["owner"] != "ann" {
+		t.Errorf("record = %v, want owner stamped by the hook", record)
+	}
+	want := "create_record notes, get_record notes/1, get_record notes/2 failed"
+	if got := strings.Join(after, ", "); got != want {
+		t.Errorf("after hooks = %q, want %q", got, want)
+	}
+}
+
+func TestRowPolicies(t *testing.T) {
+	s := New(WithAuthenticator(TokenAuthenticator(map[string]db.Subject{
+		"ann-token":  {ID: "ann"},
+		"bob-token":  {ID: "bob"},
+		"root-token": {ID: "root", Admin: true},
+	})))
+
+	do := func(token, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	steps := []struct {
+		token, method, path, body string
+		status                    int
+		contains                  string
+	}{
+		{"ann-token", http.MethodPost, "/table", `{"name": "notes", "columns": [{"name": "owner", "type": "string"}, {"name": "text", "type": "string"}], "policy": {"owner_column": "owner"}}`, http.StatusForbidden, "needs an admin"},
+		{"root-token", http.MethodPost, "/table", `{"name": "notes", "columns": [{"name": "owner", "type": "string"}, {"name": "text", "type": "string"}], "policy": {"owner_column": "owner"}}`, http.StatusCreated, ""},
+		{"wrong-token", http.MethodGet, "/tables/notes", "", http.StatusUnauthorized, "invalid token"},
+		{"", http.MethodPost, "/tables/notes", `{"text": "anonymous"}`, http.StatusForbidden, "authenticated subject"},
+		{"ann-token", http.MethodPost, "/tables/notes", `{"text": "ann's note"}`, http.StatusCreated, `"owner":"ann"`},
+		{"bob-token", http.MethodPost, "/tables/notes", `{"text": "bob's note"}`, http.StatusCreated, `"owner":"bob"`},
+		{"ann-token", http.MethodGet, "/tables/notes", "", http.StatusOK, "ann's note"},
+		{"ann-token", http.MethodGet, "/tables/notes/2", "", http.StatusNotFound, ""},
+		{"ann-token", http.MethodPut, "/tables/notes", `{"id": 2, "text": "hijacked"}`, http.StatusNotFound, ""},
+		{"ann-token", http.MethodPut, "/tables/notes", `{"id": 1, "owner": "bob"}`, http.StatusForbidden, "only admins"},
+		{"ann-token", http.MethodDelete, "/tables/notes", `{"id": 2}`, http.StatusNotFound, ""},
+		{"ann-token", http.MethodPost, "/graphql", `{"query": "{ notes { text } }"}`, http.StatusOK, `[{"text":"ann's note"}]`},
+		{"bob-token", http.MethodGet, "/tables/notes/aggregate", "", http.StatusOK, `"count":1`},
+		{"", http.MethodGet, "/tables/notes", "", http.StatusOK, "[]"},
+		{"root-token", http.MethodGet, "/tables/notes", "", http.StatusOK, "bob's note"},
+		{"bob-token", http.MethodGet, "/admin/backup", "", http.StatusForbidden, ""},
+		{"bob-token", http.MethodGet, "/admin/stats", "", http.StatusForbidden, "needs an admin"},
+		{"bob-token", http.MethodGet, "/table/notes/stats", "", http.StatusForbidden, "needs an admin"},
+		{"root-token", http.MethodGet, "/table/notes/stats", "", http.StatusOK, `"records":2`},
+		{"", http.MethodGet, "/health", "", http.StatusOK, ""},
+	}
+	for i, step := range steps {
+		rec := do(step.token, step.method, step.path, step.body)
+		if rec.Code != step.status || !strings.Contains(rec.Body.String(), step.contains) {
+			t.Errorf("step %d: %s %s as %q = %d %s, want %d containing %q", i, step.method, step.path, step.token, rec.Code, rec.Body.String(), step.status, step.contains)
+		}
+	}
+
+	if rec := do("bob-token", http.MethodGet, "/tables/notes", ""); strings.Contains(rec.Body.String(), "ann's note") {
+		t.Errorf("bob sees ann's note: %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,225 @@
+package zstd
+
+import (
+	"math"
+	"math/bits"
+)
+
+// fseTable is the decoding table of an FSE distribution, with what the
+// encoder needs to produce states the decoder follows
+type fseTable struct {
+	log    uint8
+	symbol []uint8
+	nbBits []uint8
+	base   []uint16
+	// enc holds, by symbol, the state decoding to it that moves to each
+	// next state
+	enc  [][]uint16
+	next []int
+}
+
+// newFSETable returns the table of a normalized distribution
+func newFSETable(norm []int16, log uint8) *fseTable {
+	t := &fseTable{}
+	t.build(norm, log)
+	return t
+}
+
+// build fills the table of a normalized distribution, as the decoder does,
+// reusing the table's buffers. A count of -1 is a symbol with a
+// probability below 1.
+func (t *fseTable) build(norm []int16, log uint8) {
+	size := 1 << log
+	t.log = log
+	t.symbol = resize(t.symbol, size)
+	t.nbBits = resize(t.nbBits, size)
+	t.base = resize(t.base, size)
+	t.next = resize(t.next, len(norm))
+	if len(t.enc) < len(norm) {
+		t.enc = append(t.enc, make([][]uint16, len(norm)-len(t.enc))...)
+	}
+
+	// Low probability symbols take a state each at the end of the table,
+	// and the others are spread over the rest
+	high := size - 1
+	for s, n := range norm {
+		t.next[s] = int(n)
+		if n == -1 {
+			t.symbol[high] = uint8(s)
+			high--
+			t.next[s] = 1
+		}
+	}
+	pos, step, mask := 0, size>>1+size>>3+3, size-1
+	for s, n := range norm {
+		for range max(n, 0) {
+			t.symbol[pos] = uint8(s)
+			pos = (pos + step) & mask
+			for pos > high {
+				pos = (pos + step) & mask
+			}
+		}
+	}
+
+	for state := range size {
+		s := t.symbol[state]
+		x := t.next[s]
+		t.next[s]++
+		nb := int(log) + 1 - bits.Len(uint(x))
+		t.nbBits[state] = uint8(nb)
+		t.base[state] = uint16(x<<nb - size)
+
+		t.enc[s] = resize(t.enc[s], size)
+		for n := range 1 << nb {
+			t.enc[s][int(t.base[state])+n] = uint16(state)
+		}
+	}
+}
+
+func resize[T any](s []T, n int) []T {
+	if cap(s) < n {
+		return make([]T, n)
+	}
+	return s[:n]
+}
+
+// encode writes the bits that take the decoder from the state decoding to
+// sym to the state next, and returns that state
+func (t *fseTable) encode(w *bitWriter, sym uint8, next uint16) uint16 {
+	state := t.enc[sym][next]
+	w.add(uint32(next-t.base[state]), t.nbBits[state])
+	return state
+}
+
+// normalize scales counts, which add up to total, to a distribution over
+// 1<<log states in which every symbol that occurs keeps at least one
+func normalize(norm []int16, counts []uint32, total int, log uint8) {
+	size := 1 << log
+	sum, largest := 0, 0
+	for s, c := range counts {
+		norm[s] = 0
+		if c == 0 {
+			continue
+		}
+		n := max(int((uint64(c)<<log+uint64(total)/2)/uint64(total)), 1)
+		norm[s] = int16(n)
+		sum += n
+		if n > int(norm[largest]) {
+			largest = s
+		}
+	}
+	if sum <= size {
+		norm[largest] += int16(size - sum)
+		return
+	}
+	// Rounding up and the minimum of one overshoot, which the most probable
+	// symbols can spare
+	for ; sum > size; sum-- {
+		largest := 0
+		for s, n := range norm {
+			if n > norm[largest] {
+				largest = s
+			}
+		}
+		norm[largest]--
+	}
+}
+
+// tableLog returns the accuracy of the distribution of total codes up to
+// maxSymbol, as the reference encoder picks it
+func tableLog(total, maxSymbol int, maxLog uint8) uint8 {
+	log := int(maxLog)
+	if bySize := bits.Len(uint(total-1)) - 3; bySize < log {
+		log = bySize
+	}
+	if least := min(bits.Len(uint(total)), bits.Len(uint(maxSymbol))+1); least > log {
+		log = least
+	}
+	return uint8(min(max(log, 5), int(maxLog)))
+}
+
+// cost estimates the bits that coding counts with a distribution takes,
+// or reports false when the distribution lacks one of the symbols
+func cost(counts []uint32, norm []int16, log uint8) (float64, bool) {
+	bits := 0.0
+	for s, c := range counts {
+		switch {
+		case c == 0:
+		case s >= len(norm) || norm[s] == 0:
+			return 0, false
+		case norm[s] == -1:
+			bits += float64(c) * float64(log)
+		default:
+			bits += float64(c) * (float64(log) - math.Log2(float64(norm[s])))
+		}
+	}
+	return bits, true
+}
+
+// appendNCount appends the description of a distribution to dst, as a
+// little-endian bitstream of the counts with runs of absent symbols
+// shortened
+func appendNCount(dst []byte, norm []int16, log uint8) []byte {
+	var bitStream uint64
+	nbStored := uint(4)
+	bitStream = uint64(log - 5)
+	flush := func() {
+		for nbStored >= 8 {
+			dst = append(dst, byte(bitStream))
+			bitStream >>= 8
+			nbStored -= 8
+		}
+	}
+
+	remaining := 1<<log + 1
+	threshold := 1 << log
+	nbBits := uint(log) + 1
+	previous0 := false
+	for s := 0; s < len(norm) && remaining > 1; {
+		if previous0 {
+			// Runs of absent symbols: 2 bit repeat counts, with 3 meaning
+			// more follow
+			start := s
+			for norm[s] == 0 {
+				s++
+			}
+			for s >= start+24 {
+				start += 24
+				bitStream |= 0xffff << nbStored
+				nbStored += 16
+				flush()
+			}
+			for s >= start+3 {
+				start += 3
+				bitStream |= 3 << nbStored
+				nbStored += 2
+			}
+			bitStream |= uint64(s-start) << nbStored
+			nbStored += 2
+			flush()
+		}
+		count := int(norm[s])
+		s++
+		most := 2*threshold - 1 - remaining
+		remaining -= max(count, -count)
+		count++
+		if count >= threshold {
+			count += most
+		}
+		bitStream |= uint64(count) << nbStored
+		nbStored += nbBits
+		if count < most {
+			nbStored--
+		}
+		previous0 = count == 1
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+		flush()
+	}
+	if nbStored > 0 {
+		dst = append(dst, byte(bitStream))
+	}
+	return dst
+}
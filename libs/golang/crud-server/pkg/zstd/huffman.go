@@ -0,0 +1,252 @@
+package zstd
+
+import "sort"
+
+// Literals block types
+const (
+	literalsRaw        = 0
+	literalsRLE        = 1
+	literalsCompressed = 2
+)
+
+const (
+	// maxHuffBits is the longest Huffman code the format allows
+	maxHuffBits = 11
+	// maxDirectSymbol is the largest symbol whose weight can be written
+	// directly; larger ones need FSE coded weights, which are not
+	// implemented, so such literals are sent raw
+	maxDirectSymbol = 128
+	// minHuffLiterals is the fewest literals worth describing a tree for
+	minHuffLiterals = 64
+)
+
+// huffEncoder Huffman codes literals, reusing its buffers between blocks
+type huffEncoder struct {
+	counts  [maxDirectSymbol + 1]uint32
+	lengths [maxDirectSymbol + 1]uint8
+	codes   [maxDirectSymbol + 1]uint16
+	nodes   []huffNode
+	order   []uint8
+	streams []byte
+}
+
+type huffNode struct {
+	count  uint32
+	parent int32
+}
+
+// appendLiterals appends the literals section of a block, Huffman coded
+// when that is smaller
+func (e *huffEncoder) appendLiterals(dst, lits []byte) []byte {
+	if out, ok := e.appendCompressed(dst, lits); ok {
+		return out
+	}
+	if len(lits) > 1 {
+		rle := true
+		for _, c := range lits[1:] {
+			rle = rle && c == lits[0]
+		}
+		if rle {
+			return append(appendLiteralsHeader(dst, literalsRLE, len(lits)), lits[0])
+		}
+	}
+	return append(appendLiteralsHeader(dst, literalsRaw, len(lits)), lits...)
+}
+
+// appendLiteralsHeader appends the header of raw or RLE literals
+func appendLiteralsHeader(dst []byte, blockType, size int) []byte {
+	switch {
+	case size < 1<<5:
+		return append(dst, byte(blockType|size<<3))
+	case size < 1<<12:
+		h := blockType | 1<<2 | size<<4
+		return append(dst, byte(h), byte(h>>8))
+	default:
+		h := blockType | 3<<2 | size<<4
+		return append(dst, byte(h), byte(h>>8), byte(h>>16))
+	}
+}
+
+// appendCompressed appends Huffman coded literals, and reports false when
+// they cannot be coded or would not get smaller
+func (e *huffEncoder) appendCompressed(dst, lits []byte) ([]byte, bool) {
+	if len(lits) < minHuffLiterals {
+		return dst, false
+	}
+	clear(e.counts[:])
+	maxSymbol, distinct := 0, 0
+	for _, c := range lits {
+		if c > maxDirectSymbol {
+			return dst, false
+		}
+		if e.counts[c] == 0 {
+			distinct++
+		}
+		e.counts[c]++
+		maxSymbol = max(maxSymbol, int(c))
+	}
+	if distinct < 2 {
+		return dst, false
+	}
+	maxBits := e.buildCodes(maxSymbol)
+
+	// Tree description: the weights of every symbol below the largest,
+	// whose weight the decoder deduces, two to a byte
+	start := len(dst)
+	header := 5
+	switch {
+	case len(lits) < 1<<10:
+		header = 3
+	case len(lits) < 1<<14:
+		header = 4
+	}
+	dst = append(dst, make([]byte, header)...)
+	dst = append(dst, byte(127+maxSymbol))
+	weight := func(s int) byte {
+		if s >= maxSymbol || e.lengths[s] == 0 {
+			return 0
+		}
+		return byte(maxBits + 1 - int(e.lengths[s]))
+	}
+	for s := 0; s < maxSymbol; s += 2 {
+		dst = append(dst, weight(s)<<4|weight(s+1))
+	}
+
+	// A single stream for short literals, four otherwise, after a table
+	// of the sizes of the first three
+	if header == 3 {
+		e.streams = e.appendStream(e.streams[:0], lits)
+		dst = append(dst, e.streams...)
+	} else {
+		jump := len(dst)
+		dst = append(dst, make([]byte, 6)...)
+		segment := (len(lits) + 3) / 4
+		for i := range 4 {
+			e.streams = e.appendStream(e.streams[:0], lits[min(i*segment, len(lits)):min((i+1)*segment, len(lits))])
+			if i < 3 {
+				if len(e.streams) > 0xffff {
+					return dst[:start], false
+				}
+				dst[jump+2*i], dst[jump+2*i+1] = byte(len(e.streams)), byte(len(e.streams)>>8)
+			}
+			dst = append(dst, e.streams...)
+		}
+	}
+
+	compressed := len(dst) - start - header
+	if compressed >= len(lits) {
+		return dst[:start], false
+	}
+	// Size format 0 is a single stream with 10 bit sizes, 1 to 3 are four
+	// streams with 10, 14 and 18 bit sizes
+	var h uint64
+	switch header {
+	case 3:
+		h = literalsCompressed | uint64(len(lits))<<4 | uint64(compressed)<<14
+	case 4:
+		h = literalsCompressed | 2<<2 | uint64(len(lits))<<4 | uint64(compressed)<<18
+	default:
+		h = literalsCompressed | 3<<2 | uint64(len(lits))<<4 | uint64(compressed)<<22
+	}
+	for i := range header {
+		dst[start+i] = byte(h >> (8 * i))
+	}
+	return dst, true
+}
+
+// appendStream appends lits as a Huffman coded bitstream. The decoder reads
+// it backward, so the first literal is written last.
+func (e *huffEncoder) appendStream(dst, lits []byte) []byte {
+	w := bitWriter{out: dst}
+	for i := len(lits) - 1; i >= 0; i-- {
+		c := lits[i]
+		w.add(uint32(e.codes[c]), e.lengths[c])
+	}
+	return w.close()
+}
+
+// buildCodes computes code lengths of at most maxHuffBits from the counts
+// and assigns the codes, returning the longest length
+func (e *huffEncoder) buildCodes(maxSymbol int) int {
+	counts := e.counts[:maxSymbol+1]
+	maxBits := e.buildLengths(counts)
+	// Flattening the counts until the lengths fit loses little, since only
+	// rare symbols get such long codes
+	for maxBits > maxHuffBits {
+		for s, c := range counts {
+			if c > 0 {
+				counts[s] = c>>1 | 1
+			}
+		}
+		maxBits = e.buildLengths(counts)
+	}
+
+	// The decoder assigns codes in order of increasing weight, so
+	// decreasing length, then symbol, counting up from zero
+	e.order = e.order[:0]
+	for s, c := range counts {
+		if c > 0 {
+			e.order = append(e.order, uint8(s))
+		}
+	}
+	sort.SliceStable(e.order, func(i, j int) bool {
+		return e.lengths[e.order[i]] > e.lengths[e.order[j]]
+	})
+	next := 0
+	for _, s := range e.order {
+		shift := maxBits - int(e.lengths[s])
+		e.codes[s] = uint16(next >> shift)
+		next += 1 << shift
+	}
+	return maxBits
+}
+
+// buildLengths sets the Huffman code length of every symbol with a count
+// and returns the longest
+func (e *huffEncoder) buildLengths(counts []uint32) int {
+	clear(e.lengths[:])
+	e.order = e.order[:0]
+	for s, c := range counts {
+		if c > 0 {
+			e.order = append(e.order, uint8(s))
+		}
+	}
+	sort.SliceStable(e.order, func(i, j int) bool {
+		return counts[e.order[i]] < counts[e.order[j]]
+	})
+
+	// Merge the two lightest of the remaining leaves and inner nodes, which
+	// come out in increasing order, so two queues replace a heap
+	n := len(e.order)
+	e.nodes = e.nodes[:0]
+	for _, s := range e.order {
+		e.nodes = append(e.nodes, huffNode{count: counts[s]})
+	}
+	leaf, inner := 0, n
+	lightest := func() int {
+		if leaf < n && (inner >= len(e.nodes) || e.nodes[leaf].count <= e.nodes[inner].count) {
+			leaf++
+			return leaf - 1
+		}
+		inner++
+		return inner - 1
+	}
+	for len(e.nodes) < 2*n-1 {
+		a, b := lightest(), lightest()
+		e.nodes[a].parent = int32(len(e.nodes))
+		e.nodes[b].parent = int32(len(e.nodes))
+		e.nodes = append(e.nodes, huffNode{count: e.nodes[a].count + e.nodes[b].count})
+	}
+
+	// Depths, from the root down
+	depth := make([]uint8, len(e.nodes))
+	maxBits := 0
+	for i := len(e.nodes) - 2; i >= 0; i-- {
+		depth[i] = depth[e.nodes[i].parent] + 1
+		if i < n {
+			e.lengths[e.order[i]] = depth[i]
+			maxBits = max(maxBits, int(depth[i]))
+		}
+	}
+	return maxBits
+}
@@ -0,0 +1,197 @@
+package zstd
+
+import (
+	"math"
+	"math/bits"
+)
+
+// Sequence code compression modes
+const (
+	modePredefined = 0
+	modeRLE        = 1
+	modeCompressed = 2
+)
+
+// The predefined distributions of literal lengths, match lengths and offsets
+var (
+	llNorm = []int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	}
+	mlNorm = []int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	}
+	ofNorm = []int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	}
+
+	llTable = newFSETable(llNorm, 6)
+	mlTable = newFSETable(mlNorm, 6)
+	ofTable = newFSETable(ofNorm, 5)
+)
+
+// Baselines and extra bits of the literal length codes above 15 and of the
+// match length codes above 31, which code their values directly below that.
+// Match lengths are coded minus 3, the shortest match.
+var (
+	llBase = []uint32{16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096, 8192, 16384, 32768, 65536}
+	llBits = []uint8{1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	mlBase = []uint32{32, 34, 36, 38, 40, 44, 48, 56, 64, 80, 96, 128, 256, 512, 1024, 2048, 4096, 8192, 16384, 32768, 65536}
+	mlBits = []uint8{1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+)
+
+// lengthCode returns the code of a length, its extra bits and their count
+func lengthCode(v uint32, direct uint32, base []uint32, nbits []uint8) (uint8, uint32, uint8) {
+	if v < direct {
+		return uint8(v), 0, 0
+	}
+	i := len(base) - 1
+	for base[i] > v {
+		i--
+	}
+	return uint8(direct) + uint8(i), v - base[i], nbits[i]
+}
+
+// codeTable picks the distribution that one kind of sequence code is coded
+// with in a block, reusing its buffers between blocks
+type codeTable struct {
+	predefined *fseTable
+	predefNorm []int16
+	maxLog     uint8
+
+	codes  []uint8
+	counts []uint32
+	norm   []int16
+	custom fseTable
+	// table is the distribution picked for the block
+	table *fseTable
+}
+
+func newCodeTable(predefined *fseTable, predefNorm []int16, maxLog uint8) codeTable {
+	return codeTable{
+		predefined: predefined,
+		predefNorm: predefNorm,
+		maxLog:     maxLog,
+		counts:     make([]uint32, len(predefNorm)),
+		norm:       make([]int16, len(predefNorm)),
+	}
+}
+
+// choose picks the cheapest mode for the block's codes: the predefined
+// distribution, a single repeated code, or a distribution fitted to the
+// codes, whose description it appends to dst
+func (c *codeTable) choose(dst []byte) ([]byte, uint8) {
+	clear(c.counts)
+	maxSymbol := 0
+	for _, s := range c.codes {
+		c.counts[s]++
+		maxSymbol = max(maxSymbol, int(s))
+	}
+	counts, norm := c.counts[:maxSymbol+1], c.norm[:maxSymbol+1]
+
+	first := c.codes[0]
+	if int(c.counts[first]) == len(c.codes) {
+		clear(norm)
+		norm[first] = 1
+		c.custom.build(norm, 0)
+		c.table = &c.custom
+		return append(dst, first), modeRLE
+	}
+
+	predefined, ok := cost(counts, c.predefNorm, c.predefined.log)
+	if !ok {
+		predefined = math.Inf(1)
+	}
+	log := tableLog(len(c.codes), maxSymbol, c.maxLog)
+	normalize(norm, counts, len(c.codes), log)
+	fitted, _ := cost(counts, norm, log)
+	out := appendNCount(dst, norm, log)
+	if fitted+float64(8*(len(out)-len(dst))) < predefined {
+		c.custom.build(norm, log)
+		c.table = &c.custom
+		return out, modeCompressed
+	}
+	c.table = c.predefined
+	return dst, modePredefined
+}
+
+// seqEncoder codes the sequences section of blocks
+type seqEncoder struct {
+	ll, ml, of codeTable
+}
+
+func newSeqEncoder() seqEncoder {
+	return seqEncoder{
+		ll: newCodeTable(llTable, llNorm, 9),
+		ml: newCodeTable(mlTable, mlNorm, 9),
+		of: newCodeTable(ofTable, ofNorm, 8),
+	}
+}
+
+// appendSequences appends the sequences section of a block
+func (e *seqEncoder) appendSequences(dst []byte, seqs []sequence) []byte {
+	n := len(seqs)
+	switch {
+	case n < 128:
+		dst = append(dst, byte(n))
+	case n < 0x7f00:
+		dst = append(dst, byte(n>>8+128), byte(n))
+	default:
+		dst = append(dst, 255, byte(n-0x7f00), byte((n-0x7f00)>>8))
+	}
+	if n == 0 {
+		return dst
+	}
+
+	e.ll.codes, e.ml.codes, e.of.codes = e.ll.codes[:0], e.ml.codes[:0], e.of.codes[:0]
+	for _, s := range seqs {
+		llCode, _, _ := lengthCode(s.litLen, 16, llBase, llBits)
+		mlCode, _, _ := lengthCode(s.matchLen-3, 32, mlBase, mlBits)
+		e.ll.codes = append(e.ll.codes, llCode)
+		e.ml.codes = append(e.ml.codes, mlCode)
+		e.of.codes = append(e.of.codes, uint8(bits.Len32(s.offValue)-1))
+	}
+	// The modes, then the descriptions of the distributions that need one
+	modes := len(dst)
+	dst = append(dst, 0)
+	dst, llMode := e.ll.choose(dst)
+	dst, ofMode := e.of.choose(dst)
+	dst, mlMode := e.ml.choose(dst)
+	dst[modes] = llMode<<6 | ofMode<<4 | mlMode<<2
+	ll, ml, of := e.ll.table, e.ml.table, e.of.table
+
+	// The decoder reads the initial states, then for each sequence the
+	// extra bits of its offset, match length and literal length followed by
+	// the state updates of the next one. Written backward, that starts from
+	// the last sequence.
+	w := bitWriter{out: dst}
+	var llState, mlState, ofState uint16
+	for i := n - 1; i >= 0; i-- {
+		s := seqs[i]
+		llCode, llExtra, llN := lengthCode(s.litLen, 16, llBase, llBits)
+		mlCode, mlExtra, mlN := lengthCode(s.matchLen-3, 32, mlBase, mlBits)
+		ofCode := e.of.codes[i]
+
+		if i == n-1 {
+			llState = ll.enc[llCode][0]
+			mlState = ml.enc[mlCode][0]
+			ofState = of.enc[ofCode][0]
+		} else {
+			ofState = of.encode(&w, ofCode, ofState)
+			mlState = ml.encode(&w, mlCode, mlState)
+			llState = ll.encode(&w, llCode, llState)
+		}
+		w.add(llExtra, llN)
+		w.add(mlExtra, mlN)
+		w.add(s.offValue-1<<ofCode, ofCode)
+	}
+	w.add(uint32(mlState), ml.log)
+	w.add(uint32(ofState), of.log)
+	w.add(uint32(llState), ll.log)
+	return w.close()
+}
@@ -0,0 +1,394 @@
+// Package zstd writes Zstandard frames (RFC 8878), for compressing HTTP
+// responses without external dependencies. It favors a small encoder over
+// the ratio of the reference implementation: matches are found with short
+// hash chains, ASCII literals are Huffman coded and sequences are FSE coded
+// with the predefined distributions or ones fitted to each block. Decoding
+// is not implemented.
+package zstd
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+)
+
+const (
+	frameMagic = 0xFD2FB528
+	windowLog  = 17
+	windowSize = 1 << windowLog
+	// maxBlockSize is the largest block the format allows
+	maxBlockSize = 128 << 10
+	minMatch     = 4
+	hashLog      = 15
+	// maxChain is how many earlier positions with the same hash are tried
+	maxChain = 16
+	// goodMatch is the length that stops the search early
+	goodMatch = 64
+)
+
+// Block types
+const (
+	blockRaw        = 0
+	blockCompressed = 2
+)
+
+var errClosed = errors.New("zstd: write after Close")
+
+// sequence is a run of literals followed by a match. The offset is coded:
+// 1 to 3 pick a repeat offset, and larger values are the distance plus 3.
+type sequence struct {
+	litLen   uint32
+	matchLen uint32
+	offValue uint32
+}
+
+// repeats holds the three most recent offsets, which sequences can refer to
+// instead of repeating the distance
+type repeats [3]uint32
+
+// initialRepeats are the repeat offsets at the start of a frame
+var initialRepeats = repeats{1, 4, 8}
+
+// code returns the offset value of a match at offset after litLen literals
+func (r repeats) code(offset, litLen uint32) uint32 {
+	if litLen > 0 {
+		for i, o := range r {
+			if o == offset {
+				return uint32(i) + 1
+			}
+		}
+	} else {
+		// Without literals, the first repeat would make an empty sequence
+		// pointless, so the values shift by one
+		switch offset {
+		case r[1]:
+			return 1
+		case r[2]:
+			return 2
+		case r[0] - 1:
+			return 3
+		}
+	}
+	return offset + 3
+}
+
+// update records the use of offset with its offset value, as the decoder
+// does
+func (r *repeats) update(offset, offValue, litLen uint32) {
+	i := offValue - 1
+	if litLen == 0 {
+		i++
+	}
+	switch {
+	case offValue > 3 || i >= 2:
+		r[0], r[1], r[2] = offset, r[0], r[1]
+	case i == 1:
+		r[0], r[1] = r[1], r[0]
+	}
+}
+
+// Writer compresses what is written to it into a single Zstandard frame.
+// Data is written in blocks of up to 128 KiB; Flush writes a shorter block.
+type Writer struct {
+	w       io.Writer
+	err     error
+	started bool // the frame header was written
+	closed  bool
+
+	// hist holds at least a window of data already written as blocks,
+	// when there is that much, followed by the data of the next block from
+	// pending on
+	hist    []byte
+	pending int
+	// head maps the hash of 4 bytes to their last position in hist, plus
+	// one, and chain links each position to the previous one with the same
+	// hash, indexed by position modulo the window size
+	head  []int32
+	chain []int32
+	// indexed is the position up to which hist is in the hash chains
+	indexed int
+	reps    repeats
+
+	seqs []sequence
+	lits []byte
+	huff huffEncoder
+	seq  seqEncoder
+	out  []byte
+}
+
+// NewWriter returns a Writer compressing to w. It must be closed to finish
+// the frame.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		w:     w,
+		head:  make([]int32, 1<<hashLog),
+		chain: make([]int32, windowSize),
+		reps:  initialRepeats,
+		seq:   newSeqEncoder(),
+	}
+}
+
+// Reset discards the Writer's state and makes it write a new frame to w, so
+// it can be reused
+func (z *Writer) Reset(w io.Writer) {
+	z.w, z.err, z.started, z.closed = w, nil, false, false
+	z.hist, z.pending, z.indexed, z.reps = z.hist[:0], 0, 0, initialRepeats
+	// The chain is only followed from positions indexed in the new frame,
+	// which overwrite their links first
+	clear(z.head)
+}
+
+// Write compresses p, writing full blocks as they fill up
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, errClosed
+	}
+	if z.err != nil {
+		return 0, z.err
+	}
+	written := 0
+	for len(p) > 0 {
+		n := min(maxBlockSize-(len(z.hist)-z.pending), len(p))
+		z.hist = append(z.hist, p[:n]...)
+		p = p[n:]
+		if len(z.hist)-z.pending == maxBlockSize {
+			if err := z.writeBlock(false); err != nil {
+				return written, err
+			}
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// Flush writes the data written so far as a block, so it can be decoded
+// before the frame ends
+func (z *Writer) Flush() error {
+	if z.closed {
+		return errClosed
+	}
+	if z.err != nil || len(z.hist) == z.pending {
+		return z.err
+	}
+	return z.writeBlock(false)
+}
+
+// Close writes the remaining data and ends the frame. It does not close
+// the underlying writer.
+func (z *Writer) Close() error {
+	if z.closed || z.err != nil {
+		return z.err
+	}
+	z.closed = true
+	return z.writeBlock(true)
+}
+
+// writeBlock compresses the pending data into a block and writes it
+func (z *Writer) writeBlock(last bool) error {
+	out := z.out[:0]
+	if !z.started {
+		z.started = true
+		out = binary.LittleEndian.AppendUint32(out, frameMagic)
+		// No content size, checksum or dictionary; the window descriptor
+		// holds the exponent of windowLog over 10
+		out = append(out, 0, (windowLog-10)<<3)
+	}
+	out = z.appendBlock(out, last)
+	z.out = out
+	z.pending = len(z.hist)
+	z.slide()
+
+	if _, err := z.w.Write(out); err != nil {
+		z.err = err
+	}
+	return z.err
+}
+
+// slide drops data that has left the window, by whole windows so that
+// positions keep their place in the chain
+func (z *Writer) slide() {
+	drop := (z.pending - windowSize) &^ (windowSize - 1)
+	if drop <= 0 {
+		return
+	}
+	z.hist = z.hist[:copy(z.hist, z.hist[drop:])]
+	z.pending -= drop
+	z.indexed -= drop
+	for _, table := range [][]int32{z.head, z.chain} {
+		for i, v := range table {
+			table[i] = max(v-int32(drop), 0)
+		}
+	}
+}
+
+// appendBlock appends the pending data as a compressed block, or as a raw
+// block when compression does not make it smaller
+func (z *Writer) appendBlock(dst []byte, last bool) []byte {
+	src := z.hist[z.pending:]
+	start := len(dst)
+	dst = append(dst, 0, 0, 0)
+	blockType := blockRaw
+	if len(src) > 0 {
+		reps := z.reps
+		z.parse()
+		dst = z.huff.appendLiterals(dst, z.lits)
+		dst = z.seq.appendSequences(dst, z.seqs)
+		if len(dst)-start-3 < len(src) {
+			blockType = blockCompressed
+		} else {
+			// The decoder never sees these sequences
+			dst = dst[:start+3]
+			z.reps = reps
+		}
+	}
+	if blockType == blockRaw {
+		dst = append(dst, src...)
+	}
+
+	header := uint32(blockType<<1 | (len(dst)-start-3)<<3)
+	if last {
+		header |= 1
+	}
+	dst[start], dst[start+1], dst[start+2] = byte(header), byte(header>>8), byte(header>>16)
+	return dst
+}
+
+func load32(b []byte, i int) uint32 {
+	return binary.LittleEndian.Uint32(b[i:])
+}
+
+func hash4(v uint32) uint32 {
+	return v * 2654435761 >> (32 - hashLog)
+}
+
+// index adds the positions up to end to the hash chains
+func (z *Writer) index(end int) {
+	end = min(end, len(z.hist)-minMatch+1)
+	for ; z.indexed < end; z.indexed++ {
+		h := hash4(load32(z.hist, z.indexed))
+		z.chain[z.indexed&(windowSize-1)] = z.head[h]
+		z.head[h] = int32(z.indexed + 1)
+	}
+}
+
+// matchLen returns how many bytes at a and b are equal, up to the end of
+// hist
+func (z *Writer) matchLen(a, b int) int {
+	n := 0
+	for b+n+8 <= len(z.hist) {
+		if diff := binary.LittleEndian.Uint64(z.hist[a+n:]) ^ binary.LittleEndian.Uint64(z.hist[b+n:]); diff != 0 {
+			return n + bits.TrailingZeros64(diff)/8
+		}
+		n += 8
+	}
+	for b+n < len(z.hist) && z.hist[a+n] == z.hist[b+n] {
+		n++
+	}
+	return n
+}
+
+// findMatch returns the longest match for the data at i and its offset,
+// trying the repeat offsets and then earlier positions with the same hash
+func (z *Writer) findMatch(i int) (int, uint32) {
+	best, offset := 0, uint32(0)
+	for _, o := range z.reps {
+		if int(o) <= i {
+			if n := z.matchLen(i-int(o), i); n > best {
+				best, offset = n, o
+			}
+		}
+	}
+	// A repeat offset is cheaper than a match a byte longer
+	bonus := 0
+	if best >= minMatch {
+		bonus = 1
+	}
+
+	z.index(i)
+	cand := int(z.head[hash4(load32(z.hist, i))]) - 1
+	for range maxChain {
+		if cand < 0 || i-cand > windowSize || best >= goodMatch {
+			break
+		}
+		// Only a candidate matching the byte past the best can beat it
+		if i+best >= len(z.hist) || z.hist[cand+best] == z.hist[i+best] {
+			if n := z.matchLen(cand, i); n > best+bonus {
+				best, offset, bonus = n, uint32(i-cand), 0
+			}
+		}
+		prev := int(z.chain[cand&(windowSize-1)]) - 1
+		if prev >= cand {
+			break
+		}
+		cand = prev
+	}
+	if best < minMatch {
+		return 0, 0
+	}
+	return best, offset
+}
+
+// parse splits the pending data into sequences, matching earlier data in
+// the window, and the literals between matches
+func (z *Writer) parse() {
+	z.seqs, z.lits = z.seqs[:0], z.lits[:0]
+	hist, end := z.hist, len(z.hist)
+	litStart := z.pending
+	for i := z.pending; i+minMatch <= end; {
+		n, offset := z.findMatch(i)
+		if n == 0 {
+			// Step faster through data that does not compress
+			i += 1 + (i-litStart)>>6
+			continue
+		}
+		// A longer match one byte later is worth a literal
+		if i+1+minMatch <= end {
+			if n1, offset1 := z.findMatch(i + 1); n1 > n+1 {
+				i, n, offset = i+1, n1, offset1
+			}
+		}
+		for i > litStart && i > int(offset) && hist[i-1] == hist[i-1-int(offset)] {
+			i, n = i-1, n+1
+		}
+
+		litLen := uint32(i - litStart)
+		offValue := z.reps.code(offset, litLen)
+		z.reps.update(offset, offValue, litLen)
+		z.lits = append(z.lits, hist[litStart:i]...)
+		z.seqs = append(z.seqs, sequence{litLen: litLen, matchLen: uint32(n), offValue: offValue})
+		i += n
+		litStart = i
+		z.index(i)
+	}
+	z.index(end)
+	z.lits = append(z.lits, hist[litStart:end]...)
+}
+
+// bitWriter writes the backward bitstreams of Huffman and FSE coded data:
+// the decoder reads the last value written first
+type bitWriter struct {
+	out  []byte
+	bits uint64
+	n    uint
+}
+
+func (b *bitWriter) add(v uint32, n uint8) {
+	b.bits |= uint64(v) & (1<<n - 1) << b.n
+	b.n += uint(n)
+	for b.n >= 8 {
+		b.out = append(b.out, byte(b.bits))
+		b.bits >>= 8
+		b.n -= 8
+	}
+}
+
+// close ends the stream with the marker bit the decoder starts from
+func (b *bitWriter) close() []byte {
+	b.add(1, 1)
+	if b.n > 0 {
+		b.out = append(b.out, byte(b.bits))
+	}
+	b.bits, b.n = 0, 0
+	return b.out
+}
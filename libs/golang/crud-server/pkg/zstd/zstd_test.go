@@ -0,0 +1,504 @@
+package zstd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// testInputs covers empty, incompressible, repetitive and text data, and
+// sizes crossing the block and window limits
+func testInputs() map[string][]byte {
+	rng := rand.New(rand.NewSource(1))
+	random := make([]byte, 300<<10)
+	rng.Read(random)
+
+	var records strings.Builder
+	for i := range 5000 {
+		fmt.Fprintf(&records, `{"id":%d,"name":"user-%d","email":"user%d@example.com","active":%t,"score":%d},`, i, i, i*7, i%3 == 0, rng.Intn(1000))
+	}
+	var words strings.Builder
+	vocabulary := strings.Fields("the quick brown fox jumps over lazy dog zstd frame block literal sequence offset match héllo wörld")
+	for range 40000 {
+		words.WriteString(vocabulary[rng.Intn(len(vocabulary))])
+		words.WriteByte(" \n"[rng.Intn(8)/7])
+	}
+
+	return map[string][]byte{
+		"empty":   nil,
+		"byte":    []byte("x"),
+		"short":   []byte("hello, hello, hello"),
+		"ascii":   []byte(strings.Repeat("abcdefghijklmnopqrstuvwxyz0123456789", 40)),
+		"same":    bytes.Repeat([]byte{'a'}, 200<<10),
+		"random":  random,
+		"records": []byte(records.String()),
+		"words":   []byte(words.String()),
+		"binary":  append(bytes.Repeat([]byte{0, 1, 2, 250, 251}, 5000), random[:5000]...),
+	}
+}
+
+func compress(t testing.TB, data []byte, chunk int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for len(data) > 0 {
+		n := min(chunk, len(data))
+		if _, err := w.Write(data[:n]); err != nil {
+			t.Fatal(err)
+		}
+		data = data[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestWriter(t *testing.T) {
+	for name, data := range testInputs() {
+		for _, chunk := range []int{1 << 20, 1000} {
+			t.Run(fmt.Sprintf("%s/%d", name, chunk), func(t *testing.T) {
+				frame := compress(t, data, chunk)
+				got, err := decode(frame)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, data) {
+					t.Fatalf("decoded %d bytes that differ from the %d written", len(got), len(data))
+				}
+				if name == "records" || name == "same" {
+					if ratio := float64(len(frame)) / float64(len(data)); ratio > 0.3 {
+						t.Errorf("compressed to %.0f%%", ratio*100)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestWriterFlushAndReset(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Write([]byte(strings.Repeat("first part ", 100)))
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	flushed := buf.Len()
+	if flushed == 0 {
+		t.Fatal("Flush wrote nothing")
+	}
+	if err := w.Flush(); err != nil || buf.Len() != flushed {
+		t.Errorf("empty Flush wrote %d bytes, error %v", buf.Len()-flushed, err)
+	}
+	w.Write([]byte(strings.Repeat("second part ", 100)))
+	w.Close()
+	if _, err := w.Write([]byte("late")); err == nil {
+		t.Error("Write after Close succeeded")
+	}
+
+	got, err := decode(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Repeat("first part ", 100) + strings.Repeat("second part ", 100); string(got) != want {
+		t.Errorf("decoded %q", got)
+	}
+
+	var again bytes.Buffer
+	w.Reset(&again)
+	w.Write([]byte("after reset"))
+	w.Close()
+	if got, err := decode(again.Bytes()); err != nil || string(got) != "after reset" {
+		t.Errorf("after Reset decoded %q, %v", got, err)
+	}
+}
+
+// TestReferenceDecoder checks frames against the zstd command when it is
+// installed
+func TestReferenceDecoder(t *testing.T) {
+	path, err := exec.LookPath("zstd")
+	if err != nil {
+		t.Skip("zstd is not installed")
+	}
+	for name, data := range testInputs() {
+		t.Run(name, func(t *testing.T) {
+			cmd := exec.Command(path, "-d", "-c", "-q")
+			cmd.Stdin = bytes.NewReader(compress(t, data, 1000))
+			var stderr bytes.Buffer
+			cmd.Stderr = &stderr
+			got, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("%v: %s", err, stderr.Bytes())
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("zstd decoded %d bytes that differ from the %d written", len(got), len(data))
+			}
+		})
+	}
+}
+
+func BenchmarkWriter(b *testing.B) {
+	data := testInputs()["records"]
+	w := NewWriter(nil)
+	var buf bytes.Buffer
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for range b.N {
+		buf.Reset()
+		w.Reset(&buf)
+		w.Write(data)
+		w.Close()
+	}
+}
+
+// decode decodes a frame of the kind Writer produces: blocks of any type,
+// raw, RLE or Huffman coded literals with directly written weights, and
+// sequences with predefined, RLE or described distributions
+func decode(frame []byte) ([]byte, error) {
+	if len(frame) < 6 || binary.LittleEndian.Uint32(frame) != frameMagic {
+		return nil, errors.New("bad magic")
+	}
+	if frame[4] != 0 {
+		return nil, fmt.Errorf("unexpected frame header descriptor %#x", frame[4])
+	}
+	window := 1 << (10 + frame[5]>>3)
+	data := frame[6:]
+
+	var out []byte
+	reps := [3]int{1, 4, 8}
+	for {
+		if len(data) < 3 {
+			return nil, errors.New("truncated block header")
+		}
+		header := int(data[0]) | int(data[1])<<8 | int(data[2])<<16
+		last, blockType, size := header&1 == 1, header>>1&3, header>>3
+		data = data[3:]
+		if size > maxBlockSize {
+			return nil, fmt.Errorf("block of %d bytes", size)
+		}
+		switch blockType {
+		case blockRaw:
+			if len(data) < size {
+				return nil, errors.New("truncated raw block")
+			}
+			out = append(out, data[:size]...)
+		case 1:
+			out = append(out, bytes.Repeat(data[:1], size)...)
+			size = 1
+		case blockCompressed:
+			if len(data) < size {
+				return nil, errors.New("truncated compressed block")
+			}
+			var err error
+			if out, err = decodeBlock(out, data[:size], window, &reps); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, errors.New("reserved block type")
+		}
+		data = data[size:]
+		if last {
+			break
+		}
+	}
+	if len(data) != 0 {
+		return nil, fmt.Errorf("%d bytes after the last block", len(data))
+	}
+	return out, nil
+}
+
+func decodeBlock(out, block []byte, window int, reps *[3]int) ([]byte, error) {
+	lits, rest, err := decodeLiterals(block)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rest) == 0 {
+		return nil, errors.New("missing sequences section")
+	}
+	n := int(rest[0])
+	switch {
+	case n == 0:
+		return append(out, lits...), nil
+	case n == 255:
+		n, rest = int(rest[1])|int(rest[2])<<8+0x7f00, rest[3:]
+	case n >= 128:
+		n, rest = (n-128)<<8|int(rest[1]), rest[2:]
+	default:
+		rest = rest[1:]
+	}
+	modes := rest[0]
+	rest = rest[1:]
+	llTable, rest, err := decodeTable(rest, modes>>6, llTable, 9)
+	if err != nil {
+		return nil, err
+	}
+	ofTable, rest, err := decodeTable(rest, modes>>4&3, ofTable, 8)
+	if err != nil {
+		return nil, err
+	}
+	mlTable, rest, err := decodeTable(rest, modes>>2&3, mlTable, 9)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newBitReader(rest)
+	if err != nil {
+		return nil, err
+	}
+
+	llState, ofState, mlState := r.read(llTable.log), r.read(ofTable.log), r.read(mlTable.log)
+	for i := range n {
+		llCode, ofCode, mlCode := llTable.symbol[llState], ofTable.symbol[ofState], mlTable.symbol[mlState]
+		ofValue := 1<<ofCode + r.read(ofCode)
+		matchLen := uint32(mlCode) + 3
+		if mlCode >= 32 {
+			matchLen = mlBase[mlCode-32] + 3 + r.read(mlBits[mlCode-32])
+		}
+		litLen := uint32(llCode)
+		if llCode >= 16 {
+			litLen = llBase[llCode-16] + r.read(llBits[llCode-16])
+		}
+		if i < n-1 {
+			llState = uint32(llTable.base[llState]) + r.read(llTable.nbBits[llState])
+			mlState = uint32(mlTable.base[mlState]) + r.read(mlTable.nbBits[mlState])
+			ofState = uint32(ofTable.base[ofState]) + r.read(ofTable.nbBits[ofState])
+		}
+
+		offset := int(ofValue - 3)
+		if ofValue <= 3 {
+			i := int(ofValue) - 1
+			if litLen == 0 {
+				i++
+			}
+			if i == 3 {
+				offset = reps[0] - 1
+			} else {
+				offset = reps[i]
+			}
+			switch {
+			case i == 1:
+				reps[0], reps[1] = reps[1], reps[0]
+			case i >= 2:
+				reps[0], reps[1], reps[2] = offset, reps[0], reps[1]
+			}
+		} else {
+			reps[0], reps[1], reps[2] = offset, reps[0], reps[1]
+		}
+		if int(litLen) > len(lits) || offset > len(out)+int(litLen) || offset > window {
+			return nil, fmt.Errorf("sequence %d out of range", i)
+		}
+		out = append(out, lits[:litLen]...)
+		lits = lits[litLen:]
+		for range matchLen {
+			out = append(out, out[len(out)-offset])
+		}
+	}
+	if r.pos != 0 {
+		return nil, fmt.Errorf("%d bits left in the sequences", r.pos)
+	}
+	return append(out, lits...), nil
+}
+
+// decodeTable returns the distribution of one kind of sequence code given
+// its mode, reading its description from data
+func decodeTable(data []byte, mode uint8, predefined *fseTable, maxLog uint8) (*fseTable, []byte, error) {
+	switch mode {
+	case modePredefined:
+		return predefined, data, nil
+	case modeRLE:
+		norm := make([]int16, int(data[0])+1)
+		norm[data[0]] = 1
+		return newFSETable(norm, 0), data[1:], nil
+	case modeCompressed:
+	default:
+		return nil, nil, errors.New("repeat mode is not supported")
+	}
+
+	// The description is read forward, in the reverse of appendNCount
+	var pos uint
+	read := func(n uint) int {
+		v := 0
+		for i := range n {
+			if bit := pos + i; int(bit/8) < len(data) {
+				v |= int(data[bit/8]>>(bit%8)&1) << i
+			}
+		}
+		return v
+	}
+	log := uint8(read(4) + 5)
+	pos += 4
+	if log > maxLog {
+		return nil, nil, fmt.Errorf("accuracy log %d", log)
+	}
+	var norm []int16
+	remaining, threshold, nbBits := 1<<log+1, 1<<log, uint(log)+1
+	previous0 := false
+	for remaining > 1 {
+		if previous0 {
+			for {
+				repeat := read(2)
+				pos += 2
+				norm = append(norm, make([]int16, repeat)...)
+				if repeat < 3 {
+					break
+				}
+			}
+		}
+		most := 2*threshold - 1 - remaining
+		v := read(nbBits)
+		count := v & (threshold - 1)
+		if count < most {
+			pos += nbBits - 1
+		} else {
+			count = v & (2*threshold - 1)
+			if count >= threshold {
+				count -= most
+			}
+			pos += nbBits
+		}
+		count--
+		remaining -= max(count, -count)
+		norm = append(norm, int16(count))
+		previous0 = count == 0
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+	if remaining != 1 {
+		return nil, nil, errors.New("distribution does not add up")
+	}
+	return newFSETable(norm, log), data[(pos+7)/8:], nil
+}
+
+func decodeLiterals(block []byte) (lits, rest []byte, err error) {
+	blockType, format := int(block[0]&3), int(block[0]>>2&3)
+	if blockType == literalsRaw || blockType == literalsRLE {
+		var size int
+		switch format {
+		case 0, 2:
+			size, block = int(block[0]>>3), block[1:]
+		case 1:
+			size, block = int(block[0])>>4|int(block[1])<<4, block[2:]
+		case 3:
+			size, block = int(block[0])>>4|int(block[1])<<4|int(block[2])<<12, block[3:]
+		}
+		if blockType == literalsRLE {
+			return bytes.Repeat(block[:1], size), block[1:], nil
+		}
+		return block[:size], block[size:], nil
+	}
+	if blockType != literalsCompressed {
+		return nil, nil, errors.New("unsupported literals type")
+	}
+
+	headerSize, sizeBits := []int{3, 3, 4, 5}[format], []int{10, 10, 14, 18}[format]
+	var h uint64
+	for i := range headerSize {
+		h |= uint64(block[i]) << (8 * i)
+	}
+	regenerated := int(h >> 4 & (1<<sizeBits - 1))
+	compressed := int(h >> (4 + sizeBits) & (1<<sizeBits - 1))
+	data, rest := block[headerSize:headerSize+compressed], block[headerSize+compressed:]
+
+	// Weights, and the last one completing the sum to a power of two
+	if data[0] < 128 {
+		return nil, nil, errors.New("FSE coded weights are not supported")
+	}
+	symbols := int(data[0]) - 127
+	weights := make([]int, symbols+1)
+	for i := range symbols {
+		weights[i] = int(data[1+i/2]>>(4*(1-i%2))) & 15
+	}
+	data = data[1+(symbols+1)/2:]
+	total := 0
+	for _, w := range weights {
+		if w > 0 {
+			total += 1 << (w - 1)
+		}
+	}
+	maxBits := bits.Len(uint(total))
+	weights[symbols] = bits.Len(uint(1<<maxBits - total))
+	if 1<<(weights[symbols]-1) != 1<<maxBits-total {
+		return nil, nil, errors.New("weights do not complete a tree")
+	}
+
+	// Lookup table of every maxBits prefix, filled by increasing weight
+	table := make([]struct{ symbol, length uint8 }, 1<<maxBits)
+	next := 0
+	for w := 1; w <= maxBits; w++ {
+		for s, sw := range weights {
+			if sw != w {
+				continue
+			}
+			for range 1 << (w - 1) {
+				table[next].symbol, table[next].length = uint8(s), uint8(maxBits+1-w)
+				next++
+			}
+		}
+	}
+
+	streams := [][]byte{data}
+	if format != 0 {
+		s1, s2, s3 := int(binary.LittleEndian.Uint16(data)), int(binary.LittleEndian.Uint16(data[2:])), int(binary.LittleEndian.Uint16(data[4:]))
+		data = data[6:]
+		streams = [][]byte{data[:s1], data[s1 : s1+s2], data[s1+s2 : s1+s2+s3], data[s1+s2+s3:]}
+	}
+	segment := (regenerated + 3) / 4
+	for i, stream := range streams {
+		count := regenerated
+		if len(streams) == 4 {
+			count = min((i+1)*segment, regenerated) - min(i*segment, regenerated)
+		}
+		r, err := newBitReader(stream)
+		if err != nil {
+			return nil, nil, err
+		}
+		for range count {
+			e := table[r.peek(uint8(maxBits))]
+			lits = append(lits, e.symbol)
+			r.pos -= int(e.length)
+		}
+		if r.pos != 0 {
+			return nil, nil, fmt.Errorf("%d bits left in literals stream %d", r.pos, i)
+		}
+	}
+	return lits, rest, nil
+}
+
+// bitReader reads a backward bitstream from its end
+type bitReader struct {
+	data []byte
+	pos  int // bits left to read
+}
+
+func newBitReader(data []byte) (*bitReader, error) {
+	if len(data) == 0 || data[len(data)-1] == 0 {
+		return nil, errors.New("bitstream without a final bit")
+	}
+	return &bitReader{data: data, pos: (len(data)-1)*8 + bits.Len8(data[len(data)-1]) - 1}, nil
+}
+
+// peek returns the next n bits, padded with zeros past the start
+func (r *bitReader) peek(n uint8) uint32 {
+	var v uint32
+	for i := 1; i <= int(n); i++ {
+		v <<= 1
+		if p := r.pos - i; p >= 0 {
+			v |= uint32(r.data[p/8]>>(p%8)) & 1
+		}
+	}
+	return v
+}
+
+func (r *bitReader) read(n uint8) uint32 {
+	v := r.peek(n)
+	r.pos -= int(n)
+	return v
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	pm "github.com/dae-go/process-monitor/pkg"
+)
+
+// loadRules reads alert rules from a file, one per line. Blank lines and
+// lines starting with # are skipped.
+func loadRules(path string) ([]*pm.Rule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []*pm.Rule
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r, err := pm.ParseRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, scanner.Err()
+}
+
+// openNotifiers parses the -notify specs, defaulting to stdout. The
+// interactive UI shows alerts itself, so it leaves out stdout and stderr,
+// which would garble the screen. The returned function closes log files.
+func openNotifiers(specs []string, interactive bool) ([]pm.Notifier, func(), error) {
+	if len(specs) == 0 {
+		specs = []string{"stdout"}
+	}
+	var notifiers []pm.Notifier
+	closeAll := func() {
+		for _, n := range notifiers {
+			if c, ok := n.(io.Closer); ok {
+				c.Close()
+			}
+		}
+	}
+	for _, spec := range specs {
+		if interactive && (spec == "stdout" || spec == "stderr") {
+			continue
+		}
+		n, err := pm.ParseNotifier(spec)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		notifiers = append(notifiers, n)
+	}
+	return notifiers, closeAll, nil
+}
+
+// displayAlerts lists the firing alerts below the batch output
+func displayAlerts(active []pm.Alert) {
+	if len(active) == 0 {
+		return
+	}
+	fmt.Printf("\nAlerts firing: %d\n", len(active))
+	for _, a := range active {
+		fmt.Printf("  %s (since %s)\n", strings.TrimPrefix(a.String(), "FIRING "), a.Since.Format("15:04:05"))
+	}
+}
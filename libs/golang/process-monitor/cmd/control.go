@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+
+	pm "github.com/dae-go/process-monitor/pkg"
+)
+
+// action is a change to a process requested on the command line
+type action struct {
+	pid      int
+	signal   string
+	renice   *int
+	affinity string
+	yes      bool // skip the confirmation
+	// in reads the answers; one reader for all of them, since it buffers
+	// ahead of the answer it returns
+	in *bufio.Reader
+}
+
+func (a *action) requested() bool {
+	return a.signal != "" || a.renice != nil || a.affinity != ""
+}
+
+// run applies the action, asking for confirmation on stdin first
+func (a *action) run(monitor *pm.Monitor) error {
+	if a.pid <= 0 {
+		return errors.New("-signal, -renice and -affinity need a process given with -pid")
+	}
+	t, err := monitor.Target(a.pid)
+	if err != nil {
+		return actionError(err)
+	}
+	p, err := monitor.GetProcess(a.pid)
+	if err != nil {
+		return err
+	}
+	target := describe(p)
+
+	if a.signal != "" {
+		sig, err := pm.ParseSignal(a.signal)
+		if err != nil {
+			return err
+		}
+		if !a.confirm(fmt.Sprintf("Send %s to %s?", pm.SignalName(sig), target)) {
+			return nil
+		}
+		if err := monitor.Signal(t, sig); err != nil {
+			return actionError(err)
+		}
+		fmt.Printf("Sent %s to %s\n", pm.SignalName(sig), target)
+	}
+
+	if a.renice != nil {
+		if !a.confirm(fmt.Sprintf("Change the nice value of %s from %d to %d?", target, p.Nice, *a.renice)) {
+			return nil
+		}
+		if err := monitor.Renice(t, *a.renice); err != nil {
+			return actionError(err)
+		}
+		fmt.Printf("Reniced %s to %d\n", target, *a.renice)
+	}
+
+	if a.affinity != "" {
+		cpus, err := pm.ParseCPUList(a.affinity)
+		if err != nil {
+			return err
+		}
+		if !a.confirm(fmt.Sprintf("Restrict %s to CPUs %s?", target, pm.FormatCPUList(cpus))) {
+			return nil
+		}
+		if err := monitor.SetAffinity(t, cpus); err != nil {
+			return actionError(err)
+		}
+		fmt.Printf("Restricted %s to CPUs %s\n", target, pm.FormatCPUList(cpus))
+	}
+	return nil
+}
+
+// confirm asks a yes/no question on stdin. Anything but y or yes declines.
+func (a *action) confirm(question string) bool {
+	if a.yes {
+		return true
+	}
+	if a.in == nil {
+		a.in = bufio.NewReader(os.Stdin)
+	}
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := a.in.ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	fmt.Println("Cancelled")
+	return false
+}
+
+// describe names a process in confirmations and results
+func describe(p *pm.Process) string {
+	return fmt.Sprintf("%d (%s)", p.PID, p.Name)
+}
+
+// actionError adds a hint to the errors users can do something about
+func actionError(err error) error {
+	switch {
+	case errors.Is(err, pm.ErrPermission) && os.Geteuid() != 0:
+		return fmt.Errorf("%w; run as root to control other users' processes or raise priority", err)
+	case errors.Is(err, pm.ErrNoProcess), errors.Is(err, pm.ErrProcessReplaced):
+		return fmt.Errorf("%w; it has exited", err)
+	case errors.Is(err, pm.ErrForeignProcfs):
+		return fmt.Errorf("%w; control processes from their own PID namespace, without -proc", err)
+	}
+	return err
+}
+
+// signalKeys maps the keys of the UI to the signals they send
+var signalKeys = map[rune]syscall.Signal{
+	'T': syscall.SIGTERM,
+	'K': syscall.SIGKILL,
+	'S': syscall.SIGSTOP,
+	'C': syscall.SIGCONT,
+}
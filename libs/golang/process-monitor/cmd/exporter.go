@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	pm "github.com/dae-go/process-monitor/pkg"
+)
+
+// runExporter serves the metrics of monitor on addr until interrupted
+func runExporter(addr string, monitor *pm.Monitor, opts ...pm.ExporterOption) error {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", pm.NewExporter(monitor, opts...))
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `Process Monitor exporter: metrics are at /metrics`)
+	})
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       60 * time.Second,
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	errc := make(chan error, 1)
+	go func() {
+		fmt.Printf("Serving metrics on %s/metrics\n", addr)
+		errc <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-stop:
+	}
+	fmt.Println("\nShutting down exporter...")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return server.Shutdown(ctx)
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"text/tabwriter"
 	"time"
@@ -16,16 +17,176 @@ import (
 func main() {
 	// Command line flags
 	interval := flag.Duration("interval", 2*time.Second, "Update interval")
-	top := flag.Int("top", 20, "Number of top processes to show")
-	sortBy := flag.String("sort", "cpu", "Sort by: cpu, memory, pid")
+	top := flag.Int("top", 20, "Number of top processes to show in batch mode")
+	sortBy := flag.String("sort", "cpu", "Sort by: cpu, memory, pid, threads, io (with -io), fds (with -fds)")
+	procRoot := flag.String("proc", "/proc", "Where the procfs is mounted, e.g. /host/proc inside a container")
+	treeMode := flag.Bool("tree", false, "Show the process hierarchy, sorting siblings by their subtree's usage")
+	showIO := flag.Bool("io", false, "Show disk read and write rates")
+	showFDs := flag.Bool("fds", false, "Show open file descriptors")
+	batch := flag.Bool("batch", false, "Print a table every interval instead of running the interactive UI, as when the output is not a terminal")
+	pid := flag.Int("pid", 0, "Process to act on with -signal, -renice or -affinity")
+	sigName := flag.String("signal", "", "Send a signal to -pid and exit, e.g. TERM, KILL, STOP, CONT or 9")
+	renice := flag.Int("renice", 0, "Set the nice value of every thread of -pid (-20 to 19) and exit")
+	affinity := flag.String("affinity", "", "Restrict every thread of -pid to a list of CPUs, e.g. 0-3,6, and exit")
+	yes := flag.Bool("yes", false, "Act on -pid without asking for confirmation")
+	recordDir := flag.String("record", "", "Record a snapshot every interval into this directory, for -replay")
+	recordSize := flag.Int64("record-size", 64, "Maximum size of the -record directory in MB; the oldest snapshots are deleted first")
+	replayDir := flag.String("replay", "", "Replay the snapshots recorded in this directory instead of monitoring")
+	fromFlag := flag.String("from", "", "Start of the replayed span: 15:04, 2006-01-02 15:04, an RFC 3339 time or a duration ago like 30m")
+	toFlag := flag.String("to", "", "End of the replayed span, in the same forms as -from")
+	summary := flag.Bool("summary", false, "With -replay, print the processes that used the most CPU time in the span (peak memory with -sort memory) and exit")
+	exporterAddr := flag.String("exporter", "", "Serve Prometheus metrics on this address, e.g. :9256, instead of displaying processes")
+	var groups []pm.ProcessGroup
+	flag.Func("group", "Report matching processes together in exporter metrics, as name=regexp (process name), name=cmd:regexp or name=user:alice,bob; repeatable", func(spec string) error {
+		g, err := pm.ParseProcessGroup(spec)
+		if err == nil {
+			groups = append(groups, g)
+		}
+		return err
+	})
+	maxGroups := flag.Int("max-groups", 100, "Without -group, the number of process names the exporter reports before grouping the rest as other")
+	var rules []*pm.Rule
+	flag.Func("alert", "Alert when a condition holds, e.g. 'memory > 90 for 1m clear 85' or 'proc_cpu(nginx) > 80 for 30s'; repeatable", func(expr string) error {
+		r, err := pm.ParseRule(expr)
+		if err == nil {
+			rules = append(rules, r)
+		}
+		return err
+	})
+	rulesFile := flag.String("rules", "", "Read alert rules from this file, one per line; # starts a comment")
+	var notifySpecs []string
+	flag.Func("notify", "Where alerts go: stdout, stderr, file:PATH, exec:COMMAND or webhook:URL on localhost; repeatable, stdout by default", func(spec string) error {
+		notifySpecs = append(notifySpecs, spec)
+		return nil
+	})
+	quiet := flag.Bool("quiet", false, "In batch mode, print only alerts instead of the process table")
 	flag.Parse()
 
+	if *rulesFile != "" {
+		loaded, err := loadRules(*rulesFile)
+		if err != nil {
+			log.Fatal("Failed to load alert rules: ", err)
+		}
+		rules = append(rules, loaded...)
+	}
+	if len(rules) > 0 && (*exporterAddr != "" || *replayDir != "") {
+		log.Fatal("-alert and -rules watch live processes and cannot be combined with -exporter or -replay")
+	}
+	if *quiet && len(rules) == 0 {
+		log.Fatal("-quiet prints only alerts; add some with -alert or -rules")
+	}
+	if *top < 1 {
+		log.Fatal("-top must be at least 1")
+	}
+
+	act := &action{pid: *pid, signal: *sigName, affinity: *affinity, yes: *yes}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "renice" {
+			act.renice = renice
+		}
+	})
+
+	var metrics pm.Metrics
+	if *showIO {
+		metrics |= pm.MetricIO
+	}
+	if *showFDs {
+		metrics |= pm.MetricFDs
+	}
+
+	if *exporterAddr != "" {
+		// Every scrape reports I/O, so the exporter reads it regardless of -io
+		monitor, err := pm.New(pm.WithProcRoot(*procRoot), pm.WithMetrics(metrics|pm.MetricIO))
+		if err != nil {
+			log.Fatal("Failed to create process monitor:", err)
+		}
+		if err := runExporter(*exporterAddr, monitor, pm.WithProcessGroups(groups...), pm.WithMaxGroups(*maxGroups)); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	view := displayConfig{top: *top, sortBy: *sortBy, tree: *treeMode, showIO: *showIO, showFDs: *showFDs}
+	interactive := !*batch && !*quiet && isTerminal(os.Stdin) && isTerminal(os.Stdout)
+	cfg := uiConfig{interval: *interval, sortBy: *sortBy, tree: *treeMode, showIO: *showIO, showFDs: *showFDs}
+
+	var alerter *pm.Alerter
+	if len(rules) > 0 {
+		notifiers, closeNotifiers, err := openNotifiers(notifySpecs, interactive)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer closeNotifiers()
+		alerter = pm.NewAlerter(rules, notifiers...)
+		// Deliver the alerts still queued before closing the notifiers
+		defer func() {
+			if err := alerter.Close(); err != nil {
+				log.Printf("Error sending alerts: %v\n", err)
+			}
+		}()
+		// Rules on I/O rates or descriptors need them read
+		metrics |= alerter.Metrics()
+	}
+
 	// Create process monitor
-	monitor, err := pm.New()
+	monitor, err := pm.New(pm.WithProcRoot(*procRoot), pm.WithMetrics(metrics))
 	if err != nil {
 		log.Fatal("Failed to create process monitor:", err)
 	}
 
+	if act.requested() {
+		if err := act.run(monitor); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *replayDir != "" {
+		from, to, err := parseSpan(*fromFlag, *toFlag, time.Now())
+		if err != nil {
+			log.Fatal(err)
+		}
+		history, err := pm.OpenHistory(*replayDir)
+		if err != nil {
+			log.Fatal("Failed to open history:", err)
+		}
+		switch {
+		case *summary:
+			err = printSummary(history, from, to, view)
+		case interactive:
+			cfg.history, cfg.from = history, from
+			err = runUI(monitor, nil, cfg)
+		default:
+			err = replayBatch(history, from, to, view)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	var recorder *pm.Recorder
+	if *recordDir != "" {
+		recorder, err = pm.NewRecorder(*recordDir, pm.WithMaxHistorySize(*recordSize<<20))
+		if err != nil {
+			log.Fatal("Failed to open history:", err)
+		}
+		defer recorder.Close()
+	}
+
+	if interactive {
+		// The detail pane shows every metric of the selected process
+		details, err := pm.New(pm.WithProcRoot(*procRoot), pm.WithMetrics(pm.MetricAll))
+		if err != nil {
+			log.Fatal("Failed to create process monitor:", err)
+		}
+		cfg.recorder, cfg.alerter = recorder, alerter
+		if err := runUI(monitor, details, cfg); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// Setup signal handling for graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -40,66 +201,119 @@ func main() {
 	}
 
 	// Main monitoring loop
-	fmt.Println("Process Monitor Started. Press Ctrl+C to exit.")
-	fmt.Printf("Update interval: %v, Showing top %d processes\n\n", *interval, *top)
+	if !*quiet {
+		fmt.Println("Process Monitor Started. Press Ctrl+C to exit.")
+		fmt.Printf("Update interval: %v, Showing top %d processes\n\n", *interval, *top)
+	}
 
 	for {
 		select {
 		case <-ticker.C:
-			// Get all processes
-			processes, err := monitor.GetProcesses()
+			snapshot, err := monitor.Snapshot()
 			if err != nil {
 				log.Printf("Error getting processes: %v\n", err)
 				continue
 			}
-
-			// Sort processes
-			var sortFunc func(i, j int) bool
-			switch *sortBy {
-			case "memory":
-				sortFunc = func(i, j int) bool {
-					return processes[i].Memory > processes[j].Memory
+			if recorder != nil {
+				if err := recorder.Record(snapshot); err != nil {
+					log.Printf("Error recording snapshot: %v\n", err)
 				}
-			case "pid":
-				sortFunc = func(i, j int) bool {
-					return processes[i].PID < processes[j].PID
+			}
+
+			if !*quiet {
+				clearScreen()
+				displaySnapshot(snapshot, view)
+			}
+			if alerter != nil {
+				if _, err := alerter.Evaluate(snapshot); err != nil {
+					log.Printf("Error sending alerts: %v\n", err)
 				}
-			default: // cpu
-				sortFunc = func(i, j int) bool {
-					return processes[i].CPUPercent > processes[j].CPUPercent
+				if !*quiet {
+					displayAlerts(alerter.Active())
 				}
 			}
-			pm.SortProcesses(processes, sortFunc)
 
-			// Clear screen and display header
-			clearScreen()
-			displayHeader()
+		case <-sigChan:
+			if !*quiet {
+				fmt.Println("\nShutting down process monitor...")
+			}
+			return
+		}
+	}
+}
 
-			// Display processes
-			displayProcesses(processes, *top)
+// displayConfig holds the settings of the batch output
+type displayConfig struct {
+	top     int
+	sortBy  string
+	tree    bool
+	showIO  bool
+	showFDs bool
+}
 
-			// Display system stats
-			stats, err := monitor.GetSystemStats()
-			if err == nil {
-				displaySystemStats(stats)
-			}
+// displaySnapshot prints the processes and system stats of a snapshot
+func displaySnapshot(snapshot *pm.Snapshot, view displayConfig) {
+	displayHeader(snapshot.Time)
+	processes := snapshot.Processes
+	if view.tree {
+		tree := pm.BuildTree(processes)
+		tree.SortChildren(treeSortFunc(view.sortBy))
+		displayTree(tree, view.top)
+	} else {
+		sortProcesses(processes, view.sortBy)
+		displayProcesses(processes, view.top, view.showIO, view.showFDs)
+	}
+	displaySystemStats(&snapshot.Stats)
+}
 
-		case <-sigChan:
-			fmt.Println("\nShutting down process monitor...")
-			return
+// sortProcesses orders processes for the batch output
+func sortProcesses(processes []pm.Process, sortBy string) {
+	var sortFunc func(i, j int) bool
+	switch sortBy {
+	case "memory":
+		sortFunc = func(i, j int) bool {
+			return processes[i].Memory > processes[j].Memory
+		}
+	case "pid":
+		sortFunc = func(i, j int) bool {
+			return processes[i].PID < processes[j].PID
+		}
+	case "threads":
+		sortFunc = func(i, j int) bool {
+			return processes[i].Threads > processes[j].Threads
+		}
+	case "io":
+		sortFunc = func(i, j int) bool {
+			return ioRate(processes[i]) > ioRate(processes[j])
+		}
+	case "fds":
+		sortFunc = func(i, j int) bool {
+			return processes[i].OpenFDs > processes[j].OpenFDs
+		}
+	default: // cpu
+		sortFunc = func(i, j int) bool {
+			return processes[i].CPUPercent > processes[j].CPUPercent
 		}
 	}
+	pm.SortProcesses(processes, sortFunc)
 }
 
-func displayHeader() {
-	fmt.Printf("Process Monitor - %s\n", time.Now().Format("2006-01-02 15:04:05"))
-	fmt.Println(string(make([]byte, 80, 80)))
+func displayHeader(t time.Time) {
+	fmt.Printf("Process Monitor - %s\n", t.Format("2006-01-02 15:04:05"))
+	fmt.Println(strings.Repeat("=", 80))
 }
 
-func displayProcesses(processes []pm.Process, limit int) {
+func displayProcesses(processes []pm.Process, limit int, showIO, showFDs bool) {
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
-	fmt.Fprintln(w, "PID\tNAME\tCPU%\tMEM%\tMEM(MB)\tSTATE\tUSER")
-	fmt.Fprintln(w, "---\t----\t----\t----\t-------\t-----\t----")
+	header, rule := "PID\tNAME\tCPU%\tMEM%\tMEM(MB)\tTHR", "---\t----\t----\t----\t-------\t---"
+	if showIO {
+		header, rule = header+"\tREAD/s\tWRITE/s", rule+"\t------\t-------"
+	}
+	if showFDs {
+		header, rule = header+"\tFDS", rule+"\t---"
+	}
+	fmt.Fprintln(w, header+"\tSTATE\tUSER")
+	fmt.Fprintln(w, rule+"\t-----\t----")
 
 	count := limit
 	if len(processes) < limit {
@@ -108,21 +322,108 @@ func displayProcesses(processes []pm.Process, limit int) {
 
 	for i := 0; i < count; i++ {
 		p := processes[i]
-		fmt.Fprintf(w, "%d\t%s\t%.1f\t%.1f\t%.1f\t%s\t%s\n",
+		fmt.Fprintf(w, "%d\t%s\t%.1f\t%.1f\t%.1f\t%d",
 			p.PID,
 			truncateString(p.Name, 20),
 			p.CPUPercent,
 			p.MemoryPercent,
 			float64(p.Memory)/1024/1024,
-			p.State,
-			p.User,
+			p.Threads,
 		)
+		if showIO {
+			if p.IO != nil {
+				fmt.Fprintf(w, "\t%s\t%s", formatRate(p.IO.ReadBytesPerSec), formatRate(p.IO.WriteBytesPerSec))
+			} else {
+				fmt.Fprint(w, "\t-\t-")
+			}
+		}
+		if showFDs {
+			if p.OpenFDs >= 0 {
+				fmt.Fprintf(w, "\t%d", p.OpenFDs)
+			} else {
+				fmt.Fprint(w, "\t-")
+			}
+		}
+		fmt.Fprintf(w, "\t%s\t%s\n", p.State, p.User)
+	}
+	w.Flush()
+}
+
+// ioRate is the combined disk read and write rate of a process, or -1 when
+// its I/O counters could not be read
+func ioRate(p pm.Process) float64 {
+	if p.IO == nil {
+		return -1
+	}
+	return p.IO.ReadBytesPerSec + p.IO.WriteBytesPerSec
+}
+
+// formatRate formats bytes per second with a binary unit
+func formatRate(bytesPerSec float64) string {
+	switch {
+	case bytesPerSec >= 1<<30:
+		return fmt.Sprintf("%.1fG", bytesPerSec/(1<<30))
+	case bytesPerSec >= 1<<20:
+		return fmt.Sprintf("%.1fM", bytesPerSec/(1<<20))
+	case bytesPerSec >= 1<<10:
+		return fmt.Sprintf("%.1fK", bytesPerSec/(1<<10))
+	default:
+		return fmt.Sprintf("%.0fB", bytesPerSec)
+	}
+}
+
+// treeSortFunc orders sibling processes by the usage of their subtrees
+func treeSortFunc(sortBy string) func(a, b *pm.ProcessNode) bool {
+	switch sortBy {
+	case "memory":
+		return func(a, b *pm.ProcessNode) bool { return a.TreeMemory > b.TreeMemory }
+	case "pid":
+		return func(a, b *pm.ProcessNode) bool { return a.PID < b.PID }
+	default: // cpu
+		return func(a, b *pm.ProcessNode) bool { return a.TreeCPUPercent > b.TreeCPUPercent }
+	}
+}
+
+// displayTree draws the process tree like pstree, up to limit lines. The
+// TREE columns add up each process and its descendants.
+func displayTree(tree *pm.ProcessTree, limit int) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "PID\tCPU%\tMEM(MB)\tTREE CPU%\tTREE MEM(MB)\tUSER\tNAME")
+	fmt.Fprintln(w, "---\t----\t-------\t---------\t------------\t----\t----")
+
+	lines := 0
+	var draw func(n *pm.ProcessNode, branch, indent string)
+	draw = func(n *pm.ProcessNode, branch, indent string) {
+		if lines >= limit {
+			return
+		}
+		lines++
+		fmt.Fprintf(w, "%d\t%.1f\t%.1f\t%.1f\t%.1f\t%s\t%s%s\n",
+			n.PID,
+			n.CPUPercent,
+			float64(n.Memory)/1024/1024,
+			n.TreeCPUPercent,
+			float64(n.TreeMemory)/1024/1024,
+			n.User,
+			branch,
+			truncateString(n.Name, 20),
+		)
+		for i, c := range n.Children {
+			if i == len(n.Children)-1 {
+				draw(c, indent+"└─ ", indent+"   ")
+			} else {
+				draw(c, indent+"├─ ", indent+"│  ")
+			}
+		}
+	}
+	for _, root := range tree.Roots {
+		draw(root, "", "")
 	}
 	w.Flush()
 }
 
 func displaySystemStats(stats *pm.SystemStats) {
-	fmt.Println("\n" + string(make([]byte, 80, 80)))
+	fmt.Println("\n" + strings.Repeat("=", 80))
 	fmt.Printf("System Stats:\n")
 	fmt.Printf("Total Processes: %d | Running: %d | Sleeping: %d | Stopped: %d | Zombie: %d\n",
 		stats.TotalProcesses,
@@ -134,8 +435,8 @@ func displaySystemStats(stats *pm.SystemStats) {
 	fmt.Printf("CPU Usage: %.1f%% | Memory Usage: %.1f%% (%.1f GB / %.1f GB)\n",
 		stats.CPUUsagePercent,
 		stats.MemoryUsagePercent,
-		float64(stats.UsedMemory)/1024/1024/1024,
-		float64(stats.TotalMemory)/1024/1024/1024,
+		float64(stats.UsedMemory)/1024/1024, // reported in kB
+		float64(stats.TotalMemory)/1024/1024,
 	)
 }
 
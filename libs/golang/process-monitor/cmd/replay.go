@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	pm "github.com/dae-go/process-monitor/pkg"
+)
+
+// timeLayouts are the forms -from and -to accept besides durations
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// clockLayouts are times of day, taken as today
+var clockLayouts = []string{"15:04:05", "15:04"}
+
+// parseWhen parses a -from or -to value. A duration like 30m means that long
+// before now, and an empty value gives the zero time, which leaves the span
+// open at that end.
+func parseWhen(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d.Abs()), nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+	for _, layout := range clockLayouts {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			y, m, d := now.Date()
+			return time.Date(y, m, d, t.Hour(), t.Minute(), t.Second(), 0, now.Location()), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: use 15:04, 2006-01-02 15:04, RFC 3339 or a duration like 30m", s)
+}
+
+// parseSpan parses -from and -to
+func parseSpan(fromFlag, toFlag string, now time.Time) (from, to time.Time, err error) {
+	if from, err = parseWhen(fromFlag, now); err != nil {
+		return
+	}
+	if to, err = parseWhen(toFlag, now); err != nil {
+		return
+	}
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		err = errors.New("-to is before -from")
+	}
+	return
+}
+
+// replayBatch prints every recorded snapshot of the span, oldest first
+func replayBatch(history *pm.History, from, to time.Time, view displayConfig) error {
+	found := false
+	err := history.Snapshots(from, to, func(s *pm.Snapshot) error {
+		if found {
+			fmt.Println()
+		}
+		found = true
+		displaySnapshot(s, view)
+		return nil
+	})
+	if err == nil && !found {
+		err = pm.ErrNoSnapshot
+	}
+	return err
+}
+
+// printSummary prints the processes that used the most CPU time in the
+// span, or had the highest peak memory when sorting by memory
+func printSummary(history *pm.History, from, to time.Time, view displayConfig) error {
+	top := history.TopCPU
+	if view.sortBy == "memory" {
+		top = history.TopMemory
+	}
+	summaries, err := top(from, to, view.top)
+	if err != nil {
+		return err
+	}
+
+	first, last := from, to
+	if first.IsZero() || first.Before(history.Time(0)) {
+		first = history.Time(0)
+	}
+	if last.IsZero() || last.After(history.Time(history.Len()-1)) {
+		last = history.Time(history.Len() - 1)
+	}
+	fmt.Printf("Top processes from %s to %s\n", first.Format("2006-01-02 15:04:05"), last.Format("2006-01-02 15:04:05"))
+	fmt.Println(strings.Repeat("=", 80))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "PID\tNAME\tCPU(s)\tAVG CPU%\tMAX CPU%\tMAX MEM(MB)\tSEEN\tUSER")
+	fmt.Fprintln(w, "---\t----\t------\t--------\t--------\t-----------\t----\t----")
+	for _, s := range summaries {
+		fmt.Fprintf(w, "%d\t%s\t%.1f\t%.1f\t%.1f\t%.1f\t%s-%s\t%s\n",
+			s.PID,
+			truncateString(s.Name, 20),
+			s.CPUSeconds,
+			s.AvgCPUPercent,
+			s.MaxCPUPercent,
+			float64(s.MaxMemory)/1024/1024,
+			s.FirstSeen.Format("15:04:05"),
+			s.LastSeen.Format("15:04:05"),
+			s.User,
+		)
+	}
+	return w.Flush()
+}
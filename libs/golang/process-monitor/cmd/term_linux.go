@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// terminal switches the controlling terminal into raw mode for the
+// interactive UI, and back
+type terminal struct {
+	in, out *os.File
+	saved   syscall.Termios
+}
+
+func ioctl(fd uintptr, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// isTerminal reports whether f is a terminal
+func isTerminal(f *os.File) bool {
+	var t syscall.Termios
+	return ioctl(f.Fd(), syscall.TCGETS, unsafe.Pointer(&t)) == nil
+}
+
+// openTerminal puts the terminal on stdin into raw mode, so keys arrive as
+// they are pressed and are not echoed, and switches stdout to the
+// alternate screen
+func openTerminal() (*terminal, error) {
+	t := &terminal{in: os.Stdin, out: os.Stdout}
+	if err := ioctl(t.in.Fd(), syscall.TCGETS, unsafe.Pointer(&t.saved)); err != nil {
+		return nil, err
+	}
+
+	raw := t.saved
+	raw.Iflag &^= syscall.ICRNL | syscall.IXON
+	// ISIG stays on, so Ctrl+C still sends SIGINT
+	raw.Lflag &^= syscall.ICANON | syscall.ECHO | syscall.IEXTEN
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := ioctl(t.in.Fd(), syscall.TCSETS, unsafe.Pointer(&raw)); err != nil {
+		return nil, err
+	}
+
+	// Alternate screen, hidden cursor
+	t.out.WriteString("\033[?1049h\033[?25l")
+	return t, nil
+}
+
+// restore leaves the alternate screen and restores the terminal settings
+func (t *terminal) restore() {
+	t.out.WriteString("\033[?25h\033[?1049l")
+	ioctl(t.in.Fd(), syscall.TCSETS, unsafe.Pointer(&t.saved))
+}
+
+// size returns the width and height of the terminal in characters
+func (t *terminal) size() (int, int) {
+	var ws struct{ Row, Col, X, Y uint16 }
+	if err := ioctl(t.out.Fd(), syscall.TIOCGWINSZ, unsafe.Pointer(&ws)); err != nil || ws.Col == 0 || ws.Row == 0 {
+		return 80, 24
+	}
+	return int(ws.Col), int(ws.Row)
+}
@@ -0,0 +1,1051 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	pm "github.com/dae-go/process-monitor/pkg"
+)
+
+// Escape sequences used to draw the UI
+const (
+	escHome    = "\033[H"
+	escClearLn = "\033[K"
+	escClearDn = "\033[J"
+	escReverse = "\033[7m"
+	escBold    = "\033[1m"
+	escReset   = "\033[0m"
+)
+
+// column is a column of the process table
+type column struct {
+	title string
+	key   string // name for -sort
+	width int
+	right bool
+	// desc sorts the column in descending order by default
+	desc  bool
+	value func(p *pm.Process) string
+	less  func(a, b *pm.Process) bool
+}
+
+// tableColumns returns the columns of the process table. The last one takes
+// the remaining width.
+func tableColumns(showIO, showFDs bool) []column {
+	columns := []column{
+		{title: "PID", key: "pid", width: 7, right: true,
+			value: func(p *pm.Process) string { return strconv.Itoa(p.PID) },
+			less:  func(a, b *pm.Process) bool { return a.PID < b.PID }},
+		{title: "USER", key: "user", width: 9,
+			value: func(p *pm.Process) string { return p.User },
+			less:  func(a, b *pm.Process) bool { return a.User < b.User }},
+		{title: "CPU%", key: "cpu", width: 6, right: true, desc: true,
+			value: func(p *pm.Process) string { return fmt.Sprintf("%.1f", p.CPUPercent) },
+			less:  func(a, b *pm.Process) bool { return a.CPUPercent < b.CPUPercent }},
+		{title: "MEM%", key: "memory", width: 6, right: true, desc: true,
+			value: func(p *pm.Process) string { return fmt.Sprintf("%.1f", p.MemoryPercent) },
+			less:  func(a, b *pm.Process) bool { return a.Memory < b.Memory }},
+		{title: "RES(MB)", key: "res", width: 8, right: true, desc: true,
+			value: func(p *pm.Process) string { return fmt.Sprintf("%.1f", float64(p.Memory)/1024/1024) },
+			less:  func(a, b *pm.Process) bool { return a.Memory < b.Memory }},
+		{title: "THR", key: "threads", width: 4, right: true, desc: true,
+			value: func(p *pm.Process) string { return strconv.Itoa(p.Threads) },
+			less:  func(a, b *pm.Process) bool { return a.Threads < b.Threads }},
+		{title: "NI", key: "nice", width: 3, right: true,
+			value: func(p *pm.Process) string { return strconv.Itoa(p.Nice) },
+			less:  func(a, b *pm.Process) bool { return a.Nice < b.Nice }},
+		{title: "S", key: "state", width: 1,
+			value: func(p *pm.Process) string { return p.State },
+			less:  func(a, b *pm.Process) bool { return a.State < b.State }},
+	}
+	if showIO {
+		columns = append(columns,
+			column{title: "READ/s", key: "read", width: 7, right: true, desc: true,
+				value: func(p *pm.Process) string { return formatIORate(p, readRate) },
+				less:  func(a, b *pm.Process) bool { return readRate(a) < readRate(b) }},
+			column{title: "WRITE/s", key: "write", width: 7, right: true, desc: true,
+				value: func(p *pm.Process) string { return formatIORate(p, writeRate) },
+				less:  func(a, b *pm.Process) bool { return writeRate(a) < writeRate(b) }},
+		)
+	}
+	if showFDs {
+		columns = append(columns, column{title: "FDS", key: "fds", width: 5, right: true, desc: true,
+			value: func(p *pm.Process) string {
+				if p.OpenFDs < 0 {
+					return "-"
+				}
+				return strconv.Itoa(p.OpenFDs)
+			},
+			less: func(a, b *pm.Process) bool { return a.OpenFDs < b.OpenFDs }})
+	}
+	return append(columns, column{title: "COMMAND", key: "name",
+		value: func(p *pm.Process) string { return p.Command },
+		less:  func(a, b *pm.Process) bool { return a.Name < b.Name }})
+}
+
+// readRate and writeRate return a process's disk rates, or -1 when its
+// I/O counters could not be read
+func readRate(p *pm.Process) float64 {
+	if p.IO == nil {
+		return -1
+	}
+	return p.IO.ReadBytesPerSec
+}
+
+func writeRate(p *pm.Process) float64 {
+	if p.IO == nil {
+		return -1
+	}
+	return p.IO.WriteBytesPerSec
+}
+
+func formatIORate(p *pm.Process, rate func(*pm.Process) float64) string {
+	if p.IO == nil {
+		return "-"
+	}
+	return formatRate(rate(p))
+}
+
+// row is a line of the process table
+type row struct {
+	proc   *pm.Process
+	prefix string // tree branches drawn before the command
+}
+
+// prompt asks about an action on a process in the status line
+type prompt struct {
+	text string
+	// editing reads a value ended by Enter instead of a y/n answer
+	editing bool
+	input   string
+	accept  func(input string)
+}
+
+// uiConfig holds the command line settings the UI starts with
+type uiConfig struct {
+	interval time.Duration
+	sortBy   string
+	tree     bool
+	showIO   bool
+	showFDs  bool
+
+	// recorder records every refresh. With history set, the UI replays it
+	// from the snapshot at from, or the newest one, instead of monitoring.
+	recorder *pm.Recorder
+	history  *pm.History
+	from     time.Time
+	// alerter checks every live refresh against the alert rules
+	alerter *pm.Alerter
+}
+
+// ui is the interactive process viewer
+type ui struct {
+	term    *terminal
+	monitor *pm.Monitor
+	// details reads every metric of the selected process for the detail pane
+	details  *pm.Monitor
+	recorder *pm.Recorder
+	alerter  *pm.Alerter
+	columns  []column
+
+	// Replay state: the snapshot shown and whether it advances every interval
+	history *pm.History
+	pos     int
+	playing bool
+
+	processes []pm.Process
+	stats     *pm.SystemStats
+	err       error
+	updated   time.Time
+	rows      []row
+
+	sortCol   int
+	sortDesc  bool
+	filter    string
+	searching bool
+	tree      bool
+	detail    bool
+	help      bool
+	prompt    *prompt
+	message   string // outcome of the last action
+
+	selectedPID int
+	cursor      int
+	offset      int
+	width       int
+	height      int
+}
+
+// runUI runs the interactive UI until the user quits
+func runUI(monitor, details *pm.Monitor, cfg uiConfig) error {
+	term, err := openTerminal()
+	if err != nil {
+		return err
+	}
+	defer term.restore()
+
+	u := &ui{
+		term:     term,
+		monitor:  monitor,
+		details:  details,
+		recorder: cfg.recorder,
+		alerter:  cfg.alerter,
+		history:  cfg.history,
+		columns:  tableColumns(cfg.showIO, cfg.showFDs),
+		tree:     cfg.tree,
+	}
+	if u.history != nil {
+		u.pos = u.history.Len() - 1
+		if !cfg.from.IsZero() {
+			u.pos = u.history.Search(cfg.from)
+		}
+	}
+	// The table splits -sort io into reads and writes
+	sortBy := cfg.sortBy
+	if sortBy == "io" {
+		sortBy = "read"
+	}
+	u.sortCol = 2 // CPU%
+	for i, c := range u.columns {
+		if c.key == sortBy {
+			u.sortCol = i
+		}
+	}
+	u.sortDesc = u.columns[u.sortCol].desc
+	u.width, u.height = term.size()
+
+	keys := make(chan []key)
+	go readKeys(keys)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGWINCH)
+	defer signal.Stop(signals)
+
+	ticker := time.NewTicker(cfg.interval)
+	defer ticker.Stop()
+
+	u.refresh()
+	for {
+		u.draw()
+		select {
+		case <-ticker.C:
+			if u.history == nil {
+				u.refresh()
+			} else if u.playing {
+				u.step(1)
+			}
+		case sig := <-signals:
+			if sig != syscall.SIGWINCH {
+				return nil
+			}
+			u.width, u.height = term.size()
+		case pressed, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			for _, k := range pressed {
+				if !u.handleKey(k) {
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// readKeys sends the keys typed on stdin, a read at a time
+func readKeys(keys chan<- []key) {
+	defer close(keys)
+	buf := make([]byte, 256)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+		keys <- parseKeys(buf[:n])
+	}
+}
+
+// key is a key press. Printable characters have r set, other keys a name.
+type key struct {
+	r    rune
+	name string
+}
+
+// escapeKeys maps the final part of escape sequences to key names
+var escapeKeys = map[string]string{
+	"[A": "up", "[B": "down", "[C": "right", "[D": "left",
+	"OA": "up", "OB": "down", "OC": "right", "OD": "left",
+	"[H": "home", "[F": "end", "OH": "home", "OF": "end",
+	"[1~": "home", "[4~": "end", "[7~": "home", "[8~": "end",
+	"[5~": "pgup", "[6~": "pgdn",
+}
+
+// parseKeys splits what a read from the terminal returned into keys
+func parseKeys(b []byte) []key {
+	var keys []key
+	for i := 0; i < len(b); {
+		c := b[i]
+		switch {
+		case c == 0x1b && i+1 < len(b) && (b[i+1] == '[' || b[i+1] == 'O'):
+			// A sequence ends with a byte in 0x40-0x7e after its introducer
+			j := i + 2
+			for j < len(b) && (b[j] < 0x40 || b[j] > 0x7e) {
+				j++
+			}
+			if j < len(b) {
+				j++
+			}
+			if name, ok := escapeKeys[string(b[i+1:j])]; ok {
+				keys = append(keys, key{name: name})
+			}
+			i = j
+			continue
+		case c == 0x1b:
+			keys = append(keys, key{name: "esc"})
+		case c == '\r' || c == '\n':
+			keys = append(keys, key{name: "enter"})
+		case c == 0x7f || c == 0x08:
+			keys = append(keys, key{name: "backspace"})
+		case c == '\t':
+			keys = append(keys, key{name: "tab"})
+		case c < 0x20:
+			// Other control characters are ignored
+		default:
+			r, size := utf8.DecodeRune(b[i:])
+			keys = append(keys, key{r: r})
+			i += size
+			continue
+		}
+		i++
+	}
+	return keys
+}
+
+// refresh reads the processes again, or the current snapshot in replay
+func (u *ui) refresh() {
+	var snapshot *pm.Snapshot
+	if u.history != nil {
+		snapshot, u.err = u.history.Snapshot(u.pos)
+	} else {
+		snapshot, u.err = u.monitor.Snapshot()
+		if u.err == nil && u.recorder != nil {
+			if err := u.recorder.Record(snapshot); err != nil {
+				u.err = fmt.Errorf("recording: %w", err)
+			}
+		}
+		if u.err == nil && u.alerter != nil {
+			u.evaluate(snapshot)
+		}
+	}
+	if snapshot != nil {
+		u.processes, u.stats, u.updated = snapshot.Processes, &snapshot.Stats, snapshot.Time
+	} else {
+		u.processes, u.stats, u.updated = nil, nil, time.Now()
+	}
+	u.rebuild()
+}
+
+// evaluate checks the alert rules against a snapshot and shows the latest
+// alert that fired or resolved, unless a prompt is open
+func (u *ui) evaluate(snapshot *pm.Snapshot) {
+	changes, err := u.alerter.Evaluate(snapshot)
+	if u.prompt != nil {
+		return
+	}
+	switch {
+	case err != nil:
+		u.message = "Error: " + err.Error()
+	case len(changes) == 1:
+		u.message = changes[0].String()
+	case len(changes) > 1:
+		u.message = fmt.Sprintf("%s (and %d more, A lists the firing alerts)", changes[len(changes)-1], len(changes)-1)
+	}
+}
+
+// showAlerts lists the firing alerts on the status line
+func (u *ui) showAlerts() {
+	if u.alerter == nil {
+		u.message = "No alert rules; add them with -alert or -rules"
+		return
+	}
+	active := u.alerter.Active()
+	if len(active) == 0 {
+		u.message = "No alerts firing"
+		return
+	}
+	texts := make([]string, len(active))
+	for i, a := range active {
+		texts[i] = strings.TrimPrefix(a.String(), "FIRING ")
+	}
+	u.message = "Firing: " + strings.Join(texts, "; ")
+}
+
+// step moves the replay by delta snapshots. Stepping past the newest one
+// looks for snapshots recorded since.
+func (u *ui) step(delta int) {
+	if u.pos+delta >= u.history.Len() {
+		if err := u.history.Reload(); err != nil {
+			u.err = err
+			return
+		}
+	}
+	u.seek(u.pos + delta)
+}
+
+// seek shows snapshot i of the replay
+func (u *ui) seek(i int) {
+	i = max(min(i, u.history.Len()-1), 0)
+	if i == u.pos && u.err == nil && u.processes != nil {
+		u.playing = false
+		return
+	}
+	u.pos = i
+	u.refresh()
+}
+
+// rebuild filters and orders the processes into rows, keeping the selected
+// process selected
+func (u *ui) rebuild() {
+	var visible []pm.Process
+	for _, p := range u.processes {
+		if u.matches(&p) {
+			visible = append(visible, p)
+		}
+	}
+
+	col := u.columns[u.sortCol]
+	less := func(a, b *pm.Process) bool {
+		if u.sortDesc {
+			return col.less(b, a)
+		}
+		return col.less(a, b)
+	}
+
+	u.rows = u.rows[:0]
+	if u.tree {
+		tree := pm.BuildTree(visible)
+		tree.SortChildren(func(a, b *pm.ProcessNode) bool {
+			// Compare whole subtrees on usage columns
+			ta, tb := a.Process, b.Process
+			ta.CPUPercent, tb.CPUPercent = a.TreeCPUPercent, b.TreeCPUPercent
+			ta.Memory, tb.Memory = a.TreeMemory, b.TreeMemory
+			return less(&ta, &tb)
+		})
+		var add func(n *pm.ProcessNode, branch, indent string)
+		add = func(n *pm.ProcessNode, branch, indent string) {
+			u.rows = append(u.rows, row{proc: &n.Process, prefix: branch})
+			for i, c := range n.Children {
+				if i == len(n.Children)-1 {
+					add(c, indent+"└─ ", indent+"   ")
+				} else {
+					add(c, indent+"├─ ", indent+"│  ")
+				}
+			}
+		}
+		for _, root := range tree.Roots {
+			add(root, "", "")
+		}
+	} else {
+		sort.SliceStable(visible, func(i, j int) bool { return less(&visible[i], &visible[j]) })
+		for i := range visible {
+			u.rows = append(u.rows, row{proc: &visible[i]})
+		}
+	}
+
+	for i, r := range u.rows {
+		if r.proc.PID == u.selectedPID {
+			u.cursor = i
+			break
+		}
+	}
+	u.moveCursor(0)
+}
+
+// matches reports whether a process matches the search filter, by name,
+// command, user or PID
+func (u *ui) matches(p *pm.Process) bool {
+	if u.filter == "" {
+		return true
+	}
+	f := strings.ToLower(u.filter)
+	return strings.Contains(strings.ToLower(p.Name), f) ||
+		strings.Contains(strings.ToLower(p.Command), f) ||
+		strings.Contains(strings.ToLower(p.User), f) ||
+		strings.HasPrefix(strconv.Itoa(p.PID), f)
+}
+
+// moveCursor moves the selection by delta rows and scrolls to keep it in view
+func (u *ui) moveCursor(delta int) {
+	u.cursor = max(0, min(u.cursor+delta, len(u.rows)-1))
+	if len(u.rows) == 0 {
+		u.cursor, u.selectedPID = 0, 0
+	} else {
+		u.selectedPID = u.rows[u.cursor].proc.PID
+	}
+
+	body := u.bodyHeight()
+	if u.cursor < u.offset {
+		u.offset = u.cursor
+	}
+	if u.cursor >= u.offset+body {
+		u.offset = u.cursor - body + 1
+	}
+	u.offset = max(0, min(u.offset, len(u.rows)-body))
+}
+
+// Screen layout: two status lines and the column titles at the top, the
+// key hints at the bottom, and the detail pane above them when it is open
+const (
+	headerLines = 3
+	footerLines = 1
+	detailLines = 9
+)
+
+func (u *ui) bodyHeight() int {
+	h := u.height - headerLines - footerLines
+	if u.detail {
+		h -= detailLines
+	}
+	return max(h, 1)
+}
+
+// handleKey applies a key press. It returns false to quit.
+func (u *ui) handleKey(k key) bool {
+	if u.help {
+		u.help = false
+		return true
+	}
+
+	if u.prompt != nil {
+		u.handlePrompt(k)
+		return true
+	}
+	u.message = ""
+
+	if u.searching {
+		switch {
+		case k.name == "enter":
+			u.searching = false
+		case k.name == "esc":
+			u.searching = false
+			u.filter = ""
+		case k.name == "backspace":
+			if _, size := utf8.DecodeLastRuneInString(u.filter); size > 0 {
+				u.filter = u.filter[:len(u.filter)-size]
+			}
+		case k.r != 0:
+			u.filter += string(k.r)
+		default:
+			return true
+		}
+		u.rebuild()
+		return true
+	}
+
+	page := u.bodyHeight()
+	switch {
+	case k.r == 'q':
+		return false
+	case k.name == "up" || k.r == 'k':
+		u.moveCursor(-1)
+	case k.name == "down" || k.r == 'j':
+		u.moveCursor(1)
+	case k.name == "pgup":
+		u.moveCursor(-page)
+	case k.name == "pgdn" || k.r == ' ':
+		u.moveCursor(page)
+	case k.name == "home" || k.r == 'g':
+		u.moveCursor(-len(u.rows))
+	case k.name == "end" || k.r == 'G':
+		u.moveCursor(len(u.rows))
+	case k.name == "left" || k.r == '<':
+		u.sortBy((u.sortCol + len(u.columns) - 1) % len(u.columns))
+	case k.name == "right" || k.r == '>':
+		u.sortBy((u.sortCol + 1) % len(u.columns))
+	case k.r == 'P':
+		u.sortByKey("cpu")
+	case k.r == 'M':
+		u.sortByKey("memory")
+	case k.r == 'N':
+		u.sortByKey("pid")
+	case k.r == 'r':
+		u.sortDesc = !u.sortDesc
+		u.rebuild()
+	case k.r == '/':
+		u.searching = true
+	case k.name == "esc":
+		switch {
+		case u.detail:
+			u.detail = false
+		case u.filter != "":
+			u.filter = ""
+			u.rebuild()
+		}
+	case k.name == "enter" || k.r == 'd':
+		u.detail = !u.detail
+		u.moveCursor(0)
+	case k.r == 't':
+		u.tree = !u.tree
+		u.rebuild()
+	case k.r == 'R':
+		if u.history != nil {
+			if err := u.history.Reload(); err != nil {
+				u.err = err
+				break
+			}
+			u.pos = min(u.pos, u.history.Len()-1)
+		}
+		u.refresh()
+	case u.history != nil && k.r == '[':
+		u.step(-1)
+	case u.history != nil && k.r == ']':
+		u.step(1)
+	case u.history != nil && k.r == '{':
+		u.seek(u.history.Search(u.updated.Add(-time.Minute)))
+	case u.history != nil && k.r == '}':
+		u.seek(u.history.Search(u.updated.Add(time.Minute)))
+	case u.history != nil && k.r == 'p':
+		u.playing = !u.playing
+	case k.r == '?' || k.r == 'h':
+		u.help = true
+	case signalKeys[k.r] != 0:
+		u.confirmSignal(signalKeys[k.r])
+	case k.r == 's':
+		u.askSignal()
+	case k.r == 'n':
+		u.askNice()
+	case k.r == 'a':
+		u.askAffinity()
+	case k.r == 'A':
+		u.showAlerts()
+	}
+	return true
+}
+
+// handlePrompt passes a key to the open prompt
+func (u *ui) handlePrompt(k key) {
+	p := u.prompt
+	if !p.editing {
+		u.prompt = nil
+		if k.r == 'y' || k.r == 'Y' {
+			p.accept("")
+		} else {
+			u.message = "Cancelled"
+		}
+		return
+	}
+
+	switch {
+	case k.name == "enter":
+		u.prompt = nil
+		p.accept(strings.TrimSpace(p.input))
+	case k.name == "esc":
+		u.prompt = nil
+		u.message = "Cancelled"
+	case k.name == "backspace":
+		if _, size := utf8.DecodeLastRuneInString(p.input); size > 0 {
+			p.input = p.input[:len(p.input)-size]
+		}
+	case k.r != 0:
+		p.input += string(k.r)
+	}
+}
+
+// selected returns the selected process for an action, or nil when there
+// is none
+func (u *ui) selected() *pm.Process {
+	if u.history != nil {
+		u.message = "Recorded processes cannot be controlled"
+		return nil
+	}
+	if len(u.rows) == 0 {
+		return nil
+	}
+	return u.rows[u.cursor].proc
+}
+
+// target pins the selected process before a prompt that may stay open for
+// a while, so that the action cannot reach a process reusing its PID
+func (u *ui) target(p *pm.Process) (pm.Target, bool) {
+	t, err := u.monitor.Target(p.PID)
+	if err != nil {
+		u.message = "Error: " + actionError(err).Error()
+		return pm.Target{}, false
+	}
+	return t, true
+}
+
+// confirm asks before running do on the selected process. The outcome
+// replaces the status line until the next key.
+func (u *ui) confirm(question string, do func() (string, error)) {
+	u.prompt = &prompt{text: question + " [y/N]", accept: func(string) {
+		done, err := do()
+		if err != nil {
+			u.message = "Error: " + actionError(err).Error()
+		} else {
+			u.message = done
+		}
+		u.refresh()
+	}}
+}
+
+// ask reads a value for an action on the selected process
+func (u *ui) ask(question string, accept func(input string)) {
+	u.prompt = &prompt{text: question, editing: true, accept: accept}
+}
+
+func (u *ui) confirmSignal(sig syscall.Signal) {
+	p := u.selected()
+	if p == nil {
+		return
+	}
+	if t, ok := u.target(p); ok {
+		u.confirmTargetSignal(t, describe(p), sig)
+	}
+}
+
+func (u *ui) confirmTargetSignal(t pm.Target, target string, sig syscall.Signal) {
+	u.confirm(fmt.Sprintf("Send %s to %s?", pm.SignalName(sig), target), func() (string, error) {
+		return fmt.Sprintf("Sent %s to %s", pm.SignalName(sig), target), u.monitor.Signal(t, sig)
+	})
+}
+
+func (u *ui) askSignal() {
+	p := u.selected()
+	if p == nil {
+		return
+	}
+	t, ok := u.target(p)
+	if !ok {
+		return
+	}
+	target := describe(p)
+	u.ask(fmt.Sprintf("Signal to send to %s: ", target), func(input string) {
+		sig, err := pm.ParseSignal(input)
+		if err != nil {
+			u.message = "Error: " + err.Error()
+			return
+		}
+		u.confirmTargetSignal(t, target, sig)
+	})
+}
+
+func (u *ui) askNice() {
+	p := u.selected()
+	if p == nil {
+		return
+	}
+	t, ok := u.target(p)
+	if !ok {
+		return
+	}
+	target, current := describe(p), p.Nice
+	u.ask(fmt.Sprintf("Nice value for %s (now %d, -20 to 19): ", target, current), func(input string) {
+		nice, err := strconv.Atoi(input)
+		if err != nil {
+			u.message = fmt.Sprintf("Error: invalid nice value %q", input)
+			return
+		}
+		u.confirm(fmt.Sprintf("Change the nice value of %s from %d to %d?", target, current, nice), func() (string, error) {
+			return fmt.Sprintf("Reniced %s to %d", target, nice), u.monitor.Renice(t, nice)
+		})
+	})
+}
+
+func (u *ui) askAffinity() {
+	p := u.selected()
+	if p == nil {
+		return
+	}
+	t, ok := u.target(p)
+	if !ok {
+		return
+	}
+	target := describe(p)
+	question := fmt.Sprintf("CPUs for %s, e.g. 0-3,6: ", target)
+	if cpus, err := u.monitor.Affinity(t.PID); err == nil {
+		question = fmt.Sprintf("CPUs for %s (now %s): ", target, pm.FormatCPUList(cpus))
+	}
+	u.ask(question, func(input string) {
+		cpus, err := pm.ParseCPUList(input)
+		if err != nil {
+			u.message = "Error: " + err.Error()
+			return
+		}
+		list := pm.FormatCPUList(cpus)
+		u.confirm(fmt.Sprintf("Restrict %s to CPUs %s?", target, list), func() (string, error) {
+			return fmt.Sprintf("Restricted %s to CPUs %s", target, list), u.monitor.SetAffinity(t, cpus)
+		})
+	})
+}
+
+func (u *ui) sortBy(col int) {
+	u.sortCol = col
+	u.sortDesc = u.columns[col].desc
+	u.rebuild()
+}
+
+func (u *ui) sortByKey(key string) {
+	for i, c := range u.columns {
+		if c.key == key {
+			u.sortBy(i)
+			return
+		}
+	}
+}
+
+// draw renders the whole screen
+func (u *ui) draw() {
+	var b strings.Builder
+	b.WriteString(escHome)
+
+	line := func(style, text string) {
+		b.WriteString(style)
+		b.WriteString(fit(text, u.width))
+		if style != "" {
+			b.WriteString(escReset)
+		}
+		b.WriteString(escClearLn + "\r\n")
+	}
+
+	line(escBold, u.summary())
+	if u.prompt != nil || u.message != "" {
+		line(escBold, u.status())
+	} else {
+		line("", u.status())
+	}
+
+	// Column titles, with an arrow on the sort column
+	var titles []string
+	for i, c := range u.columns {
+		title := c.title
+		if i == u.sortCol {
+			if u.sortDesc {
+				title += "▼"
+			} else {
+				title += "▲"
+			}
+		}
+		titles = append(titles, cell(title, c.width, c.right))
+	}
+	line(escReverse, strings.Join(titles, " "))
+
+	body := u.bodyHeight()
+	if u.help {
+		for i, text := range helpLines {
+			if i < body {
+				line("", text)
+			}
+		}
+		for i := len(helpLines); i < body; i++ {
+			line("", "")
+		}
+	} else {
+		for i := u.offset; i < u.offset+body; i++ {
+			if i >= len(u.rows) {
+				line("", "")
+				continue
+			}
+			style := ""
+			if i == u.cursor {
+				style = escReverse
+			}
+			line(style, u.formatRow(u.rows[i]))
+		}
+	}
+
+	if u.detail {
+		for _, text := range u.detailPane() {
+			line("", text)
+		}
+	}
+
+	footer := "↑↓ select  ←→ sort  / search  t tree  Enter details  T/K term/kill  n nice  ? help  q quit"
+	if u.history != nil {
+		footer = "[ ] step  { } ±1 min  p play  ↑↓ select  ←→ sort  / search  t tree  Enter details  ? help  q quit"
+	}
+	b.WriteString(fit(footer, u.width))
+	b.WriteString(escClearDn)
+	u.term.out.WriteString(b.String())
+}
+
+func (u *ui) summary() string {
+	s := "Process Monitor  " + u.updated.Format("15:04:05")
+	if u.history != nil {
+		state := "paused"
+		if u.playing {
+			state = "playing"
+		}
+		s = fmt.Sprintf("Replay  %s  %d/%d %s", u.updated.Format("2006-01-02 15:04:05"), u.pos+1, u.history.Len(), state)
+	}
+	if u.err != nil {
+		return s + "  error: " + u.err.Error()
+	}
+	if u.alerter != nil {
+		switch n := len(u.alerter.Active()); {
+		case n == 1:
+			s += "  1 alert firing"
+		case n > 1:
+			s += fmt.Sprintf("  %d alerts firing", n)
+		}
+	}
+	if u.stats != nil {
+		s += fmt.Sprintf("  |  %d processes, %d running, %d sleeping, %d zombie  |  CPU %.1f%%  |  Mem %.1f%% (%.1f / %.1f GB)",
+			u.stats.TotalProcesses, u.stats.RunningProcesses, u.stats.SleepingProcesses, u.stats.ZombieProcesses,
+			u.stats.CPUUsagePercent, u.stats.MemoryUsagePercent,
+			float64(u.stats.UsedMemory)/1024/1024, float64(u.stats.TotalMemory)/1024/1024)
+	}
+	return s
+}
+
+func (u *ui) status() string {
+	switch {
+	case u.prompt != nil && u.prompt.editing:
+		return u.prompt.text + u.prompt.input + "█"
+	case u.prompt != nil:
+		return u.prompt.text
+	case u.searching:
+		return "Search: " + u.filter + "█"
+	case u.message != "":
+		return u.message
+	}
+	s := fmt.Sprintf("%d shown", len(u.rows))
+	if len(u.rows) > 0 {
+		s = fmt.Sprintf("%d/%d", u.cursor+1, len(u.rows))
+	}
+	if u.filter != "" {
+		s += fmt.Sprintf("  filter: %q (Esc clears)", u.filter)
+	}
+	if u.tree {
+		s += "  tree view"
+	}
+	return s
+}
+
+func (u *ui) formatRow(r row) string {
+	cells := make([]string, len(u.columns))
+	for i, c := range u.columns {
+		value := c.value(r.proc)
+		if i == len(u.columns)-1 {
+			value = r.prefix + value
+		}
+		cells[i] = cell(value, c.width, c.right)
+	}
+	return strings.Join(cells, " ")
+}
+
+// detailPane describes the selected process
+func (u *ui) detailPane() []string {
+	lines := make([]string, detailLines)
+	lines[0] = strings.Repeat("─", u.width)
+	if len(u.rows) == 0 {
+		return lines
+	}
+
+	p := u.rows[u.cursor].proc
+	// Read the selected process with every metric enabled. Its I/O rates
+	// come from the table, which only has them with -io. Replayed processes
+	// only have what was recorded.
+	if u.details != nil {
+		if full, err := u.details.GetProcess(p.PID); err == nil {
+			if p.IO != nil && full.IO != nil {
+				full.IO.ReadBytesPerSec, full.IO.WriteBytesPerSec = p.IO.ReadBytesPerSec, p.IO.WriteBytesPerSec
+			}
+			full.CPUPercent, full.UserCPUPercent, full.SystemCPUPercent = p.CPUPercent, p.UserCPUPercent, p.SystemCPUPercent
+			p = full
+		}
+	}
+
+	kind := ""
+	if p.KernelThread {
+		kind = " (kernel thread)"
+	}
+	lines[1] = fmt.Sprintf("%d %s%s", p.PID, p.Name, kind)
+	lines[2] = "Command:  " + p.Command
+	lines[3] = fmt.Sprintf("Parent:   %d    User: %s    State: %s    Nice: %d    Started: %s",
+		p.PPID, p.User, p.State, p.Nice, p.StartTime.Format("2006-01-02 15:04:05"))
+	lines[4] = fmt.Sprintf("CPU:      %.1f%% (%.1f%% user, %.1f%% system)",
+		p.CPUPercent, p.UserCPUPercent, p.SystemCPUPercent)
+	lines[5] = fmt.Sprintf("Memory:   %.1f MB resident (%.1f%%), %.1f MB virtual",
+		float64(p.Memory)/1024/1024, p.MemoryPercent, float64(p.VirtualMemory)/1024/1024)
+	lines[6] = fmt.Sprintf("Threads:  %d    Context switches: %d voluntary, %d involuntary",
+		p.Threads, p.VoluntaryCtxSwitches, p.InvoluntaryCtxSwitches)
+	if u.details != nil {
+		if cpus, err := u.details.Affinity(p.PID); err == nil {
+			lines[6] += "    CPUs: " + pm.FormatCPUList(cpus)
+		}
+	}
+
+	io := "I/O:      not readable"
+	if p.IO != nil {
+		io = fmt.Sprintf("I/O:      %.1f MB read (%s/s), %.1f MB written (%s/s)",
+			float64(p.IO.ReadBytes)/1024/1024, formatRate(p.IO.ReadBytesPerSec),
+			float64(p.IO.WriteBytes)/1024/1024, formatRate(p.IO.WriteBytesPerSec))
+	}
+	if p.OpenFDs >= 0 {
+		io += fmt.Sprintf("    Open files: %d", p.OpenFDs)
+	}
+	lines[7] = io
+	lines[8] = strings.Repeat("─", u.width)
+	return lines
+}
+
+var helpLines = []string{
+	"",
+	"  Keys",
+	"",
+	"  ↑ ↓  k j        Select the previous or next process",
+	"  PgUp PgDn Space Scroll a page",
+	"  Home End  g G   Jump to the first or last process",
+	"  ← →  < >        Sort by the previous or next column",
+	"  P  M  N         Sort by CPU, memory or PID",
+	"  r               Reverse the sort order",
+	"  /               Search by name, command, user or PID (Enter keeps, Esc clears)",
+	"  Enter  d        Show or hide details of the selected process",
+	"  t               Switch between the list and the process tree",
+	"  R               Refresh now",
+	"  T  K            Terminate (SIGTERM) or kill (SIGKILL) the selected process",
+	"  S  C            Stop (SIGSTOP) or continue (SIGCONT) the selected process",
+	"  s               Send the selected process a signal by name or number",
+	"  n               Change the nice value of the selected process",
+	"  a               Restrict the selected process to some CPUs",
+	"  A               List the firing alerts",
+	"                  Every action asks for confirmation (y) first",
+	"  [  ]            Replay: show the previous or next snapshot",
+	"  {  }            Replay: go back or forward a minute",
+	"  p               Replay: play or pause",
+	"  Esc             Close the details, or clear the search",
+	"  ?  h            Show this help",
+	"  q  Ctrl+C       Quit",
+	"",
+	"  Press any key to close this help.",
+}
+
+// cell pads or cuts s to width runes. A width of 0 leaves s as is.
+func cell(s string, width int, right bool) string {
+	if width == 0 {
+		return s
+	}
+	s = fit(s, width)
+	pad := strings.Repeat(" ", width-utf8.RuneCountInString(s))
+	if right {
+		return pad + s
+	}
+	return s + pad
+}
+
+// fit cuts s to at most width runes
+func fit(s string, width int) string {
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:max(width, 0)])
+}
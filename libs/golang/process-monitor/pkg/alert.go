@@ -0,0 +1,498 @@
+package pm
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rule is an alerting condition on a system or process metric, such as
+// "proc_cpu(nginx) > 80 for 30s" or "memory > 90 clear 85"
+type Rule struct {
+	Metric string
+	// Selector picks the processes of a proc_ metric, as in
+	// ParseProcessGroup: a name pattern, cmd:pattern or user:names
+	Selector  string
+	Op        string // >, >=, < or <=
+	Threshold float64
+	// Clear is the value a firing alert must get past, back across the
+	// threshold, to resolve. It equals Threshold without hysteresis.
+	Clear float64
+	// For is how long the condition must hold before the alert fires
+	For time.Duration
+
+	match func(p *Process) bool
+}
+
+// systemRuleMetrics and processRuleMetrics map the metrics of rules to
+// their values in the snapshot's stats and in each matching process
+var (
+	systemRuleMetrics = map[string]func(s *SystemStats) float64{
+		"cpu":       func(s *SystemStats) float64 { return s.CPUUsagePercent },
+		"memory":    func(s *SystemStats) float64 { return s.MemoryUsagePercent },
+		"processes": func(s *SystemStats) float64 { return float64(s.TotalProcesses) },
+		"running":   func(s *SystemStats) float64 { return float64(s.RunningProcesses) },
+		"sleeping":  func(s *SystemStats) float64 { return float64(s.SleepingProcesses) },
+		"stopped":   func(s *SystemStats) float64 { return float64(s.StoppedProcesses) },
+		"zombies":   func(s *SystemStats) float64 { return float64(s.ZombieProcesses) },
+	}
+	// Process metrics report false when the value was not read
+	processRuleMetrics = map[string]func(p *Process) (float64, bool){
+		"proc_cpu":     func(p *Process) (float64, bool) { return p.CPUPercent, true },
+		"proc_memory":  func(p *Process) (float64, bool) { return p.MemoryPercent, true },
+		"proc_rss":     func(p *Process) (float64, bool) { return float64(p.Memory) / (1 << 20), true },
+		"proc_threads": func(p *Process) (float64, bool) { return float64(p.Threads), true },
+		"proc_fds":     func(p *Process) (float64, bool) { return float64(p.OpenFDs), p.OpenFDs >= 0 },
+		"proc_read":    func(p *Process) (float64, bool) { return ioRateMB(p, false) },
+		"proc_write":   func(p *Process) (float64, bool) { return ioRateMB(p, true) },
+	}
+)
+
+// proc_count counts the matching processes instead of checking each
+const procCount = "proc_count"
+
+// ioRateMB returns the read or write rate of a process in MB/s
+func ioRateMB(p *Process, write bool) (float64, bool) {
+	if p.IO == nil {
+		return 0, false
+	}
+	if write {
+		return p.IO.WriteBytesPerSec / (1 << 20), true
+	}
+	return p.IO.ReadBytesPerSec / (1 << 20), true
+}
+
+var ruleSyntax = regexp.MustCompile(`^\s*([a-z_]+)\s*(?:\((.*)\))?\s*(>=|<=|>|<)\s*(\S+)\s*(.*?)\s*$`)
+
+// ParseRule parses a rule written as
+//
+//	metric[(selector)] op threshold [for duration] [clear value]
+//
+// System metrics are cpu and memory (percentages), processes, running,
+// sleeping, stopped and zombies (counts). Process metrics take a selector
+// and fire for each matching process: proc_cpu and proc_memory
+// (percentages), proc_rss (MB), proc_threads, proc_fds and proc_read and
+// proc_write (MB/s). proc_count fires on the number of matching processes.
+func ParseRule(expr string) (*Rule, error) {
+	m := ruleSyntax.FindStringSubmatch(expr)
+	if m == nil {
+		return nil, fmt.Errorf("invalid rule %q: expected metric[(selector)] op threshold [for duration] [clear value]", expr)
+	}
+	r := &Rule{Metric: m[1], Selector: strings.TrimSpace(m[2]), Op: m[3]}
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("invalid rule %q: %s", expr, fmt.Sprintf(format, args...))
+	}
+
+	_, system := systemRuleMetrics[r.Metric]
+	_, process := processRuleMetrics[r.Metric]
+	switch {
+	case system && r.Selector != "":
+		return nil, invalid("%s takes no process selector", r.Metric)
+	case process || r.Metric == procCount:
+		if r.Selector == "" {
+			return nil, invalid("%s needs a process selector, like %s(nginx)", r.Metric, r.Metric)
+		}
+		g, err := ParseProcessGroup("rule=" + r.Selector)
+		if err != nil {
+			return nil, invalid("bad selector: %v", err)
+		}
+		r.match = g.Match
+	case !system:
+		return nil, invalid("unknown metric %s", r.Metric)
+	}
+
+	var err error
+	if r.Threshold, err = strconv.ParseFloat(m[4], 64); err != nil {
+		return nil, invalid("bad threshold %s", m[4])
+	}
+	r.Clear = r.Threshold
+
+	fields := strings.Fields(m[5])
+	for len(fields) > 0 {
+		if len(fields) < 2 {
+			return nil, invalid("%s needs a value", fields[0])
+		}
+		switch fields[0] {
+		case "for":
+			if r.For, err = time.ParseDuration(fields[1]); err != nil || r.For < 0 {
+				return nil, invalid("bad duration %s", fields[1])
+			}
+		case "clear":
+			if r.Clear, err = strconv.ParseFloat(fields[1], 64); err != nil {
+				return nil, invalid("bad clear value %s", fields[1])
+			}
+		default:
+			return nil, invalid("unexpected %q", fields[0])
+		}
+		fields = fields[2:]
+	}
+	// The clear value lies on the quiet side of the threshold
+	if r.Clear != r.Threshold && r.holds(r.Clear, r.Threshold) {
+		return nil, invalid("clear value %g is past the threshold", r.Clear)
+	}
+	return r, nil
+}
+
+// String formats the rule as ParseRule reads it
+func (r *Rule) String() string {
+	var b strings.Builder
+	b.WriteString(r.Metric)
+	if r.Selector != "" {
+		b.WriteString("(" + r.Selector + ")")
+	}
+	fmt.Fprintf(&b, " %s %g", r.Op, r.Threshold)
+	if r.For > 0 {
+		fmt.Fprintf(&b, " for %s", r.For)
+	}
+	if r.Clear != r.Threshold {
+		fmt.Fprintf(&b, " clear %g", r.Clear)
+	}
+	return b.String()
+}
+
+// Metrics returns the extended metrics the rule needs the monitor to read
+func (r *Rule) Metrics() Metrics {
+	switch r.Metric {
+	case "proc_fds":
+		return MetricFDs
+	case "proc_read", "proc_write":
+		return MetricIO
+	}
+	return 0
+}
+
+// holds reports whether value is past limit in the direction of the rule
+func (r *Rule) holds(value, limit float64) bool {
+	switch r.Op {
+	case ">":
+		return value > limit
+	case ">=":
+		return value >= limit
+	case "<":
+		return value < limit
+	default:
+		return value <= limit
+	}
+}
+
+// Alert is a change in the state of a rule, for the whole system or for one
+// process
+type Alert struct {
+	Rule   *Rule
+	Firing bool // false when the alert resolved
+	Value  float64
+
+	// The process a proc_ rule fired for; PID is 0 for other rules
+	PID     int
+	Process string
+	// Gone is set when the alert resolved because its process exited
+	Gone bool
+
+	Since time.Time // when the condition started to hold
+	Time  time.Time // of the snapshot that changed the state
+}
+
+// State returns "firing" or "resolved"
+func (a Alert) State() string {
+	if a.Firing {
+		return "firing"
+	}
+	return "resolved"
+}
+
+// String describes the alert, like
+// "FIRING proc_cpu(nginx) > 80 for 30s: nginx (1234) at 93.5"
+func (a Alert) String() string {
+	s := strings.ToUpper(a.State()) + " " + a.Rule.String() + ": "
+	if a.PID != 0 {
+		s += fmt.Sprintf("%s (%d) ", a.Process, a.PID)
+	}
+	if a.Gone {
+		return s + "exited"
+	}
+	return s + "at " + strconv.FormatFloat(a.Value, 'f', 1, 64)
+}
+
+// alertQueueSize is how many alerts can wait for slow notifiers before
+// further ones are dropped
+const alertQueueSize = 256
+
+// Alerter evaluates rules on snapshots and notifies of alerts that fire or
+// resolve. Notifiers run on a goroutine of their own, so a slow command or
+// webhook does not hold up the monitoring; Close stops it.
+type Alerter struct {
+	rules     []*Rule
+	notifiers []Notifier
+
+	mu sync.Mutex
+	// states holds the alerts whose condition holds, pending or firing,
+	// with their latest values
+	states map[alertKey]*Alert
+	closed bool
+
+	// queue feeds alerts to the notifiers, and is closed by Close
+	queue chan delivery
+	done  chan struct{}
+
+	errMu sync.Mutex
+	// errs holds the notifier errors not returned yet, and unreported the
+	// alerts dropped since they were
+	errs       []error
+	dropped    int
+	unreported int
+}
+
+// delivery is an alert for the notifiers, or with flushed set a marker
+// closed once the alerts queued before it are delivered
+type delivery struct {
+	alert   Alert
+	flushed chan struct{}
+}
+
+// alertKey identifies an alert: a rule, and for proc_ rules a process
+type alertKey struct {
+	rule  int
+	pid   int
+	start int64
+}
+
+// NewAlerter creates an alerter for rules, notifying notifiers in order
+func NewAlerter(rules []*Rule, notifiers ...Notifier) *Alerter {
+	a := &Alerter{
+		rules:     rules,
+		notifiers: notifiers,
+		states:    make(map[alertKey]*Alert),
+		queue:     make(chan delivery, alertQueueSize),
+		done:      make(chan struct{}),
+	}
+	go a.deliver()
+	return a
+}
+
+// deliver passes the queued alerts to the notifiers until Close
+func (a *Alerter) deliver() {
+	defer close(a.done)
+	for d := range a.queue {
+		if d.flushed != nil {
+			close(d.flushed)
+			continue
+		}
+		for _, n := range a.notifiers {
+			if err := n.Notify(d.alert); err != nil {
+				a.errMu.Lock()
+				a.errs = append(a.errs, err)
+				a.errMu.Unlock()
+			}
+		}
+	}
+}
+
+// takeErrors returns and forgets the notifier errors and drops not
+// returned yet
+func (a *Alerter) takeErrors() error {
+	a.errMu.Lock()
+	defer a.errMu.Unlock()
+	errs := a.errs
+	if a.unreported > 0 {
+		errs = append([]error{fmt.Errorf("dropped %d alerts: notifiers are too slow", a.unreported)}, errs...)
+	}
+	a.errs, a.unreported = nil, 0
+	return errors.Join(errs...)
+}
+
+// Dropped returns how many alerts were dropped because the notifiers fell
+// behind
+func (a *Alerter) Dropped() int {
+	a.errMu.Lock()
+	defer a.errMu.Unlock()
+	return a.dropped
+}
+
+// Flush waits until the alerts evaluated so far are delivered, and returns
+// the errors of notifiers that failed since they were last returned
+func (a *Alerter) Flush() error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return a.takeErrors()
+	}
+	flushed := make(chan struct{})
+	a.queue <- delivery{flushed: flushed}
+	a.mu.Unlock()
+	<-flushed
+	return a.takeErrors()
+}
+
+// Close delivers the queued alerts and stops the notifiers' goroutine. It
+// gives up on the alerts still queued after notifyTimeout, so that hung
+// notifiers do not hold up an exit. Alerts evaluated afterwards are not
+// delivered.
+func (a *Alerter) Close() error {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.mu.Unlock()
+	select {
+	case <-a.done:
+		return a.takeErrors()
+	case <-time.After(notifyTimeout):
+		return errors.Join(a.takeErrors(), fmt.Errorf("gave up on %d alerts the notifiers did not take in %s", len(a.queue), notifyTimeout))
+	}
+}
+
+// Metrics returns the extended metrics the rules need the monitor to read
+func (a *Alerter) Metrics() Metrics {
+	var m Metrics
+	for _, r := range a.rules {
+		m |= r.Metrics()
+	}
+	return m
+}
+
+// Evaluate checks the rules against a snapshot, which should be later than
+// the previous one, and queues the alerts that fired or resolved for the
+// notifiers. It returns those alerts, and the errors of notifiers that
+// failed since errors were last returned, including alerts dropped
+// because the queue was full.
+func (a *Alerter) Evaluate(s *Snapshot) ([]Alert, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var changes []Alert
+	seen := make(map[alertKey]bool)
+	check := func(key alertKey, alert Alert) {
+		seen[key] = true
+		if change, ok := a.step(key, alert); ok {
+			changes = append(changes, change)
+		}
+	}
+
+	for i, r := range a.rules {
+		base := Alert{Rule: r, Time: s.Time}
+		switch {
+		case systemRuleMetrics[r.Metric] != nil:
+			base.Value = systemRuleMetrics[r.Metric](&s.Stats)
+			check(alertKey{rule: i}, base)
+		case r.Metric == procCount:
+			for j := range s.Processes {
+				if r.match(&s.Processes[j]) {
+					base.Value++
+				}
+			}
+			check(alertKey{rule: i}, base)
+		default:
+			value := processRuleMetrics[r.Metric]
+			for j := range s.Processes {
+				p := &s.Processes[j]
+				if !r.match(p) {
+					continue
+				}
+				v, ok := value(p)
+				if !ok {
+					continue
+				}
+				alert := base
+				alert.Value, alert.PID, alert.Process = v, p.PID, p.Name
+				check(alertKey{rule: i, pid: p.PID, start: p.StartTime.UnixNano()}, alert)
+			}
+		}
+	}
+
+	// Processes that exited resolve their alerts
+	var gone []alertKey
+	for key := range a.states {
+		if !seen[key] {
+			gone = append(gone, key)
+		}
+	}
+	sort.Slice(gone, func(i, j int) bool {
+		if gone[i].rule != gone[j].rule {
+			return gone[i].rule < gone[j].rule
+		}
+		return gone[i].pid < gone[j].pid
+	})
+	for _, key := range gone {
+		alert := *a.states[key]
+		delete(a.states, key)
+		if alert.Firing {
+			alert.Firing, alert.Gone, alert.Time = false, true, s.Time
+			changes = append(changes, alert)
+		}
+	}
+
+	for _, alert := range changes {
+		if a.closed {
+			break
+		}
+		select {
+		case a.queue <- delivery{alert: alert}:
+		default:
+			a.errMu.Lock()
+			a.dropped++
+			a.unreported++
+			a.errMu.Unlock()
+		}
+	}
+	return changes, a.takeErrors()
+}
+
+// step advances the state of one alert with a new value. It returns the
+// alert when it fired or resolved.
+func (a *Alerter) step(key alertKey, alert Alert) (Alert, bool) {
+	r := alert.Rule
+	prev := a.states[key]
+	if prev == nil {
+		if !r.holds(alert.Value, r.Threshold) {
+			return Alert{}, false
+		}
+		// The condition starts to hold: pending until it held long enough
+		alert.Since = alert.Time
+		prev = &alert
+	}
+
+	alert.Since, alert.Firing = prev.Since, prev.Firing
+	if !alert.Firing {
+		if !r.holds(alert.Value, r.Threshold) {
+			delete(a.states, key)
+			return Alert{}, false
+		}
+		alert.Firing = alert.Time.Sub(alert.Since) >= r.For
+		a.states[key] = &alert
+		return alert, alert.Firing
+	}
+
+	// Firing until the value gets past the clear value
+	if r.holds(alert.Value, r.Clear) {
+		a.states[key] = &alert
+		return Alert{}, false
+	}
+	delete(a.states, key)
+	alert.Firing = false
+	return alert, true
+}
+
+// Active returns the firing alerts, with their latest values
+func (a *Alerter) Active() []Alert {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var active []Alert
+	for _, alert := range a.states {
+		if alert.Firing {
+			active = append(active, *alert)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool {
+		if !active[i].Since.Equal(active[j].Since) {
+			return active[i].Since.Before(active[j].Since)
+		}
+		return active[i].PID < active[j].PID
+	})
+	return active
+}
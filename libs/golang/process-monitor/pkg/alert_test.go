@@ -0,0 +1,216 @@
+package pm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    string // formatted back by String
+		wantErr bool
+	}{
+		{expr: "memory > 90", want: "memory > 90"},
+		{expr: "  zombies>5  ", want: "zombies > 5"},
+		{expr: "memory > 90 for 1m clear 85", want: "memory > 90 for 1m0s clear 85"},
+		{expr: "cpu >= 95 clear 80 for 30s", want: "cpu >= 95 for 30s clear 80"},
+		{expr: "proc_cpu(nginx) > 80 for 30s", want: "proc_cpu(nginx) > 80 for 30s"},
+		{expr: "proc_rss( cmd:java -jar (a|b)\\.jar ) > 2048", want: "proc_rss(cmd:java -jar (a|b)\\.jar) > 2048"},
+		{expr: "proc_count(user:postgres) < 1 for 10s", want: "proc_count(user:postgres) < 1 for 10s"},
+		{expr: "running <= 0 clear 2", want: "running <= 0 clear 2"},
+		{expr: "memory", wantErr: true},
+		{expr: "memory = 90", wantErr: true},
+		{expr: "swap > 10", wantErr: true},
+		{expr: "memory(nginx) > 10", wantErr: true},
+		{expr: "proc_cpu > 80", wantErr: true},
+		{expr: "proc_cpu(() > 80", wantErr: true},
+		{expr: "memory > ninety", wantErr: true},
+		{expr: "memory > 90 for", wantErr: true},
+		{expr: "memory > 90 for soon", wantErr: true},
+		{expr: "memory > 90 until 5s", wantErr: true},
+		// The clear value must be on the other side of the threshold
+		{expr: "memory > 90 clear 95", wantErr: true},
+		{expr: "proc_count(sshd) < 1 clear 0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			r, err := ParseRule(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRule(%q) error = %v, wantErr %v", tt.expr, err, tt.wantErr)
+			}
+			if err == nil && r.String() != tt.want {
+				t.Errorf("ParseRule(%q) = %q, want %q", tt.expr, r.String(), tt.want)
+			}
+		})
+	}
+}
+
+// alertLog collects alerts as text for comparisons
+type alertLog []string
+
+func (l *alertLog) Notify(alert Alert) error {
+	*l = append(*l, alert.String())
+	return nil
+}
+
+func mustRules(t *testing.T, exprs ...string) []*Rule {
+	t.Helper()
+	var rules []*Rule
+	for _, expr := range exprs {
+		r, err := ParseRule(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+func TestAlerterSystemRules(t *testing.T) {
+	var log alertLog
+	a := NewAlerter(mustRules(t, "memory > 90 for 20s clear 85", "zombies > 5"), &log)
+	defer a.Close()
+
+	steps := []struct {
+		seconds int
+		memory  float64
+		zombies int
+		want    []string
+	}{
+		{0, 50, 0, nil},
+		{10, 95, 6, []string{"FIRING zombies > 5: at 6.0"}},
+		// Pending for 10s of the 20s
+		{20, 92, 6, nil},
+		// Dropping below the threshold restarts the wait
+		{30, 89, 2, []string{"RESOLVED zombies > 5: at 2.0"}},
+		{40, 91, 0, nil},
+		{50, 99, 0, nil},
+		{60, 93, 0, []string{"FIRING memory > 90 for 20s clear 85: at 93.0"}},
+		// Below the threshold but above the clear value, it keeps firing
+		{70, 87, 0, nil},
+		{80, 85, 0, []string{"RESOLVED memory > 90 for 20s clear 85: at 85.0"}},
+	}
+	for _, step := range steps {
+		log = nil
+		s := snapshotAt(step.seconds)
+		s.Stats.MemoryUsagePercent, s.Stats.ZombieProcesses = step.memory, step.zombies
+		changes, err := a.Evaluate(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := a.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if len(changes) != len(log) || strings.Join(log, "\n") != strings.Join(step.want, "\n") {
+			t.Errorf("at +%ds: alerts %q, want %q", step.seconds, log, step.want)
+		}
+		if step.seconds == 70 {
+			active := a.Active()
+			if len(active) != 1 || active[0].Value != 87 || !active[0].Since.Equal(historyStart.Add(40*time.Second)) {
+				t.Errorf("at +70s: Active = %+v, want memory at 87 since +40s", active)
+			}
+		}
+	}
+	if active := a.Active(); len(active) != 0 {
+		t.Errorf("Active after resolving = %+v", active)
+	}
+}
+
+func TestAlerterProcessRules(t *testing.T) {
+	var log alertLog
+	a := NewAlerter(mustRules(t, "proc_cpu(nginx) > 80 for 5s", "proc_count(sshd) < 1", "proc_fds(nginx) > 100"), &log)
+	defer a.Close()
+
+	nginx := func(pid int, cpu float64, started int) Process {
+		return Process{PID: pid, Name: "nginx", CPUPercent: cpu, StartTime: historyStart.Add(time.Duration(started) * time.Second), OpenFDs: -1}
+	}
+	sshd := Process{PID: 5, Name: "sshd", OpenFDs: -1}
+
+	steps := []struct {
+		seconds   int
+		processes []Process
+		want      []string
+	}{
+		{0, []Process{sshd, nginx(10, 90, 0), nginx(11, 10, 0)}, nil},
+		{5, []Process{sshd, nginx(10, 95, 0), nginx(11, 85, 0)}, []string{"FIRING proc_cpu(nginx) > 80 for 5s: nginx (10) at 95.0"}},
+		{10, []Process{sshd, nginx(10, 95, 0), nginx(11, 85, 0)}, []string{"FIRING proc_cpu(nginx) > 80 for 5s: nginx (11) at 85.0"}},
+		// Process 10 exits and sshd is gone
+		{15, []Process{nginx(11, 85, 0)}, []string{
+			"FIRING proc_count(sshd) < 1: at 0.0",
+			"RESOLVED proc_cpu(nginx) > 80 for 5s: nginx (10) exited",
+		}},
+		// A new process reusing PID 11 starts over
+		{20, []Process{sshd, nginx(11, 99, 18)}, []string{
+			"RESOLVED proc_count(sshd) < 1: at 1.0",
+			"RESOLVED proc_cpu(nginx) > 80 for 5s: nginx (11) exited",
+		}},
+	}
+	for _, step := range steps {
+		log = nil
+		if _, err := a.Evaluate(snapshotAt(step.seconds, step.processes...)); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(log, "\n") != strings.Join(step.want, "\n") {
+			t.Errorf("at +%ds: alerts %q, want %q", step.seconds, log, step.want)
+		}
+	}
+
+	if m := a.Metrics(); m != MetricFDs {
+		t.Errorf("Metrics = %v, want MetricFDs", m)
+	}
+}
+
+func TestAlerterSlowNotifier(t *testing.T) {
+	// The notifier hangs until released, as a webhook that does not answer
+	entered, release := make(chan struct{}, 1), make(chan struct{})
+	var delivered int
+	slow := NotifierFunc(func(alert Alert) error {
+		select {
+		case entered <- struct{}{}:
+		default:
+		}
+		<-release
+		delivered++
+		return errors.New("webhook failed")
+	})
+	a := NewAlerter(mustRules(t, "zombies > 5"), slow)
+
+	// Every snapshot fires or resolves the alert
+	const evaluations = alertQueueSize + 50
+	start := time.Now()
+	for i := range evaluations {
+		s := snapshotAt(i)
+		s.Stats.ZombieProcesses = 10 * (i % 2)
+		if _, err := a.Evaluate(s); err != nil && !strings.Contains(err.Error(), "dropped") {
+			t.Fatalf("evaluation %d: %v", i, err)
+		}
+		if i == 1 {
+			<-entered
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("evaluations took %s while the notifier hung", elapsed)
+	}
+
+	// One alert is with the notifier and a queue full waits
+	close(release)
+	err := a.Close()
+	wantDropped := evaluations - 1 - 1 - alertQueueSize
+	if a.Dropped() != wantDropped || delivered != evaluations-1-wantDropped {
+		t.Errorf("Dropped = %d, delivered %d, want %d dropped of %d", a.Dropped(), delivered, wantDropped, evaluations-1)
+	}
+	if err == nil || !strings.Contains(err.Error(), "webhook failed") {
+		t.Errorf("Close error = %v, want the notifier errors", err)
+	}
+
+	// Evaluations after Close notify nobody
+	if _, err := a.Evaluate(snapshotAt(evaluations)); err != nil {
+		t.Errorf("Evaluate after Close: %v", err)
+	}
+}
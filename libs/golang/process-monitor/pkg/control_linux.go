@@ -0,0 +1,330 @@
+//go:build linux
+// +build linux
+
+package pm
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/bits"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// signals names the signals users commonly send, for ParseSignal and
+// SignalName
+var signals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+	"TERM": syscall.SIGTERM,
+	"CONT": syscall.SIGCONT,
+	"STOP": syscall.SIGSTOP,
+	"TSTP": syscall.SIGTSTP,
+}
+
+// ParseSignal parses a signal name such as "TERM", "SIGTERM" or "term", or
+// a signal number such as "15"
+func ParseSignal(name string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(name); err == nil {
+		if n <= 0 || n > 64 {
+			return 0, fmt.Errorf("invalid signal number %d", n)
+		}
+		return syscall.Signal(n), nil
+	}
+	sig, ok := signals[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !ok {
+		return 0, fmt.Errorf("unknown signal %q", name)
+	}
+	return sig, nil
+}
+
+// SignalName returns the name of a signal, such as "SIGTERM"
+func SignalName(sig syscall.Signal) string {
+	for name, s := range signals {
+		if s == sig {
+			return "SIG" + name
+		}
+	}
+	return "signal " + strconv.Itoa(int(sig))
+}
+
+// controlError reports a failed operation on process pid, mapping the
+// errors of the system call to ErrNoProcess and ErrPermission
+func controlError(op string, pid int, err error) error {
+	switch {
+	case errors.Is(err, syscall.ESRCH):
+		return fmt.Errorf("%s process %d: %w", op, pid, ErrNoProcess)
+	case errors.Is(err, syscall.EPERM), errors.Is(err, syscall.EACCES):
+		return fmt.Errorf("%s process %d: %w", op, pid, ErrPermission)
+	default:
+		return fmt.Errorf("%s process %d: %w", op, pid, err)
+	}
+}
+
+// checkPID rejects the PIDs kill(2) and friends treat specially: 0 for the
+// caller's process group and negative ones for other groups or everything
+func checkPID(pid int) error {
+	if pid <= 0 {
+		return fmt.Errorf("invalid PID %d", pid)
+	}
+	return nil
+}
+
+// checkProcfs rejects control through a procfs of another PID namespace,
+// which it detects by the PID its self entry has for the monitor
+func (m *linuxMonitor) checkProcfs() error {
+	data, err := m.readFile("self/stat")
+	if err != nil {
+		return ErrForeignProcfs
+	}
+	pid, _, _ := strings.Cut(string(data), " ")
+	if pid != strconv.Itoa(os.Getpid()) {
+		return ErrForeignProcfs
+	}
+	return nil
+}
+
+// startTicks reads when process pid started
+func (m *linuxMonitor) startTicks(pid int) (uint64, error) {
+	data, err := m.readFile("%d/stat", pid)
+	if err != nil {
+		return 0, processError(pid, err)
+	}
+	stat, err := parseStat(data)
+	if err != nil {
+		return 0, fmt.Errorf("process %d: %w", pid, err)
+	}
+	return stat.starttime, nil
+}
+
+// Target reads the start time of a process to control
+func (m *linuxMonitor) Target(pid int) (Target, error) {
+	if err := checkPID(pid); err != nil {
+		return Target{}, err
+	}
+	if err := m.checkProcfs(); err != nil {
+		return Target{}, err
+	}
+	ticks, err := m.startTicks(pid)
+	if err != nil {
+		return Target{}, err
+	}
+	return Target{PID: pid, StartTicks: ticks}, nil
+}
+
+// checkTarget makes sure t is still the process with its PID. Only a
+// pidfd rules out reuse of the PID between the check and the action.
+func (m *linuxMonitor) checkTarget(t Target) error {
+	if err := checkPID(t.PID); err != nil {
+		return err
+	}
+	if err := m.checkProcfs(); err != nil {
+		return err
+	}
+	ticks, err := m.startTicks(t.PID)
+	if err != nil {
+		return err
+	}
+	if ticks != t.StartTicks {
+		return fmt.Errorf("process %d: %w", t.PID, ErrProcessReplaced)
+	}
+	return nil
+}
+
+// The pidfd system calls, numbered alike on every architecture
+const (
+	sysPidfdSendSignal = 424
+	sysPidfdOpen       = 434
+)
+
+// Signal sends a signal to a process. It goes through a pidfd, which keeps
+// referring to the process that was checked even if it exits and its PID
+// is reused. Without pidfds (before Linux 5.3, or when seccomp blocks them)
+// it falls back to kill(2).
+func (m *linuxMonitor) Signal(t Target, sig syscall.Signal) error {
+	if err := checkPID(t.PID); err != nil {
+		return err
+	}
+	op := "signal " + SignalName(sig) + " to"
+	pidfd, _, errno := syscall.Syscall(sysPidfdOpen, uintptr(t.PID), 0, 0)
+	if errno == syscall.ESRCH {
+		return controlError(op, t.PID, errno)
+	}
+	if errno == 0 {
+		defer syscall.Close(int(pidfd))
+	}
+	if err := m.checkTarget(t); err != nil {
+		return err
+	}
+
+	var err error
+	if errno == 0 {
+		if _, _, e := syscall.Syscall6(sysPidfdSendSignal, pidfd, uintptr(sig), 0, 0, 0, 0); e != 0 {
+			err = e
+		}
+	} else {
+		err = syscall.Kill(t.PID, sig)
+	}
+	if err != nil {
+		return controlError(op, t.PID, err)
+	}
+	return nil
+}
+
+// threads returns the thread IDs of a process, the main thread's first
+func (m *linuxMonitor) threads(pid int) ([]int, error) {
+	entries, err := fs.ReadDir(m.proc, fmt.Sprintf("%d/task", pid))
+	if err != nil {
+		return nil, processError(pid, err)
+	}
+	tids := []int{pid}
+	for _, entry := range entries {
+		if tid, err := strconv.Atoi(entry.Name()); err == nil && tid != pid {
+			tids = append(tids, tid)
+		}
+	}
+	return tids, nil
+}
+
+// eachThread applies a change to every thread of a process, since nice
+// values and affinities are per thread. Threads that exit meanwhile are
+// skipped; ones started meanwhile inherit the change from the thread that
+// starts them only if it was already changed.
+func (m *linuxMonitor) eachThread(t Target, op string, change func(tid int) error) error {
+	if err := m.checkTarget(t); err != nil {
+		return err
+	}
+	tids, err := m.threads(t.PID)
+	if err != nil {
+		return err
+	}
+	for _, tid := range tids {
+		if err := change(tid); err != nil && (tid == t.PID || !errors.Is(err, syscall.ESRCH)) {
+			return controlError(op, t.PID, err)
+		}
+	}
+	return nil
+}
+
+// Renice sets the nice value of every thread of a process
+func (m *linuxMonitor) Renice(t Target, nice int) error {
+	if nice < -20 || nice > 19 {
+		return fmt.Errorf("invalid nice value %d (expected -20 to 19)", nice)
+	}
+	return m.eachThread(t, "renice", func(tid int) error {
+		return syscall.Setpriority(syscall.PRIO_PROCESS, tid, nice)
+	})
+}
+
+// maxCPUs is the number of CPUs the affinity masks cover
+const maxCPUs = 1024
+
+type cpuMask [maxCPUs / 64]uint64
+
+// Affinity returns the CPUs the main thread of a process may run on
+func (m *linuxMonitor) Affinity(pid int) ([]int, error) {
+	if err := checkPID(pid); err != nil {
+		return nil, err
+	}
+	if err := m.checkProcfs(); err != nil {
+		return nil, err
+	}
+	var mask cpuMask
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_GETAFFINITY, uintptr(pid), unsafe.Sizeof(mask), uintptr(unsafe.Pointer(&mask)))
+	if errno != 0 {
+		return nil, controlError("get affinity of", pid, errno)
+	}
+
+	var cpus []int
+	for i, word := range mask {
+		for word != 0 {
+			bit := bits.TrailingZeros64(word)
+			cpus = append(cpus, i*64+bit)
+			word &^= 1 << bit
+		}
+	}
+	return cpus, nil
+}
+
+// SetAffinity restricts every thread of a process to the given CPUs
+func (m *linuxMonitor) SetAffinity(t Target, cpus []int) error {
+	if len(cpus) == 0 {
+		return errors.New("no CPUs given")
+	}
+	var mask cpuMask
+	for _, cpu := range cpus {
+		if cpu < 0 || cpu >= maxCPUs {
+			return fmt.Errorf("invalid CPU %d", cpu)
+		}
+		mask[cpu/64] |= 1 << (cpu % 64)
+	}
+	return m.eachThread(t, "set affinity of", func(tid int) error {
+		_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, uintptr(tid), unsafe.Sizeof(mask), uintptr(unsafe.Pointer(&mask)))
+		if errno != 0 {
+			return errno
+		}
+		return nil
+	})
+}
+
+// ParseCPUList parses a CPU list such as "0-3,6" as used by taskset and
+// /sys/devices/system/cpu
+func ParseCPUList(list string) ([]int, error) {
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(list, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		first, last, isRange := strings.Cut(part, "-")
+		lo, err := strconv.Atoi(first)
+		hi := lo
+		if err == nil && isRange {
+			hi, err = strconv.Atoi(last)
+		}
+		if err != nil || lo < 0 || hi < lo {
+			return nil, fmt.Errorf("invalid CPU list entry %q", part)
+		}
+		for cpu := lo; cpu <= hi; cpu++ {
+			seen[cpu] = true
+		}
+	}
+	if len(seen) == 0 {
+		return nil, errors.New("empty CPU list")
+	}
+	cpus := make([]int, 0, len(seen))
+	for cpu := range seen {
+		cpus = append(cpus, cpu)
+	}
+	sort.Ints(cpus)
+	return cpus, nil
+}
+
+// FormatCPUList formats CPUs as a list such as "0-3,6"
+func FormatCPUList(cpus []int) string {
+	sorted := append([]int(nil), cpus...)
+	sort.Ints(sorted)
+	var parts []string
+	for i := 0; i < len(sorted); {
+		j := i
+		for j+1 < len(sorted) && sorted[j+1] <= sorted[j]+1 {
+			j++
+		}
+		if sorted[j] == sorted[i] {
+			parts = append(parts, strconv.Itoa(sorted[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", sorted[i], sorted[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
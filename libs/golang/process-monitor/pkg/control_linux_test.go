@@ -0,0 +1,298 @@
+//go:build linux
+// +build linux
+
+package pm
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseSignal(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    syscall.Signal
+		wantErr bool
+	}{
+		{name: "TERM", want: syscall.SIGTERM},
+		{name: "SIGKILL", want: syscall.SIGKILL},
+		{name: "stop", want: syscall.SIGSTOP},
+		{name: "sigcont", want: syscall.SIGCONT},
+		{name: "9", want: syscall.SIGKILL},
+		{name: "0", wantErr: true},
+		{name: "65", wantErr: true},
+		{name: "BOGUS", wantErr: true},
+		{name: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sig, err := ParseSignal(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSignal(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+			if sig != tt.want {
+				t.Errorf("ParseSignal(%q) = %d, want %d", tt.name, sig, tt.want)
+			}
+		})
+	}
+
+	if name := SignalName(syscall.SIGTERM); name != "SIGTERM" {
+		t.Errorf("SignalName(SIGTERM) = %q", name)
+	}
+	if name := SignalName(syscall.Signal(40)); name != "signal 40" {
+		t.Errorf("SignalName(40) = %q", name)
+	}
+}
+
+func TestCPUList(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    []int
+		format  string
+		wantErr bool
+	}{
+		{list: "0", want: []int{0}, format: "0"},
+		{list: "0-3,6", want: []int{0, 1, 2, 3, 6}, format: "0-3,6"},
+		{list: "6, 1,0,2", want: []int{0, 1, 2, 6}, format: "0-2,6"},
+		{list: "1-2,2-3", want: []int{1, 2, 3}, format: "1-3"},
+		{list: "", wantErr: true},
+		{list: "3-1", wantErr: true},
+		{list: "-1", wantErr: true},
+		{list: "a", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.list, func(t *testing.T) {
+			cpus, err := ParseCPUList(tt.list)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCPUList(%q) error = %v, wantErr %v", tt.list, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(cpus, tt.want) {
+				t.Errorf("ParseCPUList(%q) = %v, want %v", tt.list, cpus, tt.want)
+			}
+			if tt.wantErr {
+				return
+			}
+			if format := FormatCPUList(cpus); format != tt.format {
+				t.Errorf("FormatCPUList(%v) = %q, want %q", cpus, format, tt.format)
+			}
+		})
+	}
+}
+
+// startChild starts a process for the control tests to act on and kills it
+// when the test ends
+func startChild(t *testing.T) *exec.Cmd {
+	t.Helper()
+	cmd := exec.Command("sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("cannot start sleep: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	return cmd
+}
+
+// waitState waits for process pid to reach a state
+func waitState(t *testing.T, m *linuxMonitor, pid int, state string) {
+	t.Helper()
+	var p *Process
+	var err error
+	for range 100 {
+		if p, err = m.GetProcess(pid); err == nil && p.State == state {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("process %d did not reach state %s: %+v, %v", pid, state, p, err)
+}
+
+func TestControl(t *testing.T) {
+	impl, err := newPlatformMonitor(config{procFS: os.DirFS("/proc")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := impl.(*linuxMonitor)
+	pid := startChild(t).Process.Pid
+	target, err := m.Target(pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Stop and continue
+	if err := m.Signal(target, syscall.SIGSTOP); err != nil {
+		t.Fatal(err)
+	}
+	waitState(t, m, pid, "T")
+	if err := m.Signal(target, syscall.SIGCONT); err != nil {
+		t.Fatal(err)
+	}
+	waitState(t, m, pid, "S")
+
+	// Raising the nice value needs no privileges
+	if err := m.Renice(target, 10); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := m.GetProcess(pid); err != nil || p.Nice != 10 {
+		t.Errorf("after Renice(10): process %+v, %v", p, err)
+	}
+
+	cpus, err := m.Affinity(pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cpus) == 0 {
+		t.Fatal("Affinity returned no CPUs")
+	}
+	if err := m.SetAffinity(target, cpus[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := m.Affinity(pid); err != nil || !reflect.DeepEqual(got, cpus[:1]) {
+		t.Errorf("after SetAffinity(%v): Affinity = %v, %v", cpus[:1], got, err)
+	}
+
+	// A process that took over the PID started at another time
+	replaced := Target{PID: pid, StartTicks: target.StartTicks + 1}
+	if err := m.Signal(replaced, syscall.SIGTERM); !errors.Is(err, ErrProcessReplaced) {
+		t.Errorf("signal to a replaced process: error = %v, want ErrProcessReplaced", err)
+	}
+
+	if err := m.Signal(target, syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestControlThreads changes the test process itself, whose runtime runs
+// several threads
+func TestControlThreads(t *testing.T) {
+	impl, err := newPlatformMonitor(config{procFS: os.DirFS("/proc")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := impl.(*linuxMonitor)
+	self, err := m.Target(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tids, err := m.threads(self.PID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tids) < 2 || tids[0] != self.PID {
+		t.Fatalf("threads = %v, want the main thread first and others", tids)
+	}
+
+	cpus, err := m.Affinity(self.PID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.SetAffinity(self, cpus)
+	if err := m.SetAffinity(self, cpus[:1]); err != nil {
+		t.Fatal(err)
+	}
+	for _, tid := range tids {
+		if got, err := m.Affinity(tid); err == nil && !reflect.DeepEqual(got, cpus[:1]) {
+			t.Errorf("thread %d: Affinity = %v, want %v", tid, got, cpus[:1])
+		}
+	}
+
+	// Raising the nice value by one needs no privileges; only root can
+	// restore it
+	p, err := m.GetProcess(self.PID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nice := min(p.Nice+1, 19)
+	defer m.Renice(self, p.Nice)
+	if err := m.Renice(self, nice); err != nil {
+		t.Fatal(err)
+	}
+	for _, tid := range tids {
+		data, err := m.readFile("%d/task/%d/stat", self.PID, tid)
+		if err != nil {
+			continue
+		}
+		if stat, err := parseStat(data); err != nil || stat.nice != nice {
+			t.Errorf("thread %d: nice = %d, %v, want %d", tid, stat.nice, err, nice)
+		}
+	}
+}
+
+func TestControlErrors(t *testing.T) {
+	impl, err := newPlatformMonitor(config{procFS: os.DirFS("/proc")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := impl.(*linuxMonitor)
+
+	// An exited and reaped child is gone for good
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("cannot run true: %v", err)
+	}
+	gone := cmd.Process.Pid
+	self, err := m.Target(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	replaced := Target{PID: self.PID, StartTicks: self.StartTicks + 1}
+
+	// Another namespace's procfs, whose self entry is not the monitor
+	foreignImpl, err := newPlatformMonitor(config{procFS: os.DirFS("testdata/proc/before")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign := foreignImpl.(*linuxMonitor)
+
+	tests := []struct {
+		name    string
+		call    func() error
+		wantErr error
+	}{
+		{name: "target PID 0", call: func() error { _, err := m.Target(0); return err }},
+		{name: "signal PID 0", call: func() error { return m.Signal(Target{}, syscall.SIGTERM) }},
+		{name: "signal negative PID", call: func() error { return m.Signal(Target{PID: -1}, syscall.SIGTERM) }},
+		{name: "renice out of range", call: func() error { return m.Renice(self, 20) }},
+		{name: "no CPUs", call: func() error { return m.SetAffinity(self, nil) }},
+		{name: "CPU out of range", call: func() error { return m.SetAffinity(self, []int{maxCPUs}) }},
+		{name: "target exited process", call: func() error { _, err := m.Target(gone); return err }, wantErr: ErrNoProcess},
+		{name: "signal exited process", call: func() error { return m.Signal(Target{PID: gone}, syscall.SIGTERM) }, wantErr: ErrNoProcess},
+		{name: "renice exited process", call: func() error { return m.Renice(Target{PID: gone}, 5) }, wantErr: ErrNoProcess},
+		{name: "affinity of exited process", call: func() error { _, err := m.Affinity(gone); return err }, wantErr: ErrNoProcess},
+		{name: "renice replaced process", call: func() error { return m.Renice(replaced, 5) }, wantErr: ErrProcessReplaced},
+		{name: "affinity of replaced process", call: func() error { return m.SetAffinity(replaced, []int{0}) }, wantErr: ErrProcessReplaced},
+		{name: "target through foreign procfs", call: func() error { _, err := foreign.Target(os.Getpid()); return err }, wantErr: ErrForeignProcfs},
+		{name: "signal through foreign procfs", call: func() error { return foreign.Signal(self, syscall.SIGTERM) }, wantErr: ErrForeignProcfs},
+		{name: "affinity through foreign procfs", call: func() error { _, err := foreign.Affinity(os.Getpid()); return err }, wantErr: ErrForeignProcfs},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Signal 0 only checks for permission
+	if init, err := m.Target(1); err != nil {
+		t.Errorf("Target(1): %v", err)
+	} else if err := m.Signal(init, 0); os.Geteuid() != 0 && !errors.Is(err, ErrPermission) {
+		t.Errorf("signal to init as user %d: error = %v, want ErrPermission", os.Geteuid(), err)
+	}
+	if err := controlError("signal", 1, syscall.EPERM); !errors.Is(err, ErrPermission) {
+		t.Errorf("controlError(EPERM) = %v, want ErrPermission", err)
+	}
+	if err := controlError("renice", 1, syscall.EACCES); !errors.Is(err, ErrPermission) {
+		t.Errorf("controlError(EACCES) = %v, want ErrPermission", err)
+	}
+}
@@ -0,0 +1,336 @@
+package pm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProcessGroup is a set of processes the exporter reports together, which
+// keeps the number of label values bounded however many processes come
+// and go
+type ProcessGroup struct {
+	Name  string
+	Match func(p *Process) bool
+}
+
+// ParseProcessGroup parses a group given as name=pattern. The pattern is a
+// regular expression matched against the process name, or against the
+// full command line with a cmd: prefix. A user: prefix matches the
+// processes of a comma-separated list of users instead.
+func ParseProcessGroup(spec string) (ProcessGroup, error) {
+	name, pattern, ok := strings.Cut(spec, "=")
+	if !ok || name == "" || pattern == "" {
+		return ProcessGroup{}, fmt.Errorf("invalid process group %q: expected name=pattern", spec)
+	}
+
+	if users, ok := strings.CutPrefix(pattern, "user:"); ok {
+		set := make(map[string]bool)
+		for _, u := range strings.Split(users, ",") {
+			set[strings.TrimSpace(u)] = true
+		}
+		return ProcessGroup{Name: name, Match: func(p *Process) bool { return set[p.User] }}, nil
+	}
+
+	field := func(p *Process) string { return p.Name }
+	if command, ok := strings.CutPrefix(pattern, "cmd:"); ok {
+		pattern = command
+		field = func(p *Process) string { return p.Command }
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return ProcessGroup{}, fmt.Errorf("invalid process group %q: %w", spec, err)
+	}
+	return ProcessGroup{Name: name, Match: func(p *Process) bool { return re.MatchString(field(p)) }}, nil
+}
+
+// otherGroup holds the processes that match no group
+const otherGroup = "other"
+
+// Exporter serves the system stats and per-group process metrics of a
+// monitor in the Prometheus text format. Each scrape takes one snapshot of
+// the processes; CPU and I/O counters add up each process's usage since the previous
+// scrape, so they keep growing as processes start and exit.
+type Exporter struct {
+	monitor   *Monitor
+	groups    []ProcessGroup
+	maxGroups int
+	now       func() time.Time
+
+	mu         sync.Mutex
+	lastScrape time.Time
+	counters   map[string]*groupCounters
+}
+
+// ExporterOption configures an Exporter
+type ExporterOption func(*Exporter)
+
+// WithProcessGroups reports processes in the given groups, in order of
+// precedence. Processes that match none are reported as "other". Without
+// groups, processes are grouped by name.
+func WithProcessGroups(groups ...ProcessGroup) ExporterOption {
+	return func(e *Exporter) {
+		e.groups = append(e.groups, groups...)
+	}
+}
+
+// WithMaxGroups bounds the number of process names reported when
+// processes are grouped by name. Names seen after that many are reported
+// as "other". The default is 100.
+func WithMaxGroups(n int) ExporterOption {
+	return func(e *Exporter) {
+		e.maxGroups = n
+	}
+}
+
+// groupCounters holds the counters of a group across scrapes
+type groupCounters struct {
+	userSeconds   float64
+	systemSeconds float64
+	readBytes     float64
+	writeBytes    float64
+	// io is set once I/O counters of a process in the group were read
+	io bool
+}
+
+// groupMetrics is a group's share of one scrape
+type groupMetrics struct {
+	processes     int
+	threads       int
+	cpuPercent    float64
+	residentBytes uint64
+	virtualBytes  uint64
+	openFDs       int
+	hasFDs        bool
+}
+
+// NewExporter creates an exporter reading processes from monitor. The
+// monitor should not be used for anything else, since every read resets
+// the interval its CPU usage covers. Enable MetricIO and MetricFDs on it
+// to export I/O and open file metrics.
+func NewExporter(monitor *Monitor, opts ...ExporterOption) *Exporter {
+	e := &Exporter{
+		monitor:   monitor,
+		maxGroups: 100,
+		now:       time.Now,
+		counters:  make(map[string]*groupCounters),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// group returns the group of a process
+func (e *Exporter) group(p *Process) string {
+	if len(e.groups) > 0 {
+		for _, g := range e.groups {
+			if g.Match(p) {
+				return g.Name
+			}
+		}
+		return otherGroup
+	}
+	if _, ok := e.counters[p.Name]; ok || len(e.counters) < e.maxGroups {
+		return p.Name
+	}
+	return otherGroup
+}
+
+// ServeHTTP serves the metrics
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := e.WriteMetrics(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// WriteMetrics reads the processes and writes the metrics to w
+func (e *Exporter) WriteMetrics(w io.Writer) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	start := e.now()
+	snapshot, err := e.monitor.Snapshot()
+	if err != nil {
+		return err
+	}
+	processes, stats := snapshot.Processes, &snapshot.Stats
+
+	var elapsed float64
+	if !e.lastScrape.IsZero() {
+		elapsed = start.Sub(e.lastScrape).Seconds()
+	}
+	e.lastScrape = start
+
+	groups := make(map[string]*groupMetrics)
+	for i := range processes {
+		p := &processes[i]
+		name := e.group(p)
+		g := groups[name]
+		if g == nil {
+			g = &groupMetrics{}
+			groups[name] = g
+		}
+		c := e.counters[name]
+		if c == nil {
+			c = &groupCounters{}
+			e.counters[name] = c
+		}
+
+		g.processes++
+		g.threads += p.Threads
+		g.cpuPercent += p.CPUPercent
+		g.residentBytes += p.Memory
+		g.virtualBytes += p.VirtualMemory
+		c.userSeconds += p.UserCPUPercent / 100 * elapsed
+		c.systemSeconds += p.SystemCPUPercent / 100 * elapsed
+		if p.IO != nil {
+			c.io = true
+			c.readBytes += p.IO.ReadBytesPerSec * elapsed
+			c.writeBytes += p.IO.WriteBytesPerSec * elapsed
+		}
+		if p.OpenFDs >= 0 {
+			g.hasFDs = true
+			g.openFDs += p.OpenFDs
+		}
+	}
+
+	// Groups without processes keep their counters, at zero processes
+	names := make([]string, 0, len(e.counters))
+	for name := range e.counters {
+		names = append(names, name)
+		if groups[name] == nil {
+			groups[name] = &groupMetrics{}
+		}
+	}
+	sort.Strings(names)
+
+	mw := &metricWriter{w: bufio.NewWriter(w)}
+
+	mw.family("pm_system_processes", "gauge", "Processes by state.")
+	for _, s := range []struct {
+		state string
+		count int
+	}{
+		{"running", stats.RunningProcesses},
+		{"sleeping", stats.SleepingProcesses},
+		{"stopped", stats.StoppedProcesses},
+		{"zombie", stats.ZombieProcesses},
+	} {
+		mw.sample("pm_system_processes", float64(s.count), "state", s.state)
+	}
+	mw.family("pm_system_process_count", "gauge", "Processes in any state.")
+	mw.sample("pm_system_process_count", float64(stats.TotalProcesses))
+	mw.family("pm_system_memory_total_bytes", "gauge", "Physical memory.")
+	mw.sample("pm_system_memory_total_bytes", float64(stats.TotalMemory*1024))
+	mw.family("pm_system_memory_used_bytes", "gauge", "Physical memory in use, excluding buffers and caches.")
+	mw.sample("pm_system_memory_used_bytes", float64(stats.UsedMemory*1024))
+	mw.family("pm_system_memory_usage_percent", "gauge", "Physical memory in use, as a percentage.")
+	mw.sample("pm_system_memory_usage_percent", stats.MemoryUsagePercent)
+	mw.family("pm_system_cpu_usage_percent", "gauge", "Share of CPU time spent busy since boot, across all CPUs, as a percentage.")
+	mw.sample("pm_system_cpu_usage_percent", stats.CPUUsagePercent)
+
+	mw.family("pm_group_processes", "gauge", "Processes in the group.")
+	for _, name := range names {
+		mw.sample("pm_group_processes", float64(groups[name].processes), "group", name)
+	}
+	mw.family("pm_group_threads", "gauge", "Threads of the processes in the group.")
+	for _, name := range names {
+		mw.sample("pm_group_threads", float64(groups[name].threads), "group", name)
+	}
+	mw.family("pm_group_cpu_seconds_total", "counter", "CPU time used by the processes in the group.")
+	for _, name := range names {
+		c := e.counters[name]
+		mw.sample("pm_group_cpu_seconds_total", c.userSeconds, "group", name, "mode", "user")
+		mw.sample("pm_group_cpu_seconds_total", c.systemSeconds, "group", name, "mode", "system")
+	}
+	mw.family("pm_group_cpu_percent", "gauge", "CPU usage of the group since the previous scrape, as a percentage of one CPU.")
+	for _, name := range names {
+		mw.sample("pm_group_cpu_percent", groups[name].cpuPercent, "group", name)
+	}
+	mw.family("pm_group_memory_resident_bytes", "gauge", "Resident memory of the processes in the group.")
+	for _, name := range names {
+		mw.sample("pm_group_memory_resident_bytes", float64(groups[name].residentBytes), "group", name)
+	}
+	mw.family("pm_group_memory_virtual_bytes", "gauge", "Virtual memory of the processes in the group.")
+	for _, name := range names {
+		mw.sample("pm_group_memory_virtual_bytes", float64(groups[name].virtualBytes), "group", name)
+	}
+
+	// I/O and open files only for groups whose processes could be read
+	mw.family("pm_group_read_bytes_total", "counter", "Bytes the processes in the group read from storage.")
+	for _, name := range names {
+		if c := e.counters[name]; c.io {
+			mw.sample("pm_group_read_bytes_total", c.readBytes, "group", name)
+		}
+	}
+	mw.family("pm_group_write_bytes_total", "counter", "Bytes the processes in the group wrote to storage.")
+	for _, name := range names {
+		if c := e.counters[name]; c.io {
+			mw.sample("pm_group_write_bytes_total", c.writeBytes, "group", name)
+		}
+	}
+	mw.family("pm_group_open_fds", "gauge", "Open file descriptors of the processes in the group.")
+	for _, name := range names {
+		if groups[name].hasFDs {
+			mw.sample("pm_group_open_fds", float64(groups[name].openFDs), "group", name)
+		}
+	}
+
+	mw.family("pm_scrape_duration_seconds", "gauge", "Time taken to read the processes.")
+	mw.sample("pm_scrape_duration_seconds", e.now().Sub(start).Seconds())
+	return mw.flush()
+}
+
+// metricWriter writes the Prometheus text format
+type metricWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (mw *metricWriter) family(name, kind, help string) {
+	mw.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes a sample with labels given as name, value pairs
+func (mw *metricWriter) sample(name string, value float64, labels ...string) {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(labels[i])
+			b.WriteString(`="`)
+			b.WriteString(labelEscaper.Replace(labels[i+1]))
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	mw.printf("%s %s\n", b.String(), strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (mw *metricWriter) printf(format string, args ...any) {
+	if mw.err == nil {
+		_, mw.err = fmt.Fprintf(mw.w, format, args...)
+	}
+}
+
+func (mw *metricWriter) flush() error {
+	if mw.err != nil {
+		return mw.err
+	}
+	return mw.w.Flush()
+}
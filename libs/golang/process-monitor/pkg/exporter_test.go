@@ -0,0 +1,176 @@
+package pm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeMonitor returns a list of processes per call
+type fakeMonitor struct {
+	ProcessMonitor
+	scans [][]Process
+}
+
+func (f *fakeMonitor) GetProcesses() ([]Process, error) {
+	processes := f.scans[0]
+	if len(f.scans) > 1 {
+		f.scans = f.scans[1:]
+	}
+	return processes, nil
+}
+
+func (f *fakeMonitor) GetSystemStats() (*SystemStats, error) {
+	return &SystemStats{TotalProcesses: 3, RunningProcesses: 1, SleepingProcesses: 2,
+		TotalMemory: 16318412, UsedMemory: 4079603, MemoryUsagePercent: 25, CPUUsagePercent: 12.5}, nil
+}
+
+// scrape returns the metrics of one scrape, 10 seconds after the previous
+func scrape(t *testing.T, e *Exporter, clock *time.Time) string {
+	t.Helper()
+	*clock = clock.Add(10 * time.Second)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	return rec.Body.String()
+}
+
+func newTestExporter(scans [][]Process, opts ...ExporterOption) (*Exporter, *time.Time) {
+	e := NewExporter(&Monitor{impl: &fakeMonitor{scans: scans}}, opts...)
+	clock := historyStart
+	e.now = func() time.Time { return clock }
+	return e, &clock
+}
+
+func checkMetrics(t *testing.T, metrics string, want, unwanted []string) {
+	t.Helper()
+	lines := make(map[string]bool)
+	for _, line := range strings.Split(metrics, "\n") {
+		lines[line] = true
+	}
+	for _, line := range want {
+		if !lines[line] {
+			t.Errorf("missing %q in\n%s", line, metrics)
+		}
+	}
+	for _, prefix := range unwanted {
+		if strings.Contains(metrics, prefix) {
+			t.Errorf("unexpected %q in\n%s", prefix, metrics)
+		}
+	}
+}
+
+func TestExporterGroups(t *testing.T) {
+	nginx := Process{PID: 10, Name: "nginx", User: "www", Threads: 2, Memory: 1000, VirtualMemory: 5000,
+		UserCPUPercent: 40, SystemCPUPercent: 10, CPUPercent: 50, OpenFDs: 12,
+		IO: &IOStats{ReadBytesPerSec: 100, WriteBytesPerSec: 20}}
+	worker := Process{PID: 11, Name: "nginx", User: "www", Threads: 1, Memory: 500,
+		UserCPUPercent: 10, CPUPercent: 10, OpenFDs: 3}
+	postgres := Process{PID: 20, Name: "postgres", User: "postgres", Threads: 1, Memory: 3000,
+		SystemCPUPercent: 5, CPUPercent: 5, OpenFDs: -1}
+	shell := Process{PID: 30, Name: "bash", User: "alice", Threads: 1, OpenFDs: -1}
+
+	var groups []ProcessGroup
+	for _, spec := range []string{"web=^nginx$", `db "main"=user:postgres,mysql`, "shells=cmd:sh$"} {
+		g, err := ParseProcessGroup(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		groups = append(groups, g)
+	}
+
+	e, clock := newTestExporter([][]Process{
+		{nginx, worker, postgres, shell},
+		{nginx, postgres},
+	}, WithProcessGroups(groups...))
+
+	first := scrape(t, e, clock)
+	checkMetrics(t, first, []string{
+		"# TYPE pm_system_processes gauge",
+		`pm_system_processes{state="running"} 1`,
+		"pm_system_process_count 3",
+		"pm_system_memory_total_bytes 1.6710053888e+10",
+		"pm_system_cpu_usage_percent 12.5",
+		`pm_group_processes{group="web"} 2`,
+		`pm_group_processes{group="db \"main\""} 1`,
+		`pm_group_processes{group="other"} 1`,
+		`pm_group_threads{group="web"} 3`,
+		`pm_group_cpu_percent{group="web"} 60`,
+		`pm_group_memory_resident_bytes{group="web"} 1500`,
+		`pm_group_open_fds{group="web"} 15`,
+		// The first scrape has no interval to count
+		`pm_group_cpu_seconds_total{group="web",mode="user"} 0`,
+		`pm_group_read_bytes_total{group="web"} 0`,
+	}, []string{`pm_group_open_fds{group="other"}`, `pm_group_read_bytes_total{group="other"}`})
+
+	second := scrape(t, e, clock)
+	checkMetrics(t, second, []string{
+		`pm_group_processes{group="web"} 1`,
+		// 40% and 10% of the 10 seconds since the first scrape
+		`pm_group_cpu_seconds_total{group="web",mode="user"} 4`,
+		`pm_group_cpu_seconds_total{group="web",mode="system"} 1`,
+		`pm_group_cpu_seconds_total{group="db \"main\"",mode="system"} 0.5`,
+		`pm_group_read_bytes_total{group="web"} 1000`,
+		`pm_group_write_bytes_total{group="web"} 200`,
+		// Groups without processes keep their counters
+		`pm_group_processes{group="other"} 0`,
+		`pm_group_cpu_seconds_total{group="other",mode="user"} 0`,
+	}, nil)
+}
+
+func TestExporterGroupsByName(t *testing.T) {
+	processes := []Process{
+		{PID: 1, Name: "systemd", OpenFDs: -1},
+		{PID: 2, Name: "sshd", OpenFDs: -1},
+		{PID: 3, Name: "sshd", OpenFDs: -1},
+		{PID: 4, Name: "bash", OpenFDs: -1},
+	}
+	e, clock := newTestExporter([][]Process{processes}, WithMaxGroups(2))
+	checkMetrics(t, scrape(t, e, clock), []string{
+		`pm_group_processes{group="systemd"} 1`,
+		`pm_group_processes{group="sshd"} 2`,
+		`pm_group_processes{group="other"} 1`,
+	}, []string{`group="bash"`})
+}
+
+func TestParseProcessGroup(t *testing.T) {
+	tests := []struct {
+		spec    string
+		matches []Process
+		misses  []Process
+		wantErr bool
+	}{
+		{spec: "web=nginx|httpd", matches: []Process{{Name: "nginx"}, {Name: "httpd"}}, misses: []Process{{Name: "bash"}}},
+		{spec: "java=cmd:-jar app\\.jar", matches: []Process{{Name: "java", Command: "java -jar app.jar"}}, misses: []Process{{Name: "java", Command: "java -version"}}},
+		{spec: "db=user:postgres, mysql", matches: []Process{{User: "postgres"}, {User: "mysql"}}, misses: []Process{{User: "root"}}},
+		{spec: "web", wantErr: true},
+		{spec: "=nginx", wantErr: true},
+		{spec: "web=", wantErr: true},
+		{spec: "web=(", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			g, err := ParseProcessGroup(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseProcessGroup(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			for _, p := range tt.matches {
+				if !g.Match(&p) {
+					t.Errorf("%q does not match %+v", tt.spec, p)
+				}
+			}
+			for _, p := range tt.misses {
+				if g.Match(&p) {
+					t.Errorf("%q matches %+v", tt.spec, p)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,540 @@
+package pm
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A history directory holds segment files, named after the time of their
+// first snapshot in nanoseconds since the epoch, like
+// 01760860800000000000.pmh. Each segment starts with segmentMagic, followed
+// by records:
+//
+//	uvarint  length of the rest of the record, without the checksum
+//	varint   snapshot time, in nanoseconds since the epoch
+//	bytes    snapshot encoded by appendSnapshot, compressed with DEFLATE
+//	uint32   CRC-32 (IEEE) of the time and the compressed snapshot, little endian
+//
+// Together the segments form a ring buffer: when the directory outgrows its
+// size limit, the recorder deletes the oldest segment. A record cut short by
+// a crash ends its segment; the recorder always starts a new one.
+const (
+	segmentMagic = "PMH\x01"
+	segmentExt   = ".pmh"
+
+	defaultHistorySize = 64 << 20
+	defaultSegmentSize = 4 << 20
+)
+
+// ErrNoSnapshot is returned by history queries that match no snapshot
+var ErrNoSnapshot = errors.New("no snapshot in history")
+
+// Recorder appends snapshots to a history directory
+type Recorder struct {
+	dir string
+	cfg recorderConfig
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64 // of the current segment
+	segments []segmentFile
+	total    int64
+	buf      bytes.Buffer
+	zw       *flate.Writer
+}
+
+// RecorderOption configures a Recorder
+type RecorderOption func(*recorderConfig)
+
+type recorderConfig struct {
+	maxSize     int64
+	segmentSize int64
+}
+
+// WithMaxHistorySize bounds the size of the history directory. The oldest
+// snapshots are deleted a segment at a time to stay below it. The default
+// is 64 MiB.
+func WithMaxHistorySize(bytes int64) RecorderOption {
+	return func(c *recorderConfig) {
+		c.maxSize = bytes
+	}
+}
+
+// WithSegmentSize sets the size at which the recorder starts a new segment
+// file, and so how much history is deleted at once. The default is 4 MiB,
+// or a quarter of the history size if that is smaller.
+func WithSegmentSize(bytes int64) RecorderOption {
+	return func(c *recorderConfig) {
+		c.segmentSize = bytes
+	}
+}
+
+type segmentFile struct {
+	name string
+	size int64
+}
+
+// NewRecorder creates a recorder writing to dir, which is created if needed.
+// Snapshots already in dir are kept and count towards its size limit.
+func NewRecorder(dir string, opts ...RecorderOption) (*Recorder, error) {
+	cfg := recorderConfig{maxSize: defaultHistorySize}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.maxSize <= 0 {
+		return nil, fmt.Errorf("invalid history size %d", cfg.maxSize)
+	}
+	if cfg.segmentSize <= 0 {
+		cfg.segmentSize = min(defaultSegmentSize, cfg.maxSize/4)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	names, err := segmentNames(dir)
+	if err != nil {
+		return nil, err
+	}
+	zw, _ := flate.NewWriter(nil, flate.BestSpeed)
+	r := &Recorder{dir: dir, cfg: cfg, zw: zw}
+	for _, name := range names {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		r.segments = append(r.segments, segmentFile{name: name, size: info.Size()})
+		r.total += info.Size()
+	}
+	return r, nil
+}
+
+// Record appends a snapshot. Snapshots should be recorded in time order.
+func (r *Recorder) Record(s *Snapshot) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	record, err := r.encode(s)
+	if err != nil {
+		return err
+	}
+	if r.file == nil || r.size+int64(len(record)) > r.cfg.segmentSize {
+		if err := r.rotate(s.Time); err != nil {
+			return err
+		}
+	}
+	if _, err := r.file.Write(record); err != nil {
+		// Readers stop at the torn record, so continue in a new segment
+		r.file.Close()
+		r.file = nil
+		return err
+	}
+	r.size += int64(len(record))
+	r.total += int64(len(record))
+	r.segments[len(r.segments)-1].size = r.size
+
+	// Drop whole segments from the old end, but never the one being written
+	for r.total > r.cfg.maxSize && len(r.segments) > 1 {
+		oldest := r.segments[0]
+		if err := os.Remove(filepath.Join(r.dir, oldest.name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		r.segments = r.segments[1:]
+		r.total -= oldest.size
+	}
+	return nil
+}
+
+// encode frames a snapshot as a record
+func (r *Recorder) encode(s *Snapshot) ([]byte, error) {
+	r.buf.Reset()
+	r.zw.Reset(&r.buf)
+	if _, err := r.zw.Write(appendSnapshot(nil, s)); err != nil {
+		return nil, err
+	}
+	if err := r.zw.Close(); err != nil {
+		return nil, err
+	}
+
+	body := binary.AppendVarint(nil, s.Time.UnixNano())
+	body = append(body, r.buf.Bytes()...)
+	record := binary.AppendUvarint(nil, uint64(len(body)))
+	record = append(record, body...)
+	return binary.LittleEndian.AppendUint32(record, crc32.ChecksumIEEE(body)), nil
+}
+
+// rotate closes the current segment and starts a new one
+func (r *Recorder) rotate(t time.Time) error {
+	if r.file != nil {
+		if err := r.file.Close(); err != nil {
+			return err
+		}
+		r.file = nil
+	}
+	name := fmt.Sprintf("%020d%s", t.UnixNano(), segmentExt)
+	f, err := os.OpenFile(filepath.Join(r.dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(segmentMagic); err != nil {
+		f.Close()
+		return err
+	}
+	r.file = f
+	r.size = int64(len(segmentMagic))
+	r.total += r.size
+	r.segments = append(r.segments, segmentFile{name: name, size: r.size})
+	return nil
+}
+
+// Close closes the current segment
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// segmentNames lists the segments of a history directory, oldest first
+func segmentNames(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		stem, ok := strings.CutSuffix(e.Name(), segmentExt)
+		if !ok || e.IsDir() {
+			continue
+		}
+		if _, err := strconv.ParseUint(stem, 10, 64); err != nil {
+			continue
+		}
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// History reads the snapshots of a history directory. It indexes the
+// snapshots present when it is opened or reloaded.
+type History struct {
+	dir   string
+	index []recordRef
+}
+
+// recordRef locates a record in a segment
+type recordRef struct {
+	time    time.Time
+	segment string
+	offset  int64 // of the compressed snapshot
+	size    int
+}
+
+// OpenHistory indexes the snapshots in a history directory
+func OpenHistory(dir string) (*History, error) {
+	h := &History{dir: dir}
+	if err := h.Reload(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Reload indexes the history directory again, picking up snapshots
+// recorded since and dropping deleted ones
+func (h *History) Reload() error {
+	names, err := segmentNames(h.dir)
+	if err != nil {
+		return err
+	}
+	var index []recordRef
+	for _, name := range names {
+		err := scanSegment(filepath.Join(h.dir, name), func(t time.Time, offset int64, payload []byte) error {
+			index = append(index, recordRef{time: t, segment: name, offset: offset, size: len(payload)})
+			return nil
+		})
+		// The recorder may have deleted the segment in the meantime
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	sort.SliceStable(index, func(i, j int) bool { return index[i].time.Before(index[j].time) })
+	h.index = index
+	return nil
+}
+
+// scanSegment calls fn for every intact record of a segment with the
+// snapshot time, the offset of the compressed snapshot and the compressed
+// snapshot, which is only valid during the call. It stops quietly at a torn
+// or corrupt record.
+func scanSegment(path string, fn func(t time.Time, offset int64, payload []byte) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	magic := make([]byte, len(segmentMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != segmentMagic {
+		return nil
+	}
+	offset := int64(len(segmentMagic))
+
+	var body []byte
+	for {
+		length, err := binary.ReadUvarint(br)
+		if err != nil || length > 1<<30 {
+			return nil
+		}
+		if uint64(cap(body)) < length+4 {
+			body = make([]byte, length+4)
+		}
+		body = body[:length+4]
+		if _, err := io.ReadFull(br, body); err != nil {
+			return nil
+		}
+		sum := binary.LittleEndian.Uint32(body[length:])
+		body = body[:length]
+		if crc32.ChecksumIEEE(body) != sum {
+			return nil
+		}
+		ns, n := binary.Varint(body)
+		if n <= 0 {
+			return nil
+		}
+
+		start := offset + int64(uvarintLen(length)) + int64(n)
+		if err := fn(time.Unix(0, ns), start, body[n:]); err != nil {
+			return err
+		}
+		offset += int64(uvarintLen(length)) + int64(length) + 4
+	}
+}
+
+func uvarintLen(v uint64) int {
+	var buf [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(buf[:], v)
+}
+
+// decompress decodes a compressed snapshot
+func decompress(payload []byte, t time.Time) (*Snapshot, error) {
+	data, err := io.ReadAll(flate.NewReader(bytes.NewReader(payload)))
+	if err != nil {
+		return nil, errCorruptSnapshot
+	}
+	return decodeSnapshot(data, t)
+}
+
+// Len returns the number of snapshots
+func (h *History) Len() int {
+	return len(h.index)
+}
+
+// Time returns the time of snapshot i, counting from the oldest
+func (h *History) Time(i int) time.Time {
+	return h.index[i].time
+}
+
+// Snapshot reads snapshot i, counting from the oldest
+func (h *History) Snapshot(i int) (*Snapshot, error) {
+	if i < 0 || i >= len(h.index) {
+		return nil, ErrNoSnapshot
+	}
+	ref := h.index[i]
+	f, err := os.Open(filepath.Join(h.dir, ref.segment))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	payload := make([]byte, ref.size)
+	if _, err := f.ReadAt(payload, ref.offset); err != nil {
+		return nil, err
+	}
+	s, err := decompress(payload, ref.time)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot of %s: %w", ref.time.Format(time.RFC3339), err)
+	}
+	return s, nil
+}
+
+// Search returns the index of the last snapshot taken at or before t, or of
+// the first snapshot if all are later
+func (h *History) Search(t time.Time) int {
+	i := sort.Search(len(h.index), func(i int) bool { return h.index[i].time.After(t) })
+	return max(i-1, 0)
+}
+
+// Snapshots calls fn for every snapshot taken between from and to, both
+// included, oldest first. A zero from or to leaves that end open.
+func (h *History) Snapshots(from, to time.Time, fn func(s *Snapshot) error) error {
+	var segments []string
+	for _, ref := range h.index {
+		if inSpan(ref.time, from, to) && (len(segments) == 0 || segments[len(segments)-1] != ref.segment) {
+			segments = append(segments, ref.segment)
+		}
+	}
+	for _, name := range segments {
+		err := scanSegment(filepath.Join(h.dir, name), func(t time.Time, offset int64, payload []byte) error {
+			if !inSpan(t, from, to) {
+				return nil
+			}
+			s, err := decompress(payload, t)
+			if err != nil {
+				return fmt.Errorf("snapshot of %s: %w", t.Format(time.RFC3339), err)
+			}
+			return fn(s)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func inSpan(t, from, to time.Time) bool {
+	return (from.IsZero() || !t.Before(from)) && (to.IsZero() || !t.After(to))
+}
+
+// ProcessSummary describes a process over a span of history
+type ProcessSummary struct {
+	PID       int
+	Name      string
+	Command   string
+	User      string
+	StartTime time.Time
+
+	FirstSeen time.Time
+	LastSeen  time.Time
+	Samples   int // snapshots the process appears in
+
+	// CPUSeconds estimates the CPU time the process used during the span.
+	// Each snapshot's CPU usage covers the time since the snapshot before,
+	// so the first snapshot of the span adds nothing, and neither does the
+	// first after a break in the recording.
+	CPUSeconds       float64
+	AvgCPUPercent    float64
+	MaxCPUPercent    float64
+	MaxMemory        uint64 // in bytes
+	MaxMemoryPercent float64
+}
+
+// breakFactor is how many recording intervals a gap between snapshots must
+// exceed to count as a break, such as the recorder being stopped
+const breakFactor = 3
+
+// interval returns the median time between the snapshots taken between
+// from and to, as an estimate of the recording interval, or 0 when there
+// are fewer than two
+func (h *History) interval(from, to time.Time) time.Duration {
+	var gaps []time.Duration
+	var prev time.Time
+	for _, ref := range h.index {
+		if !inSpan(ref.time, from, to) {
+			continue
+		}
+		if !prev.IsZero() {
+			gaps = append(gaps, ref.time.Sub(prev))
+		}
+		prev = ref.time
+	}
+	if len(gaps) == 0 {
+		return 0
+	}
+	sort.Slice(gaps, func(i, j int) bool { return gaps[i] < gaps[j] })
+	return gaps[len(gaps)/2]
+}
+
+// Summarize summarizes every process seen between from and to, both
+// included, ordered by CPU time. A zero from or to leaves that end open.
+// Processes that reused a PID are summarized separately. Gaps much longer
+// than the recording interval are breaks, whose time counts towards no
+// process.
+func (h *History) Summarize(from, to time.Time) ([]ProcessSummary, error) {
+	type processKey struct {
+		pid   int
+		start int64
+	}
+	summaries := make(map[processKey]*ProcessSummary)
+	var order []processKey
+	var prev time.Time
+	maxGap := breakFactor * h.interval(from, to)
+	err := h.Snapshots(from, to, func(s *Snapshot) error {
+		var elapsed float64
+		if gap := s.Time.Sub(prev); !prev.IsZero() && gap <= maxGap {
+			elapsed = gap.Seconds()
+		}
+		prev = s.Time
+
+		for _, p := range s.Processes {
+			key := processKey{p.PID, p.StartTime.UnixNano()}
+			sum := summaries[key]
+			if sum == nil {
+				sum = &ProcessSummary{PID: p.PID, StartTime: p.StartTime, FirstSeen: s.Time}
+				summaries[key] = sum
+				order = append(order, key)
+			}
+			sum.Name, sum.Command, sum.User = p.Name, p.Command, p.User
+			sum.LastSeen = s.Time
+			sum.Samples++
+			sum.CPUSeconds += p.CPUPercent / 100 * elapsed
+			sum.AvgCPUPercent += p.CPUPercent
+			sum.MaxCPUPercent = max(sum.MaxCPUPercent, p.CPUPercent)
+			sum.MaxMemory = max(sum.MaxMemory, p.Memory)
+			sum.MaxMemoryPercent = max(sum.MaxMemoryPercent, p.MemoryPercent)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if prev.IsZero() {
+		return nil, ErrNoSnapshot
+	}
+
+	result := make([]ProcessSummary, len(order))
+	for i, key := range order {
+		sum := summaries[key]
+		sum.AvgCPUPercent /= float64(sum.Samples)
+		result[i] = *sum
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].CPUSeconds > result[j].CPUSeconds })
+	return result, nil
+}
+
+// TopCPU returns the n processes that used the most CPU time between from
+// and to, or none when n is not positive
+func (h *History) TopCPU(from, to time.Time, n int) ([]ProcessSummary, error) {
+	summaries, err := h.Summarize(from, to)
+	if err != nil {
+		return nil, err
+	}
+	return summaries[:min(max(n, 0), len(summaries))], nil
+}
+
+// TopMemory returns the n processes with the highest peak resident memory
+// between from and to, or none when n is not positive
+func (h *History) TopMemory(from, to time.Time, n int) ([]ProcessSummary, error) {
+	summaries, err := h.Summarize(from, to)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(summaries, func(i, j int) bool { return summaries[i].MaxMemory > summaries[j].MaxMemory })
+	return summaries[:min(max(n, 0), len(summaries))], nil
+}
@@ -0,0 +1,301 @@
+package pm
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var historyStart = time.Unix(1760860800, 0)
+
+// snapshotAt returns a snapshot taken n seconds after historyStart
+func snapshotAt(n int, processes ...Process) *Snapshot {
+	return &Snapshot{
+		Time:      historyStart.Add(time.Duration(n) * time.Second),
+		Stats:     SystemStats{TotalProcesses: len(processes), TotalMemory: 16318412, CPUUsagePercent: float64(n)},
+		Processes: processes,
+	}
+}
+
+// record writes snapshots to a new recorder on dir
+func record(t *testing.T, dir string, snapshots []*Snapshot, opts ...RecorderOption) {
+	t.Helper()
+	r, err := NewRecorder(dir, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range snapshots {
+		if err := r.Record(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func openHistory(t *testing.T, dir string) *History {
+	t.Helper()
+	h, err := OpenHistory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestSnapshotEncoding(t *testing.T) {
+	s := snapshotAt(0,
+		Process{PID: 1, Name: "systemd", State: "S", User: "root", Memory: 12 << 20, MemoryPercent: 0.1,
+			StartTime: historyStart.Add(-time.Hour), Command: "/sbin/init splash", OpenFDs: -1, Threads: 1},
+		Process{PID: 77, PPID: 2, Name: "kworker/0:1", State: "I", User: "root", Command: "[kworker/0:1]",
+			KernelThread: true, OpenFDs: -1},
+		Process{PID: 4242, PPID: 1, Name: "worker", State: "R", User: "4000000", Memory: 2850 * 4096,
+			MemoryPercent: 0.07, CPUPercent: 200, UserCPUPercent: 150, SystemCPUPercent: 50, Nice: -5,
+			StartTime: historyStart.Add(-time.Minute), Command: "worker --jobs=4", VirtualMemory: 27033600,
+			Threads: 4, VoluntaryCtxSwitches: 1620, InvoluntaryCtxSwitches: 251, OpenFDs: 6,
+			IO: &IOStats{ReadChars: 1 << 20, WriteChars: 2 << 20, ReadSyscalls: 10, WriteSyscalls: 20,
+				ReadBytes: 4096, WriteBytes: 8192, CancelledWriteBytes: 1, ReadBytesPerSec: 0.5, WriteBytesPerSec: math.MaxFloat64}},
+	)
+	data := appendSnapshot(nil, s)
+	got, err := decodeSnapshot(data, s.Time)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, s) {
+		t.Errorf("decoded snapshot = %+v, want %+v", got, s)
+	}
+
+	for _, n := range []int{0, 1, len(data) / 2, len(data) - 1} {
+		if _, err := decodeSnapshot(data[:n], s.Time); err == nil {
+			t.Errorf("decoding %d of %d bytes succeeded", n, len(data))
+		}
+	}
+	if _, err := decodeSnapshot(append(data, 0), s.Time); err == nil {
+		t.Error("decoding with a trailing byte succeeded")
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	var snapshots []*Snapshot
+	for i := range 10 {
+		snapshots = append(snapshots, snapshotAt(2*i, Process{PID: 100 + i, Name: "p", OpenFDs: -1}))
+	}
+	record(t, dir, snapshots[:6])
+	// A second recorder adds to the history in a new segment
+	record(t, dir, snapshots[6:])
+
+	h := openHistory(t, dir)
+	if h.Len() != len(snapshots) {
+		t.Fatalf("Len = %d, want %d", h.Len(), len(snapshots))
+	}
+	for i, want := range snapshots {
+		if !h.Time(i).Equal(want.Time) {
+			t.Errorf("Time(%d) = %v, want %v", i, h.Time(i), want.Time)
+		}
+		got, err := h.Snapshot(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Snapshot(%d) = %+v, want %+v", i, got, want)
+		}
+	}
+	if _, err := h.Snapshot(len(snapshots)); err != ErrNoSnapshot {
+		t.Errorf("Snapshot past the end: error = %v, want ErrNoSnapshot", err)
+	}
+
+	searches := []struct {
+		seconds int
+		want    int
+	}{
+		{-5, 0}, {0, 0}, {1, 0}, {2, 1}, {7, 3}, {18, 9}, {100, 9},
+	}
+	for _, s := range searches {
+		if got := h.Search(historyStart.Add(time.Duration(s.seconds) * time.Second)); got != s.want {
+			t.Errorf("Search(+%ds) = %d, want %d", s.seconds, got, s.want)
+		}
+	}
+
+	var seen []int
+	err := h.Snapshots(historyStart.Add(4*time.Second), historyStart.Add(10*time.Second), func(s *Snapshot) error {
+		seen = append(seen, s.Processes[0].PID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{102, 103, 104, 105}; !reflect.DeepEqual(seen, want) {
+		t.Errorf("Snapshots(+4s, +10s) = %v, want %v", seen, want)
+	}
+}
+
+func TestHistoryRing(t *testing.T) {
+	dir := t.TempDir()
+	const maxSize = 4096
+	var snapshots []*Snapshot
+	for i := range 200 {
+		snapshots = append(snapshots, snapshotAt(i, Process{PID: i + 1, Name: "p", Command: "p --id", OpenFDs: -1}))
+	}
+	record(t, dir, snapshots, WithMaxHistorySize(maxSize), WithSegmentSize(512))
+
+	var total int64
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += info.Size()
+	}
+	if total > maxSize {
+		t.Errorf("history takes %d bytes, want at most %d", total, maxSize)
+	}
+
+	h := openHistory(t, dir)
+	if h.Len() == 0 || h.Len() >= len(snapshots) {
+		t.Fatalf("Len = %d, want the newest of %d snapshots", h.Len(), len(snapshots))
+	}
+	// The newest snapshots survive, without gaps
+	first := len(snapshots) - h.Len()
+	for i := range h.Len() {
+		if !h.Time(i).Equal(snapshots[first+i].Time) {
+			t.Fatalf("Time(%d) = %v, want %v", i, h.Time(i), snapshots[first+i].Time)
+		}
+	}
+}
+
+func TestHistoryTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	record(t, dir, []*Snapshot{snapshotAt(0), snapshotAt(1), snapshotAt(2)})
+
+	names, err := segmentNames(dir)
+	if err != nil || len(names) != 1 {
+		t.Fatalf("segments = %v, %v", names, err)
+	}
+	path := filepath.Join(dir, names[0])
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Cut the last record short, as a crash while writing would
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+	if h := openHistory(t, dir); h.Len() != 2 {
+		t.Errorf("Len with a torn record = %d, want 2", h.Len())
+	}
+
+	// Garbage in place of a record ends the segment too
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(segmentMagic)+5] ^= 0xff
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if h := openHistory(t, dir); h.Len() != 0 {
+		t.Errorf("Len with a corrupt first record = %d, want 0", h.Len())
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	dir := t.TempDir()
+	web := Process{PID: 10, Name: "web", User: "www", StartTime: historyStart.Add(-time.Hour)}
+	batch := Process{PID: 20, Name: "batch", User: "jobs", StartTime: historyStart}
+	reused := Process{PID: 20, Name: "cron", User: "root", StartTime: historyStart.Add(7 * time.Second)}
+	with := func(p Process, cpu float64, memory uint64) Process {
+		p.CPUPercent, p.Memory, p.OpenFDs = cpu, memory, -1
+		return p
+	}
+	record(t, dir, []*Snapshot{
+		snapshotAt(0, with(web, 90, 100), with(batch, 0, 50)),
+		snapshotAt(2, with(web, 50, 100), with(batch, 100, 500)),
+		snapshotAt(4, with(web, 50, 300), with(batch, 150, 400)),
+		snapshotAt(8, with(web, 25, 200), with(reused, 10, 10)),
+	})
+	h := openHistory(t, dir)
+
+	summaries, err := h.Summarize(time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	type result struct {
+		name       string
+		cpuSeconds float64
+		samples    int
+		avg, peak  float64
+		maxMemory  uint64
+	}
+	var got []result
+	for _, s := range summaries {
+		got = append(got, result{s.Name, s.CPUSeconds, s.Samples, s.AvgCPUPercent, s.MaxCPUPercent, s.MaxMemory})
+	}
+	want := []result{
+		// 100% for 2s, then 150% for 2s
+		{"batch", 5, 3, 250.0 / 3, 150, 500},
+		// The first snapshot's 90% covers time before the span
+		{"web", 3, 4, 53.75, 90, 300},
+		{"cron", 0.4, 1, 10, 10, 10},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Summarize = %+v, want %+v", got, want)
+	}
+
+	top, err := h.TopCPU(historyStart.Add(2*time.Second), historyStart.Add(4*time.Second), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the snapshot at +4s counts towards CPU time
+	if len(top) != 1 || top[0].Name != "batch" || top[0].CPUSeconds != 3 {
+		t.Errorf("TopCPU(+2s, +4s, 1) = %+v, want batch with 3s", top)
+	}
+
+	top, err = h.TopMemory(time.Time{}, time.Time{}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(top) != 2 || top[0].Name != "batch" || top[1].Name != "web" {
+		t.Errorf("TopMemory(2) = %+v, want batch and web", top)
+	}
+
+	for _, n := range []int{0, -1} {
+		if top, err := h.TopCPU(time.Time{}, time.Time{}, n); err != nil || len(top) != 0 {
+			t.Errorf("TopCPU(%d) = %+v, %v, want none", n, top, err)
+		}
+		if top, err := h.TopMemory(time.Time{}, time.Time{}, n); err != nil || len(top) != 0 {
+			t.Errorf("TopMemory(%d) = %+v, %v, want none", n, top, err)
+		}
+	}
+
+	if _, err := h.Summarize(historyStart.Add(time.Hour), time.Time{}); err != ErrNoSnapshot {
+		t.Errorf("Summarize after the history: error = %v, want ErrNoSnapshot", err)
+	}
+}
+
+func TestSummarizeBreak(t *testing.T) {
+	dir := t.TempDir()
+	web := Process{PID: 10, Name: "web", CPUPercent: 50, OpenFDs: -1}
+	// The recorder was stopped between +4s and +100s
+	record(t, dir, []*Snapshot{
+		snapshotAt(0, web), snapshotAt(2, web), snapshotAt(4, web),
+		snapshotAt(100, web), snapshotAt(102, web),
+	})
+	h := openHistory(t, dir)
+
+	summaries, err := h.Summarize(time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 50% for 2s after +2s, +4s and +102s, none of the 96s break
+	if len(summaries) != 1 || summaries[0].CPUSeconds != 3 || summaries[0].Samples != 5 {
+		t.Errorf("Summarize = %+v, want 3 CPU seconds over 5 samples", summaries)
+	}
+}
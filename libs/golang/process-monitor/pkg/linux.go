@@ -5,53 +5,129 @@ package pm
 
 import (
 	"bufio"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"os/user"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 )
 
 // linuxMonitor implements ProcessMonitor for Linux systems
 type linuxMonitor struct {
-	pageSize      int64
-	cpuTicks      int64
-	lastCPUStats  map[int]cpuStats
-	lastTotalCPU  uint64
-	lastCheckTime time.Time
+	proc      fs.FS // the procfs, normally /proc
+	metrics   Metrics
+	pageSize  int64
+	cpuTicks  int64
+	now       func() time.Time
+	lastStats map[int]processSample
 }
 
-type cpuStats struct {
-	utime uint64
-	stime uint64
-	total uint64
+// processSample is what a scan keeps of a process to compute rates at the
+// next one
+type processSample struct {
+	cpu CPUSnapshot
+	io  *IOStats
 }
 
 // newPlatformMonitor creates a new Linux-specific process monitor
-func newPlatformMonitor() (ProcessMonitor, error) {
+func newPlatformMonitor(cfg config) (ProcessMonitor, error) {
 	pageSize := int64(os.Getpagesize())
-	cpuTicks := int64(100) // Default Hz value, could read from sysconf
+	cpuTicks := readClockTicks()
+
+	proc := cfg.procFS
+	if proc == nil {
+		proc = os.DirFS("/proc")
+	}
 
 	return &linuxMonitor{
-		pageSize:     pageSize,
-		cpuTicks:     cpuTicks,
-		lastCPUStats: make(map[int]cpuStats),
+		proc:      proc,
+		metrics:   cfg.metrics,
+		pageSize:  pageSize,
+		cpuTicks:  cpuTicks,
+		now:       time.Now,
+		lastStats: make(map[int]processSample),
 	}, nil
 }
 
-// GetProcesses returns all running processes
+// defaultClockTicks is USER_HZ on all mainstream architectures, used when
+// the auxiliary vector can't be read
+const defaultClockTicks = 100
+
+// atClkTck is the auxiliary vector entry holding the clock tick rate
+const atClkTck = 17
+
+// readClockTicks returns the rate of the clock ticks the kernel reports CPU
+// times in, sysconf(_SC_CLK_TCK). The kernel passes it to every process in
+// its auxiliary vector. It is read from the monitor's own /proc rather than
+// the configured procfs, which may not have a self entry.
+func readClockTicks() int64 {
+	auxv, err := os.ReadFile("/proc/self/auxv")
+	if err != nil {
+		return defaultClockTicks
+	}
+	if hz, ok := parseAuxvClockTicks(auxv, strconv.IntSize/8); ok {
+		return hz
+	}
+	return defaultClockTicks
+}
+
+// parseAuxvClockTicks finds AT_CLKTCK in an auxiliary vector, a list of
+// native-endian key/value pairs of wordSize bytes ending with AT_NULL
+func parseAuxvClockTicks(auxv []byte, wordSize int) (int64, bool) {
+	word := func(b []byte) uint64 {
+		if wordSize == 4 {
+			return uint64(binary.NativeEndian.Uint32(b))
+		}
+		return binary.NativeEndian.Uint64(b)
+	}
+	for i := 0; i+2*wordSize <= len(auxv); i += 2 * wordSize {
+		key, value := word(auxv[i:]), word(auxv[i+wordSize:])
+		if key == 0 {
+			break
+		}
+		if key == atClkTck && value > 0 {
+			return int64(value), true
+		}
+	}
+	return 0, false
+}
+
+// GetProcesses returns all running processes, with their CPU usage since
+// the previous call
 func (m *linuxMonitor) GetProcesses() ([]Process, error) {
-	entries, err := os.ReadDir("/proc")
+	processes, snapshots, err := m.scan()
+	if err != nil {
+		return nil, err
+	}
+
+	current := make(map[int]processSample, len(snapshots))
+	for i := range processes {
+		proc, snap := &processes[i], &snapshots[i]
+		if last, ok := m.lastStats[snap.PID]; ok {
+			setRates(proc, last, snap)
+		}
+		current[snap.PID] = processSample{cpu: *snap, io: proc.IO}
+	}
+	m.lastStats = current
+
+	return processes, nil
+}
+
+// scan reads every process in the procfs along with its CPU snapshot
+func (m *linuxMonitor) scan() ([]Process, []CPUSnapshot, error) {
+	entries, err := fs.ReadDir(m.proc, ".")
 	if err != nil {
-		return nil, fmt.Errorf("failed to read /proc: %w", err)
+		return nil, nil, fmt.Errorf("failed to read procfs: %w", err)
 	}
 
 	processes := make([]Process, 0)
-	currentCPUStats := make(map[int]cpuStats)
-	totalCPU := m.getTotalCPU()
-	currentTime := time.Now()
-	timeDelta := currentTime.Sub(m.lastCheckTime).Seconds()
+	snapshots := make([]CPUSnapshot, 0)
+	base := m.baseSnapshot()
 
 	for _, entry := range entries {
 		if !entry.IsDir() {
@@ -63,48 +139,100 @@ func (m *linuxMonitor) GetProcesses() ([]Process, error) {
 			continue // Not a PID directory
 		}
 
-		proc, cpuStat, err := m.readProcessInfo(pid)
+		proc, snap, err := m.readProcessInfo(pid, base)
 		if err != nil {
-			continue // Process might have terminated
+			continue // Process might have terminated, or be unreadable
 		}
 
-		// Calculate CPU usage
-		if lastStat, ok := m.lastCPUStats[pid]; ok && timeDelta > 0 {
-			cpuDelta := float64(cpuStat.total - lastStat.total)
-			totalDelta := float64(totalCPU - m.lastTotalCPU)
-			if totalDelta > 0 {
-				proc.CPUPercent = (cpuDelta / totalDelta) * 100.0 * float64(m.getNumCPU())
-			}
-		}
-
-		currentCPUStats[pid] = cpuStat
 		processes = append(processes, *proc)
+		snapshots = append(snapshots, snap)
 	}
 
-	// Update last stats for next calculation
-	m.lastCPUStats = currentCPUStats
-	m.lastTotalCPU = totalCPU
-	m.lastCheckTime = currentTime
+	return processes, snapshots, nil
+}
 
-	return processes, nil
+// baseSnapshot returns the system-wide part of a CPU snapshot, taken once
+// per scan
+func (m *linuxMonitor) baseSnapshot() CPUSnapshot {
+	return CPUSnapshot{
+		TotalJiffies: m.getTotalCPU(),
+		NumCPU:       m.getNumCPU(),
+		ClockTicks:   m.cpuTicks,
+		Time:         m.now(),
+	}
+}
+
+// setRates fills the CPU usage and I/O rates of proc since last, the
+// previous sample of the same PID
+func setRates(proc *Process, last processSample, snap *CPUSnapshot) {
+	usage := CalculateCPUUsage(&last.cpu, snap)
+	proc.UserCPUPercent = usage.User
+	proc.SystemCPUPercent = usage.System
+	proc.CPUPercent = usage.Total()
+
+	// The I/O counters only grow, unless the PID now belongs to another
+	// process
+	elapsed := snap.Time.Sub(last.cpu.Time).Seconds()
+	if proc.IO == nil || last.io == nil || elapsed <= 0 || last.cpu.StartTicks != snap.StartTicks {
+		return
+	}
+	if proc.IO.ReadBytes >= last.io.ReadBytes {
+		proc.IO.ReadBytesPerSec = float64(proc.IO.ReadBytes-last.io.ReadBytes) / elapsed
+	}
+	if proc.IO.WriteBytes >= last.io.WriteBytes {
+		proc.IO.WriteBytesPerSec = float64(proc.IO.WriteBytes-last.io.WriteBytes) / elapsed
+	}
+}
+
+// GetCPUSnapshot reads the CPU counters of a specific process
+func (m *linuxMonitor) GetCPUSnapshot(pid int) (*CPUSnapshot, error) {
+	data, err := m.readFile("%d/stat", pid)
+	if err != nil {
+		return nil, processError(pid, err)
+	}
+	stat, err := parseStat(data)
+	if err != nil {
+		return nil, err
+	}
+	snap := stat.snapshot(pid, m.baseSnapshot())
+	return &snap, nil
+}
+
+// GetCPUSnapshots reads the CPU counters of all processes
+func (m *linuxMonitor) GetCPUSnapshots() ([]CPUSnapshot, error) {
+	_, snapshots, err := m.scan()
+	return snapshots, err
 }
 
-// GetProcess returns information about a specific process
+// GetProcess returns information about a specific process. Its CPU usage is
+// measured since the last GetProcesses call, if the process was seen then,
+// and so are its I/O rates.
 func (m *linuxMonitor) GetProcess(pid int) (*Process, error) {
-	proc, _, err := m.readProcessInfo(pid)
-	return proc, err
+	proc, snap, err := m.readProcessInfo(pid, m.baseSnapshot())
+	if err != nil {
+		return nil, err
+	}
+	if last, ok := m.lastStats[pid]; ok {
+		setRates(proc, last, &snap)
+	}
+	return proc, nil
 }
 
 // GetSystemStats returns system-wide statistics
 func (m *linuxMonitor) GetSystemStats() (*SystemStats, error) {
-	stats := &SystemStats{}
-
-	// Get process counts by state
-	processes, err := m.GetProcesses()
+	// Get process counts by state. This leaves the CPU baseline of
+	// GetProcesses alone.
+	processes, _, err := m.scan()
 	if err != nil {
 		return nil, err
 	}
+	return m.systemStats(processes)
+}
 
+// systemStats returns the system-wide statistics, counting the states of
+// processes already read
+func (m *linuxMonitor) systemStats(processes []Process) (*SystemStats, error) {
+	stats := &SystemStats{}
 	stats.TotalProcesses = len(processes)
 	for _, p := range processes {
 		switch p.State {
@@ -137,62 +265,138 @@ func (m *linuxMonitor) GetSystemStats() (*SystemStats, error) {
 	return stats, nil
 }
 
-// readProcessInfo reads process information from /proc/[pid]/
-func (m *linuxMonitor) readProcessInfo(pid int) (*Process, cpuStats, error) {
-	proc := &Process{PID: pid}
-	stats := cpuStats{}
+// pfKthread is the flag of /proc/[pid]/stat marking kernel threads
+const pfKthread = 0x00200000
+
+// procStat holds the fields of /proc/[pid]/stat the monitor uses
+type procStat struct {
+	name      string
+	state     string
+	ppid      int
+	flags     uint64
+	utime     uint64 // clock ticks
+	stime     uint64 // clock ticks
+	nice      int
+	threads   int
+	starttime uint64 // clock ticks after boot
+	vsize     uint64 // bytes
+	rss       int64  // pages
+}
 
-	// Read stat file
-	statPath := fmt.Sprintf("/proc/%d/stat", pid)
-	statData, err := os.ReadFile(statPath)
-	if err != nil {
-		return nil, stats, err
+// statFieldsAfterName is how many fields after the name parseStat needs,
+// up to rss. Kernels since 2.6 write more.
+const statFieldsAfterName = 22
+
+// parseStat parses the contents of /proc/[pid]/stat
+func parseStat(data []byte) (*procStat, error) {
+	// The name (field 2) is in parentheses and may itself contain spaces and
+	// parentheses, but nothing after it can, so it ends at the last ')'
+	nameStart := strings.IndexByte(string(data), '(')
+	nameEnd := strings.LastIndexByte(string(data), ')')
+	if nameStart == -1 || nameEnd < nameStart {
+		return nil, fmt.Errorf("invalid stat format: no process name")
 	}
 
-	// Parse stat file
-	fields := strings.Fields(string(statData))
-	if len(fields) < 52 {
-		return nil, stats, fmt.Errorf("invalid stat format")
-	}
+	stat := &procStat{name: string(data[nameStart+1 : nameEnd])}
 
-	// Extract process name (field 1, in parentheses)
-	nameStart := strings.Index(string(statData), "(")
-	nameEnd := strings.LastIndex(string(statData), ")")
-	if nameStart != -1 && nameEnd != -1 && nameEnd > nameStart {
-		proc.Name = string(statData[nameStart+1 : nameEnd])
+	// Fields after the name, starting with the state (field 3)
+	statFields := strings.Fields(string(data[nameEnd+1:]))
+	if len(statFields) < statFieldsAfterName {
+		return nil, fmt.Errorf("invalid stat format: %d fields after the name", len(statFields))
 	}
 
-	// Find the start of numeric fields after the name
-	statFields := strings.Fields(string(statData[nameEnd+2:]))
-
 	// State is the first field after name
-	proc.State = statFields[0]
+	stat.state = statFields[0]
 
-	// PPID is field 3 (index 1 after name)
-	proc.PPID, _ = strconv.Atoi(statFields[1])
+	// PPID is field 4 (index 1 after name)
+	stat.ppid, _ = strconv.Atoi(statFields[1])
 
-	// CPU times are fields 13-14 (indices 11-12 after name)
-	utime, _ := strconv.ParseUint(statFields[11], 10, 64)
-	stime, _ := strconv.ParseUint(statFields[12], 10, 64)
-	stats.utime = utime
-	stats.stime = stime
-	stats.total = utime + stime
+	// Flags are field 9 (index 6 after name)
+	stat.flags, _ = strconv.ParseUint(statFields[6], 10, 64)
 
-	// Virtual memory size is field 22 (index 20 after name)
-	// vsize, _ := strconv.ParseUint(statFields[20], 10, 64)
+	// CPU times are fields 14-15 (indices 11-12 after name)
+	stat.utime, _ = strconv.ParseUint(statFields[11], 10, 64)
+	stat.stime, _ = strconv.ParseUint(statFields[12], 10, 64)
 
-	// RSS is field 23 (index 21 after name) in pages
-	rss, _ := strconv.ParseInt(statFields[21], 10, 64)
-	proc.Memory = uint64(rss * m.pageSize)
+	// Nice value is field 19 (index 16 after name)
+	stat.nice, _ = strconv.Atoi(statFields[16])
 
-	// Start time is field 21 (index 19 after name)
-	starttime, _ := strconv.ParseUint(statFields[19], 10, 64)
-	bootTime := m.getBootTime()
-	proc.StartTime = time.Unix(int64(bootTime+starttime/uint64(m.cpuTicks)), 0)
+	// Thread count is field 20 (index 17 after name)
+	stat.threads, _ = strconv.Atoi(statFields[17])
+
+	// Start time is field 22 (index 19 after name)
+	stat.starttime, _ = strconv.ParseUint(statFields[19], 10, 64)
+
+	// Virtual memory size is field 23 (index 20 after name)
+	stat.vsize, _ = strconv.ParseUint(statFields[20], 10, 64)
+
+	// RSS is field 24 (index 21 after name) in pages
+	stat.rss, _ = strconv.ParseInt(statFields[21], 10, 64)
+
+	return stat, nil
+}
+
+// kernelThread reports whether the process is a kernel thread. kthreadd
+// (PID 2) lacks the flag but is the parent of all the others.
+func (s *procStat) kernelThread(pid int) bool {
+	return s.flags&pfKthread != 0 || pid == 2
+}
+
+// processError reports a failure to read a file of process pid. Processes
+// can exit at any point of a read, so a missing file (or ESRCH, which some
+// files return for exiting processes) is reported as ErrNoProcess.
+func processError(pid int, err error) error {
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ESRCH) {
+		return fmt.Errorf("process %d: %w", pid, ErrNoProcess)
+	}
+	return fmt.Errorf("process %d: %w", pid, err)
+}
+
+// snapshot completes the system-wide fields of base with the process's
+// counters
+func (s *procStat) snapshot(pid int, base CPUSnapshot) CPUSnapshot {
+	base.PID = pid
+	base.StartTicks = s.starttime
+	base.UserTicks = s.utime
+	base.SystemTicks = s.stime
+	return base
+}
+
+// readProcessInfo reads process information from /proc/[pid]/. base holds
+// the system-wide fields of the returned snapshot.
+func (m *linuxMonitor) readProcessInfo(pid int, base CPUSnapshot) (*Process, CPUSnapshot, error) {
+	// Read stat file
+	statData, err := m.readFile("%d/stat", pid)
+	if err != nil {
+		return nil, CPUSnapshot{}, processError(pid, err)
+	}
+	stat, err := parseStat(statData)
+	if err != nil {
+		return nil, CPUSnapshot{}, fmt.Errorf("process %d: %w", pid, err)
+	}
 
-	// Read status for additional info
-	statusPath := fmt.Sprintf("/proc/%d/status", pid)
-	statusData, err := os.ReadFile(statusPath)
+	proc := &Process{
+		PID:           pid,
+		PPID:          stat.ppid,
+		Name:          stat.name,
+		State:         stat.state,
+		Memory:        uint64(stat.rss * m.pageSize),
+		KernelThread:  stat.kernelThread(pid),
+		Nice:          stat.nice,
+		VirtualMemory: stat.vsize,
+		Threads:       stat.threads,
+		OpenFDs:       -1,
+	}
+	bootTime := m.getBootTime()
+	sinceBoot := time.Duration(stat.starttime) * time.Second / time.Duration(m.cpuTicks)
+	proc.StartTime = time.Unix(int64(bootTime), 0).Add(sinceBoot)
+
+	// Read status for additional info. The process may have exited since
+	// stat was read, leaving a half-read process to skip.
+	statusData, err := m.readFile("%d/status", pid)
+	if err != nil && !errors.Is(err, fs.ErrPermission) {
+		return nil, CPUSnapshot{}, processError(pid, err)
+	}
 	if err == nil {
 		scanner := bufio.NewScanner(strings.NewReader(string(statusData)))
 		for scanner.Scan() {
@@ -209,33 +413,93 @@ func (m *linuxMonitor) readProcessInfo(pid int) (*Process, cpuStats, error) {
 				} else {
 					proc.User = strconv.Itoa(uid)
 				}
+			case "voluntary_ctxt_switches:":
+				proc.VoluntaryCtxSwitches, _ = strconv.ParseUint(fields[1], 10, 64)
+			case "nonvoluntary_ctxt_switches:":
+				proc.InvoluntaryCtxSwitches, _ = strconv.ParseUint(fields[1], 10, 64)
 			}
 		}
 	}
 
 	// Read cmdline
-	cmdlinePath := fmt.Sprintf("/proc/%d/cmdline", pid)
-	cmdlineData, err := os.ReadFile(cmdlinePath)
-	if err == nil {
-		proc.Command = strings.ReplaceAll(string(cmdlineData), "\x00", " ")
-		proc.Command = strings.TrimSpace(proc.Command)
-		if proc.Command == "" {
-			proc.Command = fmt.Sprintf("[%s]", proc.Name)
-		}
+	cmdlineData, err := m.readFile("%d/cmdline", pid)
+	if err != nil && !errors.Is(err, fs.ErrPermission) {
+		return nil, CPUSnapshot{}, processError(pid, err)
+	}
+	proc.Command = strings.ReplaceAll(string(cmdlineData), "\x00", " ")
+	proc.Command = strings.TrimSpace(proc.Command)
+	// Kernel threads have no command line, and zombies have lost theirs, so
+	// show their names like ps does
+	switch {
+	case proc.Command != "":
+	case proc.State == "Z":
+		proc.Command = fmt.Sprintf("[%s] <defunct>", proc.Name)
+	default:
+		proc.Command = fmt.Sprintf("[%s]", proc.Name)
 	}
 
+	m.readExtendedMetrics(proc)
+
 	// Calculate memory percentage
 	memInfo, err := m.readMemInfo()
 	if err == nil && memInfo["MemTotal"] > 0 {
 		proc.MemoryPercent = float64(proc.Memory) / float64(memInfo["MemTotal"]*1024) * 100
 	}
 
-	return proc, stats, nil
+	return proc, stat.snapshot(pid, base), nil
+}
+
+// readExtendedMetrics reads the metrics enabled with WithMetrics. Metrics
+// that can't be read stay unset: only root can read them for other users'
+// processes, and /proc/[pid]/io needs a kernel with I/O accounting.
+func (m *linuxMonitor) readExtendedMetrics(proc *Process) {
+	if m.metrics&MetricIO != 0 {
+		if data, err := m.readFile("%d/io", proc.PID); err == nil {
+			proc.IO = parseIO(data)
+		}
+	}
+
+	if m.metrics&MetricFDs != 0 {
+		if entries, err := fs.ReadDir(m.proc, fmt.Sprintf("%d/fd", proc.PID)); err == nil {
+			proc.OpenFDs = len(entries)
+		}
+	}
+}
+
+// parseIO parses the contents of /proc/[pid]/io
+func parseIO(data []byte) *IOStats {
+	io := &IOStats{}
+	fields := map[string]*uint64{
+		"rchar:":                 &io.ReadChars,
+		"wchar:":                 &io.WriteChars,
+		"syscr:":                 &io.ReadSyscalls,
+		"syscw:":                 &io.WriteSyscalls,
+		"read_bytes:":            &io.ReadBytes,
+		"write_bytes:":           &io.WriteBytes,
+		"cancelled_write_bytes:": &io.CancelledWriteBytes,
+	}
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), " ")
+		if field, known := fields[key]; ok && known {
+			*field, _ = strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		}
+	}
+	return io
+}
+
+// readFile reads a file of the procfs. The path is relative to its root and
+// formatted with args.
+func (m *linuxMonitor) readFile(path string, args ...any) ([]byte, error) {
+	if len(args) > 0 {
+		path = fmt.Sprintf(path, args...)
+	}
+	return fs.ReadFile(m.proc, path)
 }
 
 // readMemInfo reads /proc/meminfo and returns a map of values
 func (m *linuxMonitor) readMemInfo() (map[string]uint64, error) {
-	data, err := os.ReadFile("/proc/meminfo")
+	data, err := m.readFile("meminfo")
 	if err != nil {
 		return nil, err
 	}
@@ -256,11 +520,15 @@ func (m *linuxMonitor) readMemInfo() (map[string]uint64, error) {
 
 // getTotalCPU reads total CPU jiffies from /proc/stat
 func (m *linuxMonitor) getTotalCPU() uint64 {
-	data, err := os.ReadFile("/proc/stat")
+	data, err := m.readFile("stat")
 	if err != nil {
 		return 0
 	}
+	return parseTotalCPU(data)
+}
 
+// parseTotalCPU sums the jiffies of the aggregate "cpu" line of /proc/stat
+func parseTotalCPU(data []byte) uint64 {
 	scanner := bufio.NewScanner(strings.NewReader(string(data)))
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -279,7 +547,7 @@ func (m *linuxMonitor) getTotalCPU() uint64 {
 
 // getSystemCPUUsage calculates overall system CPU usage
 func (m *linuxMonitor) getSystemCPUUsage() float64 {
-	data, err := os.ReadFile("/proc/stat")
+	data, err := m.readFile("stat")
 	if err != nil {
 		return 0
 	}
@@ -311,7 +579,7 @@ func (m *linuxMonitor) getSystemCPUUsage() float64 {
 
 // getBootTime reads system boot time from /proc/stat
 func (m *linuxMonitor) getBootTime() uint64 {
-	data, err := os.ReadFile("/proc/stat")
+	data, err := m.readFile("stat")
 	if err != nil {
 		return 0
 	}
@@ -329,7 +597,7 @@ func (m *linuxMonitor) getBootTime() uint64 {
 
 // getNumCPU returns the number of CPU cores
 func (m *linuxMonitor) getNumCPU() int {
-	data, err := os.ReadFile("/proc/cpuinfo")
+	data, err := m.readFile("cpuinfo")
 	if err != nil {
 		return 1
 	}
@@ -0,0 +1,409 @@
+//go:build linux
+// +build linux
+
+package pm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// The trees in testdata/proc are captured /proc directories of a 4-CPU
+// machine, one second apart. Process 4242 spends 150 ticks in user mode and
+// 50 in the kernel in between.
+
+// readFixture reads a file of a captured /proc tree
+func readFixture(t *testing.T, path ...string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(append([]string{"testdata", "proc"}, path...)...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// fixtureMonitor returns a monitor reading the captured /proc tree dir
+func fixtureMonitor(t *testing.T, dir string) *linuxMonitor {
+	t.Helper()
+	impl, err := newPlatformMonitor(config{procFS: os.DirFS(filepath.Join("testdata", "proc", dir))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := impl.(*linuxMonitor)
+	m.pageSize = 4096
+	m.cpuTicks = 100
+	return m
+}
+
+// statLine returns a /proc/[pid]/stat line for a process named name
+func statLine(pid int, name, state string, ppid int, flags uint64) string {
+	return fmt.Sprintf("%d (%s) %s %d 1 1 0 -1 %d 100 0 0 0 1200 300 0 0 20 0 1 0 458738 27033600 2850 18446744073709551615 1 1 0 0 0 0 0 0 0 0 0 0 17 2 0 0 0 0 0 0 0 0 0 0 0 0 0\n", pid, name, state, ppid, flags)
+}
+
+func TestParseStat(t *testing.T) {
+	stat, err := parseStat(readFixture(t, "before", "4242", "stat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := procStat{name: "worker", state: "R", ppid: 1, flags: 4194304, utime: 1200, stime: 300, threads: 4, starttime: 458738, vsize: 27033600, rss: 2850}
+	if *stat != want {
+		t.Errorf("stat = %+v, want %+v", *stat, want)
+	}
+
+	tests := []struct {
+		data string
+		name string
+		err  bool
+	}{
+		{statLine(10, "tmux: server", "S", 1, 0), "tmux: server", false},
+		{statLine(11, "a) R 1 (b", "S", 1, 0), "a) R 1 (b", false},
+		{statLine(12, ":-)", "S", 1, 0), ":-)", false},
+		{statLine(13, "", "S", 1, 0), "", false},
+		// Older kernels write fewer fields, but enough to reach rss
+		{"14 (old) S 1 1 1 0 -1 0 0 0 0 0 5 5 0 0 20 0 1 0 100 1000 10", "old", false},
+		{"15 (worker) R 1", "", true},
+		{"16 worker R 1 1 1 0 -1 0 0 0 0 0 5 5 0 0 20 0 1 0 100 1000 10", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		stat, err := parseStat([]byte(tt.data))
+		if tt.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", tt.data)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.data, err)
+			continue
+		}
+		if stat.name != tt.name || stat.ppid != 1 {
+			t.Errorf("%q: name %q and ppid %d, want %q and 1", tt.data, stat.name, stat.ppid, tt.name)
+		}
+	}
+}
+
+func TestParseAuxvClockTicks(t *testing.T) {
+	auxv := func(wordSize int, pairs ...uint64) []byte {
+		b := make([]byte, len(pairs)*wordSize)
+		for i, v := range pairs {
+			if wordSize == 4 {
+				binary.NativeEndian.PutUint32(b[i*4:], uint32(v))
+			} else {
+				binary.NativeEndian.PutUint64(b[i*8:], v)
+			}
+		}
+		return b
+	}
+	const atPagesz = 6
+
+	tests := []struct {
+		name     string
+		auxv     []byte
+		wordSize int
+		hz       int64
+		ok       bool
+	}{
+		{"64-bit", auxv(8, atPagesz, 4096, atClkTck, 250, 0, 0), 8, 250, true},
+		{"32-bit", auxv(4, atPagesz, 4096, atClkTck, 1000, 0, 0), 4, 1000, true},
+		{"after AT_NULL", auxv(8, atPagesz, 4096, 0, 0, atClkTck, 250), 8, 0, false},
+		{"missing", auxv(8, atPagesz, 4096, 0, 0), 8, 0, false},
+		{"truncated", auxv(8, atPagesz, 4096, atClkTck, 250)[:20], 8, 0, false},
+		{"empty", nil, 8, 0, false},
+	}
+	for _, tt := range tests {
+		hz, ok := parseAuxvClockTicks(tt.auxv, tt.wordSize)
+		if hz != tt.hz || ok != tt.ok {
+			t.Errorf("%s: got %d, %v, want %d, %v", tt.name, hz, ok, tt.hz, tt.ok)
+		}
+	}
+
+	if hz := readClockTicks(); hz <= 0 {
+		t.Errorf("clock ticks of this machine = %d", hz)
+	}
+}
+
+func TestReadProcessInfo(t *testing.T) {
+	m := fixtureMonitor(t, "before")
+	boot := int64(1760860800)
+
+	tests := []struct {
+		pid  int
+		want Process
+	}{
+		{1, Process{PID: 1, PPID: 0, Name: "systemd", State: "S", User: "root", Memory: 3200 * 4096, StartTime: time.Unix(boot, 120e6), Command: "/sbin/init splash", VirtualMemory: 172000000, Threads: 1, OpenFDs: -1}},
+		{77, Process{PID: 77, PPID: 2, Name: "kworker/0:1-events", State: "I", User: "root", StartTime: time.Unix(boot, 300e6), Command: "[kworker/0:1-events]", KernelThread: true, Threads: 1, OpenFDs: -1}},
+		{4242, Process{PID: 4242, PPID: 1, Name: "worker", State: "R", User: "4000000", Memory: 2850 * 4096, StartTime: time.Unix(boot+4587, 380e6), Command: "./worker --jobs 4", VirtualMemory: 27033600, Threads: 4, VoluntaryCtxSwitches: 1500, InvoluntaryCtxSwitches: 230, OpenFDs: -1}},
+	}
+	for _, tt := range tests {
+		proc, snap, err := m.readProcessInfo(tt.pid, CPUSnapshot{NumCPU: 4})
+		if err != nil {
+			t.Errorf("pid %d: %v", tt.pid, err)
+			continue
+		}
+		wantPercent := float64(tt.want.Memory) / (16318412 * 1024) * 100
+		if math.Abs(proc.MemoryPercent-wantPercent) > 1e-9 {
+			t.Errorf("pid %d: memory percent = %v, want %v", tt.pid, proc.MemoryPercent, wantPercent)
+		}
+		proc.MemoryPercent = 0
+		if !proc.StartTime.Equal(tt.want.StartTime) {
+			t.Errorf("pid %d: start time = %v, want %v", tt.pid, proc.StartTime, tt.want.StartTime)
+		}
+		proc.StartTime = tt.want.StartTime
+		if *proc != tt.want {
+			t.Errorf("pid %d: process = %+v, want %+v", tt.pid, *proc, tt.want)
+		}
+		if snap.PID != tt.pid || snap.NumCPU != 4 {
+			t.Errorf("pid %d: snapshot = %+v", tt.pid, snap)
+		}
+	}
+
+	if _, _, err := m.readProcessInfo(999, CPUSnapshot{}); !errors.Is(err, ErrNoProcess) {
+		t.Errorf("missing process: error = %v, want ErrNoProcess", err)
+	}
+}
+
+func TestReadProcessInfoEdgeCases(t *testing.T) {
+	status := &fstest.MapFile{Data: []byte("Name:\tx\nUid:\t0\t0\t0\t0\n")}
+	proc := fstest.MapFS{
+		"stat":    {Data: []byte("cpu  1 0 1 10 0 0 0 0 0 0\nbtime 1760860800\n")},
+		"meminfo": {Data: []byte("MemTotal: 1000 kB\n")},
+		// kthreadd has no kernel thread flag
+		"2/stat":    {Data: []byte(statLine(2, "kthreadd", "S", 0, 0x208040))},
+		"2/status":  status,
+		"2/cmdline": {},
+		"9/stat":    {Data: []byte(statLine(9, "ksoftirqd/0", "S", 2, 0x4208040))},
+		"9/status":  status,
+		"9/cmdline": {},
+		// A zombie keeps its stat and status but loses its command line
+		"300/stat":    {Data: []byte(statLine(300, "sh", "Z", 1, 0x400000))},
+		"300/status":  status,
+		"300/cmdline": {},
+		"301/stat":    {Data: []byte(statLine(301, "my (odd) job", "S", 1, 0))},
+		"301/status":  status,
+		"301/cmdline": {Data: []byte("python3\x00job.py\x00")},
+		// Process 302 exited after its stat file was read
+		"302/stat": {Data: []byte(statLine(302, "short", "R", 1, 0))},
+		// Process 303's stat file is garbage
+		"303/stat":    {Data: []byte("303 short")},
+		"303/status":  status,
+		"303/cmdline": {},
+	}
+	impl, err := newPlatformMonitor(config{procFS: proc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := impl.(*linuxMonitor)
+
+	tests := []struct {
+		pid          int
+		name         string
+		command      string
+		kernelThread bool
+		err          error
+	}{
+		{pid: 2, name: "kthreadd", command: "[kthreadd]", kernelThread: true},
+		{pid: 9, name: "ksoftirqd/0", command: "[ksoftirqd/0]", kernelThread: true},
+		{pid: 300, name: "sh", command: "[sh] <defunct>"},
+		{pid: 301, name: "my (odd) job", command: "python3 job.py"},
+		{pid: 302, err: ErrNoProcess},
+		{pid: 303},
+		{pid: 404, err: ErrNoProcess},
+	}
+	for _, tt := range tests {
+		p, err := m.GetProcess(tt.pid)
+		switch {
+		case tt.name == "" && err == nil:
+			t.Errorf("pid %d: expected an error", tt.pid)
+		case tt.err != nil && !errors.Is(err, tt.err):
+			t.Errorf("pid %d: error = %v, want %v", tt.pid, err, tt.err)
+		case tt.name == "":
+		case err != nil:
+			t.Errorf("pid %d: %v", tt.pid, err)
+		case p.Name != tt.name || p.Command != tt.command || p.KernelThread != tt.kernelThread:
+			t.Errorf("pid %d: name %q, command %q and kernel thread %v, want %q, %q and %v", tt.pid, p.Name, p.Command, p.KernelThread, tt.name, tt.command, tt.kernelThread)
+		}
+	}
+
+	// A scan skips the processes that can't be read
+	processes, err := m.GetProcesses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(processes) != 4 {
+		t.Errorf("scan found %d processes, want 4", len(processes))
+	}
+}
+
+func TestExtendedMetrics(t *testing.T) {
+	tests := []struct {
+		metrics Metrics
+		io      bool
+		fds     int
+	}{
+		{0, false, -1},
+		{MetricIO, true, -1},
+		{MetricFDs, false, 4},
+		{MetricAll, true, 4},
+	}
+	for _, tt := range tests {
+		m := fixtureMonitor(t, "before")
+		m.metrics = tt.metrics
+		p, err := m.GetProcess(4242)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (p.IO != nil) != tt.io || p.OpenFDs != tt.fds {
+			t.Errorf("metrics %b: io %+v and %d fds", tt.metrics, p.IO, p.OpenFDs)
+		}
+		// Processes without the files keep the metrics unset
+		if p, err := m.GetProcess(1); err != nil || p.IO != nil || p.OpenFDs != -1 {
+			t.Errorf("metrics %b: pid 1 = %+v, %v", tt.metrics, p, err)
+		}
+	}
+
+	want := IOStats{ReadChars: 1068576, WriteChars: 7096, ReadSyscalls: 300, WriteSyscalls: 12, ReadBytes: 1048576, WriteBytes: 4096}
+	if got := parseIO(readFixture(t, "before", "4242", "io")); *got != want {
+		t.Errorf("io = %+v, want %+v", *got, want)
+	}
+}
+
+func TestIORates(t *testing.T) {
+	m := fixtureMonitor(t, "before")
+	m.metrics = MetricIO
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return start }
+	if _, err := m.GetProcesses(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two seconds later, 2 MiB more were read and 500 KiB more written
+	m.proc = os.DirFS(filepath.Join("testdata", "proc", "after"))
+	m.now = func() time.Time { return start.Add(2 * time.Second) }
+	p, err := m.GetProcess(4242)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.IO.ReadBytesPerSec != 1<<20 || p.IO.WriteBytesPerSec != 256000 {
+		t.Errorf("rates = %v read and %v written per second", p.IO.ReadBytesPerSec, p.IO.WriteBytesPerSec)
+	}
+	if p.VoluntaryCtxSwitches != 1620 || p.InvoluntaryCtxSwitches != 251 {
+		t.Errorf("context switches = %d and %d", p.VoluntaryCtxSwitches, p.InvoluntaryCtxSwitches)
+	}
+
+	// A new process with the same PID starts from scratch
+	last := m.lastStats[4242]
+	last.cpu.StartTicks++
+	m.lastStats[4242] = last
+	if p, _ := m.GetProcess(4242); p.IO.ReadBytesPerSec != 0 || p.IO.WriteBytesPerSec != 0 {
+		t.Errorf("rates across a reused PID = %+v", p.IO)
+	}
+}
+
+func TestReadMemInfo(t *testing.T) {
+	info, err := fixtureMonitor(t, "before").readMemInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		key  string
+		want uint64
+	}{
+		{"MemTotal", 16318412},
+		{"MemAvailable", 12238809},
+		{"SwapFree", 2097148},
+		{"Missing", 0},
+	}
+	for _, tt := range tests {
+		if info[tt.key] != tt.want {
+			t.Errorf("%s = %d, want %d", tt.key, info[tt.key], tt.want)
+		}
+	}
+
+	empty := &linuxMonitor{proc: fstest.MapFS{}}
+	if _, err := empty.readMemInfo(); err == nil {
+		t.Error("expected an error without a meminfo file")
+	}
+}
+
+func TestGetSystemCPUUsage(t *testing.T) {
+	tests := []struct {
+		name string
+		proc fstest.MapFS
+		want float64
+	}{
+		{"before", nil, 60602.0 / 457917 * 100},
+		{"after", nil, 60902.0 / 458317 * 100},
+		{"idle", fstest.MapFS{"stat": {Data: []byte("cpu  0 0 0 500 0 0 0 0 0 0\n")}}, 0},
+		{"short line", fstest.MapFS{"stat": {Data: []byte("cpu  1 2 3\n")}}, 0},
+		{"missing", fstest.MapFS{}, 0},
+	}
+	for _, tt := range tests {
+		m := &linuxMonitor{proc: tt.proc}
+		if tt.proc == nil {
+			m = fixtureMonitor(t, tt.name)
+		}
+		if got := m.getSystemCPUUsage(); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: usage = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGetProcessesFromFixtures(t *testing.T) {
+	m := fixtureMonitor(t, "before")
+	processes, err := m.GetProcesses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(processes) != 3 {
+		t.Fatalf("got %d processes, want 3", len(processes))
+	}
+
+	stats, err := m.GetSystemStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.TotalProcesses != 3 || stats.RunningProcesses != 1 || stats.SleepingProcesses != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	// Snapshots count the states of the processes they read
+	counted, err := m.systemStats(processes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *counted != *stats {
+		t.Errorf("systemStats = %+v, want %+v", counted, stats)
+	}
+
+	// A second scan one second later measures the usage in between
+	m.proc = os.DirFS(filepath.Join("testdata", "proc", "after"))
+	processes, err = m.GetProcesses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range processes {
+		want := CPUUsage{}
+		if p.PID == 4242 {
+			want = CPUUsage{User: 150, System: 50}
+		}
+		if p.UserCPUPercent != want.User || p.SystemCPUPercent != want.System || p.CPUPercent != want.Total() {
+			t.Errorf("pid %d: cpu = %v%% (%v%% user, %v%% system), want %+v", p.PID, p.CPUPercent, p.UserCPUPercent, p.SystemCPUPercent, want)
+		}
+	}
+
+	snap, err := m.GetCPUSnapshot(4242)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.UserTicks != 1350 || snap.SystemTicks != 350 || snap.TotalJiffies != 459245 || snap.NumCPU != 4 {
+		t.Errorf("snapshot = %+v", snap)
+	}
+}
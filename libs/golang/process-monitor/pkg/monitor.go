@@ -1,7 +1,11 @@
 package pm
 
 import (
+	"errors"
+	"io/fs"
+	"os"
 	"sort"
+	"syscall"
 	"time"
 )
 
@@ -14,11 +18,78 @@ type Process struct {
 	User          string
 	Memory        uint64 // in bytes
 	MemoryPercent float64
-	CPUPercent    float64
+	CPUPercent    float64 // UserCPUPercent + SystemCPUPercent
 	StartTime     time.Time
 	Command       string
+	KernelThread  bool
+	Nice          int // -20 (highest priority) to 19 (lowest)
+
+	// CPU time spent in user and kernel mode since the previous scan, as
+	// percentages of one CPU
+	UserCPUPercent   float64
+	SystemCPUPercent float64
+
+	VirtualMemory          uint64 // VSZ, in bytes
+	Threads                int
+	VoluntaryCtxSwitches   uint64 // times the process gave up the CPU, e.g. to wait for I/O
+	InvoluntaryCtxSwitches uint64 // times the process was preempted
+
+	// Extended metrics, only read when enabled with WithMetrics. IO is nil
+	// and OpenFDs is -1 when they were not read, which also happens for
+	// other users' processes without root privileges.
+	IO      *IOStats
+	OpenFDs int
 }
 
+// IOStats holds the I/O counters of a process since it started
+type IOStats struct {
+	ReadChars     uint64 // bytes read by read(2) and similar, including from the page cache
+	WriteChars    uint64 // bytes written by write(2) and similar
+	ReadSyscalls  uint64
+	WriteSyscalls uint64
+	ReadBytes     uint64 // bytes fetched from storage
+	WriteBytes    uint64 // bytes sent to storage
+	// CancelledWriteBytes is written data that never reached storage, e.g.
+	// because the file was truncated first
+	CancelledWriteBytes uint64
+
+	// ReadBytes and WriteBytes per second since the previous scan
+	ReadBytesPerSec  float64
+	WriteBytesPerSec float64
+}
+
+// Metrics selects extended process metrics, which cost extra reads per
+// process
+type Metrics uint
+
+const (
+	// MetricIO reads I/O counters into Process.IO
+	MetricIO Metrics = 1 << iota
+	// MetricFDs counts open file descriptors into Process.OpenFDs
+	MetricFDs
+
+	// MetricAll enables every extended metric
+	MetricAll = MetricIO | MetricFDs
+)
+
+// ErrNoProcess is returned for a process that does not exist, including one
+// that exited while it was being read
+var ErrNoProcess = errors.New("no such process")
+
+// ErrPermission is returned when the monitor may not control a process,
+// usually because it belongs to another user and the monitor is not root
+var ErrPermission = errors.New("operation not permitted")
+
+// ErrProcessReplaced is returned when the PID of a process to control now
+// belongs to a later process
+var ErrProcessReplaced = errors.New("process was replaced by another with the same PID")
+
+// ErrForeignProcfs is returned when controlling processes through a procfs
+// other than the monitor's own /proc, such as a host's /proc mounted in a
+// container. Its PIDs belong to another PID namespace, while signals and
+// scheduling calls take the monitor's own.
+var ErrForeignProcfs = errors.New("procfs is not the monitor's own /proc")
+
 // SystemStats represents overall system statistics
 type SystemStats struct {
 	TotalProcesses     int
@@ -37,6 +108,49 @@ type ProcessMonitor interface {
 	GetProcesses() ([]Process, error)
 	GetProcess(pid int) (*Process, error)
 	GetSystemStats() (*SystemStats, error)
+	GetCPUSnapshot(pid int) (*CPUSnapshot, error)
+	GetCPUSnapshots() ([]CPUSnapshot, error)
+	Target(pid int) (Target, error)
+	Signal(t Target, sig syscall.Signal) error
+	Renice(t Target, nice int) error
+	Affinity(pid int) ([]int, error)
+	SetAffinity(t Target, cpus []int) error
+}
+
+// Target is a process to control. Its start time tells it apart from a
+// later process that reuses the PID while a user confirms the action.
+type Target struct {
+	PID        int
+	StartTicks uint64 // see CPUSnapshot.StartTicks
+}
+
+// CPUSnapshot is a reading of a process's CPU counters. Two snapshots of the
+// same process give its CPU usage in between, see CalculateCPUUsage.
+type CPUSnapshot struct {
+	PID int
+	// StartTicks is when the process started, in clock ticks after boot. It
+	// tells a process apart from a later one that reused its PID.
+	StartTicks  uint64
+	UserTicks   uint64 // utime, in clock ticks
+	SystemTicks uint64 // stime, in clock ticks
+	// TotalJiffies is the CPU time of the whole system across all CPUs at the
+	// time of the reading, in clock ticks
+	TotalJiffies uint64
+	NumCPU       int
+	ClockTicks   int64 // clock ticks per second
+	Time         time.Time
+}
+
+// CPUUsage is the CPU time a process used between two snapshots, as
+// percentages of one CPU. A process keeping two cores busy uses 200%.
+type CPUUsage struct {
+	User   float64
+	System float64
+}
+
+// Total returns the combined user and system usage
+func (u CPUUsage) Total() float64 {
+	return u.User + u.System
 }
 
 // Monitor is the main process monitor struct
@@ -44,9 +158,45 @@ type Monitor struct {
 	impl ProcessMonitor
 }
 
+// Option configures a Monitor
+type Option func(*config)
+
+type config struct {
+	procFS  fs.FS
+	metrics Metrics
+}
+
+// WithProcRoot reads process information from the procfs mounted at root
+// instead of /proc, e.g. a host's /proc bind-mounted into a container
+func WithProcRoot(root string) Option {
+	return func(c *config) {
+		c.procFS = os.DirFS(root)
+	}
+}
+
+// WithProcFS reads process information from fsys, whose root stands for
+// /proc. It lets tests run the monitor on captured files.
+func WithProcFS(fsys fs.FS) Option {
+	return func(c *config) {
+		c.procFS = fsys
+	}
+}
+
+// WithMetrics enables extended process metrics. Without it, scans read only
+// the files needed for the basic fields.
+func WithMetrics(metrics Metrics) Option {
+	return func(c *config) {
+		c.metrics |= metrics
+	}
+}
+
 // New creates a new process monitor for the current OS
-func New() (*Monitor, error) {
-	impl, err := newPlatformMonitor()
+func New(opts ...Option) (*Monitor, error) {
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	impl, err := newPlatformMonitor(cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -93,12 +243,74 @@ func GroupProcessesByState(processes []Process) map[string][]Process {
 	return groups
 }
 
-// CalculateCPUUsage calculates the CPU usage percentage between two process snapshots
-func CalculateCPUUsage(prev, curr *Process, duration time.Duration) float64 {
-	if prev == nil || curr == nil || duration == 0 {
-		return 0
+// GetCPUSnapshot reads the CPU counters of a specific process
+func (m *Monitor) GetCPUSnapshot(pid int) (*CPUSnapshot, error) {
+	return m.impl.GetCPUSnapshot(pid)
+}
+
+// GetCPUSnapshots reads the CPU counters of all processes
+func (m *Monitor) GetCPUSnapshots() ([]CPUSnapshot, error) {
+	return m.impl.GetCPUSnapshots()
+}
+
+// Target reads what the control methods need to act on process pid. Take
+// it before asking the user, so that the action fails with
+// ErrProcessReplaced rather than reach another process if the PID is reused
+// in the meantime. Processes can only be controlled through the monitor's
+// own /proc, otherwise it fails with ErrForeignProcfs.
+func (m *Monitor) Target(pid int) (Target, error) {
+	return m.impl.Target(pid)
+}
+
+// Signal sends a signal to a process, e.g. syscall.SIGTERM to ask it to exit
+// or syscall.SIGSTOP to pause it
+func (m *Monitor) Signal(t Target, sig syscall.Signal) error {
+	return m.impl.Signal(t, sig)
+}
+
+// Renice sets the nice value of every thread of a process, from -20
+// (highest priority) to 19 (lowest). Only root can lower it.
+func (m *Monitor) Renice(t Target, nice int) error {
+	return m.impl.Renice(t, nice)
+}
+
+// Affinity returns the CPUs the main thread of a process may run on
+func (m *Monitor) Affinity(pid int) ([]int, error) {
+	return m.impl.Affinity(pid)
+}
+
+// SetAffinity restricts every thread of a process to the given CPUs
+func (m *Monitor) SetAffinity(t Target, cpus []int) error {
+	return m.impl.SetAffinity(t, cpus)
+}
+
+// CalculateCPUUsage calculates the CPU usage between two snapshots of the
+// same process. The elapsed time is measured in system jiffies when both
+// snapshots carry them, and in wall-clock time otherwise. It returns zero
+// usage when the snapshots belong to different processes or are out of order.
+func CalculateCPUUsage(prev, curr *CPUSnapshot) CPUUsage {
+	if prev == nil || curr == nil || prev.PID != curr.PID || prev.StartTicks != curr.StartTicks {
+		return CPUUsage{}
+	}
+	if curr.UserTicks < prev.UserTicks || curr.SystemTicks < prev.SystemTicks {
+		return CPUUsage{}
+	}
+	userDelta := float64(curr.UserTicks - prev.UserTicks)
+	systemDelta := float64(curr.SystemTicks - prev.SystemTicks)
+
+	// Ticks that one CPU could have spent on the process in between
+	var elapsed float64
+	switch {
+	case curr.TotalJiffies > prev.TotalJiffies && curr.NumCPU > 0:
+		elapsed = float64(curr.TotalJiffies-prev.TotalJiffies) / float64(curr.NumCPU)
+	case curr.Time.After(prev.Time) && curr.ClockTicks > 0:
+		elapsed = curr.Time.Sub(prev.Time).Seconds() * float64(curr.ClockTicks)
+	default:
+		return CPUUsage{}
+	}
+
+	return CPUUsage{
+		User:   userDelta / elapsed * 100,
+		System: systemDelta / elapsed * 100,
 	}
-	// This is a placeholder - actual implementation would depend on OS-specific metrics
-	// Linux would use jiffies, Windows would use different counters
-	return 0
 }
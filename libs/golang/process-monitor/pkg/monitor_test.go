@@ -0,0 +1,65 @@
+package pm
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCalculateCPUUsage(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	prev := CPUSnapshot{PID: 7, StartTicks: 500, UserTicks: 1000, SystemTicks: 200, TotalJiffies: 10000, NumCPU: 4, ClockTicks: 100, Time: start}
+
+	tests := []struct {
+		name         string
+		curr         CPUSnapshot
+		user, system float64
+	}{
+		{
+			name: "jiffies",
+			// 400 jiffies over 4 CPUs is one second of one CPU
+			curr: CPUSnapshot{PID: 7, StartTicks: 500, UserTicks: 1150, SystemTicks: 250, TotalJiffies: 10400, NumCPU: 4, ClockTicks: 100, Time: start.Add(time.Second)},
+			user: 150, system: 50,
+		},
+		{
+			name: "wall clock without jiffies",
+			curr: CPUSnapshot{PID: 7, StartTicks: 500, UserTicks: 1100, SystemTicks: 210, ClockTicks: 100, Time: start.Add(2 * time.Second)},
+			user: 50, system: 5,
+		},
+		{
+			name: "idle",
+			curr: CPUSnapshot{PID: 7, StartTicks: 500, UserTicks: 1000, SystemTicks: 200, TotalJiffies: 10400, NumCPU: 4, Time: start.Add(time.Second)},
+		},
+		{
+			name: "reused pid",
+			curr: CPUSnapshot{PID: 7, StartTicks: 900, UserTicks: 1150, SystemTicks: 250, TotalJiffies: 10400, NumCPU: 4, Time: start.Add(time.Second)},
+		},
+		{
+			name: "different process",
+			curr: CPUSnapshot{PID: 8, StartTicks: 500, UserTicks: 1150, SystemTicks: 250, TotalJiffies: 10400, NumCPU: 4, Time: start.Add(time.Second)},
+		},
+		{
+			name: "out of order",
+			curr: CPUSnapshot{PID: 7, StartTicks: 500, UserTicks: 900, SystemTicks: 150, TotalJiffies: 9600, NumCPU: 4, Time: start.Add(-time.Second)},
+		},
+		{
+			name: "no time passed",
+			curr: CPUSnapshot{PID: 7, StartTicks: 500, UserTicks: 1150, SystemTicks: 250, TotalJiffies: 10000, NumCPU: 4, ClockTicks: 100, Time: start},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := CalculateCPUUsage(&prev, &tt.curr)
+			if math.Abs(got.User-tt.user) > 1e-9 || math.Abs(got.System-tt.system) > 1e-9 {
+				t.Errorf("usage = %+v, want user %v and system %v", got, tt.user, tt.system)
+			}
+			if got.Total() != got.User+got.System {
+				t.Errorf("total = %v", got.Total())
+			}
+		})
+	}
+
+	if got := CalculateCPUUsage(nil, &prev); got != (CPUUsage{}) {
+		t.Errorf("usage without a previous snapshot = %+v", got)
+	}
+}
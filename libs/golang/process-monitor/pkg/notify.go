@@ -0,0 +1,244 @@
+package pm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Notifier delivers alerts
+type Notifier interface {
+	Notify(alert Alert) error
+}
+
+// NotifierFunc adapts a function to a Notifier
+type NotifierFunc func(alert Alert) error
+
+// Notify calls f
+func (f NotifierFunc) Notify(alert Alert) error {
+	return f(alert)
+}
+
+// ParseNotifier parses a notifier given as stdout, stderr, file:path,
+// exec:command or webhook:url
+func ParseNotifier(spec string) (Notifier, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch {
+	case spec == "stdout":
+		return NewWriterNotifier(os.Stdout), nil
+	case spec == "stderr":
+		return NewWriterNotifier(os.Stderr), nil
+	case kind == "file" && arg != "":
+		return NewFileNotifier(arg)
+	case kind == "exec" && arg != "":
+		return NewExecNotifier(arg), nil
+	case kind == "webhook" && arg != "":
+		return NewWebhookNotifier(arg)
+	}
+	return nil, fmt.Errorf("invalid notifier %q: expected stdout, stderr, file:path, exec:command or webhook:url", spec)
+}
+
+// formatAlert formats an alert as a line of text
+func formatAlert(alert Alert) string {
+	return alert.Time.Format(time.RFC3339) + " " + alert.String() + "\n"
+}
+
+// WriterNotifier writes alerts to a writer, a line each
+type WriterNotifier struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterNotifier creates a notifier writing to w
+func NewWriterNotifier(w io.Writer) *WriterNotifier {
+	return &WriterNotifier{w: w}
+}
+
+// Notify writes the alert
+func (n *WriterNotifier) Notify(alert Alert) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	_, err := io.WriteString(n.w, formatAlert(alert))
+	return err
+}
+
+// FileNotifier appends alerts to a log file, a line each
+type FileNotifier struct {
+	WriterNotifier
+	file *os.File
+}
+
+// NewFileNotifier opens a log file for appending, creating it if needed
+func NewFileNotifier(path string) (*FileNotifier, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileNotifier{WriterNotifier: WriterNotifier{w: f}, file: f}, nil
+}
+
+// Close closes the log file
+func (n *FileNotifier) Close() error {
+	return n.file.Close()
+}
+
+// notifyTimeout bounds how long a command or webhook may take
+const notifyTimeout = 10 * time.Second
+
+// execWaitDelay bounds how long a command's output is awaited after it
+// exits or times out, as processes it left in the background may hold it
+const execWaitDelay = time.Second
+
+// ExecNotifier runs a shell command for each alert, with the alert in
+// environment variables:
+//
+//	PM_ALERT_STATE    firing or resolved
+//	PM_ALERT_RULE     the rule, e.g. proc_cpu(nginx) > 80 for 30s
+//	PM_ALERT_VALUE    the value that changed the state
+//	PM_ALERT_PID      the process of a proc_ rule, or 0
+//	PM_ALERT_PROCESS  its name
+//	PM_ALERT_MESSAGE  the alert as text
+type ExecNotifier struct {
+	command string
+}
+
+// NewExecNotifier creates a notifier running command with /bin/sh
+func NewExecNotifier(command string) *ExecNotifier {
+	return &ExecNotifier{command: command}
+}
+
+// Notify runs the command and waits for it
+func (n *ExecNotifier) Notify(alert Alert) error {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", n.command)
+	cmd.Env = append(os.Environ(),
+		"PM_ALERT_STATE="+alert.State(),
+		"PM_ALERT_RULE="+alert.Rule.String(),
+		"PM_ALERT_VALUE="+strconv.FormatFloat(alert.Value, 'f', -1, 64),
+		"PM_ALERT_PID="+strconv.Itoa(alert.PID),
+		"PM_ALERT_PROCESS="+alert.Process,
+		"PM_ALERT_MESSAGE="+alert.String(),
+	)
+	cmd.WaitDelay = execWaitDelay
+	// A command that succeeded but left its output open is done
+	if out, err := cmd.CombinedOutput(); err != nil && !errors.Is(err, exec.ErrWaitDelay) {
+		return fmt.Errorf("alert command: %w: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// WebhookNotifier posts each alert as JSON to a local endpoint, such as
+// one relaying it to chat or paging. Alerts name processes and users, so
+// they only go to the loopback interface, and redirects are not followed.
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+// errRemoteWebhook rejects webhooks and connections off the loopback
+// interface
+var errRemoteWebhook = errors.New("webhooks must be on localhost or a loopback address")
+
+// NewWebhookNotifier creates a notifier posting to an http or https URL on
+// localhost or a loopback address
+func NewWebhookNotifier(rawURL string) (*WebhookNotifier, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid webhook URL %q: expected http or https", rawURL)
+	}
+	if host := u.Hostname(); host != "localhost" && !isLoopback(host) {
+		return nil, fmt.Errorf("webhook %q: %w", rawURL, errRemoteWebhook)
+	}
+
+	// localhost is resolved when connecting, so the address is checked
+	// again there
+	dialer := &net.Dialer{
+		Timeout: notifyTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if host, _, err := net.SplitHostPort(address); err != nil || !isLoopback(host) {
+				return fmt.Errorf("webhook connection to %s: %w", address, errRemoteWebhook)
+			}
+			return nil
+		},
+	}
+	client := &http.Client{
+		Timeout:   notifyTimeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return &WebhookNotifier{url: rawURL, client: client}, nil
+}
+
+// isLoopback reports whether host is a loopback IP address
+func isLoopback(host string) bool {
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// webhookAlert is the JSON body of a webhook
+type webhookAlert struct {
+	State     string    `json:"state"`
+	Rule      string    `json:"rule"`
+	Metric    string    `json:"metric"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	PID       int       `json:"pid,omitempty"`
+	Process   string    `json:"process,omitempty"`
+	Gone      bool      `json:"gone,omitempty"`
+	Since     time.Time `json:"since"`
+	Time      time.Time `json:"time"`
+	Message   string    `json:"message"`
+}
+
+// Notify posts the alert and expects a 2xx response
+func (n *WebhookNotifier) Notify(alert Alert) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	// Rules compare with > and <, which read better unescaped
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(webhookAlert{
+		State:     alert.State(),
+		Rule:      alert.Rule.String(),
+		Metric:    alert.Rule.Metric,
+		Value:     alert.Value,
+		Threshold: alert.Rule.Threshold,
+		PID:       alert.PID,
+		Process:   alert.Process,
+		Gone:      alert.Gone,
+		Since:     alert.Since,
+		Time:      alert.Time,
+		Message:   alert.String(),
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(n.url, "application/json", &body)
+	if err != nil {
+		return fmt.Errorf("alert webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("alert webhook: %s returned %s", n.url, resp.Status)
+	}
+	return nil
+}
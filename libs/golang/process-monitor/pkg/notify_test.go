@@ -0,0 +1,136 @@
+package pm
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testAlert(t *testing.T) Alert {
+	t.Helper()
+	return Alert{Rule: mustRules(t, "proc_cpu(nginx) > 80 for 30s")[0], Firing: true, Value: 93.5,
+		PID: 1234, Process: "nginx", Since: historyStart, Time: historyStart.Add(30e9)}
+}
+
+func TestFileNotifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alerts.log")
+	for range 2 {
+		n, err := NewFileNotifier(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := n.Notify(testAlert(t)); err != nil {
+			t.Fatal(err)
+		}
+		if err := n.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := historyStart.Add(30e9).Format("2006-01-02T15:04:05Z07:00") + " FIRING proc_cpu(nginx) > 80 for 30s: nginx (1234) at 93.5\n"
+	if string(data) != line+line {
+		t.Errorf("log = %q, want the alert twice", data)
+	}
+}
+
+func TestExecNotifier(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("no /bin/sh")
+	}
+	out := filepath.Join(t.TempDir(), "out")
+	n := NewExecNotifier(`printf '%s|%s|%s|%s|%s' "$PM_ALERT_STATE" "$PM_ALERT_RULE" "$PM_ALERT_VALUE" "$PM_ALERT_PID" "$PM_ALERT_PROCESS" > ` + out)
+	if err := n.Notify(testAlert(t)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "firing|proc_cpu(nginx) > 80 for 30s|93.5|1234|nginx"; string(data) != want {
+		t.Errorf("command saw %q, want %q", data, want)
+	}
+
+	// A process left in the background keeps the output open
+	start := time.Now()
+	if err := NewExecNotifier("sleep 10 &").Notify(testAlert(t)); err != nil {
+		t.Errorf("command leaving a process behind: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("command leaving a process behind took %s", elapsed)
+	}
+
+	err = NewExecNotifier("echo broken >&2; exit 3").Notify(testAlert(t))
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("failing command: error = %v, want its output", err)
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var got map[string]any
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q", ct)
+		}
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Errorf("body %s: %v", body, err)
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	n, err := NewWebhookNotifier(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(testAlert(t)); err != nil {
+		t.Fatal(err)
+	}
+	if got["state"] != "firing" || got["rule"] != "proc_cpu(nginx) > 80 for 30s" || got["value"] != 93.5 ||
+		got["threshold"] != 80.0 || got["pid"] != 1234.0 || got["process"] != "nginx" {
+		t.Errorf("webhook body = %v", got)
+	}
+
+	status = http.StatusBadGateway
+	if err := n.Notify(testAlert(t)); err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("error = %v, want the 502 status", err)
+	}
+
+	// Redirects could lead off the machine
+	redirect := httptest.NewServer(http.RedirectHandler(srv.URL, http.StatusTemporaryRedirect))
+	defer redirect.Close()
+	n, err = NewWebhookNotifier(redirect.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = nil
+	if err := n.Notify(testAlert(t)); err == nil || !strings.Contains(err.Error(), "307") || got != nil {
+		t.Errorf("redirect: error = %v, body %v delivered, want the 307 status", err, got)
+	}
+}
+
+func TestParseNotifier(t *testing.T) {
+	dir := t.TempDir()
+	for _, spec := range []string{"stdout", "stderr", "file:" + filepath.Join(dir, "a.log"), "exec:true",
+		"webhook:http://127.0.0.1:9000/alerts", "webhook:http://localhost/alerts", "webhook:https://[::1]:8443/alerts"} {
+		if _, err := ParseNotifier(spec); err != nil {
+			t.Errorf("ParseNotifier(%q): %v", spec, err)
+		}
+	}
+	for _, spec := range []string{"", "email:ops@example.com", "file:", "exec:", "file:" + filepath.Join(dir, "missing", "a.log"),
+		"webhook:https://hooks.example.com/alerts", "webhook:http://10.0.0.1/alerts", "webhook:ftp://127.0.0.1/alerts", "webhook:127.0.0.1:9000"} {
+		if _, err := ParseNotifier(spec); err == nil {
+			t.Errorf("ParseNotifier(%q) succeeded", spec)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package pm
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+)
+
+// Snapshot is the state of the system at one point in time, as recorded by
+// a Recorder
+type Snapshot struct {
+	Time      time.Time
+	Stats     SystemStats
+	Processes []Process
+}
+
+// statsCounter is implemented by monitors that can compute the system
+// statistics from processes already read, sparing a second pass over them
+type statsCounter interface {
+	systemStats(processes []Process) (*SystemStats, error)
+}
+
+// Snapshot reads all processes and the system statistics, in one pass
+// over the processes where the platform allows
+func (m *Monitor) Snapshot() (*Snapshot, error) {
+	processes, err := m.GetProcesses()
+	if err != nil {
+		return nil, err
+	}
+	var stats *SystemStats
+	if c, ok := m.impl.(statsCounter); ok {
+		stats, err = c.systemStats(processes)
+	} else {
+		stats, err = m.GetSystemStats()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{Time: time.Now(), Stats: *stats, Processes: processes}, nil
+}
+
+var errCorruptSnapshot = errors.New("corrupt snapshot")
+
+// appendSnapshot appends the binary encoding of s, without its time, to buf.
+// Integers are varints and floats are stored as their IEEE 754 bits.
+func appendSnapshot(buf []byte, s *Snapshot) []byte {
+	e := encoder(buf)
+	e.int(int64(s.Stats.TotalProcesses))
+	e.int(int64(s.Stats.RunningProcesses))
+	e.int(int64(s.Stats.SleepingProcesses))
+	e.int(int64(s.Stats.StoppedProcesses))
+	e.int(int64(s.Stats.ZombieProcesses))
+	e.uint(s.Stats.TotalMemory)
+	e.uint(s.Stats.UsedMemory)
+	e.float(s.Stats.MemoryUsagePercent)
+	e.float(s.Stats.CPUUsagePercent)
+
+	e.uint(uint64(len(s.Processes)))
+	for i := range s.Processes {
+		p := &s.Processes[i]
+		e.int(int64(p.PID))
+		e.int(int64(p.PPID))
+		e.string(p.Name)
+		e.string(p.State)
+		e.string(p.User)
+		e.uint(p.Memory)
+		e.float(p.MemoryPercent)
+		e.float(p.CPUPercent)
+		e.float(p.UserCPUPercent)
+		e.float(p.SystemCPUPercent)
+		e.time(p.StartTime)
+		e.string(p.Command)
+		e.bool(p.KernelThread)
+		e.int(int64(p.Nice))
+		e.uint(p.VirtualMemory)
+		e.int(int64(p.Threads))
+		e.uint(p.VoluntaryCtxSwitches)
+		e.uint(p.InvoluntaryCtxSwitches)
+		e.int(int64(p.OpenFDs))
+		e.bool(p.IO != nil)
+		if p.IO != nil {
+			e.uint(p.IO.ReadChars)
+			e.uint(p.IO.WriteChars)
+			e.uint(p.IO.ReadSyscalls)
+			e.uint(p.IO.WriteSyscalls)
+			e.uint(p.IO.ReadBytes)
+			e.uint(p.IO.WriteBytes)
+			e.uint(p.IO.CancelledWriteBytes)
+			e.float(p.IO.ReadBytesPerSec)
+			e.float(p.IO.WriteBytesPerSec)
+		}
+	}
+	return e
+}
+
+// decodeSnapshot decodes a snapshot encoded by appendSnapshot
+func decodeSnapshot(data []byte, t time.Time) (*Snapshot, error) {
+	d := &decoder{data: data}
+	s := &Snapshot{Time: t}
+	s.Stats.TotalProcesses = int(d.int())
+	s.Stats.RunningProcesses = int(d.int())
+	s.Stats.SleepingProcesses = int(d.int())
+	s.Stats.StoppedProcesses = int(d.int())
+	s.Stats.ZombieProcesses = int(d.int())
+	s.Stats.TotalMemory = d.uint()
+	s.Stats.UsedMemory = d.uint()
+	s.Stats.MemoryUsagePercent = d.float()
+	s.Stats.CPUUsagePercent = d.float()
+
+	n := d.uint()
+	// Every process takes more than one byte, which bounds a corrupt count
+	if n > uint64(len(d.data)) {
+		return nil, errCorruptSnapshot
+	}
+	s.Processes = make([]Process, n)
+	for i := range s.Processes {
+		p := &s.Processes[i]
+		p.PID = int(d.int())
+		p.PPID = int(d.int())
+		p.Name = d.string()
+		p.State = d.string()
+		p.User = d.string()
+		p.Memory = d.uint()
+		p.MemoryPercent = d.float()
+		p.CPUPercent = d.float()
+		p.UserCPUPercent = d.float()
+		p.SystemCPUPercent = d.float()
+		p.StartTime = d.time()
+		p.Command = d.string()
+		p.KernelThread = d.bool()
+		p.Nice = int(d.int())
+		p.VirtualMemory = d.uint()
+		p.Threads = int(d.int())
+		p.VoluntaryCtxSwitches = d.uint()
+		p.InvoluntaryCtxSwitches = d.uint()
+		p.OpenFDs = int(d.int())
+		if d.bool() {
+			p.IO = &IOStats{
+				ReadChars:           d.uint(),
+				WriteChars:          d.uint(),
+				ReadSyscalls:        d.uint(),
+				WriteSyscalls:       d.uint(),
+				ReadBytes:           d.uint(),
+				WriteBytes:          d.uint(),
+				CancelledWriteBytes: d.uint(),
+				ReadBytesPerSec:     d.float(),
+				WriteBytesPerSec:    d.float(),
+			}
+		}
+		if d.err != nil {
+			break
+		}
+	}
+	if d.err != nil || len(d.data) != 0 {
+		return nil, errCorruptSnapshot
+	}
+	return s, nil
+}
+
+type encoder []byte
+
+func (e *encoder) uint(v uint64) { *e = binary.AppendUvarint(*e, v) }
+func (e *encoder) int(v int64)   { *e = binary.AppendVarint(*e, v) }
+func (e *encoder) float(v float64) {
+	*e = binary.LittleEndian.AppendUint64(*e, math.Float64bits(v))
+}
+
+func (e *encoder) string(s string) {
+	e.uint(uint64(len(s)))
+	*e = append(*e, s...)
+}
+
+func (e *encoder) bool(b bool) {
+	if b {
+		*e = append(*e, 1)
+	} else {
+		*e = append(*e, 0)
+	}
+}
+
+// time stores t in nanoseconds since the epoch, and the zero time as 0
+func (e *encoder) time(t time.Time) {
+	if t.IsZero() {
+		e.int(0)
+		return
+	}
+	e.int(t.UnixNano())
+}
+
+// decoder reads what encoder wrote. After the first error it returns zero
+// values and keeps the error.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) uint() uint64 {
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) int() int64 {
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) float() float64 {
+	if len(d.data) < 8 {
+		d.fail()
+		return 0
+	}
+	v := math.Float64frombits(binary.LittleEndian.Uint64(d.data))
+	d.data = d.data[8:]
+	return v
+}
+
+func (d *decoder) string() string {
+	n := d.uint()
+	if n > uint64(len(d.data)) {
+		d.fail()
+		return ""
+	}
+	s := string(d.data[:n])
+	d.data = d.data[n:]
+	return s
+}
+
+func (d *decoder) bool() bool {
+	if len(d.data) < 1 {
+		d.fail()
+		return false
+	}
+	b := d.data[0] != 0
+	d.data = d.data[1:]
+	return b
+}
+
+func (d *decoder) time() time.Time {
+	ns := d.int()
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+func (d *decoder) fail() {
+	if d.err == nil {
+		d.err = errCorruptSnapshot
+	}
+	d.data = nil
+}
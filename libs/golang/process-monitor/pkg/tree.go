@@ -0,0 +1,163 @@
+package pm
+
+import "sort"
+
+// ProcessNode is a process in a ProcessTree
+type ProcessNode struct {
+	Process
+	Parent   *ProcessNode
+	Children []*ProcessNode
+
+	// Usage of the process and all its descendants
+	TreeCPUPercent    float64
+	TreeMemory        uint64 // in bytes
+	TreeMemoryPercent float64
+	TreeSize          int // number of processes, including this one
+}
+
+// ProcessTree arranges processes by their parent PID. Its roots are the
+// processes whose parent is not part of the tree, such as init (PID 1) and
+// kthreadd (PID 2).
+type ProcessTree struct {
+	Roots []*ProcessNode
+	nodes map[int]*ProcessNode
+}
+
+// BuildTree builds the process forest of processes. Children are ordered by
+// PID.
+func BuildTree(processes []Process) *ProcessTree {
+	t := &ProcessTree{nodes: make(map[int]*ProcessNode, len(processes))}
+	for _, p := range processes {
+		t.nodes[p.PID] = &ProcessNode{Process: p}
+	}
+
+	pids := make([]int, 0, len(t.nodes))
+	for pid := range t.nodes {
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+
+	for _, pid := range pids {
+		n := t.nodes[pid]
+		parent, ok := t.nodes[n.PPID]
+		// A process read while its PID was reused can end up as its own
+		// ancestor; it becomes a root instead of closing a cycle
+		if !ok || parent == n || parent.hasAncestor(n) {
+			t.Roots = append(t.Roots, n)
+			continue
+		}
+		n.Parent = parent
+		parent.Children = append(parent.Children, n)
+	}
+
+	for _, root := range t.Roots {
+		root.aggregate()
+	}
+	return t
+}
+
+func (n *ProcessNode) hasAncestor(a *ProcessNode) bool {
+	for p := n.Parent; p != nil; p = p.Parent {
+		if p == a {
+			return true
+		}
+	}
+	return false
+}
+
+// aggregate computes the tree totals of n and its descendants
+func (n *ProcessNode) aggregate() {
+	n.TreeCPUPercent = n.CPUPercent
+	n.TreeMemory = n.Memory
+	n.TreeMemoryPercent = n.MemoryPercent
+	n.TreeSize = 1
+	for _, c := range n.Children {
+		c.aggregate()
+		n.TreeCPUPercent += c.TreeCPUPercent
+		n.TreeMemory += c.TreeMemory
+		n.TreeMemoryPercent += c.TreeMemoryPercent
+		n.TreeSize += c.TreeSize
+	}
+}
+
+// Node returns the node of a process, or nil if it is not in the tree
+func (t *ProcessTree) Node(pid int) *ProcessNode {
+	return t.nodes[pid]
+}
+
+// Len returns the number of processes in the tree
+func (t *ProcessTree) Len() int {
+	return len(t.nodes)
+}
+
+// Ancestors returns the parent of a process, its parent and so on up to the
+// root of its tree
+func (t *ProcessTree) Ancestors(pid int) []Process {
+	n := t.nodes[pid]
+	if n == nil {
+		return nil
+	}
+	var ancestors []Process
+	for p := n.Parent; p != nil; p = p.Parent {
+		ancestors = append(ancestors, p.Process)
+	}
+	return ancestors
+}
+
+// Descendants returns the children of a process, their children and so on,
+// depth first
+func (t *ProcessTree) Descendants(pid int) []Process {
+	n := t.nodes[pid]
+	if n == nil {
+		return nil
+	}
+	descendants := make([]Process, 0, n.TreeSize-1)
+	for _, c := range n.Children {
+		c.Walk(func(d *ProcessNode, depth int) bool {
+			descendants = append(descendants, d.Process)
+			return true
+		})
+	}
+	return descendants
+}
+
+// IsDescendant reports whether process pid descends from process ancestor
+func (t *ProcessTree) IsDescendant(pid, ancestor int) bool {
+	n, a := t.nodes[pid], t.nodes[ancestor]
+	return n != nil && a != nil && n.hasAncestor(a)
+}
+
+// Walk calls fn for each process of the tree, depth first, with its depth
+// below its root. Returning false from fn skips the descendants of a process.
+func (t *ProcessTree) Walk(fn func(n *ProcessNode, depth int) bool) {
+	for _, root := range t.Roots {
+		root.walk(0, fn)
+	}
+}
+
+// Walk calls fn for n and its descendants, depth first, with their depth
+// below n. Returning false from fn skips the descendants of a process.
+func (n *ProcessNode) Walk(fn func(n *ProcessNode, depth int) bool) {
+	n.walk(0, fn)
+}
+
+func (n *ProcessNode) walk(depth int, fn func(n *ProcessNode, depth int) bool) {
+	if !fn(n, depth) {
+		return
+	}
+	for _, c := range n.Children {
+		c.walk(depth+1, fn)
+	}
+}
+
+// SortChildren reorders the roots and the children of every process using
+// the provided comparison function
+func (t *ProcessTree) SortChildren(less func(a, b *ProcessNode) bool) {
+	sortNodes := func(nodes []*ProcessNode) {
+		sort.SliceStable(nodes, func(i, j int) bool { return less(nodes[i], nodes[j]) })
+	}
+	sortNodes(t.Roots)
+	for _, n := range t.nodes {
+		sortNodes(n.Children)
+	}
+}
@@ -0,0 +1,132 @@
+package pm
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func pids(processes []Process) string {
+	s := make([]string, len(processes))
+	for i, p := range processes {
+		s[i] = fmt.Sprint(p.PID)
+	}
+	return strings.Join(s, ",")
+}
+
+// render draws the tree as "pid(children...)" for comparisons
+func render(t *ProcessTree) string {
+	var b strings.Builder
+	var draw func(n *ProcessNode)
+	draw = func(n *ProcessNode) {
+		fmt.Fprint(&b, n.PID)
+		if len(n.Children) > 0 {
+			b.WriteString("(")
+			for i, c := range n.Children {
+				if i > 0 {
+					b.WriteString(" ")
+				}
+				draw(c)
+			}
+			b.WriteString(")")
+		}
+	}
+	for i, root := range t.Roots {
+		if i > 0 {
+			b.WriteString(" ")
+		}
+		draw(root)
+	}
+	return b.String()
+}
+
+func TestBuildTree(t *testing.T) {
+	processes := []Process{
+		{PID: 300, PPID: 10, CPUPercent: 5, Memory: 300, MemoryPercent: 3},
+		{PID: 1, PPID: 0, CPUPercent: 1, Memory: 100, MemoryPercent: 1},
+		{PID: 2, PPID: 0},
+		{PID: 9, PPID: 2, CPUPercent: 0.5},
+		{PID: 10, PPID: 1, CPUPercent: 2, Memory: 200, MemoryPercent: 2},
+		{PID: 20, PPID: 1, Memory: 50},
+		{PID: 301, PPID: 10, CPUPercent: 7, Memory: 400, MemoryPercent: 4},
+		// Parent exited before the scan
+		{PID: 500, PPID: 499},
+	}
+	tree := BuildTree(processes)
+
+	if got := render(tree); got != "1(10(300 301) 20) 2(9) 500" {
+		t.Errorf("tree = %s", got)
+	}
+	if tree.Len() != len(processes) {
+		t.Errorf("len = %d", tree.Len())
+	}
+
+	tests := []struct {
+		pid    int
+		cpu    float64
+		memory uint64
+		size   int
+	}{
+		{1, 15, 1050, 5},
+		{10, 14, 900, 3},
+		{300, 5, 300, 1},
+		{2, 0.5, 0, 2},
+	}
+	for _, tt := range tests {
+		n := tree.Node(tt.pid)
+		if n.TreeCPUPercent != tt.cpu || n.TreeMemory != tt.memory || n.TreeSize != tt.size {
+			t.Errorf("pid %d: tree cpu %v, memory %d, size %d, want %v, %d, %d", tt.pid, n.TreeCPUPercent, n.TreeMemory, n.TreeSize, tt.cpu, tt.memory, tt.size)
+		}
+	}
+	if n := tree.Node(1); n.TreeMemoryPercent != 10 {
+		t.Errorf("tree memory percent of 1 = %v", n.TreeMemoryPercent)
+	}
+
+	if got := pids(tree.Ancestors(301)); got != "10,1" {
+		t.Errorf("ancestors of 301 = %s", got)
+	}
+	if got := pids(tree.Ancestors(1)); got != "" {
+		t.Errorf("ancestors of 1 = %s", got)
+	}
+	if got := pids(tree.Descendants(1)); got != "10,300,301,20" {
+		t.Errorf("descendants of 1 = %s", got)
+	}
+	if tree.Descendants(404) != nil || tree.Ancestors(404) != nil || tree.Node(404) != nil {
+		t.Error("expected nothing for a missing process")
+	}
+	if !tree.IsDescendant(300, 1) || tree.IsDescendant(1, 300) || tree.IsDescendant(9, 1) || tree.IsDescendant(1, 1) {
+		t.Error("IsDescendant")
+	}
+
+	var walked []string
+	tree.Walk(func(n *ProcessNode, depth int) bool {
+		walked = append(walked, fmt.Sprintf("%d@%d", n.PID, depth))
+		return n.PID != 10
+	})
+	if got := strings.Join(walked, " "); got != "1@0 10@1 20@1 2@0 9@1 500@0" {
+		t.Errorf("walk = %s", got)
+	}
+
+	tree.SortChildren(func(a, b *ProcessNode) bool { return a.TreeCPUPercent > b.TreeCPUPercent })
+	if got := render(tree); got != "1(10(301 300) 20) 2(9) 500" {
+		t.Errorf("sorted tree = %s", got)
+	}
+}
+
+func TestBuildTreeCycles(t *testing.T) {
+	tests := []struct {
+		processes []Process
+		want      string
+	}{
+		{[]Process{{PID: 5, PPID: 5}}, "5"},
+		{[]Process{{PID: 5, PPID: 6}, {PID: 6, PPID: 5}}, "6(5)"},
+		{[]Process{{PID: 5, PPID: 6}, {PID: 6, PPID: 7}, {PID: 7, PPID: 5}, {PID: 8, PPID: 7}}, "7(6(5) 8)"},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		tree := BuildTree(tt.processes)
+		if got := render(tree); got != tt.want {
+			t.Errorf("%v: tree = %s, want %s", tt.processes, got, tt.want)
+		}
+	}
+}